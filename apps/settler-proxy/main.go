@@ -43,6 +43,7 @@ func main() {
 	}
 
 	mw := x402.NewMiddleware(cfg)
+	defer mw.Close()
 
 	handler := mw.Handler(proxy)
 
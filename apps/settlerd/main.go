@@ -7,13 +7,18 @@ import (
 	"math/big"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/nathfavour/settlerengine/core/domain/model"
 	"github.com/nathfavour/settlerengine/core/domain/service"
+	"github.com/nathfavour/settlerengine/pkg/bundler"
 	"github.com/nathfavour/settlerengine/pkg/chains"
 	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/remotesigner"
 	"github.com/nathfavour/settlerengine/pkg/storage"
 	"github.com/nathfavour/settlerengine/pkg/yield"
 )
@@ -30,16 +35,62 @@ func main() {
 	defer db.Close()
 	log.Printf("📂 Data Directory: %s", db.DataDir)
 
-	// 2. Initialize Blockchain Multi-Client
+	// 2. Initialize Blockchain Multi-Client, on the chains configured by SETTLER_CHAINS_FILE
+	// and SETTLER_<NETWORK>_RPC_URL
+	if err := chains.Configure(os.Getenv("SETTLER_CHAINS_FILE")); err != nil {
+		log.Fatalf("Invalid chain configuration: %v", err)
+	}
 	mc := chains.NewMultiClient()
 	defer mc.Close()
 
-	// 3. Initialize Signer for Automation (Load from env in production)
-	// Using a placeholder key for demonstration.
-	signer, err := crypto.NewSessionKeySigner("0x0000000000000000000000000000000000000000000000000000000000000001", big.NewInt(56))
+	// Pick up the transactions a previous run left in flight: settle those that were mined,
+	// drop those whose nonce was used, and rebroadcast the rest.
+	if resumed, err := crypto.NewJournal(db, mc).Resume(context.Background()); err != nil {
+		log.Printf("⚠️  Failed to resume pending transactions: %v", err)
+	} else if resumed > 0 {
+		log.Printf("🔁 Resumed %d pending transactions", resumed)
+	}
+
+	// 3. Initialize Signer for Automation: a remote signer, a keystore file, a development key
+	// from the environment, or the active key managed by `settler keys`.
+	var key crypto.Signer
+	if os.Getenv("SETTLER_REMOTE_SIGNER") != "" {
+		key, err = remoteSigner()
+	} else {
+		key, err = crypto.KeySource{
+			Keystore:       os.Getenv("SETTLER_KEYSTORE"),
+			KeyDir:         db.KeysDir(),
+			PassphraseFile: os.Getenv("SETTLER_KEYSTORE_PASSWORD_FILE"),
+			PassphraseEnv:  "SETTLER_KEYSTORE_PASSWORD",
+			RawKeyEnv:      "SETTLER_SESSION_KEY",
+		}.Load()
+	}
 	if err != nil {
-		log.Fatalf("Failed to initialize signer: %v", err)
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
+	if _, ok := key.(*crypto.PrivateKeySigner); ok {
+		log.Println("⚠️  Signing with SETTLER_SESSION_KEY; use an encrypted keystore in production")
+	}
+	signer := crypto.NewSessionKeySignerFrom(key, big.NewInt(56))
+
+	strategies := []model.YieldStrategy{
+		{
+			ID:           "riquid_bnb_vault",
+			Provider:     "Riquid",
+			AutoHarvest:  true,
+			VaultAddress: "0x0000000000000000000000000000000000000000", // placeholder
+		},
+	}
+
+	// Restrict the session key to the strategies' vaults, auditing every decision.
+	var expiry time.Time
+	if v := os.Getenv("SETTLER_SESSION_EXPIRY"); v != "" {
+		if expiry, err = time.Parse(time.RFC3339, v); err != nil {
+			log.Fatalf("Invalid SETTLER_SESSION_EXPIRY: %v", err)
+		}
 	}
+	signer = signer.WithPolicy(yield.SessionPolicy(strategies, expiry, crypto.NewSQLitePolicyAuditor(db)))
+	log.Printf("🔑 Session key %s scoped to %d vaults", signer.Address().Hex(), len(strategies))
 
 	// 4. Initialize Riquid Adapter
 	bscClient, err := mc.GetClient(chains.ChainIDBSC)
@@ -51,6 +102,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize Riquid adapter: %v", err)
 	}
+	if os.Getenv("SETTLER_BUNDLER_URL") != "" {
+		tm, err := smartAccountManager(bscClient, signer)
+		if err != nil {
+			log.Fatalf("Failed to initialize smart account: %v", err)
+		}
+		riquid.WithTransactionManager(tm)
+	} else {
+		tm, err := accountManager(bscClient, signer, db)
+		if err != nil {
+			log.Fatalf("Failed to initialize transaction manager: %v", err)
+		}
+		riquid.WithTransactionManager(tm)
+	}
 
 	// 5. Initialize Event Bus
 	bus := service.NewLocalBus()
@@ -67,15 +131,6 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	strategies := []model.YieldStrategy{
-		{
-			ID:           "riquid_bnb_vault",
-			Provider:     "Riquid",
-			AutoHarvest:   true,
-			VaultAddress: "0x0000000000000000000000000000000000000000", // placeholder
-		},
-	}
-	
 	// Start Auto-Harvesting
 	go yieldSvc.StartAutoHarvestWorker(ctx, 1*time.Hour, strategies)
 
@@ -88,3 +143,97 @@ func main() {
 	<-ctx.Done()
 	log.Println("Shutting down...")
 }
+
+// remoteSigner signs with the account in SETTLER_REMOTE_SIGNER_ACCOUNT, held by the Clef-style
+// signer at SETTLER_REMOTE_SIGNER (an HTTP URL or a Unix socket path). Requests time out after
+// SETTLER_REMOTE_SIGNER_TIMEOUT.
+func remoteSigner() (crypto.Signer, error) {
+	account, err := remotesigner.FromEnv(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🔏 Signing remotely via %s", os.Getenv("SETTLER_REMOTE_SIGNER"))
+	return account, nil
+}
+
+// accountManager sends automation from the session key's own address, within the fee bounds
+// of BSC. Transactions still pending after SETTLER_TX_REPLACE_AFTER (default 3m) are sped up.
+func accountManager(client *ethclient.Client, signer *crypto.SessionKeySigner, db *storage.DB) (*crypto.TransactionManager, error) {
+	chain, err := chains.GetChainConfig(chains.ChainIDBSC)
+	if err != nil {
+		return nil, err
+	}
+	replaceAfter := 3 * time.Minute
+	if v := os.Getenv("SETTLER_TX_REPLACE_AFTER"); v != "" {
+		if replaceAfter, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid SETTLER_TX_REPLACE_AFTER: %w", err)
+		}
+	}
+	return crypto.NewTransactionManager(client, nil).
+		WithChain(chain).
+		WithSigner(signer).
+		WithFeePolicy(crypto.FeePolicy{MinTip: chain.MinPriorityFee, MaxFee: chain.MaxFeePerGas}).
+		WithStore(db).
+		WithReplacement(replaceAfter), nil
+}
+
+// smartAccountManager sends automation through the smart account in SETTLER_SMART_ACCOUNT via
+// the bundler in SETTLER_BUNDLER_URL. With SETTLER_PAYMASTER_URL, gas is sponsored within
+// SETTLER_SPONSOR_BUDGETS, a list of operation=wei per day such as "harvest=1e16,deposit=2e16".
+func smartAccountManager(client *ethclient.Client, signer *crypto.SessionKeySigner) (*crypto.TransactionManager, error) {
+	ctx := context.Background()
+	account := os.Getenv("SETTLER_SMART_ACCOUNT")
+	if !common.IsHexAddress(account) {
+		return nil, fmt.Errorf("SETTLER_SMART_ACCOUNT must be the smart account address")
+	}
+	aa, err := bundler.Dial(ctx, os.Getenv("SETTLER_BUNDLER_URL"))
+	if err != nil {
+		return nil, err
+	}
+	tm := crypto.NewTransactionManager(client, aa).WithSmartAccount(crypto.SmartAccount{
+		Address:    common.HexToAddress(account),
+		Owner:      signer.Address(),
+		Entrypoint: common.HexToAddress(os.Getenv("SETTLER_ENTRYPOINT")), // v0.7 when unset
+		Paymaster:  common.HexToAddress(os.Getenv("SETTLER_PAYMASTER")), // any when unset
+	}, signer)
+	log.Printf("🔐 Sending automation through smart account %s", account)
+
+	paymasterURL := os.Getenv("SETTLER_PAYMASTER_URL")
+	if paymasterURL == "" {
+		return tm, nil
+	}
+	var policy map[string]any
+	if id := os.Getenv("SETTLER_PAYMASTER_POLICY"); id != "" {
+		policy = map[string]any{"sponsorshipPolicyId": id}
+	}
+	pm, err := bundler.DialPaymaster(ctx, paymasterURL, policy)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := parseBudgets(os.Getenv("SETTLER_SPONSOR_BUDGETS"))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("⛽ Gas sponsorship enabled for %d operation types", len(limits))
+	return tm.WithPaymaster(pm, crypto.NewSponsorshipBudget(limits, 24*time.Hour)), nil
+}
+
+// parseBudgets parses "operation=wei" pairs separated by commas. Amounts may use e notation.
+func parseBudgets(s string) (map[string]*big.Int, error) {
+	limits := make(map[string]*big.Int)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		operation, amount, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sponsorship budget %q, want operation=wei", pair)
+		}
+		f, ok := new(big.Float).SetString(strings.TrimSpace(amount))
+		if !ok || f.Sign() < 0 {
+			return nil, fmt.Errorf("invalid sponsorship budget amount %q", amount)
+		}
+		limits[strings.TrimSpace(operation)], _ = f.Int(nil)
+	}
+	return limits, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -9,11 +11,20 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/core/domain/service"
 	"github.com/nathfavour/settlerengine/pkg/anyisland"
+	"github.com/nathfavour/settlerengine/pkg/chains"
 	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/facilitator"
+	"github.com/nathfavour/settlerengine/pkg/pricing"
+	"github.com/nathfavour/settlerengine/pkg/remotesigner"
+	"github.com/nathfavour/settlerengine/pkg/settlement"
 	"github.com/nathfavour/settlerengine/pkg/storage"
 	"github.com/nathfavour/settlerengine/pkg/uds"
 	"github.com/nathfavour/settlerengine/pkg/x402"
@@ -38,6 +49,12 @@ func main() {
 		runProxy(os.Args[2:])
 	case "facilitator":
 		runFacilitator(os.Args[2:])
+	case "passkeys":
+		runPasskeys(os.Args[2:])
+	case "keys":
+		runKeys(os.Args[2:])
+	case "chains":
+		runChains(os.Args[2:])
 	case "help":
 		printUsage()
 	default:
@@ -54,6 +71,9 @@ func printUsage() {
 	fmt.Println("\nCommands:")
 	fmt.Println("  proxy        Start the x402 reverse proxy")
 	fmt.Println("  facilitator  Start the settlement facilitator daemon")
+	fmt.Println("  passkeys     Manage WebAuthn passkeys agents sign payments with")
+	fmt.Println("  keys         Manage the encrypted signing keys of the engine")
+	fmt.Println("  chains       List the configured chains and check their RPC endpoints")
 	fmt.Println("  help         Show this help message")
 }
 
@@ -65,7 +85,33 @@ func runProxy(args []string) {
 	chainID := fs.Int64("chain-id", 84532, "Chain ID (default Base Sepolia)")
 	asset := fs.String("asset", "0x036CbD53842c5426634e7929541eC2318f3dCF7e", "Asset address (USDC)")
 	amount := fs.String("amount", "1000000", "Amount in atomic units")
+	maxRequests := fs.Int("max-requests", 1, "Requests one payment unlocks (0 = unlimited within -usage-window)")
+	usageWindow := fs.Duration("usage-window", 0, "How long one payment stays valid (0 = no time limit)")
+	requireBinding := fs.Bool("require-binding", false, "Only accept intents bound to the request method, URL and body")
+	credits := fs.Bool("credits", false, "Accept prepaid deposits and debit each request from the signer's balance")
+	minDeposit := fs.String("min-deposit", "0", "Smallest prepaid deposit in atomic units (never below the request price)")
+	maxDeposit := fs.String("max-deposit", "", "Largest prepaid deposit credited, in atomic units (required with -credits)")
+	accept := fs.String("accept", "", "Comma-separated payment options as SYMBOL@CHAIN[=AMOUNT], e.g. USDC@base,USDT@bsc=1000000000000000000")
+	settle := fs.Bool("settle", false, "Settle exact-scheme payments on-chain with the facilitator key")
+	pricingFile := fs.String("pricing", "", "JSON pricing rules file, reloaded on change (overrides -amount)")
+	checkSolvency := fs.Bool("check-solvency", false, "Reject payers whose on-chain balance or allowance cannot cover the payment")
+	solvencyTTL := fs.Duration("solvency-ttl", x402.DefaultSolvencyTTL, "How long a payer's balance reading is cached")
+	facilitatorURL := fs.String("facilitator-url", "", "Delegate exact-scheme verification and settlement to a facilitator (http(s):// or unix://)")
+	domainVersions := fs.String("domain-versions", crypto.DomainVersion, "Comma-separated EIP-712 domain versions accepted, current first")
+	passkeys := fs.Bool("passkeys", false, "Accept intents signed by passkeys registered with 'settler passkeys add'")
+	chainsFile := fs.String("chains", os.Getenv("SETTLER_CHAINS_FILE"), "JSON chain registry file overriding the built-in chains")
 	fs.Parse(args)
+	configureChains(*chainsFile)
+
+	// Deposits are signed promises, not transfers: each is checked on-chain and capped.
+	if *credits {
+		if !*checkSolvency {
+			log.Fatal("-credits requires -check-solvency")
+		}
+		if max, ok := new(big.Int).SetString(*maxDeposit, 10); !ok || max.Sign() <= 0 {
+			log.Fatal("-credits requires a positive -max-deposit")
+		}
+	}
 
 	// 1. Initialize Storage
 	db, err := storage.OpenDefault()
@@ -89,6 +135,9 @@ func runProxy(args []string) {
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
 	cfg := x402.Config{
 		DomainParams: crypto.DomainParams{
 			ChainID:           big.NewInt(*chainID),
@@ -99,9 +148,117 @@ func runProxy(args []string) {
 		Asset:       *asset,
 		Amount:      *amount,
 		DB:          db,
+		Usage: x402.UsagePolicy{
+			MaxRequests: *maxRequests,
+			Window:      *usageWindow,
+		},
+		RequireRequestBinding: *requireBinding,
+		DomainVersions:        strings.Split(*domainVersions, ","),
+		Credits:               *credits,
+		MinDeposit:            *minDeposit,
+		MaxDeposit:            *maxDeposit,
+	}
+
+	if *accept != "" {
+		options, err := parseAccept(*accept)
+		if err != nil {
+			log.Fatalf("Invalid -accept: %v", err)
+		}
+		cfg.Options = options
+		for _, opt := range options {
+			log.Printf("💳 Accepting %s on chain %s", opt.Asset, opt.ChainID)
+		}
+	}
+
+	if *pricingFile != "" {
+		offered := cfg.Options
+		if len(offered) == 0 {
+			offered = []x402.PaymentOption{{ChainID: cfg.DomainParams.ChainID, VerifyingContract: cfg.DomainParams.VerifyingContract}}
+		}
+		watcher, err := pricing.Watch(*pricingFile, pricing.Terms{
+			Price:     *amount,
+			Asset:     *asset,
+			Recipient: *recipient,
+		}, offered, 2*time.Second)
+		if err != nil {
+			log.Fatalf("Invalid pricing file: %v", err)
+		}
+		defer watcher.Close()
+		cfg.PriceResolver = watcher.PriceResolver()
+		cfg.OptionsResolver = watcher.OptionsResolver()
+		log.Printf("💲 Pricing: %d rules from %s", watcher.Table().Len(), *pricingFile)
+	}
+
+	if *settle && *facilitatorURL != "" {
+		log.Fatal("-settle and -facilitator-url are mutually exclusive")
+	}
+	if *facilitatorURL != "" {
+		client, err := facilitator.NewClient(*facilitatorURL)
+		if err != nil {
+			log.Fatalf("Invalid -facilitator-url: %v", err)
+		}
+		client.WithToken(os.Getenv("SETTLER_FACILITATOR_TOKEN"))
+		if _, err := client.Supported(context.Background()); err != nil {
+			log.Printf("⚠️  Facilitator is not reachable yet: %v", err)
+		}
+		cfg.Facilitator = client
+		log.Printf("🤝 Facilitator: %s", *facilitatorURL)
+	}
+
+	if *settle {
+		key, err := facilitatorKey(db)
+		if err != nil {
+			log.Fatalf("-settle requires a facilitator key: %v", err)
+		}
+		// Each settlement signs for the chain its payment is on.
+		signer := crypto.NewSessionKeySignerFrom(key, nil)
+		cfg.Settler = settlement.NewEIP3009Settler(clients, settlementSenders(clients, signer, db), db)
+		log.Printf("⛓️  Settlement: EIP-3009 via %s", signer.Address().Hex())
+	}
+
+	// Smart account signatures are checked against the chain the intent names.
+	cfg.Clients = clients
+
+	if *passkeys {
+		cfg.Passkeys = true
+		log.Println("🔑 Passkeys: accepting WebAuthn P-256 signatures")
+	}
+
+	if *checkSolvency {
+		cfg.Solvency = x402.NewChainSolvency(clients, *solvencyTTL)
+		log.Printf("🏦 Solvency: checking balances on-chain (cached %s)", *solvencyTTL)
+	}
+
+	// Stateless nonces let several proxy replicas verify each other's challenges. A nonce
+	// redeemed on one replica must be refused by the others, so the spent-set lives in a
+	// database they all open rather than in each replica's own.
+	if hexSecret := os.Getenv("SETTLER_NONCE_SECRET"); hexSecret != "" {
+		secret, err := x402.ParseNonceSecret(hexSecret)
+		if err != nil {
+			log.Fatalf("Invalid SETTLER_NONCE_SECRET: %v", err)
+		}
+		spentDir := os.Getenv("SETTLER_NONCE_SPENT_DIR")
+		if spentDir == "" {
+			log.Fatal("SETTLER_NONCE_SECRET requires SETTLER_NONCE_SPENT_DIR, the data directory every replica shares for redeemed nonces")
+		}
+		spentDB, err := storage.Open(spentDir)
+		if err != nil {
+			log.Fatalf("Failed to open the shared spent-set: %v", err)
+		}
+		defer spentDB.Close()
+		spent := x402.NewSQLiteSpentSet(spentDB)
+
+		issuer, err := x402.NewStatelessNonceManager(secret, spent)
+		if err != nil {
+			log.Fatalf("Failed to initialize stateless nonces: %v", err)
+		}
+		cfg.NonceIssuer = issuer
+		cfg.AuthorizationSpent = spent
+		log.Printf("🔐 Nonces: stateless (HMAC) mode, spent-set in %s", spentDB.DataDir)
 	}
 
 	mw := x402.NewMiddleware(cfg)
+	defer mw.Close()
 	handler := mw.Handler(proxy)
 
 	log.Printf("🚀 SettlerProxy: Listening on %s", *listen)
@@ -112,6 +269,17 @@ func runProxy(args []string) {
 }
 
 func runFacilitator(args []string) {
+	fs := flag.NewFlagSet("facilitator", flag.ExitOnError)
+	listen := fs.String("listen", ":8402", "HTTP listen address (empty to serve only on the socket)")
+	socket := fs.String("socket", "", "Unix socket path (default facilitator.sock in the data directory)")
+	batch := fs.Bool("batch", false, "Settle payments in Multicall3 batches instead of one transaction each")
+	batchSize := fs.Int("batch-size", 50, "Submit a batch once this many payments are queued on a chain")
+	batchValue := fs.String("batch-value", "", "Submit a batch once queued payments on a chain add up to this many atomic units")
+	batchWait := fs.Duration("batch-wait", 30*time.Second, "Submit a batch once the oldest queued payment has waited this long")
+	chainsFile := fs.String("chains", os.Getenv("SETTLER_CHAINS_FILE"), "JSON chain registry file overriding the built-in chains")
+	fs.Parse(args)
+	configureChains(*chainsFile)
+
 	db, err := storage.OpenDefault()
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
@@ -120,6 +288,384 @@ func runFacilitator(args []string) {
 
 	fmt.Println("Starting Settler Facilitator...")
 	log.Printf("📂 Data Directory: %s", db.DataDir)
-	log.Println("Facilitator daemon is running (stateless verification mode active)")
-	select {} // Keep alive
+
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	// Without a key the facilitator only verifies.
+	// Each settlement signs for the chain its payment is on. The settler and the batcher send
+	// through the same managers, so they share the key's nonces.
+	var senders *crypto.Senders
+	key, err := facilitatorKey(db)
+	switch {
+	case errors.Is(err, crypto.ErrNoActiveKey):
+		log.Println("⚠️  No facilitator key is configured, /settle is disabled")
+	case err != nil:
+		log.Fatalf("Failed to load facilitator key: %v", err)
+	default:
+		senders = settlementSenders(clients, crypto.NewSessionKeySignerFrom(key, nil), db)
+		log.Printf("⛓️  Settlement: EIP-3009 via %s", senders.Address().Hex())
+	}
+	server := facilitator.NewServer(settlement.NewEIP3009Settler(clients, senders, db), db)
+
+	// The socket is private to this user; anything reachable over TCP must present the token.
+	server.Token = os.Getenv("SETTLER_FACILITATOR_TOKEN")
+	if *listen != "" && senders != nil && server.Token == "" {
+		log.Fatal("Listening with -listen requires SETTLER_FACILITATOR_TOKEN, or pass -listen \"\" to serve only on the socket")
+	}
+
+	if *batch {
+		if senders == nil {
+			log.Fatal("-batch requires a facilitator key")
+		}
+		policy := settlement.BatchPolicy{MaxSize: *batchSize, MaxWait: *batchWait}
+		if *batchValue != "" {
+			value, ok := new(big.Int).SetString(*batchValue, 10)
+			if !ok {
+				log.Fatalf("Invalid -batch-value %q", *batchValue)
+			}
+			policy.MaxValue = value
+		}
+
+		bus := service.NewLocalBus()
+		go logSettlements(bus)
+		batcher := settlement.NewBatcher(clients, senders, db, bus, policy)
+		if err := batcher.Start(); err != nil {
+			log.Fatalf("Failed to start batcher: %v", err)
+		}
+		defer batcher.Close()
+		server.Batcher = batcher
+		log.Printf("📦 Batching: up to %d payments, every %s", *batchSize, *batchWait)
+	}
+
+	if *socket == "" {
+		*socket = filepath.Join(db.DataDir, "facilitator.sock")
+	}
+	udsServer := uds.NewServer(*socket)
+	if err := udsServer.StartHTTP(server); err != nil {
+		log.Printf("⚠️  UDS Server failed to start: %v", err)
+	}
+	defer udsServer.Close()
+
+	if *listen == "" {
+		select {} // Serve on the socket only
+	}
+	log.Printf("🚀 Facilitator: Listening on %s", *listen)
+	if err := http.ListenAndServe(*listen, server); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runPasskeys(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: settler passkeys <add|list|remove> [arguments]")
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("passkeys "+args[0], flag.ExitOnError)
+	agent := fs.String("agent", "", "Agent address payments signed by the passkey are attributed to")
+	credential := fs.String("credential", "", "WebAuthn credential ID (base64url)")
+	key := fs.String("key", "", "P-256 public key: hex uncompressed point or base64 SubjectPublicKeyInfo")
+	rpID := fs.String("rp-id", "", "Relying party ID assertions must be scoped to (empty to skip the check)")
+	fs.Parse(args[1:])
+
+	db, err := storage.OpenDefault()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "add":
+		if !common.IsHexAddress(*agent) || *credential == "" || *key == "" {
+			log.Fatal("passkeys add requires -agent, -credential and -key")
+		}
+		pub, err := crypto.ParseP256PublicKey(*key)
+		if err != nil {
+			log.Fatalf("Invalid -key: %v", err)
+		}
+		passkey := x402.Passkey{CredentialID: *credential, Agent: common.HexToAddress(*agent), PublicKey: pub, RPID: *rpID}
+		if err := x402.NewSQLitePasskeyRegistry(db).Register(passkey); err != nil {
+			log.Fatalf("Failed to register passkey: %v", err)
+		}
+		fmt.Printf("Registered passkey %s for %s\n", passkey.CredentialID, passkey.Agent.Hex())
+	case "list":
+		if *agent != "" && !common.IsHexAddress(*agent) {
+			log.Fatalf("Invalid -agent %q", *agent)
+		}
+		filter := ""
+		if *agent != "" {
+			filter = common.HexToAddress(*agent).Hex()
+		}
+		passkeys, err := db.ListPasskeys(filter)
+		if err != nil {
+			log.Fatalf("Failed to list passkeys: %v", err)
+		}
+		for _, p := range passkeys {
+			fmt.Printf("%s\t%s\t%s\t%s\n", p.CredentialID, p.Agent, p.RPID, p.PublicKey)
+		}
+	case "remove":
+		removed, err := db.DeletePasskey(*credential)
+		if err != nil {
+			log.Fatalf("Failed to remove passkey: %v", err)
+		}
+		if !removed {
+			log.Fatalf("Passkey %q is not registered", *credential)
+		}
+		fmt.Printf("Removed passkey %s\n", *credential)
+	default:
+		fmt.Printf("Unknown passkeys command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func runKeys(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: settler keys <generate|import|list|address|rotate> [arguments]")
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	passphraseFile := fs.String("passphrase-file", "", "File holding the passphrase keys are encrypted with (default $SETTLER_KEYSTORE_PASSWORD)")
+	keyFile := fs.String("key-file", "", "import: file holding a hex private key")
+	keystoreFile := fs.String("keystore", "", "import: geth keystore file to import")
+	keystorePassphraseFile := fs.String("keystore-passphrase-file", "", "import: file holding the passphrase of -keystore (default the new passphrase)")
+	fs.Parse(args[1:])
+
+	db, err := storage.OpenDefault()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer db.Close()
+	keys, err := crypto.OpenKeyDir(db.KeysDir())
+	if err != nil {
+		log.Fatalf("Failed to open key directory: %v", err)
+	}
+	passphrase := func() string {
+		p, err := crypto.ReadPassphrase(*passphraseFile, "SETTLER_KEYSTORE_PASSWORD")
+		if err != nil {
+			log.Fatalf("keys %s needs -passphrase-file or SETTLER_KEYSTORE_PASSWORD: %v", args[0], err)
+		}
+		return p
+	}
+
+	switch args[0] {
+	case "generate":
+		addr, err := keys.Generate(passphrase())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Generated key %s in %s\n", addr.Hex(), keys.Dir())
+	case "import":
+		var addr common.Address
+		switch {
+		case *keyFile != "":
+			hexKey, err := os.ReadFile(*keyFile)
+			if err != nil {
+				log.Fatalf("Failed to read -key-file: %v", err)
+			}
+			key, err := ethcrypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(string(hexKey)), "0x"))
+			if err != nil {
+				log.Fatalf("Invalid -key-file: %v", err)
+			}
+			addr, err = keys.Import(key, passphrase())
+			if err != nil {
+				log.Fatal(err)
+			}
+		case *keystoreFile != "":
+			keyJSON, err := os.ReadFile(*keystoreFile)
+			if err != nil {
+				log.Fatalf("Failed to read -keystore: %v", err)
+			}
+			newPassphrase := passphrase()
+			oldPassphrase := newPassphrase
+			if *keystorePassphraseFile != "" {
+				if oldPassphrase, err = crypto.ReadPassphrase(*keystorePassphraseFile, ""); err != nil {
+					log.Fatal(err)
+				}
+			}
+			addr, err = keys.ImportKeystore(keyJSON, oldPassphrase, newPassphrase)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal("keys import requires -key-file or -keystore")
+		}
+		fmt.Printf("Imported key %s into %s\n", addr.Hex(), keys.Dir())
+	case "list":
+		active, _ := keys.Active()
+		for _, addr := range keys.List() {
+			marker := " "
+			if addr == active {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, addr.Hex())
+		}
+	case "address":
+		active, err := keys.Active()
+		if err != nil {
+			log.Fatalf("%v (run `settler keys generate`)", err)
+		}
+		fmt.Println(active.Hex())
+	case "rotate":
+		previous, next, err := keys.Rotate(passphrase())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if previous != (common.Address{}) {
+			fmt.Printf("Rotated %s -> %s; move funds and grants off the previous key\n", previous.Hex(), next.Hex())
+		} else {
+			fmt.Printf("Generated key %s\n", next.Hex())
+		}
+	default:
+		fmt.Printf("Unknown keys command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func runChains(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: settler chains <list|check> [-chains file] [chain...]")
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("chains "+args[0], flag.ExitOnError)
+	chainsFile := fs.String("chains", os.Getenv("SETTLER_CHAINS_FILE"), "JSON chain registry file overriding the built-in chains")
+	timeout := fs.Duration("timeout", 10*time.Second, "check: how long each chain may take to answer")
+	fs.Parse(args[1:])
+	configureChains(*chainsFile)
+
+	// Chains named after the flags, or all of them.
+	list := chains.Chains()
+	if fs.NArg() > 0 {
+		list = nil
+		for _, name := range fs.Args() {
+			cfg, err := chains.LookupChain(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			list = append(list, cfg)
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		for _, cfg := range list {
+			var tokens []string
+			for _, symbol := range []string{"USDC", "USDT", "BUSD"} {
+				if _, ok := cfg.TokenAddress(symbol); ok {
+					tokens = append(tokens, symbol)
+				}
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\n", cfg.ChainID, cfg.Network, cfg.Name, cfg.RPCURL, strings.Join(tokens, ","))
+		}
+	case "check":
+		failed := 0
+		for _, cfg := range list {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			head, err := cfg.Check(ctx)
+			cancel()
+			if err != nil {
+				failed++
+				fmt.Printf("❌ %s (%d): %v\n", cfg.Name, cfg.ChainID, err)
+				continue
+			}
+			fmt.Printf("✅ %s (%d): block %d\n", cfg.Name, cfg.ChainID, head)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown chains command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// configureChains loads the chain registry from file and the environment, exiting if any
+// chain is invalid.
+func configureChains(file string) {
+	if err := chains.Configure(file); err != nil {
+		log.Fatalf("Invalid chain configuration: %v", err)
+	}
+	if file != "" {
+		log.Printf("⛓️  Chains: Loaded %s", file)
+	}
+}
+
+// settlementSenders sends settlements from signer. Settlements still pending after
+// SETTLER_TX_REPLACE_AFTER (default 3m) are sped up.
+func settlementSenders(clients *chains.MultiClient, signer *crypto.SessionKeySigner, db *storage.DB) *crypto.Senders {
+	replaceAfter := 3 * time.Minute
+	if v := os.Getenv("SETTLER_TX_REPLACE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid SETTLER_TX_REPLACE_AFTER: %v", err)
+		}
+		replaceAfter = d
+	}
+	return crypto.NewSenders(clients, signer, db).WithReplacement(replaceAfter)
+}
+
+// facilitatorKey loads the key settlements are paid from the way settlerd loads its own: a
+// remote signer, a keystore file, a development key in SETTLER_FACILITATOR_KEY, or the active
+// key managed by `settler keys`.
+func facilitatorKey(db *storage.DB) (crypto.Signer, error) {
+	if os.Getenv("SETTLER_REMOTE_SIGNER") != "" {
+		account, err := remotesigner.FromEnv(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("🔏 Signing remotely via %s", os.Getenv("SETTLER_REMOTE_SIGNER"))
+		return account, nil
+	}
+	key, err := crypto.KeySource{
+		Keystore:       os.Getenv("SETTLER_KEYSTORE"),
+		KeyDir:         db.KeysDir(),
+		PassphraseFile: os.Getenv("SETTLER_KEYSTORE_PASSWORD_FILE"),
+		PassphraseEnv:  "SETTLER_KEYSTORE_PASSWORD",
+		RawKeyEnv:      "SETTLER_FACILITATOR_KEY",
+	}.Load()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*crypto.PrivateKeySigner); ok {
+		log.Println("⚠️  Signing with SETTLER_FACILITATOR_KEY; use an encrypted keystore in production")
+	}
+	return key, nil
+}
+
+// logSettlements reports batched settlement results published on the bus.
+func logSettlements(bus *service.LocalBus) {
+	settled := bus.Subscribe(service.EventPaymentSettled)
+	failed := bus.Subscribe(service.EventPaymentSettlementFailed)
+	for {
+		select {
+		case e := <-settled:
+			r := e.Data.(settlement.Result)
+			log.Printf("✅ Settled %s in %s", r.PaymentID, r.TxHash)
+		case e := <-failed:
+			r := e.Data.(settlement.Result)
+			log.Printf("❌ Settlement of %s failed: %s", r.PaymentID, r.Error)
+		}
+	}
+}
+
+// parseAccept parses -accept entries such as "USDC@base-sepolia=1000000" into payment options.
+func parseAccept(spec string) ([]x402.PaymentOption, error) {
+	var options []x402.PaymentOption
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		token, amount, _ := strings.Cut(entry, "=")
+		symbol, chain, ok := strings.Cut(token, "@")
+		if !ok {
+			return nil, fmt.Errorf("%q is not SYMBOL@CHAIN[=AMOUNT]", entry)
+		}
+		cfg, err := chains.LookupChain(chain)
+		if err != nil {
+			return nil, err
+		}
+		opt, err := x402.TokenOption(cfg.ChainID, symbol, amount)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, opt)
+	}
+	return options, nil
 }
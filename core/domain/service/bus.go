@@ -1,11 +1,7 @@
 package service
 
 import (
-	"context"
-	"fmt"
 	"sync"
-
-	"github.com/nathfavour/settlerengine/core/domain/model"
 )
 
 // Event is a simple representation of a domain event.
@@ -16,6 +12,11 @@ type Event struct {
 
 const (
 	EventSettlementConfirmed = "SETTLEMENT_CONFIRMED"
+
+	// Batched settlement of x402 payments.
+	EventSettlementBatchSubmitted = "SETTLEMENT_BATCH_SUBMITTED"
+	EventPaymentSettled           = "PAYMENT_SETTLED"
+	EventPaymentSettlementFailed  = "PAYMENT_SETTLEMENT_FAILED"
 )
 
 // LocalBus is a simple, in-memory event bus for decoupled communication.
@@ -0,0 +1,162 @@
+// Package bundler talks to ERC-4337 bundlers over their JSON-RPC API.
+package bundler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// Client calls a bundler's eth_* user operation methods. It implements crypto.AAProvider.
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the bundler at rawURL.
+func Dial(ctx context.Context, rawURL string) (*Client, error) {
+	c, err := rpc.DialContext(ctx, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial bundler: %w", err)
+	}
+	return &Client{rpc: c}, nil
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() {
+	c.rpc.Close()
+}
+
+// SupportedEntryPoints returns the EntryPoints the bundler accepts operations for.
+func (c *Client) SupportedEntryPoints(ctx context.Context) ([]common.Address, error) {
+	var entryPoints []common.Address
+	if err := c.rpc.CallContext(ctx, &entryPoints, "eth_supportedEntryPoints"); err != nil {
+		return nil, fmt.Errorf("failed to list entry points: %w", err)
+	}
+	return entryPoints, nil
+}
+
+// SendUserOperation submits a signed user operation and returns its userOpHash.
+func (c *Client) SendUserOperation(ctx context.Context, op crypto.UserOperation, ep crypto.EntryPoint) (string, error) {
+	var hash common.Hash
+	if err := c.rpc.CallContext(ctx, &hash, "eth_sendUserOperation", encodeUserOperation(op, ep.Version), ep.Address); err != nil {
+		return "", fmt.Errorf("failed to send user operation: %w", err)
+	}
+	return hash.Hex(), nil
+}
+
+// EstimateUserOperationGas returns op with the gas limits estimated by the bundler.
+func (c *Client) EstimateUserOperationGas(ctx context.Context, op crypto.UserOperation, ep crypto.EntryPoint) (*crypto.UserOperation, error) {
+	var gas struct {
+		PreVerificationGas            *hexutil.Big `json:"preVerificationGas"`
+		VerificationGasLimit          *hexutil.Big `json:"verificationGasLimit"`
+		CallGasLimit                  *hexutil.Big `json:"callGasLimit"`
+		PaymasterVerificationGasLimit *hexutil.Big `json:"paymasterVerificationGasLimit"`
+		PaymasterPostOpGasLimit       *hexutil.Big `json:"paymasterPostOpGasLimit"`
+	}
+	if err := c.rpc.CallContext(ctx, &gas, "eth_estimateUserOperationGas", encodeUserOperation(op, ep.Version), ep.Address); err != nil {
+		return nil, fmt.Errorf("failed to estimate user operation gas: %w", err)
+	}
+	if gas.PreVerificationGas == nil || gas.VerificationGasLimit == nil || gas.CallGasLimit == nil {
+		return nil, fmt.Errorf("bundler returned an incomplete gas estimate")
+	}
+
+	estimated := op
+	estimated.PreVerificationGas = gas.PreVerificationGas.ToInt()
+	estimated.VerificationGas = gas.VerificationGasLimit.ToInt()
+	estimated.CallGasLimit = gas.CallGasLimit.ToInt()
+	if gas.PaymasterVerificationGasLimit != nil {
+		estimated.PaymasterVerificationGas = gas.PaymasterVerificationGasLimit.ToInt()
+	}
+	if gas.PaymasterPostOpGasLimit != nil {
+		estimated.PaymasterPostOpGas = gas.PaymasterPostOpGasLimit.ToInt()
+	}
+	return &estimated, nil
+}
+
+// GetUserOperationReceipt returns the receipt of a user operation, or nil while it is pending.
+func (c *Client) GetUserOperationReceipt(ctx context.Context, userOpHash string) (*crypto.UserOperationReceipt, error) {
+	var raw json.RawMessage
+	if err := c.rpc.CallContext(ctx, &raw, "eth_getUserOperationReceipt", common.HexToHash(userOpHash)); err != nil {
+		return nil, fmt.Errorf("failed to get user operation receipt: %w", err)
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var r struct {
+		UserOpHash    common.Hash    `json:"userOpHash"`
+		Sender        common.Address `json:"sender"`
+		Nonce         *hexutil.Big   `json:"nonce"`
+		Success       bool           `json:"success"`
+		Reason        string         `json:"reason"`
+		ActualGasCost *hexutil.Big   `json:"actualGasCost"`
+		ActualGasUsed *hexutil.Big   `json:"actualGasUsed"`
+		Receipt       struct {
+			TransactionHash common.Hash  `json:"transactionHash"`
+			BlockNumber     *hexutil.Big `json:"blockNumber"`
+		} `json:"receipt"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode user operation receipt: %w", err)
+	}
+	return &crypto.UserOperationReceipt{
+		UserOpHash:    r.UserOpHash,
+		Sender:        r.Sender,
+		Nonce:         r.Nonce.ToInt(),
+		Success:       r.Success,
+		Reason:        r.Reason,
+		ActualGasCost: r.ActualGasCost.ToInt(),
+		ActualGasUsed: r.ActualGasUsed.ToInt(),
+		TxHash:        r.Receipt.TransactionHash,
+		BlockNumber:   r.Receipt.BlockNumber.ToInt(),
+	}, nil
+}
+
+// encodeUserOperation renders op in the bundler RPC format of the EntryPoint version: v0.6
+// takes initCode and paymasterAndData, v0.7 splits them into factory and paymaster fields.
+func encodeUserOperation(op crypto.UserOperation, version crypto.EntryPointVersion) map[string]any {
+	fields := map[string]any{
+		"sender":               op.Sender,
+		"nonce":                hexBig(op.Nonce),
+		"callData":             hexutil.Bytes(op.CallData),
+		"callGasLimit":         hexBig(op.CallGasLimit),
+		"verificationGasLimit": hexBig(op.VerificationGas),
+		"preVerificationGas":   hexBig(op.PreVerificationGas),
+		"maxFeePerGas":         hexBig(op.MaxFeePerGas),
+		"maxPriorityFeePerGas": hexBig(op.MaxPriorityFeePerGas),
+		"signature":            hexutil.Bytes(op.Signature),
+	}
+	if version == crypto.EntryPointV06 {
+		fields["initCode"] = hexutil.Bytes(op.InitCode)
+		fields["paymasterAndData"] = hexutil.Bytes(op.PaymasterAndData)
+		return fields
+	}
+
+	if len(op.InitCode) >= common.AddressLength {
+		fields["factory"] = common.BytesToAddress(op.InitCode[:common.AddressLength])
+		fields["factoryData"] = hexutil.Bytes(op.InitCode[common.AddressLength:])
+	}
+	if len(op.PaymasterAndData) >= common.AddressLength {
+		fields["paymaster"] = common.BytesToAddress(op.PaymasterAndData[:common.AddressLength])
+		fields["paymasterData"] = hexutil.Bytes(op.PaymasterAndData[common.AddressLength:])
+		fields["paymasterVerificationGasLimit"] = hexBig(op.PaymasterVerificationGas)
+		fields["paymasterPostOpGasLimit"] = hexBig(op.PaymasterPostOpGas)
+	}
+	return fields
+}
+
+func hexBig(v *big.Int) *hexutil.Big {
+	if v == nil {
+		return (*hexutil.Big)(new(big.Int))
+	}
+	return (*hexutil.Big)(v)
+}
+
+// Ensure Client implements crypto.AAProvider.
+var _ crypto.AAProvider = (*Client)(nil)
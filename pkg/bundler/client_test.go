@@ -0,0 +1,273 @@
+package bundler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// rpcUserOperation is a v0.7 user operation as bundlers receive it.
+type rpcUserOperation struct {
+	Sender               common.Address  `json:"sender"`
+	Nonce                hexutil.Big     `json:"nonce"`
+	Factory              *common.Address `json:"factory"`
+	FactoryData          hexutil.Bytes   `json:"factoryData"`
+	CallData             hexutil.Bytes   `json:"callData"`
+	CallGasLimit         hexutil.Big     `json:"callGasLimit"`
+	VerificationGasLimit hexutil.Big     `json:"verificationGasLimit"`
+	PreVerificationGas   hexutil.Big     `json:"preVerificationGas"`
+	MaxFeePerGas         hexutil.Big     `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas hexutil.Big     `json:"maxPriorityFeePerGas"`
+	Signature            hexutil.Bytes   `json:"signature"`
+
+	Paymaster                     *common.Address `json:"paymaster"`
+	PaymasterData                 hexutil.Bytes   `json:"paymasterData"`
+	PaymasterVerificationGasLimit *hexutil.Big    `json:"paymasterVerificationGasLimit"`
+	PaymasterPostOpGasLimit       *hexutil.Big    `json:"paymasterPostOpGasLimit"`
+}
+
+func (op rpcUserOperation) unpacked() *crypto.UserOperation {
+	u := &crypto.UserOperation{
+		Sender:               op.Sender,
+		Nonce:                op.Nonce.ToInt(),
+		CallData:             op.CallData,
+		CallGasLimit:         op.CallGasLimit.ToInt(),
+		VerificationGas:      op.VerificationGasLimit.ToInt(),
+		PreVerificationGas:   op.PreVerificationGas.ToInt(),
+		MaxFeePerGas:         op.MaxFeePerGas.ToInt(),
+		MaxPriorityFeePerGas: op.MaxPriorityFeePerGas.ToInt(),
+		Signature:            op.Signature,
+	}
+	if op.Factory != nil {
+		u.InitCode = append(op.Factory.Bytes(), op.FactoryData...)
+	}
+	if op.Paymaster != nil {
+		u.PaymasterAndData = append(op.Paymaster.Bytes(), op.PaymasterData...)
+		u.PaymasterVerificationGas = op.PaymasterVerificationGasLimit.ToInt()
+		u.PaymasterPostOpGas = op.PaymasterPostOpGasLimit.ToInt()
+	}
+	return u
+}
+
+// fakeBundler accepts operations from account signed by owner, appending them to sent, and
+// includes them after one receipt poll.
+func fakeBundler(t *testing.T, account, owner common.Address, chainID *big.Int, sent *[]rpcUserOperation) *chaintest.Server {
+	srv := chaintest.NewServer(chainID.Uint64())
+	t.Cleanup(srv.Close)
+
+	ep := crypto.SmartAccount{}.EntryPoint()
+	srv.Handle("eth_estimateUserOperationGas", func(params []json.RawMessage) (any, error) {
+		var op rpcUserOperation
+		json.Unmarshal(params[0], &op)
+		if len(op.Signature) != 65 {
+			return nil, errors.New("estimate needs a dummy signature")
+		}
+		return map[string]string{
+			"preVerificationGas":   "0xc350",
+			"verificationGasLimit": "0x30d40",
+			"callGasLimit":         "0x186a0",
+		}, nil
+	})
+
+	var last common.Hash
+	srv.Handle("eth_sendUserOperation", func(params []json.RawMessage) (any, error) {
+		var op rpcUserOperation
+		var entryPoint common.Address
+		json.Unmarshal(params[0], &op)
+		json.Unmarshal(params[1], &entryPoint)
+		if entryPoint != ep.Address || op.Sender != account {
+			return nil, errors.New("unexpected sender or entry point")
+		}
+		if op.CallGasLimit.ToInt().Uint64() != 100_000 {
+			return nil, errors.New("gas limits were not estimated")
+		}
+		hash, err := ep.UserOpHash(op.unpacked(), chainID)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := crypto.RecoverSigner(accounts.TextHash(hash.Bytes()), hexutil.Encode(op.Signature))
+		if err != nil || signer != owner {
+			return nil, errors.New("AA24 signature error")
+		}
+		last = hash
+		*sent = append(*sent, op)
+		return hash, nil
+	})
+
+	polls := 0
+	srv.Handle("eth_getUserOperationReceipt", func(params []json.RawMessage) (any, error) {
+		if polls++; polls == 1 {
+			return nil, nil
+		}
+		return map[string]any{
+			"userOpHash":    last,
+			"sender":        account,
+			"nonce":         "0x5",
+			"success":       true,
+			"actualGasCost": "0x2386f26fc10000",
+			"actualGasUsed": "0x249f0",
+			"receipt":       map[string]any{"transactionHash": common.HexToHash("0xbeef"), "blockNumber": "0x10"},
+		}, nil
+	})
+	return srv
+}
+
+// fakeChain serves the EntryPoint nonce of an account without code.
+func fakeChain(t *testing.T, chainID *big.Int) *chaintest.Server {
+	srv := chaintest.NewServer(chainID.Uint64())
+	t.Cleanup(srv.Close)
+	srv.Handle("eth_getCode", chaintest.Result("0x"))
+	srv.Handle("eth_call", chaintest.Result(hexutil.Encode(common.LeftPadBytes([]byte{5}, 32))))
+	return srv
+}
+
+func TestTransactionManager_BroadcastUserOperation(t *testing.T) {
+	ctx := context.Background()
+	chainID := big.NewInt(31340)
+	key, _ := gethcrypto.GenerateKey()
+	signer, _ := crypto.NewSessionKeySigner(hexutil.Encode(gethcrypto.FromECDSA(key))[2:], chainID)
+	account := common.HexToAddress("0x4337433743374337433743374337433743374337")
+
+	var sent []rpcUserOperation
+	bundlerSrv := fakeBundler(t, account, signer.Address(), chainID, &sent)
+	chainSrv := fakeChain(t, chainID)
+
+	client, err := Dial(ctx, bundlerSrv.URL)
+	if err != nil {
+		t.Fatalf("dial bundler: %v", err)
+	}
+	defer client.Close()
+	eth, err := ethclient.Dial(chainSrv.URL)
+	if err != nil {
+		t.Fatalf("dial chain: %v", err)
+	}
+
+	factory := common.HexToAddress("0x9406Cc6185a346906296840746125a0E44976454")
+	manager := crypto.NewTransactionManager(eth, client).WithSmartAccount(crypto.SmartAccount{
+		Address:  account,
+		Owner:    signer.Address(),
+		InitCode: append(factory.Bytes(), 0x5f, 0xbf, 0xb9, 0xcf),
+	}, signer)
+
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	tx := types.NewTx(&types.DynamicFeeTx{To: &to, Value: big.NewInt(1), GasFeeCap: big.NewInt(2e9), GasTipCap: big.NewInt(1e6)})
+	if err := manager.Broadcast(ctx, tx); err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+	if bundlerSrv.Calls("eth_sendUserOperation") != 1 {
+		t.Fatalf("expected one user operation, got %d", bundlerSrv.Calls("eth_sendUserOperation"))
+	}
+
+	receipt, err := crypto.WaitForUserOperation(ctx, client, "0x01", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if !receipt.Success || receipt.TxHash != common.HexToHash("0xbeef") || receipt.BlockNumber.Uint64() != 16 {
+		t.Errorf("unexpected receipt %+v", receipt)
+	}
+	if bundlerSrv.Calls("eth_getUserOperationReceipt") != 2 {
+		t.Errorf("expected a pending poll before the receipt, got %d polls", bundlerSrv.Calls("eth_getUserOperationReceipt"))
+	}
+}
+
+func TestTransactionManager_ConcurrentUserOperations(t *testing.T) {
+	ctx := context.Background()
+	chainID := big.NewInt(31342)
+	key, _ := gethcrypto.GenerateKey()
+	signer, _ := crypto.NewSessionKeySigner(hexutil.Encode(gethcrypto.FromECDSA(key))[2:], chainID)
+	account := common.HexToAddress("0x4337433743374337433743374337433743374337")
+
+	bundlerSrv := fakeBundler(t, account, signer.Address(), chainID, nil)
+	var mu sync.Mutex
+	nonces := make(map[uint64]bool)
+	rejectNext := true
+	bundlerSrv.Handle("eth_sendUserOperation", func(params []json.RawMessage) (any, error) {
+		var op rpcUserOperation
+		json.Unmarshal(params[0], &op)
+		mu.Lock()
+		defer mu.Unlock()
+		if rejectNext {
+			rejectNext = false
+			return nil, errors.New("AA21 didn't pay prefund")
+		}
+		nonces[op.Nonce.ToInt().Uint64()] = true
+		return common.BigToHash(op.Nonce.ToInt()), nil
+	})
+	chainSrv := fakeChain(t, chainID) // getNonce answers 5 until an operation is mined
+
+	client, err := Dial(ctx, bundlerSrv.URL)
+	if err != nil {
+		t.Fatalf("dial bundler: %v", err)
+	}
+	defer client.Close()
+	eth, err := ethclient.Dial(chainSrv.URL)
+	if err != nil {
+		t.Fatalf("dial chain: %v", err)
+	}
+	manager := crypto.NewTransactionManager(eth, client).WithSmartAccount(crypto.SmartAccount{Address: account}, signer)
+
+	// An operation the bundler refused leaves its nonce to the next one.
+	if _, err := manager.SendUserOperation(ctx, crypto.Call{To: common.HexToAddress("0xdead")}); err == nil {
+		t.Fatal("expected the first operation to be refused")
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.SendUserOperation(ctx, crypto.Call{To: common.HexToAddress("0xdead")})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(5); i < 5+n; i++ {
+		if !nonces[i] {
+			t.Errorf("expected nonce %d to be used, got %v", i, nonces)
+		}
+	}
+}
+
+func TestEncodeUserOperation(t *testing.T) {
+	op := crypto.UserOperation{
+		InitCode:         common.FromHex("0x2222222222222222222222222222222222222222abcd"),
+		PaymasterAndData: common.FromHex("0x3333333333333333333333333333333333333333beef"),
+	}
+
+	v06 := encodeUserOperation(op, crypto.EntryPointV06)
+	if _, ok := v06["factory"]; ok || v06["initCode"] == nil {
+		t.Errorf("v0.6 operation should carry initCode: %v", v06)
+	}
+
+	v07 := encodeUserOperation(op, crypto.EntryPointV07)
+	if v07["factory"] != common.HexToAddress("0x2222222222222222222222222222222222222222") {
+		t.Errorf("factory = %v", v07["factory"])
+	}
+	if data := v07["paymasterData"].(hexutil.Bytes); data.String() != "0xbeef" {
+		t.Errorf("paymasterData = %s", data)
+	}
+	if _, ok := v07["initCode"]; ok {
+		t.Error("v0.7 operation should not carry initCode")
+	}
+}
@@ -0,0 +1,90 @@
+package bundler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// PaymasterClient requests gas sponsorship with pm_sponsorUserOperation. It implements
+// crypto.Paymaster.
+type PaymasterClient struct {
+	rpc    *rpc.Client
+	policy map[string]any
+}
+
+// DialPaymaster connects to the paymaster service at rawURL. policy is passed as the
+// sponsorship context, e.g. {"sponsorshipPolicyId": "..."}, and may be nil.
+func DialPaymaster(ctx context.Context, rawURL string, policy map[string]any) (*PaymasterClient, error) {
+	c, err := rpc.DialContext(ctx, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial paymaster: %w", err)
+	}
+	return &PaymasterClient{rpc: c, policy: policy}, nil
+}
+
+// Close closes the underlying connection.
+func (c *PaymasterClient) Close() {
+	c.rpc.Close()
+}
+
+// SponsorUserOperation returns op with paymaster data and the paymaster's gas limits. An error
+// answered by the paymaster is a denial and wraps crypto.ErrSponsorshipDenied.
+func (c *PaymasterClient) SponsorUserOperation(ctx context.Context, op crypto.UserOperation, ep crypto.EntryPoint) (*crypto.UserOperation, error) {
+	args := []any{encodeUserOperation(op, ep.Version), ep.Address}
+	if c.policy != nil {
+		args = append(args, c.policy)
+	}
+
+	var resp struct {
+		PaymasterAndData              hexutil.Bytes   `json:"paymasterAndData"` // v0.6
+		Paymaster                     *common.Address `json:"paymaster"`        // v0.7
+		PaymasterData                 hexutil.Bytes   `json:"paymasterData"`
+		PaymasterVerificationGasLimit *hexutil.Big    `json:"paymasterVerificationGasLimit"`
+		PaymasterPostOpGasLimit       *hexutil.Big    `json:"paymasterPostOpGasLimit"`
+		PreVerificationGas            *hexutil.Big    `json:"preVerificationGas"`
+		VerificationGasLimit          *hexutil.Big    `json:"verificationGasLimit"`
+		CallGasLimit                  *hexutil.Big    `json:"callGasLimit"`
+	}
+	if err := c.rpc.CallContext(ctx, &resp, "pm_sponsorUserOperation", args...); err != nil {
+		var rpcErr rpc.Error
+		if errors.As(err, &rpcErr) {
+			return nil, fmt.Errorf("%w: %v", crypto.ErrSponsorshipDenied, err)
+		}
+		return nil, fmt.Errorf("failed to request sponsorship: %w", err)
+	}
+
+	sponsored := op
+	switch {
+	case resp.Paymaster != nil:
+		sponsored.PaymasterAndData = append(resp.Paymaster.Bytes(), resp.PaymasterData...)
+	case len(resp.PaymasterAndData) >= common.AddressLength:
+		sponsored.PaymasterAndData = resp.PaymasterAndData
+	default:
+		return nil, fmt.Errorf("%w: paymaster returned no paymaster data", crypto.ErrSponsorshipDenied)
+	}
+	if resp.PaymasterVerificationGasLimit != nil {
+		sponsored.PaymasterVerificationGas = resp.PaymasterVerificationGasLimit.ToInt()
+	}
+	if resp.PaymasterPostOpGasLimit != nil {
+		sponsored.PaymasterPostOpGas = resp.PaymasterPostOpGasLimit.ToInt()
+	}
+	if resp.PreVerificationGas != nil {
+		sponsored.PreVerificationGas = resp.PreVerificationGas.ToInt()
+	}
+	if resp.VerificationGasLimit != nil {
+		sponsored.VerificationGas = resp.VerificationGasLimit.ToInt()
+	}
+	if resp.CallGasLimit != nil {
+		sponsored.CallGasLimit = resp.CallGasLimit.ToInt()
+	}
+	return &sponsored, nil
+}
+
+// Ensure PaymasterClient implements crypto.Paymaster.
+var _ crypto.Paymaster = (*PaymasterClient)(nil)
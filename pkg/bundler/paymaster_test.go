@@ -0,0 +1,152 @@
+package bundler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	gethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+var testPaymaster = common.HexToAddress("0x00000000000000fB866DaAA79352cC568a005D96")
+
+// fakePaymaster sponsors operations with fixed paymaster data until deny is set.
+func fakePaymaster(t *testing.T, deny *bool) *chaintest.Server {
+	srv := chaintest.NewServer(31341)
+	t.Cleanup(srv.Close)
+	srv.Handle("pm_sponsorUserOperation", func(params []json.RawMessage) (any, error) {
+		if len(params) != 3 {
+			return nil, errors.New("expected an operation, entry point and context")
+		}
+		if *deny {
+			return nil, errors.New("sponsorship policy rejected the operation")
+		}
+		return map[string]any{
+			"paymaster":                     testPaymaster,
+			"paymasterData":                 "0xc0ffee",
+			"paymasterVerificationGasLimit": "0x7530",
+			"paymasterPostOpGasLimit":       "0x2710",
+		}, nil
+	})
+	return srv
+}
+
+func TestTransactionManager_Paymaster(t *testing.T) {
+	ctx := context.Background()
+	chainID := big.NewInt(31341)
+	key, _ := gethcrypto.GenerateKey()
+	signer, _ := crypto.NewSessionKeySigner(hexutil.Encode(gethcrypto.FromECDSA(key))[2:], chainID)
+	account := common.HexToAddress("0x4337433743374337433743374337433743374337")
+
+	var sent []rpcUserOperation
+	bundlerSrv := fakeBundler(t, account, signer.Address(), chainID, &sent)
+	chainSrv := fakeChain(t, chainID)
+	deny := false
+	pmSrv := fakePaymaster(t, &deny)
+
+	client, err := Dial(ctx, bundlerSrv.URL)
+	if err != nil {
+		t.Fatalf("dial bundler: %v", err)
+	}
+	defer client.Close()
+	pm, err := DialPaymaster(ctx, pmSrv.URL, map[string]any{"sponsorshipPolicyId": "harvests"})
+	if err != nil {
+		t.Fatalf("dial paymaster: %v", err)
+	}
+	defer pm.Close()
+	eth, err := ethclient.Dial(chainSrv.URL)
+	if err != nil {
+		t.Fatalf("dial chain: %v", err)
+	}
+
+	// Estimated gas is 350000, plus 40000 for the paymaster, at 2 gwei.
+	perOp := big.NewInt(390_000 * 2e9)
+	budget := crypto.NewSponsorshipBudget(map[string]*big.Int{crypto.OperationHarvest: perOp}, 0)
+	manager := crypto.NewTransactionManager(eth, client).
+		WithSmartAccount(crypto.SmartAccount{Address: account, Paymaster: testPaymaster}, signer).
+		WithPaymaster(pm, budget)
+
+	call := func(operation string) rpcUserOperation {
+		t.Helper()
+		_, err := manager.SendUserOperation(ctx, crypto.Call{
+			Operation:            operation,
+			To:                   common.HexToAddress("0xdead"),
+			Data:                 common.FromHex("0x4641257d"),
+			MaxFeePerGas:         big.NewInt(2e9),
+			MaxPriorityFeePerGas: big.NewInt(1e6),
+		})
+		if err != nil {
+			t.Fatalf("send %s: %v", operation, err)
+		}
+		return sent[len(sent)-1]
+	}
+
+	// 1. Sponsored within budget, with the signature covering the paymaster data.
+	op := call(crypto.OperationHarvest)
+	if op.Paymaster == nil || *op.Paymaster != testPaymaster || op.PaymasterData.String() != "0xc0ffee" {
+		t.Fatalf("expected a sponsored operation, got paymaster %v", op.Paymaster)
+	}
+	if remaining := budget.Remaining(crypto.OperationHarvest); remaining.Sign() != 0 {
+		t.Errorf("expected the budget to be spent, %s left", remaining)
+	}
+
+	// 2. Budget exhausted: paid by the account without asking the paymaster.
+	calls := pmSrv.Calls("pm_sponsorUserOperation")
+	if op := call(crypto.OperationHarvest); op.Paymaster != nil {
+		t.Error("expected a self-paid operation once the budget is spent")
+	}
+	if pmSrv.Calls("pm_sponsorUserOperation") != calls {
+		t.Error("paymaster should not be asked once the budget is spent")
+	}
+
+	// 3. Operation types without a budget are never sponsored.
+	if op := call(crypto.OperationDeposit); op.Paymaster != nil {
+		t.Error("expected deposits without a budget to be self-paid")
+	}
+
+	// 4. Denied sponsorship falls back to self-paid gas and refunds nothing it did not take.
+	budget.Release(crypto.OperationHarvest, perOp)
+	deny = true
+	if op := call(crypto.OperationHarvest); op.Paymaster != nil {
+		t.Error("expected a self-paid operation after the paymaster denied it")
+	}
+	if remaining := budget.Remaining(crypto.OperationHarvest); remaining.Cmp(perOp) != 0 {
+		t.Errorf("denied sponsorship should not spend the budget, %s left", remaining)
+	}
+}
+
+func TestTransactionManager_UnexpectedPaymaster(t *testing.T) {
+	ctx := context.Background()
+	chainID := big.NewInt(31341)
+	key, _ := gethcrypto.GenerateKey()
+	signer, _ := crypto.NewSessionKeySigner(hexutil.Encode(gethcrypto.FromECDSA(key))[2:], chainID)
+	account := common.HexToAddress("0x4337433743374337433743374337433743374337")
+
+	var sent []rpcUserOperation
+	bundlerSrv := fakeBundler(t, account, signer.Address(), chainID, &sent)
+	deny := false
+	pmSrv := fakePaymaster(t, &deny)
+	client, _ := Dial(ctx, bundlerSrv.URL)
+	defer client.Close()
+	pm, _ := DialPaymaster(ctx, pmSrv.URL, map[string]any{})
+	defer pm.Close()
+	eth, _ := ethclient.Dial(fakeChain(t, chainID).URL)
+
+	manager := crypto.NewTransactionManager(eth, client).
+		WithSmartAccount(crypto.SmartAccount{Address: account, Paymaster: common.HexToAddress("0x1234")}, signer).
+		WithPaymaster(pm, nil)
+	_, err := manager.SendUserOperation(ctx, crypto.Call{Operation: crypto.OperationHarvest, To: common.HexToAddress("0xdead")})
+	if err == nil {
+		t.Fatal("expected sponsorship by another paymaster to be refused")
+	}
+	if len(sent) != 0 {
+		t.Error("no operation should have been sent")
+	}
+}
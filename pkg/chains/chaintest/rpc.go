@@ -0,0 +1,138 @@
+// Package chaintest provides a fake Ethereum JSON-RPC endpoint for tests.
+package chaintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// BaseFee is the base fee of the fake chain's latest block.
+const BaseFee = 1_000_000_000
+
+// Handler answers one JSON-RPC method. A returned error becomes a JSON-RPC error.
+type Handler func(params []json.RawMessage) (any, error)
+
+// Server is a JSON-RPC server that answers registered methods and records every call.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	calls    map[string]int
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewServer starts a server answering eth_chainId, eth_gasPrice, eth_maxPriorityFeePerGas,
+// eth_getTransactionCount, eth_blockNumber, eth_getBlockByNumber, eth_estimateGas and
+// eth_getCode with fixed values. Close it when done.
+func NewServer(chainID uint64) *Server {
+	s := &Server{
+		handlers: make(map[string]Handler),
+		calls:    make(map[string]int),
+	}
+	s.Handle("eth_chainId", Result(hexutil.EncodeUint64(chainID)))
+	s.Handle("eth_gasPrice", Result(hexutil.EncodeBig(big.NewInt(1_000_000_000))))
+	s.Handle("eth_maxPriorityFeePerGas", Result(hexutil.EncodeBig(big.NewInt(1_000_000))))
+	s.Handle("eth_getTransactionCount", Result("0x0"))
+	s.Handle("eth_blockNumber", Result("0x1"))
+	s.Handle("eth_getBlockByNumber", Result(&types.Header{
+		Number:     big.NewInt(1),
+		Difficulty: new(big.Int),
+		GasLimit:   30_000_000,
+		BaseFee:    big.NewInt(BaseFee),
+	}))
+	s.Handle("eth_estimateGas", Result("0x30d40"))
+	s.Handle("eth_getCode", Result("0x6001"))
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Handle registers or replaces the handler for a method.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// Calls returns how many times a method was called.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// Result returns a handler that always answers with v.
+func Result(v any) Handler {
+	return func([]json.RawMessage) (any, error) { return v, nil }
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(raw) > 0 && raw[0] == '[' {
+		var batch []request
+		json.Unmarshal(raw, &batch)
+		responses := make([]response, len(batch))
+		for i, req := range batch {
+			responses[i] = s.dispatch(req)
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+
+	var req request
+	json.Unmarshal(raw, &req)
+	json.NewEncoder(w).Encode(s.dispatch(req))
+}
+
+func (s *Server) dispatch(req request) response {
+	s.mu.Lock()
+	s.calls[req.Method]++
+	h, ok := s.handlers[req.Method]
+	s.mu.Unlock()
+
+	resp := response{JSONRPC: "2.0", ID: req.ID}
+	if !ok {
+		resp.Error = &rpcError{Code: -32601, Message: fmt.Sprintf("method %s not found", req.Method)}
+		return resp
+	}
+	result, err := h(req.Params)
+	if err != nil {
+		resp.Error = &rpcError{Code: -32000, Message: err.Error()}
+		return resp
+	}
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	resp.Result = result
+	return resp
+}
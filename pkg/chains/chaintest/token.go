@@ -0,0 +1,166 @@
+package chaintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+)
+
+// token is the state of a fake EIP-3009 token: which authorizations were used and the
+// logs of every mined transaction.
+type token struct {
+	balance *big.Int
+
+	mu   sync.Mutex
+	used map[[2]common.Hash]bool
+	logs map[common.Hash][]*types.Log
+}
+
+// ServeToken makes the server behave like an EIP-3009 token that every holder holds balance of,
+// and has approved every spender to transfer.
+// A transferWithAuthorization, sent directly or through Multicall3 aggregate3, succeeds when its
+// value is within balance and its nonce is unused, and emits AuthorizationUsed. Every sent
+// transaction is mined and delivered on the returned channel, which buffers up to 16.
+func (s *Server) ServeToken(balance *big.Int) <-chan *types.Transaction {
+	t := &token{
+		balance: new(big.Int).Set(balance),
+		used:    make(map[[2]common.Hash]bool),
+		logs:    make(map[common.Hash][]*types.Log),
+	}
+
+	s.Handle("eth_call", func(params []json.RawMessage) (any, error) {
+		var call struct {
+			Input hexutil.Bytes `json:"input"`
+			Data  hexutil.Bytes `json:"data"`
+		}
+		json.Unmarshal(params[0], &call)
+		input := call.Input
+		if len(input) == 0 {
+			input = call.Data
+		}
+		if len(input) < 4 {
+			return nil, fmt.Errorf("call without a selector")
+		}
+		method, err := chains.TokenABI.MethodById(input[:4])
+		if err != nil {
+			return nil, err
+		}
+		args, err := method.Inputs.Unpack(input[4:])
+		if err != nil {
+			return nil, err
+		}
+		switch method.Name {
+		case "balanceOf", "allowance":
+			return hexutil.Encode(math.U256Bytes(new(big.Int).Set(t.balance))), nil
+		case "authorizationState":
+			t.mu.Lock()
+			used := t.used[[2]common.Hash{common.BytesToHash(args[0].(common.Address).Bytes()), args[1].([32]byte)}]
+			t.mu.Unlock()
+			state := make([]byte, 32)
+			if used {
+				state[31] = 1
+			}
+			return hexutil.Encode(state), nil
+		}
+		return nil, fmt.Errorf("unexpected call to %s", method.Name)
+	})
+
+	sent := make(chan *types.Transaction, 16)
+	s.Handle("eth_sendRawTransaction", func(params []json.RawMessage) (any, error) {
+		var raw hexutil.Bytes
+		json.Unmarshal(params[0], &raw)
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return nil, err
+		}
+		t.execute(tx)
+		sent <- tx
+		return tx.Hash().Hex(), nil
+	})
+	s.Handle("eth_getTransactionReceipt", func(params []json.RawMessage) (any, error) {
+		var hash common.Hash
+		json.Unmarshal(params[0], &hash)
+		t.mu.Lock()
+		logs := t.logs[hash]
+		t.mu.Unlock()
+		if logs == nil {
+			logs = []*types.Log{}
+		}
+		return map[string]any{
+			"transactionHash":   hash,
+			"transactionIndex":  "0x0",
+			"blockHash":         common.Hash{1},
+			"blockNumber":       "0x2",
+			"status":            "0x1",
+			"cumulativeGasUsed": "0x5208",
+			"gasUsed":           "0x5208",
+			"effectiveGasPrice": "0x1",
+			"logsBloom":         hexutil.Encode(make([]byte, 256)),
+			"logs":              logs,
+			"type":              "0x0",
+		}, nil
+	})
+	return sent
+}
+
+// execute applies the authorizations in a transaction and records the logs they emit.
+func (t *token) execute(tx *types.Transaction) {
+	type call struct {
+		target common.Address
+		data   []byte
+	}
+	var calls []call
+	if data := tx.Data(); len(data) >= 4 {
+		if method, err := chains.Multicall3ABI.MethodById(data[:4]); err == nil && method.Name == "aggregate3" {
+			var batch []chains.Call3
+			if args, err := method.Inputs.Unpack(data[4:]); err == nil {
+				method.Inputs.Copy(&batch, args)
+			}
+			for _, c := range batch {
+				calls = append(calls, call{c.Target, c.CallData})
+			}
+		} else {
+			calls = append(calls, call{*tx.To(), data})
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	logs := []*types.Log{}
+	used := chains.TokenABI.Events["AuthorizationUsed"].ID
+	for _, c := range calls {
+		if len(c.data) < 4 {
+			continue
+		}
+		method, err := chains.TokenABI.MethodById(c.data[:4])
+		if err != nil || method.Name != "transferWithAuthorization" {
+			continue
+		}
+		args, err := method.Inputs.Unpack(c.data[4:])
+		if err != nil {
+			continue
+		}
+		key := [2]common.Hash{common.BytesToHash(args[0].(common.Address).Bytes()), args[5].([32]byte)}
+		if t.used[key] || args[2].(*big.Int).Cmp(t.balance) > 0 {
+			continue
+		}
+		t.used[key] = true
+		logs = append(logs, &types.Log{
+			Address:     c.target,
+			Topics:      []common.Hash{used, key[0], key[1]},
+			Data:        []byte{},
+			TxHash:      tx.Hash(),
+			BlockHash:   common.Hash{1},
+			BlockNumber: 2,
+			Index:       uint(len(logs)),
+		})
+	}
+	t.logs[tx.Hash()] = logs
+}
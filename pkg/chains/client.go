@@ -14,13 +14,15 @@ import (
 
 // MultiClient manages multiple RPC clients for different chains.
 type MultiClient struct {
-	clients map[ChainID]*ethclient.Client
-	mu      sync.RWMutex
+	clients   map[ChainID]*ethclient.Client
+	wsClients map[ChainID]*ethclient.Client // Subscriptions, for chains with a WSURL
+	mu        sync.RWMutex
 }
 
 func NewMultiClient() *MultiClient {
 	return &MultiClient{
-		clients: make(map[ChainID]*ethclient.Client),
+		clients:   make(map[ChainID]*ethclient.Client),
+		wsClients: make(map[ChainID]*ethclient.Client),
 	}
 }
 
@@ -103,7 +105,11 @@ func (mc *MultiClient) Close() {
 	for _, client := range mc.clients {
 		client.Close()
 	}
+	for _, client := range mc.wsClients {
+		client.Close()
+	}
 	mc.clients = make(map[ChainID]*ethclient.Client)
+	mc.wsClients = make(map[ChainID]*ethclient.Client)
 }
 
 // Ensure implementation of model.BlockchainClient.
@@ -2,82 +2,127 @@ package chains
 
 import (
 	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ChainID uint64
 
 const (
-	ChainIDEthereum      ChainID = 1
-	ChainIDBase          ChainID = 8453
-	ChainIDCronos        ChainID = 25
-	ChainIDAvalanche     ChainID = 43114
-	ChainIDPolygon       ChainID = 137
-	ChainIDBaseSepolia   ChainID = 84532
-	ChainIDCronoszkEVM   ChainID = 240
-	ChainIDBSC           ChainID = 56
-	ChainIDBSCTestnet    ChainID = 97
+	ChainIDEthereum    ChainID = 1
+	ChainIDBase        ChainID = 8453
+	ChainIDCronos      ChainID = 25
+	ChainIDAvalanche   ChainID = 43114
+	ChainIDPolygon     ChainID = 137
+	ChainIDBaseSepolia ChainID = 84532
+	ChainIDCronoszkEVM ChainID = 240
+	ChainIDBSC         ChainID = 56
+	ChainIDBSCTestnet  ChainID = 97
 )
 
 type ChainConfig struct {
 	Name               string
+	Network            string // x402 network slug, e.g. "base-sepolia"
 	ChainID            ChainID
 	RPCURL             string
-	FacilitatorAddress string
+	WSURL              string // Optional WebSocket endpoint for subscriptions
+	FacilitatorAddress string // EIP-712 verifying contract of payments on this chain
 	USDCAddress        string
 	USDTAddress        string
 	BUSDAddress        string
 	ExplorerURL        string
+	MulticallAddress   string // Defaults to Multicall3Address
+
+	Confirmations uint64        // Blocks on top of a transaction before it is final; 0 trusts the receipt
+	BlockTime     time.Duration // Expected block interval; 0 if unknown
+
+	// EIP-1559 fee bounds for transactions the engine sends; nil leaves them to the node.
+	MinPriorityFee *big.Int // Tips below this are not picked up by validators
+	MaxFeePerGas   *big.Int // Never pay more than this per gas
+
+	// TokenDomains holds the EIP-712 domain of tokens that support EIP-3009, by symbol.
+	TokenDomains map[string]TokenDomain
+}
+
+// TokenDomain is the EIP-712 name and version a token signs authorizations under.
+type TokenDomain struct {
+	Name    string
+	Version string
 }
 
 func init() {
 	RegisterChain(ChainConfig{
-		Name:        "Base",
-		ChainID:     ChainIDBase,
-		RPCURL:      "https://mainnet.base.org",
-		USDCAddress: "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913",
-		ExplorerURL: "https://basescan.org",
+		Name:         "Base",
+		Network:      "base",
+		ChainID:      ChainIDBase,
+		RPCURL:       "https://mainnet.base.org",
+		BlockTime:    2 * time.Second,
+		USDCAddress:  "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913",
+		ExplorerURL:  "https://basescan.org",
+		TokenDomains: map[string]TokenDomain{"USDC": {Name: "USD Coin", Version: "2"}},
 	})
 	RegisterChain(ChainConfig{
 		Name:        "BSC",
+		Network:     "bsc",
 		ChainID:     ChainIDBSC,
 		RPCURL:      "https://bsc-dataseed.binance.org/",
+		BlockTime:   750 * time.Millisecond,
 		USDTAddress: "0x55d398326f99059fF775485246999027B3197955",
 		BUSDAddress: "0xe9e7CEA3DedcA5984780Bafc599bD69ADd087D56",
 		ExplorerURL: "https://bscscan.com",
+		// BSC has no base fee; validators order by tip above their minimum gas price.
+		MinPriorityFee: big.NewInt(100_000_000),
 	})
 	RegisterChain(ChainConfig{
 		Name:        "BSC Testnet",
+		Network:     "bsc-testnet",
 		ChainID:     ChainIDBSCTestnet,
 		RPCURL:      "https://data-seed-prebsc-1-s1.binance.org:8545/",
+		BlockTime:   750 * time.Millisecond,
 		ExplorerURL: "https://testnet.bscscan.com",
 	})
 	RegisterChain(ChainConfig{
-		Name:        "Base Sepolia",
-		ChainID:     ChainIDBaseSepolia,
-		RPCURL:      "https://sepolia.base.org",
-		USDCAddress: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
-		ExplorerURL: "https://sepolia.basescan.org",
+		Name:         "Base Sepolia",
+		Network:      "base-sepolia",
+		ChainID:      ChainIDBaseSepolia,
+		RPCURL:       "https://sepolia.base.org",
+		BlockTime:    2 * time.Second,
+		USDCAddress:  "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+		ExplorerURL:  "https://sepolia.basescan.org",
+		TokenDomains: map[string]TokenDomain{"USDC": {Name: "USDC", Version: "2"}},
 	})
 	RegisterChain(ChainConfig{
 		Name:        "Cronos zkEVM Testnet",
+		Network:     "cronos-zkevm-testnet",
 		ChainID:     ChainIDCronoszkEVM,
 		RPCURL:      "https://cronos-zkevm-testnet.drpc.org",
 		USDCAddress: "0xaa5b845F8C9c047779bEDf64829601d8B264076c",
 		ExplorerURL: "https://explorer.zkevm.cronos.org/testnet/",
 	})
 	RegisterChain(ChainConfig{
-		Name:        "Avalanche",
-		ChainID:     ChainIDAvalanche,
-		RPCURL:      "https://api.avax.network/ext/bc/C/rpc",
-		USDCAddress: "0xB97EF9Ef8734C71904D8002F8b6Bc66Dd9c48a6E",
-		ExplorerURL: "https://snowtrace.io",
+		Name:         "Avalanche",
+		Network:      "avalanche",
+		ChainID:      ChainIDAvalanche,
+		RPCURL:       "https://api.avax.network/ext/bc/C/rpc",
+		BlockTime:    2 * time.Second,
+		USDCAddress:  "0xB97EF9Ef8734C71904D8002F8b6Bc66Dd9c48a6E",
+		ExplorerURL:  "https://snowtrace.io",
+		TokenDomains: map[string]TokenDomain{"USDC": {Name: "USD Coin", Version: "2"}},
 	})
 	RegisterChain(ChainConfig{
-		Name:        "Polygon",
-		ChainID:     ChainIDPolygon,
-		RPCURL:      "https://polygon-rpc.com",
-		USDCAddress: "0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359", // Native USDC
-		ExplorerURL: "https://polygonscan.com",
+		Name:         "Polygon",
+		Network:      "polygon",
+		ChainID:      ChainIDPolygon,
+		RPCURL:       "https://polygon-rpc.com",
+		BlockTime:    2 * time.Second,
+		USDCAddress:  "0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359", // Native USDC
+		ExplorerURL:  "https://polygonscan.com",
+		TokenDomains: map[string]TokenDomain{"USDC": {Name: "USD Coin", Version: "2"}},
+		// Polygon PoS rejects tips under 25 gwei.
+		MinPriorityFee: big.NewInt(25_000_000_000),
 	})
 }
 
@@ -94,3 +139,65 @@ func GetChainConfig(id ChainID) (ChainConfig, error) {
 	}
 	return cfg, nil
 }
+
+// Chains returns every registered chain, ordered by chain ID.
+func Chains() []ChainConfig {
+	list := make([]ChainConfig, 0, len(registry))
+	for _, cfg := range registry {
+		list = append(list, cfg)
+	}
+	sortChains(list)
+	return list
+}
+
+func sortChains(list []ChainConfig) {
+	sort.Slice(list, func(i, j int) bool { return list[i].ChainID < list[j].ChainID })
+}
+
+// LookupChain finds a chain by ID or by name, ignoring case, spaces and dashes ("base-sepolia").
+func LookupChain(nameOrID string) (ChainConfig, error) {
+	if id, err := strconv.ParseUint(nameOrID, 10, 64); err == nil {
+		return GetChainConfig(ChainID(id))
+	}
+	want := simplifyName(nameOrID)
+	for _, cfg := range registry {
+		if simplifyName(cfg.Name) == want || simplifyName(cfg.Network) == want {
+			return cfg, nil
+		}
+	}
+	return ChainConfig{}, fmt.Errorf("chain %q not supported", nameOrID)
+}
+
+// TokenAddress returns the address of a known token on this chain by symbol.
+func (c ChainConfig) TokenAddress(symbol string) (string, bool) {
+	var addr string
+	switch strings.ToUpper(symbol) {
+	case "USDC":
+		addr = c.USDCAddress
+	case "USDT":
+		addr = c.USDTAddress
+	case "BUSD":
+		addr = c.BUSDAddress
+	}
+	return addr, addr != ""
+}
+
+// EIP3009Domain returns the EIP-712 domain of a token that supports transferWithAuthorization.
+func (c ChainConfig) EIP3009Domain(symbol string) (TokenDomain, bool) {
+	d, ok := c.TokenDomains[strings.ToUpper(symbol)]
+	return d, ok
+}
+
+// SymbolOf returns the symbol of a known token address on this chain.
+func (c ChainConfig) SymbolOf(asset string) (string, bool) {
+	for _, symbol := range []string{"USDC", "USDT", "BUSD"} {
+		if addr, ok := c.TokenAddress(symbol); ok && strings.EqualFold(addr, asset) {
+			return symbol, true
+		}
+	}
+	return "", false
+}
+
+func simplifyName(name string) string {
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(name))
+}
@@ -0,0 +1,136 @@
+package chains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// DefaultPollInterval is how often a chain without a known block time is polled.
+const DefaultPollInterval = 2 * time.Second
+
+// PollInterval returns how often to poll the chain for new blocks: its block time, or
+// DefaultPollInterval when unknown.
+func (c ChainConfig) PollInterval() time.Duration {
+	if c.BlockTime > 0 {
+		return c.BlockTime
+	}
+	return DefaultPollInterval
+}
+
+// Final reports whether a receipt has confirmations blocks on top of it. Once deep enough the
+// receipt is looked up again, so a transaction a reorg moved or dropped is not reported final.
+// It returns the receipt as last seen, or nil if the transaction is no longer in the chain.
+func Final(ctx context.Context, client *ethclient.Client, receipt *types.Receipt, confirmations uint64) (*types.Receipt, bool, error) {
+	if confirmations == 0 {
+		return receipt, true, nil
+	}
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return receipt, false, fmt.Errorf("failed to get latest block: %w", err)
+	}
+	if head < receipt.BlockNumber.Uint64()+confirmations {
+		return receipt, false, nil
+	}
+
+	current, err := client.TransactionReceipt(ctx, receipt.TxHash)
+	switch {
+	case errors.Is(err, ethereum.NotFound):
+		return nil, false, nil
+	case err != nil:
+		return receipt, false, fmt.Errorf("failed to get receipt of %s: %w", receipt.TxHash.Hex(), err)
+	case current.BlockHash != receipt.BlockHash:
+		// Re-mined in another block; its depth counts from there.
+		return current, false, nil
+	}
+	return current, true, nil
+}
+
+// WaitReceipt waits until a transaction is mined on chain id with the chain's Confirmations on
+// top of it, and returns its receipt. It checks on every new head when the chain has a
+// WebSocket endpoint, and every block time otherwise.
+func (mc *MultiClient) WaitReceipt(ctx context.Context, id ChainID, hash common.Hash) (*types.Receipt, error) {
+	cfg, err := GetChainConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mc.GetClient(id)
+	if err != nil {
+		return nil, err
+	}
+	heads, unsubscribe := mc.subscribeHeads(ctx, cfg)
+	defer unsubscribe()
+	ticker := time.NewTicker(cfg.PollInterval())
+	defer ticker.Stop()
+
+	var mined *types.Receipt
+	for {
+		if mined == nil {
+			receipt, err := client.TransactionReceipt(ctx, hash)
+			if err == nil {
+				mined = receipt
+			} else if !errors.Is(err, ethereum.NotFound) {
+				log.Printf("⚠️  chains: Failed to get receipt of %s: %v", hash.Hex(), err)
+			}
+		}
+		if mined != nil {
+			receipt, final, err := Final(ctx, client, mined, cfg.Confirmations)
+			if err != nil {
+				log.Printf("⚠️  chains: %v", err)
+			} else if final {
+				return receipt, nil
+			} else {
+				mined = receipt
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		case <-heads:
+		}
+	}
+}
+
+// subscribeHeads subscribes to the chain's new heads over its WebSocket endpoint. Without
+// one, or if the subscription fails, it returns a nil channel and callers keep polling.
+func (mc *MultiClient) subscribeHeads(ctx context.Context, cfg ChainConfig) (<-chan *types.Header, func()) {
+	if cfg.WSURL == "" {
+		return nil, func() {}
+	}
+	ws, err := mc.getWSClient(cfg)
+	if err != nil {
+		log.Printf("⚠️  chains: %v; polling instead", err)
+		return nil, func() {}
+	}
+	heads := make(chan *types.Header, 1)
+	sub, err := ws.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		log.Printf("⚠️  chains: Failed to subscribe to new heads on chain %d: %v; polling instead", cfg.ChainID, err)
+		return nil, func() {}
+	}
+	return heads, sub.Unsubscribe
+}
+
+// getWSClient returns the WebSocket client of a chain, dialing it if necessary.
+func (mc *MultiClient) getWSClient(cfg ChainConfig) (*ethclient.Client, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if client, ok := mc.wsClients[cfg.ChainID]; ok {
+		return client, nil
+	}
+	client, err := ethclient.Dial(cfg.WSURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial WebSocket for chain %d: %w", cfg.ChainID, err)
+	}
+	mc.wsClients[cfg.ChainID] = client
+	return client, nil
+}
@@ -0,0 +1,79 @@
+package chains
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TokenABI covers the ERC-20 and EIP-3009 methods the engine uses.
+var TokenABI = mustParseABI(`[
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"allowance","stateMutability":"view","inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"authorizationState","stateMutability":"view","inputs":[{"name":"authorizer","type":"address"},{"name":"nonce","type":"bytes32"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"transferWithAuthorization","stateMutability":"nonpayable","inputs":[
+		{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"value","type":"uint256"},
+		{"name":"validAfter","type":"uint256"},{"name":"validBefore","type":"uint256"},{"name":"nonce","type":"bytes32"},
+		{"name":"v","type":"uint8"},{"name":"r","type":"bytes32"},{"name":"s","type":"bytes32"}],"outputs":[]},
+	{"type":"event","name":"AuthorizationUsed","anonymous":false,"inputs":[
+		{"name":"authorizer","type":"address","indexed":true},{"name":"nonce","type":"bytes32","indexed":true}]}
+]`)
+
+func mustParseABI(def string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(def))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// TokenBalance returns the ERC-20 balance of owner.
+func (mc *MultiClient) TokenBalance(ctx context.Context, id ChainID, token, owner common.Address) (*big.Int, error) {
+	var balance *big.Int
+	if err := mc.callToken(ctx, id, token, &balance, "balanceOf", owner); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// TokenAllowance returns how much spender may transfer on behalf of owner.
+func (mc *MultiClient) TokenAllowance(ctx context.Context, id ChainID, token, owner, spender common.Address) (*big.Int, error) {
+	var allowance *big.Int
+	if err := mc.callToken(ctx, id, token, &allowance, "allowance", owner, spender); err != nil {
+		return nil, err
+	}
+	return allowance, nil
+}
+
+// AuthorizationState reports whether an EIP-3009 nonce has already been used or canceled.
+func (mc *MultiClient) AuthorizationState(ctx context.Context, id ChainID, token, authorizer common.Address, nonce [32]byte) (bool, error) {
+	var used bool
+	if err := mc.callToken(ctx, id, token, &used, "authorizationState", authorizer, nonce); err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+func (mc *MultiClient) callToken(ctx context.Context, id ChainID, token common.Address, out any, method string, args ...any) error {
+	client, err := mc.GetClient(id)
+	if err != nil {
+		return err
+	}
+	data, err := TokenABI.Pack(method, args...)
+	if err != nil {
+		return fmt.Errorf("failed to pack %s: %w", method, err)
+	}
+	result, err := client.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	if err := TokenABI.UnpackIntoInterface(out, method, result); err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", method, err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package chains
+
+import "github.com/ethereum/go-ethereum/common"
+
+// Multicall3Address is where Multicall3 is deployed on nearly every EVM chain.
+const Multicall3Address = "0xcA11bde05977b3631167028862bE2a3a5C1e9C76"
+
+// Multicall3ABI covers aggregate3, which runs several calls in one transaction.
+var Multicall3ABI = mustParseABI(`[
+	{"type":"function","name":"aggregate3","stateMutability":"payable","inputs":[
+		{"name":"calls","type":"tuple[]","components":[
+			{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],
+	 "outputs":[{"name":"returnData","type":"tuple[]","components":[
+			{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}
+]`)
+
+// Call3 is one call of a Multicall3 aggregate3 batch.
+type Call3 struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// Multicall returns the Multicall3 deployment used on this chain.
+func (c ChainConfig) Multicall() common.Address {
+	if common.IsHexAddress(c.MulticallAddress) {
+		return common.HexToAddress(c.MulticallAddress)
+	}
+	return common.HexToAddress(Multicall3Address)
+}
@@ -0,0 +1,321 @@
+package chains
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// File is the on-disk chain registry configuration. Entries for a built-in chain override the
+// fields they set; entries for any other chain ID add it.
+type File struct {
+	Chains []ChainEntry `json:"chains"`
+}
+
+// ChainEntry configures one chain.
+type ChainEntry struct {
+	ChainID           ChainID               `json:"chainId"`
+	Name              string                `json:"name,omitempty"`
+	Network           string                `json:"network,omitempty"` // x402 network slug
+	RPCURL            string                `json:"rpcUrl,omitempty"`
+	WSURL             string                `json:"wsUrl,omitempty"`
+	ExplorerURL       string                `json:"explorerUrl,omitempty"`
+	VerifyingContract string                `json:"verifyingContract,omitempty"` // EIP-712 verifying contract of payments
+	Multicall         string                `json:"multicall,omitempty"`
+	Confirmations     *uint64               `json:"confirmations,omitempty"`
+	BlockTime         string                `json:"blockTime,omitempty"`      // Duration such as "2s"
+	MinPriorityFee    string                `json:"minPriorityFee,omitempty"` // Wei
+	MaxFeePerGas      string                `json:"maxFeePerGas,omitempty"`   // Wei
+	Tokens            map[string]TokenEntry `json:"tokens,omitempty"`         // By symbol: USDC, USDT or BUSD
+}
+
+// TokenEntry sets a token's address and, for tokens that support EIP-3009, its EIP-712 domain.
+type TokenEntry struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Configure loads the chain registry file at filename, if any, then applies per-chain RPC
+// overrides from the environment and validates every chain. Nothing is registered unless all
+// of them are valid.
+func Configure(filename string) error {
+	list := Chains()
+	if filename != "" {
+		var err error
+		if list, err = LoadFile(filename); err != nil {
+			return err
+		}
+	}
+	list = ApplyEnv(list, os.Getenv)
+	for _, cfg := range list {
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("chain %d: %w", cfg.ChainID, err)
+		}
+	}
+	for _, cfg := range list {
+		RegisterChain(cfg)
+	}
+	return nil
+}
+
+// LoadFile reads a chain registry file and merges it over the registered chains.
+func LoadFile(filename string) ([]ChainConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chains file: %w", err)
+	}
+	list, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(filename), err)
+	}
+	return list, nil
+}
+
+// Parse merges a JSON chain registry configuration over the registered chains and returns
+// every chain, ordered by chain ID. Each merged chain is validated.
+func Parse(data []byte) ([]ChainConfig, error) {
+	var f File
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse chains file: %w", err)
+	}
+
+	merged := make(map[ChainID]ChainConfig, len(registry))
+	for id, cfg := range registry {
+		merged[id] = cfg
+	}
+	seen := make(map[ChainID]bool)
+	for i, entry := range f.Chains {
+		if entry.ChainID == 0 {
+			return nil, fmt.Errorf("chain #%d: chainId is required", i+1)
+		}
+		if seen[entry.ChainID] {
+			return nil, fmt.Errorf("chain %d is configured twice", entry.ChainID)
+		}
+		seen[entry.ChainID] = true
+
+		cfg, err := entry.apply(merged[entry.ChainID])
+		if err != nil {
+			return nil, fmt.Errorf("chain %d: %w", entry.ChainID, err)
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("chain %d: %w", entry.ChainID, err)
+		}
+		merged[entry.ChainID] = cfg
+	}
+
+	list := make([]ChainConfig, 0, len(merged))
+	for _, cfg := range merged {
+		list = append(list, cfg)
+	}
+	sortChains(list)
+	return list, nil
+}
+
+// apply sets the fields the entry configures on cfg.
+func (e ChainEntry) apply(cfg ChainConfig) (ChainConfig, error) {
+	cfg.ChainID = e.ChainID
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&cfg.Name, e.Name)
+	set(&cfg.Network, e.Network)
+	set(&cfg.RPCURL, e.RPCURL)
+	set(&cfg.WSURL, e.WSURL)
+	set(&cfg.ExplorerURL, e.ExplorerURL)
+	set(&cfg.FacilitatorAddress, e.VerifyingContract)
+	set(&cfg.MulticallAddress, e.Multicall)
+	if e.Confirmations != nil {
+		cfg.Confirmations = *e.Confirmations
+	}
+	if e.BlockTime != "" {
+		d, err := time.ParseDuration(e.BlockTime)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid blockTime %q", e.BlockTime)
+		}
+		cfg.BlockTime = d
+	}
+	for _, fee := range []struct {
+		name  string
+		value string
+		dst   **big.Int
+	}{
+		{"minPriorityFee", e.MinPriorityFee, &cfg.MinPriorityFee},
+		{"maxFeePerGas", e.MaxFeePerGas, &cfg.MaxFeePerGas},
+	} {
+		if fee.value == "" {
+			continue
+		}
+		v, ok := new(big.Int).SetString(fee.value, 10)
+		if !ok || v.Sign() < 0 {
+			return cfg, fmt.Errorf("invalid %s %q", fee.name, fee.value)
+		}
+		*fee.dst = v
+	}
+
+	if len(e.Tokens) > 0 {
+		domains := make(map[string]TokenDomain, len(cfg.TokenDomains))
+		for symbol, d := range cfg.TokenDomains {
+			domains[symbol] = d
+		}
+		cfg.TokenDomains = domains
+	}
+	for symbol, token := range e.Tokens {
+		symbol = strings.ToUpper(symbol)
+		switch symbol {
+		case "USDC":
+			cfg.USDCAddress = token.Address
+		case "USDT":
+			cfg.USDTAddress = token.Address
+		case "BUSD":
+			cfg.BUSDAddress = token.Address
+		default:
+			return cfg, fmt.Errorf("unsupported token %q", symbol)
+		}
+		if token.Name != "" || token.Version != "" {
+			cfg.TokenDomains[symbol] = TokenDomain{Name: token.Name, Version: token.Version}
+		}
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the endpoints of chains from SETTLER_<NETWORK>_RPC_URL and
+// SETTLER_<NETWORK>_WS_URL, e.g. SETTLER_BASE_SEPOLIA_RPC_URL.
+func ApplyEnv(list []ChainConfig, getenv func(string) string) []ChainConfig {
+	out := make([]ChainConfig, len(list))
+	for i, cfg := range list {
+		prefix := EnvPrefix(cfg)
+		if v := getenv(prefix + "_RPC_URL"); v != "" {
+			cfg.RPCURL = v
+		}
+		if v := getenv(prefix + "_WS_URL"); v != "" {
+			cfg.WSURL = v
+		}
+		out[i] = cfg
+	}
+	return out
+}
+
+// EnvPrefix is the prefix of a chain's environment overrides: SETTLER_ and its network slug
+// in upper case, or its chain ID when it has no slug.
+func EnvPrefix(cfg ChainConfig) string {
+	name := cfg.Network
+	if name == "" {
+		name = fmt.Sprint(uint64(cfg.ChainID))
+	}
+	return "SETTLER_" + strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
+
+// Validate checks that a chain is usable: it has a name and an RPC endpoint, and every
+// endpoint and address is well-formed.
+func (c ChainConfig) Validate() error {
+	if c.ChainID == 0 {
+		return errors.New("chain ID is required")
+	}
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.RPCURL == "" {
+		return errors.New("RPC URL is required")
+	}
+	if err := validateEndpoint(c.RPCURL, "http", "https", "ws", "wss"); err != nil {
+		return fmt.Errorf("RPC URL: %w", err)
+	}
+	if c.WSURL != "" {
+		if err := validateEndpoint(c.WSURL, "ws", "wss"); err != nil {
+			return fmt.Errorf("WebSocket URL: %w", err)
+		}
+	}
+
+	for name, addr := range map[string]string{
+		"verifying contract": c.FacilitatorAddress,
+		"multicall":          c.MulticallAddress,
+		"USDC":               c.USDCAddress,
+		"USDT":               c.USDTAddress,
+		"BUSD":               c.BUSDAddress,
+	} {
+		if addr != "" && !common.IsHexAddress(addr) {
+			return fmt.Errorf("%s address %q is not a hex address", name, addr)
+		}
+	}
+	for symbol, d := range c.TokenDomains {
+		if _, ok := c.TokenAddress(symbol); !ok {
+			return fmt.Errorf("EIP-712 domain set for %s, which has no address", symbol)
+		}
+		if d.Name == "" || d.Version == "" {
+			return fmt.Errorf("EIP-712 domain of %s needs a name and a version", symbol)
+		}
+	}
+	if c.MinPriorityFee != nil && c.MaxFeePerGas != nil && c.MinPriorityFee.Cmp(c.MaxFeePerGas) > 0 {
+		return fmt.Errorf("minimum priority fee %s exceeds the maximum fee %s", c.MinPriorityFee, c.MaxFeePerGas)
+	}
+	return nil
+}
+
+// validateEndpoint accepts a URL with one of schemes, or the absolute path of an IPC socket.
+func validateEndpoint(endpoint string, schemes ...string) error {
+	if filepath.IsAbs(endpoint) {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme && u.Host != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a %s URL", endpoint, strings.Join(schemes, ", "))
+}
+
+// Check connects to a chain's endpoints and checks that they serve the configured chain. It
+// returns the latest block number.
+func (c ChainConfig) Check(ctx context.Context) (uint64, error) {
+	head, err := checkEndpoint(ctx, c.RPCURL, c.ChainID)
+	if err != nil {
+		return 0, fmt.Errorf("RPC: %w", err)
+	}
+	if c.WSURL != "" {
+		if _, err := checkEndpoint(ctx, c.WSURL, c.ChainID); err != nil {
+			return 0, fmt.Errorf("WebSocket: %w", err)
+		}
+	}
+	return head, nil
+}
+
+func checkEndpoint(ctx context.Context, endpoint string, want ChainID) (uint64, error) {
+	client, err := ethclient.DialContext(ctx, endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	id, err := client.ChainID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chain ID: %w", err)
+	}
+	if !id.IsUint64() || ChainID(id.Uint64()) != want {
+		return 0, fmt.Errorf("endpoint serves chain %s", id)
+	}
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest block: %w", err)
+	}
+	return head, nil
+}
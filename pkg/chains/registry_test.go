@@ -0,0 +1,122 @@
+package chains
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testChainsFile = `{
+	"chains": [
+		{"chainId": 8453, "rpcUrl": "https://base.internal:8545", "wsUrl": "wss://base.internal:8546", "confirmations": 3},
+		{
+			"chainId": 10, "name": "Optimism", "network": "optimism", "rpcUrl": "https://mainnet.optimism.io",
+			"blockTime": "2s", "verifyingContract": "0x00000000000000000000000000000000000000aa",
+			"tokens": {"usdc": {"address": "0x0b2C639c533813f4Aa9D7837CAf62653d097Ff85", "name": "USD Coin", "version": "2"}}
+		}
+	]
+}`
+
+func TestParse(t *testing.T) {
+	list, err := Parse([]byte(testChainsFile))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	byID := make(map[ChainID]ChainConfig)
+	for _, cfg := range list {
+		byID[cfg.ChainID] = cfg
+	}
+
+	// 1. A built-in chain keeps the fields the file leaves unset.
+	base := byID[ChainIDBase]
+	if base.RPCURL != "https://base.internal:8545" || base.WSURL != "wss://base.internal:8546" || base.Confirmations != 3 {
+		t.Errorf("expected Base endpoints and confirmations to be overridden, got %+v", base)
+	}
+	if base.USDCAddress != "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913" || base.BlockTime != 2*time.Second {
+		t.Errorf("expected Base to keep its built-in token and block time, got %+v", base)
+	}
+
+	// 2. A new chain is added with its token domain.
+	op, ok := byID[10]
+	if !ok {
+		t.Fatal("expected Optimism to be added")
+	}
+	if addr, _ := op.TokenAddress("USDC"); addr != "0x0b2C639c533813f4Aa9D7837CAf62653d097Ff85" {
+		t.Errorf("unexpected USDC address %q", addr)
+	}
+	if d, ok := op.EIP3009Domain("USDC"); !ok || d.Name != "USD Coin" || op.FacilitatorAddress == "" || op.BlockTime != 2*time.Second {
+		t.Errorf("unexpected Optimism config %+v", op)
+	}
+
+	// 3. Nothing is registered until Configure.
+	if _, err := GetChainConfig(10); err == nil {
+		t.Error("expected Parse to leave the registry alone")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":     `{"chains": [{"chainId": 10, "rpc": "https://x"}]}`,
+		"missing chain ID":  `{"chains": [{"name": "X", "rpcUrl": "https://x"}]}`,
+		"duplicate":         `{"chains": [{"chainId": 8453}, {"chainId": 8453}]}`,
+		"new chain, no RPC": `{"chains": [{"chainId": 10, "name": "Optimism"}]}`,
+		"bad RPC scheme":    `{"chains": [{"chainId": 8453, "rpcUrl": "ftp://x"}]}`,
+		"HTTP as WebSocket": `{"chains": [{"chainId": 8453, "wsUrl": "https://x"}]}`,
+		"bad block time":    `{"chains": [{"chainId": 8453, "blockTime": "fast"}]}`,
+		"bad address":       `{"chains": [{"chainId": 8453, "verifyingContract": "0x12"}]}`,
+		"unsupported token": `{"chains": [{"chainId": 8453, "tokens": {"DAI": {"address": "0x6B175474E89094C44Da98b954EedeAC495271d0F"}}}]}`,
+		"incomplete domain": `{"chains": [{"chainId": 56, "tokens": {"USDT": {"address": "0x55d398326f99059fF775485246999027B3197955", "name": "Tether"}}}]}`,
+		"tip above fee cap": `{"chains": [{"chainId": 56, "maxFeePerGas": "1"}]}`,
+	}
+	for name, config := range tests {
+		if _, err := Parse([]byte(config)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"SETTLER_BASE_SEPOLIA_RPC_URL": "http://localhost:8545",
+		"SETTLER_BASE_SEPOLIA_WS_URL":  "ws://localhost:8546",
+	}
+	list := ApplyEnv(Chains(), func(key string) string { return env[key] })
+	for _, cfg := range list {
+		switch cfg.ChainID {
+		case ChainIDBaseSepolia:
+			if cfg.RPCURL != "http://localhost:8545" || cfg.WSURL != "ws://localhost:8546" {
+				t.Errorf("expected Base Sepolia endpoints from the environment, got %q and %q", cfg.RPCURL, cfg.WSURL)
+			}
+		case ChainIDBase:
+			if cfg.RPCURL != "https://mainnet.base.org" {
+				t.Errorf("expected Base to be left alone, got %q", cfg.RPCURL)
+			}
+		}
+	}
+}
+
+func TestChainConfig_Check(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result := map[string]string{"eth_chainId": "0x2105", "eth_blockNumber": "0x10"}[req.Method]
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	head, err := ChainConfig{Name: "Base", ChainID: ChainIDBase, RPCURL: srv.URL}.Check(ctx)
+	if err != nil || head != 16 {
+		t.Errorf("expected block 16, got %d, %v", head, err)
+	}
+	if _, err := (ChainConfig{Name: "Base Sepolia", ChainID: ChainIDBaseSepolia, RPCURL: srv.URL}).Check(ctx); err == nil || !strings.Contains(err.Error(), "8453") {
+		t.Errorf("expected an endpoint serving another chain to be reported, got %v", err)
+	}
+}
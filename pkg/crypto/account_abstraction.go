@@ -2,66 +2,511 @@ package crypto
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"math/big"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/pkg/storage"
 )
 
+// EntryPointVersion selects how user operations are packed and hashed.
+type EntryPointVersion string
+
+const (
+	EntryPointV06 EntryPointVersion = "v0.6"
+	EntryPointV07 EntryPointVersion = "v0.7"
+)
+
+// Canonical EntryPoint deployments, at the same address on every chain.
+var (
+	EntryPointV06Address = common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	EntryPointV07Address = common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")
+)
+
+var (
+	entryPointABI = mustParseABI(`[{"type":"function","name":"getNonce","stateMutability":"view",
+		"inputs":[{"name":"sender","type":"address"},{"name":"key","type":"uint192"}],"outputs":[{"name":"nonce","type":"uint256"}]}]`)
+	// smartAccountABI is the call surface of SimpleAccount-style accounts.
+	smartAccountABI = mustParseABI(`[{"type":"function","name":"execute","stateMutability":"nonpayable",
+		"inputs":[{"name":"dest","type":"address"},{"name":"value","type":"uint256"},{"name":"func","type":"bytes"}],"outputs":[]}]`)
+
+	// userOpV06Args packs a v0.6 UserOperation for hashing, with dynamic fields pre-hashed.
+	userOpV06Args = abi.Arguments{
+		{Type: mustType("address")}, {Type: mustType("uint256")}, {Type: mustType("bytes32")}, {Type: mustType("bytes32")},
+		{Type: mustType("uint256")}, {Type: mustType("uint256")}, {Type: mustType("uint256")},
+		{Type: mustType("uint256")}, {Type: mustType("uint256")}, {Type: mustType("bytes32")},
+	}
+	// userOpV07Args packs a v0.7 PackedUserOperation for hashing.
+	userOpV07Args = abi.Arguments{
+		{Type: mustType("address")}, {Type: mustType("uint256")}, {Type: mustType("bytes32")}, {Type: mustType("bytes32")},
+		{Type: mustType("bytes32")}, {Type: mustType("uint256")}, {Type: mustType("bytes32")}, {Type: mustType("bytes32")},
+	}
+	userOpHashArgs = abi.Arguments{{Type: mustType("bytes32")}, {Type: mustType("address")}, {Type: mustType("uint256")}}
+)
+
+// dummySignature stands in for the real signature while gas is estimated. It has the length
+// and shape of an ECDSA signature so validation costs the same.
+var dummySignature = common.FromHex("0xffffffffffffffffffffffffffffffff000000000000000000000000000000007aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa1c")
+
+// EntryPoint identifies the EntryPoint contract user operations are sent through.
+type EntryPoint struct {
+	Address common.Address
+	Version EntryPointVersion
+}
+
 // SmartAccount represents an ERC-4337 Account Abstraction wallet.
 type SmartAccount struct {
-	Address     common.Address
-	Owner       common.Address
-	Entrypoint  common.Address
-	Paymaster   common.Address
+	Address           common.Address
+	Owner             common.Address
+	Entrypoint        common.Address
+	EntryPointVersion EntryPointVersion // Inferred from Entrypoint when empty
+	Paymaster         common.Address
+	InitCode          []byte // Factory address and calldata deploying the account, used until it is deployed
 }
 
-// UserOperation represents an ERC-4337 user operation.
+// EntryPoint returns the EntryPoint the account is bound to, defaulting to v0.7.
+func (a SmartAccount) EntryPoint() EntryPoint {
+	ep := EntryPoint{Address: a.Entrypoint, Version: a.EntryPointVersion}
+	if ep.Address == (common.Address{}) {
+		ep.Address = EntryPointV07Address
+	}
+	if ep.Version == "" {
+		ep.Version = EntryPointV07
+		if ep.Address == EntryPointV06Address {
+			ep.Version = EntryPointV06
+		}
+	}
+	return ep
+}
+
+// UserOperation represents an ERC-4337 user operation. It uses the v0.6 layout: for v0.7,
+// InitCode is packed as factory || factoryData and PaymasterAndData as paymaster ||
+// paymasterData, with the paymaster gas limits taken from their own fields.
 type UserOperation struct {
-	Sender             common.Address
-	Nonce              *big.Int
-	InitCode           []byte
-	CallData           []byte
-	CallGasLimit       *big.Int
-	VerificationGas    *big.Int
-	PreVerificationGas *big.Int
-	MaxFeePerGas       *big.Int
+	Sender               common.Address
+	Nonce                *big.Int
+	InitCode             []byte
+	CallData             []byte
+	CallGasLimit         *big.Int
+	VerificationGas      *big.Int
+	PreVerificationGas   *big.Int
+	MaxFeePerGas         *big.Int
 	MaxPriorityFeePerGas *big.Int
-	PaymasterAndData   []byte
-	Signature          []byte
+	PaymasterAndData     []byte
+	Signature            []byte
+
+	// Paymaster gas limits, v0.7 only.
+	PaymasterVerificationGas *big.Int
+	PaymasterPostOpGas       *big.Int
+}
+
+// UserOperationReceipt reports how a bundled user operation was executed.
+type UserOperationReceipt struct {
+	UserOpHash    common.Hash
+	Sender        common.Address
+	Nonce         *big.Int
+	Success       bool
+	Reason        string // Revert reason when the call failed
+	ActualGasCost *big.Int
+	ActualGasUsed *big.Int
+	TxHash        common.Hash // Bundle transaction that included the operation
+	BlockNumber   *big.Int
+}
+
+// UserOpHash returns the hash an account signs for op, as EntryPoint.getUserOpHash computes it.
+func (ep EntryPoint) UserOpHash(op *UserOperation, chainID *big.Int) (common.Hash, error) {
+	var packed []byte
+	var err error
+	switch ep.Version {
+	case EntryPointV06:
+		packed, err = userOpV06Args.Pack(op.Sender, bigOrZero(op.Nonce),
+			crypto.Keccak256Hash(op.InitCode), crypto.Keccak256Hash(op.CallData),
+			bigOrZero(op.CallGasLimit), bigOrZero(op.VerificationGas), bigOrZero(op.PreVerificationGas),
+			bigOrZero(op.MaxFeePerGas), bigOrZero(op.MaxPriorityFeePerGas), crypto.Keccak256Hash(op.PaymasterAndData))
+	case EntryPointV07:
+		packed, err = userOpV07Args.Pack(op.Sender, bigOrZero(op.Nonce),
+			crypto.Keccak256Hash(op.InitCode), crypto.Keccak256Hash(op.CallData),
+			packUint128s(op.VerificationGas, op.CallGasLimit), bigOrZero(op.PreVerificationGas),
+			packUint128s(op.MaxPriorityFeePerGas, op.MaxFeePerGas), crypto.Keccak256Hash(op.PackedPaymasterAndData()))
+	default:
+		return common.Hash{}, fmt.Errorf("unsupported EntryPoint version %q", ep.Version)
+	}
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to pack user operation: %w", err)
+	}
+
+	encoded, err := userOpHashArgs.Pack(crypto.Keccak256Hash(packed), ep.Address, bigOrZero(chainID))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to pack user operation hash: %w", err)
+	}
+	return crypto.Keccak256Hash(encoded), nil
+}
+
+// PackedPaymasterAndData returns the v0.7 paymasterAndData field: paymaster ||
+// verificationGasLimit (uint128) || postOpGasLimit (uint128) || paymasterData.
+func (op *UserOperation) PackedPaymasterAndData() []byte {
+	if len(op.PaymasterAndData) < common.AddressLength {
+		return nil
+	}
+	limits := packUint128s(op.PaymasterVerificationGas, op.PaymasterPostOpGas)
+	packed := append([]byte{}, op.PaymasterAndData[:common.AddressLength]...)
+	packed = append(packed, limits[:]...)
+	return append(packed, op.PaymasterAndData[common.AddressLength:]...)
+}
+
+// packUint128s packs two uint128 values into one bytes32, high first.
+func packUint128s(high, low *big.Int) [32]byte {
+	var out [32]byte
+	bigOrZero(high).FillBytes(out[:16])
+	bigOrZero(low).FillBytes(out[16:])
+	return out
+}
+
+func bigOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v
+}
+
+// SignUserOperation signs op for ep with the session key. Like SimpleAccount, the account is
+// expected to check an EIP-191 personal signature over the userOpHash.
+// With a policy, only execute calls within its scope are signed.
+func (s *SessionKeySigner) SignUserOperation(op *UserOperation, ep EntryPoint) error {
+	if s.policy != nil {
+		if err := s.authorizeUserOperation(op); err != nil {
+			return err
+		}
+	}
+	hash, err := ep.UserOpHash(op, s.chainID)
+	if err != nil {
+		return err
+	}
+	sig, err := s.key.SignText(hash.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to sign user operation: %w", err)
+	}
+	sig[64] += 27
+	op.Signature = sig
+	return nil
+}
+
+// authorizeUserOperation checks the call a user operation makes against the signer's policy.
+func (s *SessionKeySigner) authorizeUserOperation(op *UserOperation) error {
+	method, err := smartAccountABI.MethodById(op.CallData)
+	if err != nil || method.Name != "execute" {
+		return s.policy.refuse(s.address, SignKindUserOperation, s.chainID, "user operations must make a single execute call")
+	}
+	args, err := method.Inputs.Unpack(op.CallData[4:])
+	if err != nil {
+		return fmt.Errorf("failed to decode execute call: %w", err)
+	}
+	to, value, data := args[0].(common.Address), args[1].(*big.Int), args[2].([]byte)
+	return s.policy.Authorize(s.address, SignKindUserOperation, s.chainID, &to, value, data)
 }
 
 // AAProvider defines the port for interacting with ERC-4337 bundlers and paymasters.
 type AAProvider interface {
-	// SendUserOperation submits a signed user operation to the bundler.
-	SendUserOperation(ctx context.Context, op UserOperation) (string, error)
-	
-	// EstimateUserOperationGas estimates gas for a user operation.
-	EstimateUserOperationGas(ctx context.Context, op UserOperation) (*UserOperation, error)
-	
-	// GetSmartAccountAddress computes or retrieves the address of a smart account.
-	GetSmartAccountAddress(ctx context.Context, owner common.Address) (common.Address, error)
+	// SendUserOperation submits a signed user operation to the bundler and returns its userOpHash.
+	SendUserOperation(ctx context.Context, op UserOperation, ep EntryPoint) (string, error)
+
+	// EstimateUserOperationGas returns op with its gas limits filled in by the bundler.
+	EstimateUserOperationGas(ctx context.Context, op UserOperation, ep EntryPoint) (*UserOperation, error)
+
+	// GetUserOperationReceipt returns the receipt of a user operation, or nil while it is pending.
+	GetUserOperationReceipt(ctx context.Context, userOpHash string) (*UserOperationReceipt, error)
+}
+
+// WaitForUserOperation polls the provider until the user operation is included.
+func WaitForUserOperation(ctx context.Context, aa AAProvider, userOpHash string, interval time.Duration) (*UserOperationReceipt, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		receipt, err := aa.GetUserOperationReceipt(ctx, userOpHash)
+		if err != nil {
+			return nil, err
+		}
+		if receipt != nil {
+			return receipt, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("user operation %s not included: %w", userOpHash, ctx.Err())
+		case <-ticker.C:
+		}
+	}
 }
 
 // TransactionManager handles the orchestration of AA or EOA transactions.
 type TransactionManager struct {
-	client *ethclient.Client
-	aa     AAProvider
+	client    *ethclient.Client
+	aa        AAProvider
+	account   *SmartAccount
+	signer    *SessionKeySigner
+	paymaster Paymaster
+	budget    *SponsorshipBudget
+	opNonces  nonceQueue // EntryPoint nonces of the smart account
+
+	// EOA transactions
+	nonces        nonceQueue
+	fees          FeePolicy
+	store         *storage.DB
+	stuckAfter    time.Duration
+	pollInterval  time.Duration
+	confirmations uint64
 }
 
 func NewTransactionManager(client *ethclient.Client, aa AAProvider) *TransactionManager {
 	return &TransactionManager{
-		client: client,
-		aa:     aa,
+		client:       client,
+		aa:           aa,
+		pollInterval: 2 * time.Second,
 	}
 }
 
-// Broadcast handles the dispatch of a transaction, using AA if available.
+// WithSmartAccount sets the account user operations are sent from and the session key that
+// signs them. The signer's chain ID must be the chain client is connected to.
+func (m *TransactionManager) WithSmartAccount(account SmartAccount, signer *SessionKeySigner) *TransactionManager {
+	m.account = &account
+	m.signer = signer
+	return m
+}
+
+// WithPaymaster asks pm to sponsor user operations within budget, or without limit if budget
+// is nil. Operations it denies or that exceed the budget are paid by the account.
+func (m *TransactionManager) WithPaymaster(pm Paymaster, budget *SponsorshipBudget) *TransactionManager {
+	m.paymaster = pm
+	m.budget = budget
+	return m
+}
+
+// Call is a call made by the smart account, or by the signer's own address.
+type Call struct {
+	Operation            string // Operation type, e.g. OperationHarvest, sponsorship is budgeted by
+	Reference            string // Invoice, payment or strategy the call is made for, kept in the journal
+	To                   common.Address
+	Value                *big.Int
+	Data                 []byte
+	Gas                  uint64   // Gas limit of a transaction; estimated when zero
+	MaxFeePerGas         *big.Int // Suggested by the node when nil
+	MaxPriorityFeePerGas *big.Int
+}
+
+// Broadcast handles the dispatch of a transaction, using AA if available. With AA, the
+// transaction's target, value, data and fee caps are wrapped in a user operation.
 func (m *TransactionManager) Broadcast(ctx context.Context, tx *types.Transaction) error {
 	if m.aa != nil {
-		// TODO: Wrap tx in UserOperation and send via AAProvider
-		return nil
+		if tx.To() == nil {
+			return errors.New("contract creation is not supported through a smart account")
+		}
+		_, err := m.SendUserOperation(ctx, Call{
+			Operation:            OperationBroadcast,
+			To:                   *tx.To(),
+			Value:                tx.Value(),
+			Data:                 tx.Data(),
+			MaxFeePerGas:         tx.GasFeeCap(),
+			MaxPriorityFeePerGas: tx.GasTipCap(),
+		})
+		return err
 	}
 	return m.client.SendTransaction(ctx, tx)
 }
+
+// SendUserOperation has the smart account make call and returns the userOpHash. Gas limits are
+// estimated by the bundler, and gas is sponsored by the paymaster when one is configured.
+// Nonces come from the manager's sequence, so concurrent operations never share one.
+func (m *TransactionManager) SendUserOperation(ctx context.Context, call Call) (string, error) {
+	if m.aa == nil || m.account == nil || m.signer == nil {
+		return "", errors.New("no smart account configured")
+	}
+	ep := m.account.EntryPoint()
+
+	// 1. Nonce
+	nonce, err := m.opNonces.take(func() (uint64, error) { return m.syncEntryPointNonce(ctx, ep) })
+	if err != nil {
+		return "", err
+	}
+
+	// 2. Gas limits and sponsorship
+	op, err := m.buildUserOperation(ctx, call, new(big.Int).SetUint64(nonce))
+	if err != nil {
+		m.opNonces.release(nonce)
+		return "", err
+	}
+	op.Signature = dummySignature
+	estimated, err := m.aa.EstimateUserOperationGas(ctx, *op, ep)
+	if err != nil {
+		m.opNonces.release(nonce)
+		return "", err
+	}
+
+	var sponsored *big.Int
+	if m.paymaster != nil {
+		withPaymaster, cost, err := m.sponsor(ctx, call.Operation, *estimated, ep)
+		if err != nil {
+			m.opNonces.release(nonce)
+			return "", err
+		}
+		if withPaymaster != nil {
+			estimated, sponsored = withPaymaster, cost
+		}
+	}
+
+	// 3. Signature and submission
+	if err := m.signer.SignUserOperation(estimated, ep); err != nil {
+		m.release(call.Operation, sponsored)
+		m.opNonces.release(nonce)
+		return "", err
+	}
+	hash, err := m.aa.SendUserOperation(ctx, *estimated, ep)
+	if err != nil {
+		m.release(call.Operation, sponsored)
+		switch {
+		case strings.Contains(err.Error(), "AA25"):
+			// The account's nonce moved on outside this manager.
+			m.opNonces.reset()
+		case rejected(err):
+			m.opNonces.release(nonce)
+		default:
+			// The bundler may hold the operation; resync rather than reuse its nonce.
+			m.opNonces.reset()
+		}
+		return "", err
+	}
+	return hash, nil
+}
+
+// sponsor asks the paymaster to pay for op and charges the budget. It returns a nil operation
+// when op should be paid by the account instead.
+func (m *TransactionManager) sponsor(ctx context.Context, operation string, op UserOperation, ep EntryPoint) (*UserOperation, *big.Int, error) {
+	if m.budget != nil && m.budget.Remaining(operation).Cmp(MaxGasCost(&op)) < 0 {
+		log.Printf("⚠️  aa: Sponsorship budget for %q exhausted, paying gas from %s", operation, op.Sender.Hex())
+		return nil, nil, nil
+	}
+
+	sponsored, err := m.paymaster.SponsorUserOperation(ctx, op, ep)
+	if errors.Is(err, ErrSponsorshipDenied) {
+		log.Printf("⚠️  aa: %v, paying gas for %q from %s", err, operation, op.Sender.Hex())
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to request sponsorship: %w", err)
+	}
+	paymaster, ok := sponsored.Paymaster()
+	if !ok {
+		return nil, nil, errors.New("paymaster returned no paymaster data")
+	}
+	if want := m.account.Paymaster; want != (common.Address{}) && paymaster != want {
+		return nil, nil, fmt.Errorf("sponsored by paymaster %s, expected %s", paymaster.Hex(), want.Hex())
+	}
+
+	cost := MaxGasCost(sponsored)
+	if m.budget != nil && !m.budget.Reserve(operation, cost) {
+		log.Printf("⚠️  aa: Sponsorship budget for %q exhausted, paying gas from %s", operation, op.Sender.Hex())
+		return nil, nil, nil
+	}
+	log.Printf("⛽ aa: Paymaster %s sponsors %q for up to %s wei", paymaster.Hex(), operation, cost)
+	return sponsored, cost, nil
+}
+
+// release returns an unused sponsorship to the budget.
+func (m *TransactionManager) release(operation string, cost *big.Int) {
+	if m.budget != nil && cost != nil {
+		m.budget.Release(operation, cost)
+	}
+}
+
+// BuildUserOperation prepares an unsigned user operation without gas limits: the EntryPoint
+// nonce of the account, its init code while it is not deployed, and an execute call. The
+// nonce is read from the chain, not reserved; SendUserOperation takes its own.
+func (m *TransactionManager) BuildUserOperation(ctx context.Context, call Call) (*UserOperation, error) {
+	nonce, err := m.entryPointNonce(ctx, m.account.EntryPoint(), m.account.Address)
+	if err != nil {
+		return nil, err
+	}
+	return m.buildUserOperation(ctx, call, nonce)
+}
+
+// buildUserOperation is BuildUserOperation with the nonce given.
+func (m *TransactionManager) buildUserOperation(ctx context.Context, call Call, nonce *big.Int) (*UserOperation, error) {
+	account := m.account
+	callData, err := smartAccountABI.Pack("execute", call.To, bigOrZero(call.Value), call.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack execute call: %w", err)
+	}
+
+	op := &UserOperation{
+		Sender:               account.Address,
+		Nonce:                nonce,
+		CallData:             callData,
+		MaxFeePerGas:         call.MaxFeePerGas,
+		MaxPriorityFeePerGas: call.MaxPriorityFeePerGas,
+	}
+	if len(account.InitCode) > 0 {
+		code, err := m.client.CodeAt(ctx, account.Address, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read code of %s: %w", account.Address.Hex(), err)
+		}
+		if len(code) == 0 {
+			op.InitCode = account.InitCode
+		}
+	}
+	if op.MaxFeePerGas == nil || op.MaxPriorityFeePerGas == nil {
+		tip, err := m.client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to suggest gas tip: %w", err)
+		}
+		price, err := m.client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to suggest gas price: %w", err)
+		}
+		op.MaxPriorityFeePerGas, op.MaxFeePerGas = tip, new(big.Int).Add(price, tip)
+	}
+	return op, nil
+}
+
+// WaitForUserOperation polls the bundler until the user operation is included.
+func (m *TransactionManager) WaitForUserOperation(ctx context.Context, userOpHash string) (*UserOperationReceipt, error) {
+	if m.aa == nil {
+		return nil, errors.New("no AA provider configured")
+	}
+	return WaitForUserOperation(ctx, m.aa, userOpHash, m.pollInterval)
+}
+
+// syncEntryPointNonce returns where the account's sequence of operation nonces starts.
+func (m *TransactionManager) syncEntryPointNonce(ctx context.Context, ep EntryPoint) (uint64, error) {
+	nonce, err := m.entryPointNonce(ctx, ep, m.account.Address)
+	if err != nil {
+		return 0, err
+	}
+	if !nonce.IsUint64() {
+		return 0, fmt.Errorf("EntryPoint nonce %s is out of range", nonce)
+	}
+	return nonce.Uint64(), nil
+}
+
+// entryPointNonce reads the account's next nonce for the default key from the EntryPoint.
+func (m *TransactionManager) entryPointNonce(ctx context.Context, ep EntryPoint, sender common.Address) (*big.Int, error) {
+	data, err := entryPointABI.Pack("getNonce", sender, new(big.Int))
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.client.CallContract(ctx, ethereum.CallMsg{To: &ep.Address, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EntryPoint nonce: %w", err)
+	}
+	values, err := entryPointABI.Unpack("getNonce", out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EntryPoint nonce: %w", err)
+	}
+	return values[0].(*big.Int), nil
+}
@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// word left-pads b to a 32-byte ABI word.
+func word(b []byte) []byte {
+	return common.LeftPadBytes(b, 32)
+}
+
+func testUserOperation() *UserOperation {
+	return &UserOperation{
+		Sender:                   common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		Nonce:                    big.NewInt(7),
+		InitCode:                 common.FromHex("0x2222222222222222222222222222222222222222abcd"),
+		CallData:                 common.FromHex("0xb61d27f6"),
+		CallGasLimit:             big.NewInt(100_000),
+		VerificationGas:          big.NewInt(200_000),
+		PreVerificationGas:       big.NewInt(50_000),
+		MaxFeePerGas:             big.NewInt(2_000_000_000),
+		MaxPriorityFeePerGas:     big.NewInt(1_000_000),
+		PaymasterAndData:         common.FromHex("0x3333333333333333333333333333333333333333beef"),
+		PaymasterVerificationGas: big.NewInt(30_000),
+		PaymasterPostOpGas:       big.NewInt(10_000),
+	}
+}
+
+func TestUserOpHash(t *testing.T) {
+	op := testUserOperation()
+	chainID := big.NewInt(8453)
+	keccak := crypto.Keccak256
+
+	outer := func(inner []byte, ep common.Address) common.Hash {
+		return crypto.Keccak256Hash(word(keccak(inner)), word(ep.Bytes()), word(chainID.Bytes()))
+	}
+
+	v06 := bytes.Join([][]byte{
+		word(op.Sender.Bytes()), word(op.Nonce.Bytes()), keccak(op.InitCode), keccak(op.CallData),
+		word(op.CallGasLimit.Bytes()), word(op.VerificationGas.Bytes()), word(op.PreVerificationGas.Bytes()),
+		word(op.MaxFeePerGas.Bytes()), word(op.MaxPriorityFeePerGas.Bytes()), keccak(op.PaymasterAndData),
+	}, nil)
+	got, err := EntryPoint{EntryPointV06Address, EntryPointV06}.UserOpHash(op, chainID)
+	if err != nil {
+		t.Fatalf("v0.6 hash: %v", err)
+	}
+	if want := outer(v06, EntryPointV06Address); got != want {
+		t.Errorf("v0.6 hash = %s, want %s", got.Hex(), want.Hex())
+	}
+
+	uint128 := func(v *big.Int) []byte { return common.LeftPadBytes(v.Bytes(), 16) }
+	paymasterAndData := bytes.Join([][]byte{
+		op.PaymasterAndData[:20], uint128(op.PaymasterVerificationGas), uint128(op.PaymasterPostOpGas), op.PaymasterAndData[20:],
+	}, nil)
+	v07 := bytes.Join([][]byte{
+		word(op.Sender.Bytes()), word(op.Nonce.Bytes()), keccak(op.InitCode), keccak(op.CallData),
+		append(uint128(op.VerificationGas), uint128(op.CallGasLimit)...), word(op.PreVerificationGas.Bytes()),
+		append(uint128(op.MaxPriorityFeePerGas), uint128(op.MaxFeePerGas)...), keccak(paymasterAndData),
+	}, nil)
+	got, err = EntryPoint{EntryPointV07Address, EntryPointV07}.UserOpHash(op, chainID)
+	if err != nil {
+		t.Fatalf("v0.7 hash: %v", err)
+	}
+	if want := outer(v07, EntryPointV07Address); got != want {
+		t.Errorf("v0.7 hash = %s, want %s", got.Hex(), want.Hex())
+	}
+
+	if _, err := (EntryPoint{Version: "v0.5"}).UserOpHash(op, chainID); err == nil {
+		t.Error("expected an unknown EntryPoint version to be rejected")
+	}
+}
+
+func TestSmartAccount_EntryPoint(t *testing.T) {
+	if ep := (SmartAccount{}).EntryPoint(); ep.Address != EntryPointV07Address || ep.Version != EntryPointV07 {
+		t.Errorf("default EntryPoint = %+v", ep)
+	}
+	if ep := (SmartAccount{Entrypoint: EntryPointV06Address}).EntryPoint(); ep.Version != EntryPointV06 {
+		t.Errorf("v0.6 EntryPoint inferred as %s", ep.Version)
+	}
+}
+
+func TestSessionKeySigner_SignUserOperation(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer, err := NewSessionKeySigner(hexutil.Encode(crypto.FromECDSA(key))[2:], big.NewInt(8453))
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	op := testUserOperation()
+	ep := SmartAccount{}.EntryPoint()
+	if err := signer.SignUserOperation(op, ep); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	hash, _ := ep.UserOpHash(op, big.NewInt(8453))
+	recovered, err := RecoverSigner(accounts.TextHash(hash.Bytes()), hexutil.Encode(op.Signature))
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if recovered != signer.Address() {
+		t.Errorf("recovered %s, want %s", recovered.Hex(), signer.Address().Hex())
+	}
+}
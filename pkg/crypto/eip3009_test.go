@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestVerifyTransferWithAuthorization(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	domain := TokenDomain{
+		Name:              "USDC",
+		Version:           "2",
+		ChainID:           big.NewInt(84532),
+		VerifyingContract: common.HexToAddress("0x036CbD53842c5426634e7929541eC2318f3dCF7e"),
+	}
+	auth := TransferWithAuthorization{
+		From:        from.Hex(),
+		To:          "0x0000000000000000000000000000000000000123",
+		Value:       "1000000",
+		ValidAfter:  "0",
+		ValidBefore: "1900000000",
+		Nonce:       "0x" + common.Bytes2Hex(bytes.Repeat([]byte{0xab}, 32)),
+	}
+
+	sighash, err := HashTransferWithAuthorization(auth, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The digest must match what the token contract computes on-chain.
+	word := func(n int64) []byte { return math.U256Bytes(big.NewInt(n)) }
+	domainSeparator := crypto.Keccak256(
+		crypto.Keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)")),
+		crypto.Keccak256([]byte(domain.Name)),
+		crypto.Keccak256([]byte(domain.Version)),
+		word(84532),
+		common.LeftPadBytes(domain.VerifyingContract.Bytes(), 32),
+	)
+	structHash := crypto.Keccak256(
+		common.FromHex("0x7c7c6cdb67a18743f49ec6fa9b35f50d52ed05cbed4cc592e13b44501c1a2267"), // TRANSFER_WITH_AUTHORIZATION_TYPEHASH
+		common.LeftPadBytes(from.Bytes(), 32),
+		common.LeftPadBytes(common.HexToAddress(auth.To).Bytes(), 32),
+		word(1000000),
+		word(0),
+		word(1900000000),
+		bytes.Repeat([]byte{0xab}, 32),
+	)
+	expected := crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, structHash)
+	if !bytes.Equal(sighash, expected) {
+		t.Fatalf("digest mismatch: got %x, want %x", sighash, expected)
+	}
+
+	signature, _ := crypto.Sign(sighash, key)
+	signature[64] += 27
+	if signer, err := VerifyTransferWithAuthorization(auth, hexutil.Encode(signature), domain); err != nil || signer != from {
+		t.Errorf("expected %s, got %s (%v)", from.Hex(), signer.Hex(), err)
+	}
+
+	// An authorization claiming another payer is rejected.
+	auth.From = "0x0000000000000000000000000000000000000abc"
+	if _, err := VerifyTransferWithAuthorization(auth, hexutil.Encode(signature), domain); err == nil {
+		t.Error("expected an error for a mismatched from address")
+	}
+}
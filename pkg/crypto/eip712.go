@@ -5,7 +5,6 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/signer/core/apitypes"
@@ -18,56 +17,146 @@ type IntentToPay struct {
 	Asset     string `json:"asset"`     // Token contract address (USDC)
 	Nonce     string `json:"nonce"`     // Unique session UUID to prevent replay
 	Deadline  uint64 `json:"deadline"`  // Unix timestamp for signature expiry
+
+	// Optional binding to a single HTTP request. When set, the intent is signed as a
+	// BoundIntentToPay and only covers that method, canonical URL and body.
+	Method   string `json:"method,omitempty"`   // HTTP method, upper case
+	URL      string `json:"url,omitempty"`      // Canonical URL (host, path and sorted query)
+	BodyHash string `json:"bodyHash,omitempty"` // keccak256 of the request body (bytes32 hex)
+}
+
+// IsBound reports whether the intent is bound to a specific HTTP request.
+func (i IntentToPay) IsBound() bool {
+	return i.Method != "" || i.URL != "" || i.BodyHash != ""
+}
+
+// DepositIntent is signed by an agent to fund a prepaid credit balance at a merchant.
+type DepositIntent struct {
+	Recipient string `json:"recipient"` // Merchant wallet address
+	Amount    string `json:"amount"`    // Amount credited in atomic units (uint256 string)
+	Asset     string `json:"asset"`     // Token contract address (USDC)
+	Nonce     string `json:"nonce"`     // Challenge nonce the deposit answers
+	Deadline  uint64 `json:"deadline"`  // Unix timestamp for signature expiry
+}
+
+// CreditSpend is signed by an agent for every request it pays from a prepaid balance after
+// the one that credited the deposit. It proves the depositor sent that request.
+type CreditSpend struct {
+	Deposit  string `json:"deposit"`  // EIP-712 digest of the credited deposit (bytes32 hex)
+	Nonce    string `json:"nonce"`    // Chosen by the agent; single use per deposit
+	Deadline uint64 `json:"deadline"` // Unix timestamp for signature expiry
+	Method   string `json:"method"`   // HTTP method, upper case
+	URL      string `json:"url"`      // Canonical URL (host, path and sorted query)
+	BodyHash string `json:"bodyHash"` // keccak256 of the request body (bytes32 hex)
 }
 
 // DomainParams defines the parameters for the EIP-712 domain separator.
 type DomainParams struct {
 	ChainID           *big.Int
 	VerifyingContract common.Address
+	Version           string // Domain version; DomainVersion when empty
+}
+
+// TypedMessage returns the registered primary type and EIP-712 message of the intent.
+func (i IntentToPay) TypedMessage() (string, apitypes.TypedDataMessage) {
+	message := apitypes.TypedDataMessage{
+		"recipient": i.Recipient,
+		"amount":    i.Amount,
+		"asset":     i.Asset,
+		"nonce":     i.Nonce,
+		"deadline":  (*math.HexOrDecimal256)(new(big.Int).SetUint64(i.Deadline)),
+	}
+	if !i.IsBound() {
+		return TypeIntentToPay, message
+	}
+	message["method"] = i.Method
+	message["url"] = i.URL
+	message["bodyHash"] = i.BodyHash
+	return TypeBoundIntentToPay, message
+}
+
+// HashIntentToPay returns the EIP-712 digest an agent signs for the given intent and domain.
+func HashIntentToPay(intent IntentToPay, params DomainParams) ([]byte, error) {
+	if intent.IsBound() && intent.BodyHash == "" {
+		return nil, fmt.Errorf("bound intent requires a bodyHash")
+	}
+	primaryType, message := intent.TypedMessage()
+	return HashTypedMessage(primaryType, message, params)
 }
 
 // VerifyIntentToPay checks if the signature is valid for the given intent and domain.
 func VerifyIntentToPay(intent IntentToPay, signature string, params DomainParams) (common.Address, error) {
+	sighash, err := HashIntentToPay(intent, params)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return RecoverSigner(sighash, signature)
+}
+
+// TypedMessage returns the registered primary type and EIP-712 message of the deposit.
+func (d DepositIntent) TypedMessage() (string, apitypes.TypedDataMessage) {
+	return TypeDeposit, apitypes.TypedDataMessage{
+		"recipient": d.Recipient,
+		"amount":    d.Amount,
+		"asset":     d.Asset,
+		"nonce":     d.Nonce,
+		"deadline":  (*math.HexOrDecimal256)(new(big.Int).SetUint64(d.Deadline)),
+	}
+}
+
+// HashDeposit returns the EIP-712 digest an agent signs for a deposit.
+func HashDeposit(deposit DepositIntent, params DomainParams) ([]byte, error) {
+	primaryType, message := deposit.TypedMessage()
+	return HashTypedMessage(primaryType, message, params)
+}
+
+// VerifyDeposit checks if the signature is valid for the given deposit and domain.
+func VerifyDeposit(deposit DepositIntent, signature string, params DomainParams) (common.Address, error) {
+	sighash, err := HashDeposit(deposit, params)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return RecoverSigner(sighash, signature)
+}
+
+// TypedMessage returns the registered primary type and EIP-712 message of the spend.
+func (c CreditSpend) TypedMessage() (string, apitypes.TypedDataMessage) {
+	return TypeCreditSpend, apitypes.TypedDataMessage{
+		"deposit":  c.Deposit,
+		"nonce":    c.Nonce,
+		"deadline": (*math.HexOrDecimal256)(new(big.Int).SetUint64(c.Deadline)),
+		"method":   c.Method,
+		"url":      c.URL,
+		"bodyHash": c.BodyHash,
+	}
+}
+
+// HashCreditSpend returns the EIP-712 digest an agent signs to spend a deposit on a request.
+func HashCreditSpend(spend CreditSpend, params DomainParams) ([]byte, error) {
+	primaryType, message := spend.TypedMessage()
+	return HashTypedMessage(primaryType, message, params)
+}
+
+// hashTypedData computes the EIP-712 digest of a message under the given domain.
+func hashTypedData(primaryType string, fields []apitypes.Type, message apitypes.TypedDataMessage, domain apitypes.TypedDataDomain) ([]byte, error) {
 	typedData := apitypes.TypedData{
 		Types: apitypes.Types{
-			"EIP712Domain": []apitypes.Type{
-				{Name: "name", Type: "string"},
-				{Name: "version", Type: "string"},
-				{Name: "chainId", Type: "uint256"},
-				{Name: "verifyingContract", Type: "address"},
-			},
-			"IntentToPay": []apitypes.Type{
-				{Name: "recipient", Type: "address"},
-				{Name: "amount", Type: "uint256"},
-				{Name: "asset", Type: "address"},
-				{Name: "nonce", Type: "string"},
-				{Name: "deadline", Type: "uint256"},
-			},
-		},
-		PrimaryType: "IntentToPay",
-		Domain: apitypes.TypedDataDomain{
-			Name:              "SettlerEngine",
-			Version:           "1",
-			ChainId:           (*math.HexOrDecimal256)(params.ChainID),
-			VerifyingContract: params.VerifyingContract.Hex(),
-		},
-		Message: apitypes.TypedDataMessage{
-			"recipient": intent.Recipient,
-			"amount":    intent.Amount,
-			"asset":     intent.Asset,
-			"nonce":     intent.Nonce,
-			"deadline":  (*math.HexOrDecimal256)(big.NewInt(int64(intent.Deadline))),
+			"EIP712Domain": eip712DomainFields,
+			primaryType:    fields,
 		},
+		PrimaryType: primaryType,
+		Domain:      domain,
+		Message:     message,
 	}
 
 	domainSeparator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
 	if err != nil {
-		return common.Address{}, fmt.Errorf("failed to hash domain separator: %w", err)
+		return nil, fmt.Errorf("failed to hash domain separator: %w", err)
 	}
 
 	typedDataHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
 	if err != nil {
-		return common.Address{}, fmt.Errorf("failed to hash message: %w", err)
+		return nil, fmt.Errorf("failed to hash message: %w", err)
 	}
 
 	rawData := make([]byte, 2+32+32)
@@ -75,25 +164,5 @@ func VerifyIntentToPay(intent IntentToPay, signature string, params DomainParams
 	rawData[1] = 0x01
 	copy(rawData[2:34], domainSeparator)
 	copy(rawData[34:66], typedDataHash)
-	sighash := crypto.Keccak256(rawData)
-
-	sig, err := hexutil.Decode(signature)
-	if err != nil {
-		return common.Address{}, fmt.Errorf("failed to decode signature: %w", err)
-	}
-
-	if len(sig) != 65 {
-		return common.Address{}, fmt.Errorf("invalid signature length: %d", len(sig))
-	}
-
-	if sig[64] >= 27 {
-		sig[64] -= 27
-	}
-
-	pubKey, err := crypto.SigToPub(sighash, sig)
-	if err != nil {
-		return common.Address{}, fmt.Errorf("failed to recover public key: %w", err)
-	}
-
-	return crypto.PubkeyToAddress(*pubKey), nil
+	return crypto.Keccak256(rawData), nil
 }
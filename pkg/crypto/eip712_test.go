@@ -1,12 +1,13 @@
 package crypto
 
 import (
+	"bytes"
+	"encoding/json"
 	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
@@ -27,49 +28,27 @@ func TestVerifyIntentToPay(t *testing.T) {
 
 	intent := IntentToPay{
 		Recipient: "0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B",
-		Amount:    "1000000", // 1 USDC (6 decimals)
+		Amount:    "1000000",                                    // 1 USDC (6 decimals)
 		Asset:     "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", // USDC
 		Nonce:     "test-nonce-123",
 		Deadline:  1739686400,
 	}
 
-	// 3. Manually create the hash for signing (mirroring logic in eip712.go)
-	typedData := apitypes.TypedData{
-		Types: apitypes.Types{
-			"EIP712Domain": []apitypes.Type{
-				{Name: "name", Type: "string"},
-				{Name: "version", Type: "string"},
-				{Name: "chainId", Type: "uint256"},
-				{Name: "verifyingContract", Type: "address"},
-			},
-			"IntentToPay": []apitypes.Type{
-				{Name: "recipient", Type: "address"},
-				{Name: "amount", Type: "uint256"},
-				{Name: "asset", Type: "address"},
-				{Name: "nonce", Type: "string"},
-				{Name: "deadline", Type: "uint256"},
-			},
-		},
-		PrimaryType: "IntentToPay",
-		Domain: apitypes.TypedDataDomain{
-			Name:              "SettlerEngine",
-			Version:           "1",
-			ChainId:           (*math.HexOrDecimal256)(params.ChainID),
-			VerifyingContract: params.VerifyingContract.Hex(),
-		},
-		Message: apitypes.TypedDataMessage{
-			"recipient": intent.Recipient,
-			"amount":    intent.Amount,
-			"asset":     intent.Asset,
-			"nonce":     intent.Nonce,
-			"deadline":  (*math.HexOrDecimal256)(big.NewInt(int64(intent.Deadline))),
-		},
-	}
-
-	domainSeparator, _ := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
-	typedDataHash, _ := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
-	rawData := []byte("\x19\x01" + string(domainSeparator) + string(typedDataHash))
-	sighash := crypto.Keccak256(rawData)
+	// 3. Hash the intent from the advertised schema, as an agent would after a 402
+	schema, err := NewTypedDataSchema(TypeIntentToPay, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(schema)
+	var received TypedDataSchema
+	if err := json.Unmarshal(raw, &received); err != nil {
+		t.Fatal(err)
+	}
+	_, message := intent.TypedMessage()
+	sighash, _, err := apitypes.TypedDataAndHash(received.TypedData(message))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// 4. Sign the hash
 	signatureBytes, err := crypto.Sign(sighash, privateKey)
@@ -90,3 +69,73 @@ func TestVerifyIntentToPay(t *testing.T) {
 		t.Errorf("Expected address %s, got %s", addr.Hex(), recoveredAddr.Hex())
 	}
 }
+
+func TestVerifyIntentToPay_Bound(t *testing.T) {
+	privateKey, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(privateKey.PublicKey)
+	params := DomainParams{ChainID: big.NewInt(8453)}
+
+	intent := IntentToPay{
+		Recipient: "0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B",
+		Amount:    "1000000",
+		Asset:     "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+		Nonce:     "test-nonce-123",
+		Deadline:  1739686400,
+		Method:    "POST",
+		URL:       "api.example.com/v1/infer",
+		BodyHash:  hexutil.Encode(crypto.Keccak256([]byte(`{"prompt":"hi"}`))),
+	}
+
+	sighash, err := HashIntentToPay(intent, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureBytes, _ := crypto.Sign(sighash, privateKey)
+	signature := hexutil.Encode(signatureBytes)
+
+	recoveredAddr, err := VerifyIntentToPay(intent, signature, params)
+	if err != nil || recoveredAddr != addr {
+		t.Fatalf("Expected %s, got %s (%v)", addr.Hex(), recoveredAddr.Hex(), err)
+	}
+
+	// The binding is part of the signed message.
+	intent.URL = "api.example.com/v1/other"
+	if recoveredAddr, _ := VerifyIntentToPay(intent, signature, params); recoveredAddr == addr {
+		t.Error("Expected a different URL to change the signed digest")
+	}
+
+	intent.BodyHash = ""
+	if _, err := HashIntentToPay(intent, params); err == nil {
+		t.Error("Expected a bound intent without bodyHash to be rejected")
+	}
+}
+
+func TestHashTypedMessage_DomainVersion(t *testing.T) {
+	params := DomainParams{ChainID: big.NewInt(8453)}
+	intent := IntentToPay{
+		Recipient: "0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B",
+		Amount:    "1000000",
+		Asset:     "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+		Nonce:     "test-nonce-123",
+		Deadline:  1739686400,
+	}
+
+	v1, _ := HashIntentToPay(intent, params)
+	params.Version = DomainVersion
+	if explicit, _ := HashIntentToPay(intent, params); !bytes.Equal(v1, explicit) {
+		t.Error("expected an empty version to mean DomainVersion")
+	}
+	params.Version = "2"
+	if v2, _ := HashIntentToPay(intent, params); bytes.Equal(v1, v2) {
+		t.Error("expected the domain version to change the digest")
+	}
+
+	for _, primaryType := range PrimaryTypes() {
+		if _, err := NewTypedDataSchema(primaryType, params); err != nil {
+			t.Errorf("%s: %v", primaryType, err)
+		}
+	}
+	if _, err := HashTypedMessage("Unknown", nil, params); err == nil {
+		t.Error("expected an unregistered type to be rejected")
+	}
+}
@@ -0,0 +1,178 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+)
+
+// ErrInvalidSignature is returned when a signature does not belong to the claimed signer.
+var ErrInvalidSignature = errors.New("signature does not match signer")
+
+var (
+	// erc1271MagicValue is returned by isValidSignature for a valid signature.
+	erc1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+	// erc6492MagicSuffix ends signatures wrapped for accounts that are not deployed yet.
+	erc6492MagicSuffix = common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")
+
+	erc1271ABI = mustParseABI(`[{"type":"function","name":"isValidSignature","stateMutability":"view",
+		"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],"outputs":[{"name":"","type":"bytes4"}]}]`)
+	erc6492Args = abi.Arguments{
+		{Type: mustType("address")}, // Account factory
+		{Type: mustType("bytes")},   // Factory calldata deploying the account
+		{Type: mustType("bytes")},   // Signature to check once deployed
+	}
+)
+
+// VerifyIntentToPayFrom checks that signer signed the intent. Besides ECDSA it accepts
+// ERC-1271 signatures from deployed smart accounts and EIP-6492 signatures from accounts not
+// deployed yet, checked on the intent's chain through clients. With nil clients only ECDSA is accepted.
+func VerifyIntentToPayFrom(ctx context.Context, clients *chains.MultiClient, intent IntentToPay, signature string, params DomainParams, signer common.Address) error {
+	sighash, err := HashIntentToPay(intent, params)
+	if err != nil {
+		return err
+	}
+	return VerifySignatureFrom(ctx, clients, params.ChainID, signer, sighash, signature)
+}
+
+// VerifyDepositFrom is VerifyIntentToPayFrom for deposits.
+func VerifyDepositFrom(ctx context.Context, clients *chains.MultiClient, deposit DepositIntent, signature string, params DomainParams, signer common.Address) error {
+	sighash, err := HashDeposit(deposit, params)
+	if err != nil {
+		return err
+	}
+	return VerifySignatureFrom(ctx, clients, params.ChainID, signer, sighash, signature)
+}
+
+// VerifySignatureFrom checks that signer produced signature over hash, as an EOA, a deployed
+// ERC-1271 account or a counterfactual EIP-6492 account. It returns ErrInvalidSignature when
+// the signature is not the signer's, and other errors when the chain could not be read.
+func VerifySignatureFrom(ctx context.Context, clients *chains.MultiClient, chainID *big.Int, signer common.Address, hash []byte, signature string) error {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode signature: %v", ErrInvalidSignature, err)
+	}
+
+	var factory common.Address
+	var factoryCalldata []byte
+	if wrapped := bytes.HasSuffix(sig, erc6492MagicSuffix); wrapped {
+		values, err := erc6492Args.Unpack(sig[:len(sig)-len(erc6492MagicSuffix)])
+		if err != nil {
+			return fmt.Errorf("%w: malformed EIP-6492 signature: %v", ErrInvalidSignature, err)
+		}
+		factory, factoryCalldata, sig = values[0].(common.Address), values[1].([]byte), values[2].([]byte)
+	}
+
+	if len(sig) == 64 || len(sig) == 65 {
+		if recovered, err := RecoverSigner(hash, hexutil.Encode(sig)); err == nil && recovered == signer {
+			return nil
+		}
+	}
+	if clients == nil {
+		return ErrInvalidSignature
+	}
+	if chainID == nil || !chainID.IsUint64() {
+		return fmt.Errorf("invalid chain ID %v", chainID)
+	}
+	id := chains.ChainID(chainID.Uint64())
+	client, err := clients.GetClient(id)
+	if err != nil {
+		return err
+	}
+
+	call, err := erc1271ABI.Pack("isValidSignature", common.BytesToHash(hash), sig)
+	if err != nil {
+		return err
+	}
+	code, err := client.CodeAt(ctx, signer, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read code of %s: %w", signer.Hex(), err)
+	}
+
+	var result []byte
+	switch {
+	case len(code) > 0:
+		result, err = client.CallContract(ctx, ethereum.CallMsg{To: &signer, Data: call}, nil)
+		if err != nil {
+			// A reverting isValidSignature rejects the signature.
+			return fmt.Errorf("%w: isValidSignature reverted: %v", ErrInvalidSignature, err)
+		}
+	case factory != (common.Address{}):
+		result, err = simulateDeployment(ctx, client, id, factory, factoryCalldata, signer, call)
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidSignature
+	}
+
+	if len(result) < 4 || !bytes.Equal(result[:4], erc1271MagicValue[:]) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// simulateDeployment deploys a counterfactual account and asks it to validate the signature,
+// in one eth_call through Multicall3, and returns what isValidSignature returned.
+func simulateDeployment(ctx context.Context, client ethereum.ContractCaller, id chains.ChainID, factory common.Address, factoryCalldata []byte, account common.Address, call []byte) ([]byte, error) {
+	multicall := common.HexToAddress(chains.Multicall3Address)
+	if cfg, err := chains.GetChainConfig(id); err == nil {
+		multicall = cfg.Multicall()
+	}
+	data, err := chains.Multicall3ABI.Pack("aggregate3", []chains.Call3{
+		{Target: factory, AllowFailure: true, CallData: factoryCalldata},
+		{Target: account, AllowFailure: true, CallData: call},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.CallContract(ctx, ethereum.CallMsg{To: &multicall, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate account deployment: %w", err)
+	}
+
+	var results []struct {
+		Success    bool
+		ReturnData []byte
+	}
+	if err := chains.Multicall3ABI.UnpackIntoInterface(&results, "aggregate3", out); err != nil {
+		return nil, fmt.Errorf("failed to decode simulation: %w", err)
+	}
+	if len(results) != 2 || !results[1].Success {
+		return nil, fmt.Errorf("%w: account rejected the signature after deployment", ErrInvalidSignature)
+	}
+	return results[1].ReturnData, nil
+}
+
+// WrapERC6492 wraps a signature for an account that is not deployed yet, as EIP-6492 describes.
+func WrapERC6492(factory common.Address, factoryCalldata, signature []byte) ([]byte, error) {
+	packed, err := erc6492Args.Pack(factory, factoryCalldata, signature)
+	if err != nil {
+		return nil, err
+	}
+	return append(packed, erc6492MagicSuffix...), nil
+}
+
+func mustParseABI(def string) abi.ABI {
+	parsed, err := abi.JSON(bytes.NewReader([]byte(def)))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func mustType(t string) abi.Type {
+	typ, err := abi.NewType(t, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+)
+
+// fakeAccount serves a smart account at account that accepts signatures by owner. Unless
+// deployed, it only exists inside a Multicall3 simulation that first calls factory.
+func fakeAccount(t *testing.T, account, factory common.Address, owner *ecdsa.PrivateKey, deployed bool) *chains.MultiClient {
+	srv := chaintest.NewServer(31338)
+	t.Cleanup(srv.Close)
+	chains.RegisterChain(chains.ChainConfig{Name: "Account Test", ChainID: 31338, RPCURL: srv.URL})
+
+	srv.Handle("eth_getCode", func(params []json.RawMessage) (any, error) {
+		var addr common.Address
+		json.Unmarshal(params[0], &addr)
+		if addr == account && deployed {
+			return "0x6001", nil
+		}
+		return "0x", nil
+	})
+
+	isValid := func(input []byte) ([]byte, error) {
+		args, err := erc1271ABI.Methods["isValidSignature"].Inputs.Unpack(input[4:])
+		if err != nil {
+			return nil, err
+		}
+		hash, sig := args[0].([32]byte), args[1].([]byte)
+		result := make([]byte, 32)
+		if recovered, err := RecoverSigner(hash[:], hexutil.Encode(sig)); err == nil && recovered == crypto.PubkeyToAddress(owner.PublicKey) {
+			copy(result, erc1271MagicValue[:])
+		}
+		return result, nil
+	}
+
+	srv.Handle("eth_call", func(params []json.RawMessage) (any, error) {
+		var call struct {
+			To    common.Address `json:"to"`
+			Input hexutil.Bytes  `json:"input"`
+		}
+		json.Unmarshal(params[0], &call)
+		switch {
+		case call.To == account && deployed:
+			result, err := isValid(call.Input)
+			return hexutil.Encode(result), err
+		case call.To == common.HexToAddress(chains.Multicall3Address):
+			args, err := chains.Multicall3ABI.Methods["aggregate3"].Inputs.Unpack(call.Input[4:])
+			if err != nil {
+				return nil, err
+			}
+			var calls []chains.Call3
+			chains.Multicall3ABI.Methods["aggregate3"].Inputs.Copy(&calls, args)
+			type result struct {
+				Success    bool
+				ReturnData []byte
+			}
+			var results []result
+			created := false
+			for _, c := range calls {
+				switch {
+				case c.Target == factory:
+					created = true
+					results = append(results, result{Success: true, ReturnData: account.Bytes()})
+				case c.Target == account && (created || deployed):
+					data, err := isValid(c.CallData)
+					results = append(results, result{Success: err == nil, ReturnData: data})
+				default:
+					results = append(results, result{})
+				}
+			}
+			out, err := chains.Multicall3ABI.Methods["aggregate3"].Outputs.Pack(results)
+			return hexutil.Encode(out), err
+		}
+		return nil, fmt.Errorf("unexpected call to %s", call.To.Hex())
+	})
+
+	clients := chains.NewMultiClient()
+	t.Cleanup(clients.Close)
+	return clients
+}
+
+func TestVerifySignatureFrom(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	stranger, _ := crypto.GenerateKey()
+	account := common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	factory := common.HexToAddress("0x00000000000000000000000000000000000fac70")
+	chainID := big.NewInt(31338)
+	hash := crypto.Keccak256([]byte("intent"))
+
+	sign := func(key *ecdsa.PrivateKey) []byte {
+		sig, _ := crypto.Sign(hash, key)
+		sig[64] += 27
+		return sig
+	}
+	wrap := func(sig []byte) []byte {
+		wrapped, err := WrapERC6492(factory, []byte{0xde, 0xad}, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wrapped
+	}
+
+	tests := []struct {
+		name     string
+		signer   common.Address
+		sig      []byte
+		deployed bool
+		valid    bool
+	}{
+		{name: "EOA", signer: crypto.PubkeyToAddress(owner.PublicKey), sig: sign(owner), valid: true},
+		{name: "EOA mismatch", signer: crypto.PubkeyToAddress(stranger.PublicKey), sig: sign(owner)},
+		{name: "ERC-1271", signer: account, sig: sign(owner), deployed: true, valid: true},
+		{name: "ERC-1271 wrong owner", signer: account, sig: sign(stranger), deployed: true},
+		{name: "EIP-6492 counterfactual", signer: account, sig: wrap(sign(owner)), valid: true},
+		{name: "EIP-6492 wrong owner", signer: account, sig: wrap(sign(stranger))},
+		{name: "EIP-6492 already deployed", signer: account, sig: wrap(sign(owner)), deployed: true, valid: true},
+		{name: "undeployed without wrapper", signer: account, sig: sign(owner)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := fakeAccount(t, account, factory, owner, tt.deployed)
+			err := VerifySignatureFrom(context.Background(), clients, chainID, tt.signer, hash, hexutil.Encode(tt.sig))
+			if tt.valid && err != nil {
+				t.Errorf("expected a valid signature, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+
+	// Without clients only ECDSA signatures are accepted.
+	if err := VerifySignatureFrom(context.Background(), nil, chainID, account, hash, hexutil.Encode(sign(owner))); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature without clients, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// Journal is the outgoing transaction journal. Every transaction the engine sends is kept in
+// storage with its raw bytes, purpose and reference until it is mined, so that a restart can
+// pick up whatever the previous run left in flight.
+type Journal struct {
+	db           *storage.DB
+	clients      *chains.MultiClient
+	pollInterval time.Duration // Overrides each chain's block time when set
+}
+
+// NewJournal creates a journal kept in db. clients is only needed to Resume.
+func NewJournal(db *storage.DB, clients *chains.MultiClient) *Journal {
+	return &Journal{db: db, clients: clients}
+}
+
+// Record adds tx, sent from sender for purpose, to the journal. reference is the invoice,
+// payment or strategy it was sent for. A nil journal records nothing.
+func (j *Journal) Record(tx *types.Transaction, sender common.Address, purpose, reference string) {
+	if j == nil || j.db == nil {
+		return
+	}
+	row, err := journalEntry(tx, sender, purpose, reference)
+	if err == nil {
+		err = j.db.RecordTransaction(row)
+	}
+	if err != nil {
+		log.Printf("⚠️  tx: Failed to record %s: %v", tx.Hash().Hex(), err)
+	}
+}
+
+// Settle closes the journal entry of a mined transaction with its receipt.
+func (j *Journal) Settle(receipt *types.Receipt) {
+	if j == nil || j.db == nil {
+		return
+	}
+	recordReceipt(j.db, receipt)
+}
+
+// Resume picks up the transactions left PENDING by a previous run. Each one is settled once it
+// was mined with its chain's confirmations on top, marked DROPPED if another transaction took
+// its nonce, and otherwise rebroadcast and followed in the background until it is settled or
+// dropped. It returns how many are still in flight.
+func (j *Journal) Resume(ctx context.Context) (int, error) {
+	rows, err := j.db.ListPendingTransactions()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load pending transactions: %w", err)
+	}
+
+	resumed := 0
+	for _, row := range rows {
+		client, err := j.clients.GetClient(chains.ChainID(row.ChainID))
+		if err != nil {
+			log.Printf("⚠️  tx: Cannot resume %s on chain %d: %v", row.Hash, row.ChainID, err)
+			continue
+		}
+		tx, err := decodeRaw(row.Raw)
+		if err != nil {
+			log.Printf("⚠️  tx: Cannot resume %s: %v", row.Hash, err)
+			j.drop(row.Hash, "undecodable")
+			continue
+		}
+
+		// 1. Mined while the engine was down
+		closed, mined, err := j.check(ctx, client, tx, row)
+		if err != nil {
+			log.Printf("⚠️  tx: Failed to check %s: %v", row.Hash, err)
+			continue
+		}
+		if closed {
+			continue
+		}
+
+		// 2. Still valid: make sure the node has it
+		if !mined {
+			if err := client.SendTransaction(ctx, tx); err != nil {
+				switch msg := err.Error(); {
+				case strings.Contains(msg, "already known"), strings.Contains(msg, "known transaction"):
+				case strings.Contains(msg, "nonce too low"):
+					closed, _, err := j.dropUnlessMined(ctx, client, tx, row)
+					if err != nil {
+						log.Printf("⚠️  tx: Failed to check %s: %v", row.Hash, err)
+					}
+					if closed || err != nil {
+						continue
+					}
+				default:
+					log.Printf("⚠️  tx: Failed to rebroadcast %s: %v", row.Hash, err)
+				}
+			} else {
+				log.Printf("🔁 tx: Rebroadcast %s %s (nonce %d)", row.Purpose, row.Hash, row.Nonce)
+			}
+		}
+		resumed++
+		go j.follow(client, tx, row)
+	}
+	return resumed, nil
+}
+
+// check settles a journaled transaction that was mined deep enough, or drops it if its nonce
+// was taken by another one. It reports whether the entry is closed, and whether the
+// transaction is mined but still short of its confirmations.
+func (j *Journal) check(ctx context.Context, client *ethclient.Client, tx *types.Transaction, row storage.TransactionRow) (closed, mined bool, err error) {
+	if closed, mined, err := j.settleIfMined(ctx, client, tx, row); mined || err != nil {
+		return closed, mined, err
+	}
+	nonce, err := client.NonceAt(ctx, common.HexToAddress(row.Sender), nil)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get nonce: %w", err)
+	}
+	if nonce > row.Nonce {
+		return j.dropUnlessMined(ctx, client, tx, row)
+	}
+	return false, false, nil
+}
+
+// settleIfMined records the receipt of tx if it was mined with its chain's confirmations on
+// top. It reports whether the entry is closed and whether tx was mined at all.
+func (j *Journal) settleIfMined(ctx context.Context, client *ethclient.Client, tx *types.Transaction, row storage.TransactionRow) (closed, mined bool, err error) {
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	switch {
+	case errors.Is(err, ethereum.NotFound):
+		return false, false, nil
+	case err != nil:
+		return false, false, err
+	}
+	chain, _ := chains.GetChainConfig(chains.ChainID(row.ChainID))
+	receipt, final, err := chains.Final(ctx, client, receipt, chain.Confirmations)
+	if err != nil || !final {
+		return false, receipt != nil, err
+	}
+	recordReceipt(j.db, receipt)
+	return true, true, nil
+}
+
+// dropUnlessMined marks a transaction whose nonce was used as DROPPED. DROPPED is final, so
+// the receipt is looked up once more first: the transaction itself may have been mined at
+// that nonce since it was last checked.
+func (j *Journal) dropUnlessMined(ctx context.Context, client *ethclient.Client, tx *types.Transaction, row storage.TransactionRow) (closed, mined bool, err error) {
+	if closed, mined, err := j.settleIfMined(ctx, client, tx, row); mined || err != nil {
+		return closed, mined, err
+	}
+	j.drop(row.Hash, "nonce used by another transaction")
+	return true, false, nil
+}
+
+// follow polls a resumed transaction, every block time of its chain, until its entry is
+// closed. Entries still open after trackTimeout stay PENDING for the next run.
+func (j *Journal) follow(client *ethclient.Client, tx *types.Transaction, row storage.TransactionRow) {
+	ctx, cancel := context.WithTimeout(context.Background(), trackTimeout)
+	defer cancel()
+	interval := j.pollInterval
+	if interval == 0 {
+		chain, _ := chains.GetChainConfig(chains.ChainID(row.ChainID))
+		interval = chain.PollInterval()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("⚠️  tx: %s still pending after %s", row.Hash, trackTimeout)
+			return
+		case <-ticker.C:
+		}
+		closed, _, err := j.check(ctx, client, tx, row)
+		if err != nil {
+			log.Printf("⚠️  tx: Failed to check %s: %v", row.Hash, err)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+func (j *Journal) drop(hash, reason string) {
+	if err := j.db.UpdateTransactionStatus(hash, storage.TransactionDropped); err != nil {
+		log.Printf("⚠️  tx: Failed to record %s as dropped: %v", hash, err)
+		return
+	}
+	log.Printf("🗑️  tx: Dropped %s: %s", hash, reason)
+}
+
+// journalEntry builds the PENDING journal entry of a sent transaction.
+func journalEntry(tx *types.Transaction, sender common.Address, purpose, reference string) (storage.TransactionRow, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return storage.TransactionRow{}, fmt.Errorf("failed to encode transaction: %w", err)
+	}
+	return storage.TransactionRow{
+		Hash:      tx.Hash().Hex(),
+		ChainID:   tx.ChainId().Uint64(),
+		Sender:    sender.Hex(),
+		Nonce:     tx.Nonce(),
+		Raw:       hexutil.Encode(raw),
+		Status:    storage.TransactionPending,
+		Purpose:   purpose,
+		Reference: reference,
+	}, nil
+}
+
+// recordReceipt moves the entry of a mined transaction to CONFIRMED or REVERTED.
+func recordReceipt(db *storage.DB, receipt *types.Receipt) {
+	status := storage.TransactionConfirmed
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		status = storage.TransactionReverted
+	}
+	encoded, err := receipt.MarshalJSON()
+	if err == nil {
+		err = db.RecordTransactionReceipt(receipt.TxHash.Hex(), status, string(encoded))
+	}
+	if err != nil {
+		log.Printf("⚠️  tx: Failed to record %s as %s: %v", receipt.TxHash.Hex(), status, err)
+	}
+}
+
+func decodeRaw(raw string) (*types.Transaction, error) {
+	b, err := hexutil.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
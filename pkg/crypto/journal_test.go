@@ -0,0 +1,169 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+func TestJournal_Resume(t *testing.T) {
+	const chainID = 31337
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer db.Close()
+
+	// 1. A previous run left three transactions in flight; the account has since used nonce 1.
+	key := NewTestSigner("eoa")
+	journal := NewJournal(db, nil)
+	var txs []*types.Transaction
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx, err := key.SignTx(types.NewTx(&types.DynamicFeeTx{
+			ChainID: big.NewInt(chainID), Nonce: nonce, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2), Gas: 21_000, To: &testVault,
+		}), big.NewInt(chainID))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		journal.Record(tx, key.Address(), OperationHarvest, "strategy-1")
+		txs = append(txs, tx)
+	}
+
+	// 2. Nonce 0 was mined, nonce 1 was replaced by something else, and the node has
+	// forgotten nonce 2 until it is rebroadcast.
+	srv := chaintest.NewServer(chainID)
+	defer srv.Close()
+	chains.RegisterChain(chains.ChainConfig{Name: "Test", Network: "test", ChainID: chainID, RPCURL: srv.URL})
+	var mu sync.Mutex
+	mined := map[common.Hash]bool{txs[0].Hash(): true}
+	rebroadcast := make(chan common.Hash, 1)
+	srv.Handle("eth_getTransactionCount", chaintest.Result(hexutil.EncodeUint64(2)))
+	srv.Handle("eth_sendRawTransaction", func(params []json.RawMessage) (any, error) {
+		var raw hexutil.Bytes
+		json.Unmarshal(params[0], &raw)
+		tx := new(types.Transaction)
+		tx.UnmarshalBinary(raw)
+		rebroadcast <- tx.Hash()
+		return tx.Hash(), nil
+	})
+	srv.Handle("eth_getTransactionReceipt", func(params []json.RawMessage) (any, error) {
+		var hash common.Hash
+		json.Unmarshal(params[0], &hash)
+		mu.Lock()
+		defer mu.Unlock()
+		if !mined[hash] {
+			return nil, nil
+		}
+		return map[string]any{
+			"transactionHash": hash, "transactionIndex": "0x0", "blockHash": common.Hash{1}, "blockNumber": "0x2",
+			"status": "0x1", "cumulativeGasUsed": "0x5208", "gasUsed": "0x5208", "effectiveGasPrice": "0x1",
+			"logsBloom": hexutil.Encode(make([]byte, 256)), "logs": []any{}, "type": "0x2",
+		}, nil
+	})
+
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+	journal = NewJournal(db, clients)
+	journal.pollInterval = 5 * time.Millisecond
+	resumed, err := journal.Resume(context.Background())
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if resumed != 1 {
+		t.Errorf("expected one transaction still in flight, got %d", resumed)
+	}
+	if hash := <-rebroadcast; hash != txs[2].Hash() {
+		t.Errorf("expected nonce 2 to be rebroadcast, got %s", hash.Hex())
+	}
+
+	sender := key.Address().Hex()
+	confirmed, _ := db.ListTransactions(chainID, sender, storage.TransactionConfirmed)
+	dropped, _ := db.ListTransactions(chainID, sender, storage.TransactionDropped)
+	if len(confirmed) != 1 || confirmed[0].Hash != txs[0].Hash().Hex() || confirmed[0].Receipt == "" {
+		t.Errorf("expected nonce 0 to be confirmed with its receipt, got %+v", confirmed)
+	}
+	if len(dropped) != 1 || dropped[0].Hash != txs[1].Hash().Hex() {
+		t.Errorf("expected nonce 1 to be dropped, got %+v", dropped)
+	}
+	if confirmed[0].Purpose != OperationHarvest || confirmed[0].Reference != "strategy-1" {
+		t.Errorf("expected the purpose and reference to be kept, got %q, %q", confirmed[0].Purpose, confirmed[0].Reference)
+	}
+
+	// 3. The rebroadcast transaction is followed until it is mined.
+	mu.Lock()
+	mined[txs[2].Hash()] = true
+	mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		confirmed, _ = db.ListTransactions(chainID, sender, storage.TransactionConfirmed)
+		if len(confirmed) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rebroadcast transaction was not confirmed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJournal_MinedWhileCheckingNonce(t *testing.T) {
+	const chainID = 31338
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer db.Close()
+
+	key := NewTestSigner("eoa")
+	tx, _ := key.SignTx(types.NewTx(&types.DynamicFeeTx{
+		ChainID: big.NewInt(chainID), GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2), Gas: 21_000, To: &testVault,
+	}), big.NewInt(chainID))
+	NewJournal(db, nil).Record(tx, key.Address(), OperationDeposit, "strategy-1")
+
+	// The transaction is mined right after its receipt was first looked up, so the nonce
+	// read already counts it.
+	srv := chaintest.NewServer(chainID)
+	defer srv.Close()
+	chains.RegisterChain(chains.ChainConfig{Name: "Test", Network: "test", ChainID: chainID, RPCURL: srv.URL})
+	var mu sync.Mutex
+	mined := false
+	srv.Handle("eth_getTransactionCount", func([]json.RawMessage) (any, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		mined = true
+		return "0x1", nil
+	})
+	srv.Handle("eth_getTransactionReceipt", func([]json.RawMessage) (any, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !mined {
+			return nil, nil
+		}
+		return map[string]any{
+			"transactionHash": tx.Hash(), "transactionIndex": "0x0", "blockHash": common.Hash{1}, "blockNumber": "0x2",
+			"status": "0x1", "cumulativeGasUsed": "0x5208", "gasUsed": "0x5208", "effectiveGasPrice": "0x1",
+			"logsBloom": hexutil.Encode(make([]byte, 256)), "logs": []any{}, "type": "0x2",
+		}, nil
+	})
+
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+	if _, err := NewJournal(db, clients).Resume(context.Background()); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	confirmed, _ := db.ListTransactions(chainID, key.Address().Hex(), storage.TransactionConfirmed)
+	if len(confirmed) != 1 {
+		dropped, _ := db.ListTransactions(chainID, key.Address().Hex(), storage.TransactionDropped)
+		t.Errorf("expected the transaction to be confirmed, got %d confirmed and %d dropped", len(confirmed), len(dropped))
+	}
+}
@@ -0,0 +1,325 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Signer holds a secp256k1 key that signs on behalf of the engine. The key may live in
+// process memory or behind a remote signer, so signers are asked for whole transactions and
+// messages rather than raw digests.
+type Signer interface {
+	// Address returns the address of the key.
+	Address() common.Address
+
+	// SignTx signs tx for chainID.
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+
+	// SignText signs data as an EIP-191 personal message and returns [R || S || V] with V
+	// of 0 or 1.
+	SignText(data []byte) ([]byte, error)
+
+	// SignTypedData signs EIP-712 typed data and returns [R || S || V] with V of 0 or 1.
+	SignTypedData(typedData apitypes.TypedData) ([]byte, error)
+}
+
+// localKey signs with a private key held in process memory.
+type localKey struct {
+	key *ecdsa.PrivateKey
+}
+
+// SignHash signs a 32-byte digest and returns [R || S || V] with V of 0 or 1.
+func (k localKey) SignHash(hash []byte) ([]byte, error) {
+	return crypto.Sign(hash, k.key)
+}
+
+func (k localKey) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), k.key)
+}
+
+func (k localKey) SignText(data []byte) ([]byte, error) {
+	return crypto.Sign(accounts.TextHash(data), k.key)
+}
+
+func (k localKey) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash typed data: %w", err)
+	}
+	return crypto.Sign(hash, k.key)
+}
+
+// PrivateKeySigner signs with a private key held in process memory.
+type PrivateKeySigner struct {
+	localKey
+	address common.Address
+}
+
+func NewPrivateKeySigner(key *ecdsa.PrivateKey) *PrivateKeySigner {
+	return &PrivateKeySigner{localKey: localKey{key: key}, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// ParsePrivateKeySigner parses a hex private key, with or without 0x.
+func ParsePrivateKeySigner(hexKey string) (*PrivateKeySigner, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(hexKey), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return NewPrivateKeySigner(key), nil
+}
+
+// EnvSigner reads a hex private key from an environment variable. It is meant for
+// development; production keys belong in an encrypted keystore.
+func EnvSigner(name string) (*PrivateKeySigner, error) {
+	hexKey := os.Getenv(name)
+	if hexKey == "" {
+		return nil, fmt.Errorf("%s is not set", name)
+	}
+	signer, err := ParsePrivateKeySigner(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return signer, nil
+}
+
+// NewTestSigner derives a deterministic key from seed, so tests get stable addresses.
+func NewTestSigner(seed string) *PrivateKeySigner {
+	key, err := crypto.ToECDSA(crypto.Keccak256([]byte(seed)))
+	if err != nil {
+		panic(fmt.Sprintf("test signer %q: %v", seed, err))
+	}
+	return NewPrivateKeySigner(key)
+}
+
+func (s *PrivateKeySigner) Address() common.Address {
+	return s.address
+}
+
+// KeystoreSigner signs with a key decrypted from a geth-format encrypted keystore file.
+type KeystoreSigner struct {
+	localKey
+	path    string
+	address common.Address
+}
+
+// LoadKeystore decrypts a keystore file with passphrase.
+func LoadKeystore(path, passphrase string) (*KeystoreSigner, error) {
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore %s: %w", path, err)
+	}
+	return &KeystoreSigner{localKey: localKey{key: key.PrivateKey}, path: path, address: key.Address}, nil
+}
+
+// Path returns the keystore file the key was loaded from.
+func (s *KeystoreSigner) Path() string {
+	return s.path
+}
+
+func (s *KeystoreSigner) Address() common.Address {
+	return s.address
+}
+
+// ReadPassphrase reads a keystore passphrase from file, if set, or else from the environment
+// variable env. A trailing newline in the file is ignored.
+func ReadPassphrase(file, env string) (string, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if env != "" {
+		if passphrase, ok := os.LookupEnv(env); ok {
+			return passphrase, nil
+		}
+	}
+	return "", errors.New("no passphrase file or environment variable set")
+}
+
+// ErrNoActiveKey is returned by a KeyDir no key has been generated or imported into.
+var ErrNoActiveKey = errors.New("no active key")
+
+// activeKeyFile names the key a KeyDir signs with by default.
+const activeKeyFile = ".active" // Dot files are skipped when the keystore scans the directory
+
+// KeyDir is a directory of geth-format encrypted keystores, one of which is active.
+type KeyDir struct {
+	dir string
+	ks  *keystore.KeyStore
+}
+
+// OpenKeyDir opens or creates a key directory with standard scrypt parameters.
+func OpenKeyDir(dir string) (*KeyDir, error) {
+	return openKeyDir(dir, keystore.StandardScryptN, keystore.StandardScryptP)
+}
+
+func openKeyDir(dir string, scryptN, scryptP int) (*KeyDir, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	return &KeyDir{dir: dir, ks: keystore.NewKeyStore(dir, scryptN, scryptP)}, nil
+}
+
+// Dir returns the path of the key directory.
+func (d *KeyDir) Dir() string {
+	return d.dir
+}
+
+// Generate creates a new key encrypted with passphrase. The first key becomes active.
+func (d *KeyDir) Generate(passphrase string) (common.Address, error) {
+	account, err := d.ks.NewAccount(passphrase)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return account.Address, d.activateFirst(account.Address)
+}
+
+// Import stores a private key encrypted with passphrase. The first key becomes active.
+func (d *KeyDir) Import(key *ecdsa.PrivateKey, passphrase string) (common.Address, error) {
+	account, err := d.ks.ImportECDSA(key, passphrase)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to import key: %w", err)
+	}
+	return account.Address, d.activateFirst(account.Address)
+}
+
+// ImportKeystore stores an existing keystore file, re-encrypting it with newPassphrase.
+func (d *KeyDir) ImportKeystore(keyJSON []byte, passphrase, newPassphrase string) (common.Address, error) {
+	account, err := d.ks.Import(keyJSON, passphrase, newPassphrase)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to import keystore: %w", err)
+	}
+	return account.Address, d.activateFirst(account.Address)
+}
+
+// List returns the addresses of the stored keys.
+func (d *KeyDir) List() []common.Address {
+	var addrs []common.Address
+	for _, account := range d.ks.Accounts() {
+		addrs = append(addrs, account.Address)
+	}
+	return addrs
+}
+
+// Active returns the address of the active key.
+func (d *KeyDir) Active() (common.Address, error) {
+	b, err := os.ReadFile(filepath.Join(d.dir, activeKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return common.Address{}, fmt.Errorf("%w in %s", ErrNoActiveKey, d.dir)
+	}
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to read active key: %w", err)
+	}
+	addr := strings.TrimSpace(string(b))
+	if !common.IsHexAddress(addr) {
+		return common.Address{}, fmt.Errorf("invalid active key %q", addr)
+	}
+	return common.HexToAddress(addr), nil
+}
+
+// SetActive makes a stored key the active one.
+func (d *KeyDir) SetActive(addr common.Address) error {
+	if !d.ks.HasAddress(addr) {
+		return fmt.Errorf("no key for %s in %s", addr.Hex(), d.dir)
+	}
+	if err := os.WriteFile(filepath.Join(d.dir, activeKeyFile), []byte(addr.Hex()+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to set active key: %w", err)
+	}
+	return nil
+}
+
+// Rotate generates a new key and makes it active. The previous key is kept so funds and
+// grants can be moved off it.
+func (d *KeyDir) Rotate(passphrase string) (previous, next common.Address, err error) {
+	previous, _ = d.Active()
+	account, err := d.ks.NewAccount(passphrase)
+	if err != nil {
+		return previous, common.Address{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return previous, account.Address, d.SetActive(account.Address)
+}
+
+// Signer decrypts a stored key, or the active key if addr is zero.
+func (d *KeyDir) Signer(addr common.Address, passphrase string) (*KeystoreSigner, error) {
+	if addr == (common.Address{}) {
+		active, err := d.Active()
+		if err != nil {
+			return nil, err
+		}
+		addr = active
+	}
+	account, err := d.ks.Find(accounts.Account{Address: addr})
+	if err != nil {
+		return nil, fmt.Errorf("no key for %s in %s", addr.Hex(), d.dir)
+	}
+	return LoadKeystore(account.URL.Path, passphrase)
+}
+
+func (d *KeyDir) activateFirst(addr common.Address) error {
+	if _, err := d.Active(); err == nil {
+		return nil
+	}
+	return d.SetActive(addr)
+}
+
+// KeySource says where a signing key is loaded from: a keystore file if Keystore is set, else
+// a development key if the RawKeyEnv variable is set, else the active key of KeyDir.
+type KeySource struct {
+	Keystore       string // Path of a keystore file
+	KeyDir         string // Directory of keys managed by `settler keys`
+	PassphraseFile string // File holding the keystore passphrase
+	PassphraseEnv  string // Variable holding the passphrase when there is no file
+	RawKeyEnv      string // Variable holding a hex private key, for development
+}
+
+// Load returns the signer the source points at.
+func (k KeySource) Load() (Signer, error) {
+	switch {
+	case k.Keystore != "":
+		passphrase, err := ReadPassphrase(k.PassphraseFile, k.PassphraseEnv)
+		if err != nil {
+			return nil, err
+		}
+		return LoadKeystore(k.Keystore, passphrase)
+	case k.RawKeyEnv != "" && os.Getenv(k.RawKeyEnv) != "":
+		return EnvSigner(k.RawKeyEnv)
+	case k.KeyDir != "":
+		dir, err := OpenKeyDir(k.KeyDir)
+		if err != nil {
+			return nil, err
+		}
+		active, err := dir.Active()
+		if err != nil {
+			return nil, err
+		}
+		passphrase, err := ReadPassphrase(k.PassphraseFile, k.PassphraseEnv)
+		if err != nil {
+			return nil, err
+		}
+		return dir.Signer(active, passphrase)
+	}
+	return nil, errors.New("no key source configured")
+}
+
+var (
+	_ Signer = (*PrivateKeySigner)(nil)
+	_ Signer = (*KeystoreSigner)(nil)
+)
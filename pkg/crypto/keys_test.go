@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func testKeyDir(t *testing.T) *KeyDir {
+	d, err := openKeyDir(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("open key dir: %v", err)
+	}
+	return d
+}
+
+// signs checks that s produces signatures that recover to its address.
+func signs(t *testing.T, s Signer) {
+	t.Helper()
+	msg := []byte("settler")
+	sig, err := s.SignText(msg)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	recovered, err := crypto.SigToPub(accounts.TextHash(msg), sig)
+	if err != nil || crypto.PubkeyToAddress(*recovered) != s.Address() {
+		t.Errorf("signature does not recover to %s", s.Address().Hex())
+	}
+}
+
+func TestKeyDir_Lifecycle(t *testing.T) {
+	d := testKeyDir(t)
+
+	if _, err := d.Active(); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("expected no active key in an empty directory, got %v", err)
+	}
+	first, err := d.Generate("hunter2")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	imported, err := d.Import(NewTestSigner("imported").key, "hunter2")
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if imported != NewTestSigner("imported").Address() {
+		t.Errorf("imported key has address %s", imported.Hex())
+	}
+	if active, _ := d.Active(); active != first {
+		t.Errorf("active key = %s, want the first key %s", active.Hex(), first.Hex())
+	}
+	if got := d.List(); len(got) != 2 {
+		t.Errorf("expected 2 keys, got %v", got)
+	}
+
+	signer, err := d.Signer(common.Address{}, "hunter2")
+	if err != nil {
+		t.Fatalf("load active key: %v", err)
+	}
+	if signer.Address() != first {
+		t.Errorf("active signer is %s, want %s", signer.Address().Hex(), first.Hex())
+	}
+	signs(t, signer)
+	if _, err := d.Signer(first, "wrong"); err == nil {
+		t.Error("expected a wrong passphrase to be rejected")
+	}
+
+	previous, next, err := d.Rotate("hunter3")
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if previous != first || next == first {
+		t.Errorf("rotate returned %s -> %s", previous.Hex(), next.Hex())
+	}
+	if active, _ := d.Active(); active != next {
+		t.Errorf("active key after rotation = %s, want %s", active.Hex(), next.Hex())
+	}
+	if err := d.SetActive(common.HexToAddress("0x1234")); err == nil {
+		t.Error("expected an unknown key to be refused as active")
+	}
+}
+
+func TestKeyDir_ImportKeystore(t *testing.T) {
+	key := NewTestSigner("exported").key
+	keyJSON, err := keystore.EncryptKey(&keystore.Key{
+		Address:    crypto.PubkeyToAddress(key.PublicKey),
+		PrivateKey: key,
+	}, "old", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	d := testKeyDir(t)
+	addr, err := d.ImportKeystore(keyJSON, "old", "new")
+	if err != nil {
+		t.Fatalf("import keystore: %v", err)
+	}
+	if _, err := d.Signer(addr, "new"); err != nil {
+		t.Errorf("expected the key to be re-encrypted with the new passphrase: %v", err)
+	}
+}
+
+func TestKeySource_Load(t *testing.T) {
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "passphrase")
+	os.WriteFile(passFile, []byte("s3cret\n"), 0600)
+
+	key := NewTestSigner("keystore").key
+	keyJSON, _ := keystore.EncryptKey(&keystore.Key{Address: crypto.PubkeyToAddress(key.PublicKey), PrivateKey: key}, "s3cret", keystore.LightScryptN, keystore.LightScryptP)
+	keyFile := filepath.Join(dir, "key.json")
+	os.WriteFile(keyFile, keyJSON, 0600)
+
+	// 1. Keystore file, passphrase from a file.
+	s, err := KeySource{Keystore: keyFile, PassphraseFile: passFile}.Load()
+	if err != nil {
+		t.Fatalf("load keystore: %v", err)
+	}
+	if s.Address() != NewTestSigner("keystore").Address() {
+		t.Errorf("keystore signer is %s", s.Address().Hex())
+	}
+	signs(t, s)
+
+	// 2. Passphrase from the environment.
+	t.Setenv("TEST_KEYSTORE_PASSWORD", "s3cret")
+	if _, err := (KeySource{Keystore: keyFile, PassphraseEnv: "TEST_KEYSTORE_PASSWORD"}).Load(); err != nil {
+		t.Errorf("load keystore with env passphrase: %v", err)
+	}
+
+	// 3. Raw key from the environment when no keystore is named.
+	dev := NewTestSigner("dev")
+	t.Setenv("TEST_SESSION_KEY", hexutil.Encode(crypto.FromECDSA(dev.key)))
+	s, err = KeySource{RawKeyEnv: "TEST_SESSION_KEY", KeyDir: dir}.Load()
+	if err != nil {
+		t.Fatalf("load env key: %v", err)
+	}
+	if s.Address() != dev.Address() {
+		t.Errorf("env signer is %s, want %s", s.Address().Hex(), dev.Address().Hex())
+	}
+
+	// 4. A key directory without keys is reported as such, before any passphrase is needed.
+	if _, err := (KeySource{KeyDir: t.TempDir()}).Load(); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("expected no active key, got %v", err)
+	}
+
+	if _, err := (KeySource{}).Load(); err == nil {
+		t.Error("expected an empty key source to fail")
+	}
+}
+
+func TestNewTestSigner(t *testing.T) {
+	if NewTestSigner("a").Address() != NewTestSigner("a").Address() {
+		t.Error("expected test signers to be deterministic")
+	}
+	if NewTestSigner("a").Address() == NewTestSigner("b").Address() {
+		t.Error("expected different seeds to give different keys")
+	}
+	signs(t, NewTestSigner("a"))
+}
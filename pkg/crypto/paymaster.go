@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Operation types user operations and transactions are sent as. Sponsorship budgets are kept
+// per type, and the transaction journal records it as the purpose.
+const (
+	OperationBroadcast = "broadcast" // Transactions sent through TransactionManager.Broadcast
+	OperationDeposit   = "deposit"
+	OperationWithdraw  = "withdraw"
+	OperationHarvest   = "harvest"
+	OperationSettle    = "settle"
+	OperationRefund    = "refund"
+)
+
+// ErrSponsorshipDenied is returned by a Paymaster that will not pay for an operation.
+var ErrSponsorshipDenied = errors.New("sponsorship denied")
+
+// Paymaster sponsors the gas of user operations.
+type Paymaster interface {
+	// SponsorUserOperation returns op with its paymaster data, and gas limits if the paymaster
+	// re-estimated them, filled in. It returns ErrSponsorshipDenied if it will not pay for op.
+	SponsorUserOperation(ctx context.Context, op UserOperation, ep EntryPoint) (*UserOperation, error)
+}
+
+// MaxGasCost is the most op can cost in wei: all of its gas limits at MaxFeePerGas.
+func MaxGasCost(op *UserOperation) *big.Int {
+	gas := new(big.Int)
+	for _, limit := range []*big.Int{op.PreVerificationGas, op.VerificationGas, op.CallGasLimit, op.PaymasterVerificationGas, op.PaymasterPostOpGas} {
+		gas.Add(gas, bigOrZero(limit))
+	}
+	return gas.Mul(gas, bigOrZero(op.MaxFeePerGas))
+}
+
+// Paymaster returns the paymaster that sponsors op, if any.
+func (op *UserOperation) Paymaster() (common.Address, bool) {
+	if len(op.PaymasterAndData) < common.AddressLength {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(op.PaymasterAndData[:common.AddressLength]), true
+}
+
+// SponsorshipBudget caps the gas cost a paymaster is asked to sponsor for each operation type
+// within a window. Spending is kept in memory and starts afresh when the process restarts.
+type SponsorshipBudget struct {
+	limits map[string]*big.Int
+	window time.Duration
+
+	mu     sync.Mutex
+	spent  map[string]*big.Int
+	starts map[string]time.Time
+	now    func() time.Time
+}
+
+// NewSponsorshipBudget creates a budget from limits in wei per window, by operation type.
+// Operation types without a limit are never sponsored. The window defaults to a day.
+func NewSponsorshipBudget(limits map[string]*big.Int, window time.Duration) *SponsorshipBudget {
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	return &SponsorshipBudget{
+		limits: limits,
+		window: window,
+		spent:  make(map[string]*big.Int),
+		starts: make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Reserve takes cost from the operation type's budget and reports whether it fit.
+func (b *SponsorshipBudget) Reserve(operation string, cost *big.Int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit, ok := b.limits[operation]
+	if !ok {
+		return false
+	}
+	spent := b.current(operation)
+	total := new(big.Int).Add(spent, cost)
+	if total.Cmp(limit) > 0 {
+		return false
+	}
+	b.spent[operation] = total
+	return true
+}
+
+// Release returns a reservation that was not used, e.g. because the operation was never sent.
+func (b *SponsorshipBudget) Release(operation string, cost *big.Int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spent := new(big.Int).Sub(b.current(operation), cost)
+	if spent.Sign() < 0 {
+		spent.SetInt64(0)
+	}
+	b.spent[operation] = spent
+}
+
+// Remaining returns what is left of the operation type's budget in the current window.
+func (b *SponsorshipBudget) Remaining(operation string) *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit, ok := b.limits[operation]
+	if !ok {
+		return new(big.Int)
+	}
+	remaining := new(big.Int).Sub(limit, b.current(operation))
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
+	}
+	return remaining
+}
+
+// current returns the spending of the operation type, starting a new window if the last one
+// has ended. The caller holds b.mu.
+func (b *SponsorshipBudget) current(operation string) *big.Int {
+	now := b.now()
+	if start, ok := b.starts[operation]; !ok || now.Sub(start) >= b.window {
+		b.starts[operation] = now
+		b.spent[operation] = new(big.Int)
+	}
+	return b.spent[operation]
+}
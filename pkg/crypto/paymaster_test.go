@@ -0,0 +1,46 @@
+package crypto
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSponsorshipBudget(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	budget := NewSponsorshipBudget(map[string]*big.Int{OperationHarvest: big.NewInt(100)}, time.Hour)
+	budget.now = func() time.Time { return now }
+
+	if !budget.Reserve(OperationHarvest, big.NewInt(60)) {
+		t.Fatal("expected the first reservation to fit")
+	}
+	if budget.Reserve(OperationHarvest, big.NewInt(50)) {
+		t.Error("expected a reservation over the limit to be refused")
+	}
+	if budget.Reserve(OperationDeposit, big.NewInt(1)) {
+		t.Error("expected operation types without a limit to be refused")
+	}
+
+	budget.Release(OperationHarvest, big.NewInt(20))
+	if got := budget.Remaining(OperationHarvest); got.Int64() != 60 {
+		t.Errorf("remaining after release = %s, want 60", got)
+	}
+
+	now = now.Add(time.Hour)
+	if got := budget.Remaining(OperationHarvest); got.Int64() != 100 {
+		t.Errorf("remaining in a new window = %s, want 100", got)
+	}
+}
+
+func TestMaxGasCost(t *testing.T) {
+	op := &UserOperation{
+		PreVerificationGas:       big.NewInt(1),
+		VerificationGas:          big.NewInt(2),
+		CallGasLimit:             big.NewInt(3),
+		PaymasterVerificationGas: big.NewInt(4),
+		MaxFeePerGas:             big.NewInt(10),
+	}
+	if got := MaxGasCost(op); got.Int64() != 100 {
+		t.Errorf("MaxGasCost = %s, want 100", got)
+	}
+}
@@ -0,0 +1,260 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// ErrOutOfScope is returned when a session key is asked to sign outside its policy.
+var ErrOutOfScope = errors.New("outside session key scope")
+
+// Kinds of requests a session key policy decides on.
+const (
+	SignKindTransaction   = "transaction"
+	SignKindUserOperation = "user_operation"
+)
+
+// ScopeRule allows calls to one contract.
+type ScopeRule struct {
+	Target    common.Address
+	Selectors [][4]byte // Allowed function selectors; empty allows any call, including plain transfers
+}
+
+// SessionPolicy bounds what a session key will sign, so a leaked signer can only do what the
+// automation it was issued for does.
+type SessionPolicy struct {
+	Rules          []ScopeRule
+	MaxValuePerTx  *big.Int  // Native value per call, in wei; nil for no cap
+	MaxValuePerDay *big.Int  // Native value over a rolling day, in wei; nil for no cap
+	Expiry         time.Time // Zero for a key that does not expire
+	Auditor        PolicyAuditor
+
+	mu          sync.Mutex
+	spent       *big.Int
+	windowStart time.Time
+	now         func() time.Time
+}
+
+// Selector returns the 4-byte selector of a function signature such as "harvest()".
+func Selector(signature string) [4]byte {
+	var sel [4]byte
+	copy(sel[:], crypto.Keccak256([]byte(signature)))
+	return sel
+}
+
+// Authorize decides whether a call may be signed and audits the decision. An allowed call
+// counts against the daily value cap.
+func (p *SessionPolicy) Authorize(signer common.Address, kind string, chainID *big.Int, to *common.Address, value *big.Int, data []byte) error {
+	return p.authorize(signer, kind, chainID, to, value, data, true)
+}
+
+// Reauthorize decides whether a call that was already authorized may be signed again, such as
+// a replacement of a stuck transaction at the same nonce. The call is checked against the
+// scope, expiry and per-call cap, but its value already counts against the daily cap.
+func (p *SessionPolicy) Reauthorize(signer common.Address, kind string, chainID *big.Int, to *common.Address, value *big.Int, data []byte) error {
+	return p.authorize(signer, kind, chainID, to, value, data, false)
+}
+
+func (p *SessionPolicy) authorize(signer common.Address, kind string, chainID *big.Int, to *common.Address, value *big.Int, data []byte, charge bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	value = bigOrZero(value)
+	decision := PolicyDecision{
+		Time:    p.clock(),
+		Signer:  signer,
+		Kind:    kind,
+		ChainID: chainID,
+		Value:   value,
+	}
+	if to != nil {
+		decision.Target = *to
+	}
+	if len(data) >= 4 {
+		decision.Selector = hexutil.Encode(data[:4])
+	}
+
+	err := p.check(decision.Time, to, value, data, charge)
+	decision.Allowed = err == nil
+	if err != nil {
+		decision.Reason = err.Error()
+		err = fmt.Errorf("%w: %v", ErrOutOfScope, err)
+	} else if charge && p.MaxValuePerDay != nil {
+		p.spent.Add(p.spent, value)
+	}
+	p.audit(decision)
+	return err
+}
+
+// check returns why a call falls outside the policy. The daily cap is only checked for calls
+// that will be charged against it. The caller holds p.mu.
+func (p *SessionPolicy) check(now time.Time, to *common.Address, value *big.Int, data []byte, charge bool) error {
+	if !p.Expiry.IsZero() && !now.Before(p.Expiry) {
+		return fmt.Errorf("session key expired at %s", p.Expiry.Format(time.RFC3339))
+	}
+	if to == nil {
+		return errors.New("contract creation is not allowed")
+	}
+	rule := p.rule(*to)
+	if rule == nil {
+		return fmt.Errorf("target %s is not allowed", to.Hex())
+	}
+	if len(rule.Selectors) > 0 && !allowsSelector(rule.Selectors, data) {
+		if len(data) < 4 {
+			return fmt.Errorf("calls to %s must name an allowed function", to.Hex())
+		}
+		return fmt.Errorf("function %s is not allowed on %s", hexutil.Encode(data[:4]), to.Hex())
+	}
+	if p.MaxValuePerTx != nil && value.Cmp(p.MaxValuePerTx) > 0 {
+		return fmt.Errorf("value %s exceeds the per-transaction cap of %s", value, p.MaxValuePerTx)
+	}
+	if charge && p.MaxValuePerDay != nil {
+		if p.spent == nil || now.Sub(p.windowStart) >= 24*time.Hour {
+			p.spent, p.windowStart = new(big.Int), now
+		}
+		if total := new(big.Int).Add(p.spent, value); total.Cmp(p.MaxValuePerDay) > 0 {
+			return fmt.Errorf("value %s exceeds the daily cap of %s, %s already spent", value, p.MaxValuePerDay, p.spent)
+		}
+	}
+	return nil
+}
+
+// CheckExpiry refuses a signer whose key has expired, before anything is signed.
+func (p *SessionPolicy) CheckExpiry(signer common.Address, kind string, chainID *big.Int) error {
+	if p.Expiry.IsZero() || p.clock().Before(p.Expiry) {
+		return nil
+	}
+	return p.refuse(signer, kind, chainID, fmt.Sprintf("session key expired at %s", p.Expiry.Format(time.RFC3339)))
+}
+
+// refuse audits a request refused before its call could be checked.
+func (p *SessionPolicy) refuse(signer common.Address, kind string, chainID *big.Int, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.audit(PolicyDecision{Time: p.clock(), Signer: signer, Kind: kind, ChainID: chainID, Value: new(big.Int), Reason: reason})
+	return fmt.Errorf("%w: %s", ErrOutOfScope, reason)
+}
+
+func (p *SessionPolicy) rule(target common.Address) *ScopeRule {
+	for i := range p.Rules {
+		if p.Rules[i].Target == target {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+func allowsSelector(selectors [][4]byte, data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	for _, sel := range selectors {
+		if sel == [4]byte(data[:4]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SessionPolicy) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// audit logs a decision and hands it to the auditor. The caller holds p.mu.
+func (p *SessionPolicy) audit(d PolicyDecision) {
+	if d.Allowed {
+		log.Printf("🔑 session: %s may sign %s to %s (selector %s, value %s)", d.Signer.Hex(), d.Kind, d.Target.Hex(), d.Selector, d.Value)
+	} else {
+		log.Printf("⚠️  session: %s refused to sign %s to %s: %s", d.Signer.Hex(), d.Kind, d.Target.Hex(), d.Reason)
+	}
+	if p.Auditor == nil {
+		return
+	}
+	if err := p.Auditor.Record(d); err != nil {
+		log.Printf("⚠️  session: Failed to audit decision: %v", err)
+	}
+}
+
+// PolicyDecision records a session key policy allowing or refusing to sign.
+type PolicyDecision struct {
+	Time     time.Time
+	Signer   common.Address
+	Kind     string // SignKindTransaction or SignKindUserOperation
+	ChainID  *big.Int
+	Target   common.Address
+	Selector string // Hex function selector, empty for plain transfers
+	Value    *big.Int
+	Allowed  bool
+	Reason   string // Why the request was refused
+}
+
+// PolicyAuditor keeps the decisions of session key policies.
+type PolicyAuditor interface {
+	Record(d PolicyDecision) error
+}
+
+// MemoryPolicyAuditor keeps decisions in process memory.
+type MemoryPolicyAuditor struct {
+	mu        sync.Mutex
+	decisions []PolicyDecision
+}
+
+func NewMemoryPolicyAuditor() *MemoryPolicyAuditor {
+	return &MemoryPolicyAuditor{}
+}
+
+func (a *MemoryPolicyAuditor) Record(d PolicyDecision) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.decisions = append(a.decisions, d)
+	return nil
+}
+
+// Decisions returns the recorded decisions, oldest first.
+func (a *MemoryPolicyAuditor) Decisions() []PolicyDecision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]PolicyDecision{}, a.decisions...)
+}
+
+// SQLitePolicyAuditor appends decisions to the audit log in the settler database.
+type SQLitePolicyAuditor struct {
+	db *storage.DB
+}
+
+func NewSQLitePolicyAuditor(db *storage.DB) *SQLitePolicyAuditor {
+	return &SQLitePolicyAuditor{db: db}
+}
+
+func (a *SQLitePolicyAuditor) Record(d PolicyDecision) error {
+	row := storage.SessionDecisionRow{
+		Signer:   d.Signer.Hex(),
+		Kind:     d.Kind,
+		Target:   d.Target.Hex(),
+		Selector: d.Selector,
+		Value:    bigOrZero(d.Value).String(),
+		Allowed:  d.Allowed,
+		Reason:   d.Reason,
+	}
+	if d.ChainID != nil {
+		row.ChainID = d.ChainID.Uint64()
+	}
+	return a.db.RecordSessionDecision(row)
+}
+
+var (
+	_ PolicyAuditor = (*MemoryPolicyAuditor)(nil)
+	_ PolicyAuditor = (*SQLitePolicyAuditor)(nil)
+)
@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+var (
+	testVault    = common.HexToAddress("0x7a11777a11777a11777a11777a11777a11777a11")
+	harvestCall  = Selector("harvest()")
+	withdrawCall = Selector("withdraw(uint256)")
+)
+
+func testPolicy(auditor PolicyAuditor) *SessionPolicy {
+	return &SessionPolicy{
+		Rules:          []ScopeRule{{Target: testVault, Selectors: [][4]byte{harvestCall, Selector("deposit(uint256)")}}},
+		MaxValuePerTx:  big.NewInt(100),
+		MaxValuePerDay: big.NewInt(150),
+		Expiry:         time.Unix(1_800_000_000, 0),
+		Auditor:        auditor,
+	}
+}
+
+func TestSessionPolicy_Authorize(t *testing.T) {
+	auditor := NewMemoryPolicyAuditor()
+	policy := testPolicy(auditor)
+	now := time.Unix(1_700_000_000, 0)
+	policy.now = func() time.Time { return now }
+	signer := common.HexToAddress("0x5e55")
+	other := common.HexToAddress("0xbad")
+
+	cases := []struct {
+		name  string
+		to    *common.Address
+		value int64
+		data  []byte
+		ok    bool
+	}{
+		{"allowed", &testVault, 0, harvestCall[:], true},
+		{"unknown selector", &testVault, 0, withdrawCall[:], false},
+		{"no selector", &testVault, 0, nil, false},
+		{"unknown target", &other, 0, harvestCall[:], false},
+		{"creation", nil, 0, nil, false},
+		{"over per-tx cap", &testVault, 101, harvestCall[:], false},
+		{"within caps", &testVault, 100, harvestCall[:], true},
+		{"over daily cap", &testVault, 60, harvestCall[:], false},
+	}
+	for _, tc := range cases {
+		err := policy.Authorize(signer, SignKindTransaction, big.NewInt(56), tc.to, big.NewInt(tc.value), tc.data)
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected refusal: %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrOutOfScope) {
+			t.Errorf("%s: expected ErrOutOfScope, got %v", tc.name, err)
+		}
+	}
+
+	decisions := auditor.Decisions()
+	if len(decisions) != len(cases) {
+		t.Fatalf("expected %d audited decisions, got %d", len(cases), len(decisions))
+	}
+	for i, d := range decisions {
+		if d.Allowed != cases[i].ok || (!d.Allowed && d.Reason == "") {
+			t.Errorf("%s: audited as allowed=%v reason=%q", cases[i].name, d.Allowed, d.Reason)
+		}
+	}
+
+	// A new day frees the daily cap; expiry refuses everything.
+	now = now.Add(24 * time.Hour)
+	if err := policy.Authorize(signer, SignKindTransaction, big.NewInt(56), &testVault, big.NewInt(60), harvestCall[:]); err != nil {
+		t.Errorf("expected the daily cap to reset: %v", err)
+	}
+	now = policy.Expiry
+	if err := policy.Authorize(signer, SignKindTransaction, big.NewInt(56), &testVault, nil, harvestCall[:]); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected an expired key to be refused, got %v", err)
+	}
+	if err := policy.CheckExpiry(signer, SignKindTransaction, big.NewInt(56)); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected CheckExpiry to refuse an expired key, got %v", err)
+	}
+}
+
+func TestSessionKeySigner_PolicyTransactor(t *testing.T) {
+	srv := chaintest.NewServer(56)
+	defer srv.Close()
+	client, err := ethclient.Dial(srv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	key, _ := crypto.GenerateKey()
+	base, _ := NewSessionKeySigner(common.Bytes2Hex(crypto.FromECDSA(key)), big.NewInt(56))
+	policy := testPolicy(nil)
+	policy.Expiry = time.Time{}
+	signer := base.WithPolicy(policy).WithChainID(big.NewInt(56))
+
+	auth, err := signer.GetTransactor(context.Background(), client)
+	if err != nil {
+		t.Fatalf("transactor: %v", err)
+	}
+	tx := func(to common.Address, data []byte) *types.Transaction {
+		return types.NewTx(&types.LegacyTx{To: &to, Gas: 100_000, GasPrice: big.NewInt(1), Data: data})
+	}
+	if _, err := auth.Signer(signer.Address(), tx(testVault, harvestCall[:])); err != nil {
+		t.Errorf("expected a harvest to be signed: %v", err)
+	}
+	if _, err := auth.Signer(signer.Address(), tx(common.HexToAddress("0xbad"), harvestCall[:])); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected a call to another contract to be refused, got %v", err)
+	}
+
+	policy.Expiry = time.Now().Add(-time.Minute)
+	if _, err := signer.GetTransactor(context.Background(), client); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected no transactor for an expired key, got %v", err)
+	}
+}
+
+func TestSessionKeySigner_PolicyUserOperation(t *testing.T) {
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer db.Close()
+
+	key, _ := crypto.GenerateKey()
+	base, _ := NewSessionKeySigner(common.Bytes2Hex(crypto.FromECDSA(key)), big.NewInt(56))
+	policy := testPolicy(NewSQLitePolicyAuditor(db))
+	policy.Expiry = time.Time{}
+	signer := base.WithPolicy(policy)
+	ep := SmartAccount{}.EntryPoint()
+
+	execute := func(to common.Address, data []byte) *UserOperation {
+		callData, _ := smartAccountABI.Pack("execute", to, new(big.Int), data)
+		return &UserOperation{Sender: common.HexToAddress("0x4337"), Nonce: new(big.Int), CallData: callData}
+	}
+	if err := signer.SignUserOperation(execute(testVault, harvestCall[:]), ep); err != nil {
+		t.Errorf("expected a harvest to be signed: %v", err)
+	}
+	if err := signer.SignUserOperation(execute(testVault, withdrawCall[:]), ep); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected a withdrawal to be refused, got %v", err)
+	}
+	if err := signer.SignUserOperation(&UserOperation{CallData: common.FromHex("0x12345678")}, ep); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected an unknown account call to be refused, got %v", err)
+	}
+
+	rows, err := db.ListSessionDecisions(signer.Address().Hex(), 0)
+	if err != nil {
+		t.Fatalf("list decisions: %v", err)
+	}
+	if len(rows) != 3 || rows[2].Allowed != true || rows[1].Allowed || rows[0].Allowed {
+		t.Errorf("unexpected audit log %+v", rows)
+	}
+	if rows[1].Kind != SignKindUserOperation || rows[1].Target != testVault.Hex() || rows[1].ChainID != 56 {
+		t.Errorf("unexpected audit row %+v", rows[1])
+	}
+}
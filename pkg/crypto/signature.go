@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// secp256k1HalfN is the largest s accepted, as EIP-2 requires of transactions.
+var secp256k1HalfN = new(big.Int).Rsh(crypto.S256().Params().N, 1)
+
+// CanonicalSignature decodes a hex ECDSA signature into its one canonical 65-byte form,
+// r || s || v with v of 27 or 28. It accepts v encoded as 0/1 or 27/28 and 64-byte EIP-2098
+// compact signatures, and rejects high-s signatures, so a signature cannot be re-encoded
+// into another valid one.
+func CanonicalSignature(signature string) ([]byte, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	switch len(sig) {
+	case 64:
+		// EIP-2098: the top bit of s carries the y parity.
+		yParity := sig[32] >> 7
+		sig = append(sig[:64:64], 27+yParity)
+		sig[32] &= 0x7f
+	case 65:
+		switch sig[64] {
+		case 0, 1:
+			sig[64] += 27
+		case 27, 28:
+		default:
+			return nil, fmt.Errorf("invalid signature recovery id: %d", sig[64])
+		}
+	default:
+		return nil, fmt.Errorf("invalid signature length: %d", len(sig))
+	}
+
+	if new(big.Int).SetBytes(sig[32:64]).Cmp(secp256k1HalfN) > 0 {
+		return nil, fmt.Errorf("signature s value is not in the lower half order")
+	}
+	return sig, nil
+}
+
+// CompactSignature encodes a signature in the 64-byte EIP-2098 form.
+func CompactSignature(signature string) ([]byte, error) {
+	sig, err := CanonicalSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	compact := sig[:64]
+	compact[32] |= (sig[64] - 27) << 7
+	return compact, nil
+}
+
+// RecoverSigner recovers the address that produced a signature over sighash. The signature
+// must be canonical as CanonicalSignature defines it.
+func RecoverSigner(sighash []byte, signature string) (common.Address, error) {
+	sig, err := CanonicalSignature(signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	sig[64] -= 27
+
+	pubKey, err := crypto.SigToPub(sighash, sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to recover public key: %w", err)
+	}
+	return crypto.PubkeyToAddress(*pubKey), nil
+}
+
+// IdempotencyKey identifies a payment by the digest that was signed and the signer, so
+// every encoding of a signature over the same message names the same payment.
+func IdempotencyKey(digest []byte, signer common.Address) string {
+	return hexutil.Encode(crypto.Keccak256(digest, signer.Bytes()))
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestCanonicalSignature(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	sighash := crypto.Keccak256([]byte("intent"))
+	raw, _ := crypto.Sign(sighash, key) // v is 0 or 1
+
+	canonical := append([]byte{}, raw...)
+	canonical[64] += 27
+	compact, err := CompactSignature(hexutil.Encode(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, sig := range map[string][]byte{"v 0/1": raw, "v 27/28": canonical, "EIP-2098": compact} {
+		got, err := CanonicalSignature(hexutil.Encode(sig))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, canonical) {
+			t.Errorf("%s: expected %x, got %x", name, canonical, got)
+		}
+		if recovered, err := RecoverSigner(sighash, hexutil.Encode(sig)); err != nil || recovered != addr {
+			t.Errorf("%s: expected %s, got %s (%v)", name, addr.Hex(), recovered.Hex(), err)
+		}
+	}
+
+	// (r, n-s, v^1) is the same signature mirrored into the upper half order.
+	malleated := append([]byte{}, canonical...)
+	s := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(canonical[32:64]))
+	s.FillBytes(malleated[32:64])
+	malleated[64] ^= 1
+	if _, err := RecoverSigner(sighash, hexutil.Encode(malleated)); err == nil {
+		t.Error("expected a high-s signature to be rejected")
+	}
+
+	digest := crypto.Keccak256([]byte("other"))
+	if IdempotencyKey(sighash, addr) == IdempotencyKey(digest, addr) {
+		t.Error("expected different digests to give different keys")
+	}
+}
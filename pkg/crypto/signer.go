@@ -1,70 +1,140 @@
 package crypto
 
 import (
+	"bytes"
 	"context"
-	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
-// SessionKeySigner manages a local private key for automated transaction signing.
-// In a production environment, this should wrap a secure enclave or KMS.
+// SessionKeySigner signs automated transactions with a key from a pluggable Signer: an
+// encrypted keystore, a remote signer, a development key from the environment or an
+// in-memory test key.
 type SessionKeySigner struct {
-	privateKey *ecdsa.PrivateKey
-	address    common.Address
-	chainID    *big.Int
+	key     Signer
+	address common.Address
+	chainID *big.Int
+	policy  *SessionPolicy
 }
 
+// NewSessionKeySigner creates a signer from a hex private key held in memory.
 func NewSessionKeySigner(hexKey string, chainID *big.Int) (*SessionKeySigner, error) {
-	privateKey, err := crypto.HexToECDSA(hexKey)
+	key, err := ParsePrivateKeySigner(hexKey)
 	if err != nil {
-		return nil, fmt.Errorf("invalid private key: %w", err)
-	}
-
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("failed to cast public key to ECDSA")
+		return nil, err
 	}
+	return NewSessionKeySignerFrom(key, chainID), nil
+}
 
-	address := crypto.PubkeyToAddress(*publicKeyECDSA)
+// NewSessionKeySignerFrom creates a signer that signs with key.
+func NewSessionKeySignerFrom(key Signer, chainID *big.Int) *SessionKeySigner {
 	return &SessionKeySigner{
-		privateKey: privateKey,
-		address:    address,
-		chainID:    chainID,
-	}, nil
+		key:     key,
+		address: key.Address(),
+		chainID: chainID,
+	}
 }
 
 func (s *SessionKeySigner) Address() common.Address {
 	return s.address
 }
 
-// GetTransactor returns a bind.Transopts configured with the session key.
+// GetTransactor returns a bind.TransactOpts that signs with the session key. Gas is estimated
+// and EIP-1559 fees are suggested by bind when the transaction is sent, and the nonce is the
+// account's pending nonce. Concurrent senders should go through a TransactionManager, which
+// owns the nonce sequence.
 func (s *SessionKeySigner) GetTransactor(ctx context.Context, client *ethclient.Client) (*bind.TransactOpts, error) {
+	if s.chainID == nil {
+		return nil, errors.New("failed to create transactor: no chain ID")
+	}
+	if s.policy != nil {
+		if err := s.policy.CheckExpiry(s.address, SignKindTransaction, s.chainID); err != nil {
+			return nil, err
+		}
+	}
+
 	nonce, err := client.PendingNonceAt(ctx, s.address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get nonce: %w", err)
 	}
 
-	gasPrice, err := client.SuggestGasPrice(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to suggest gas price: %w", err)
+	return &bind.TransactOpts{
+		From:  s.address,
+		Nonce: new(big.Int).SetUint64(nonce),
+		Signer: func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if from != s.address {
+				return nil, bind.ErrNotAuthorized
+			}
+			return s.SignTx(tx)
+		},
+		Value:   big.NewInt(0),
+		Context: ctx,
+	}, nil
+}
+
+// SignTx signs tx for the signer's chain. With a policy, only transactions within its scope
+// are signed.
+func (s *SessionKeySigner) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	if s.chainID == nil {
+		return nil, errors.New("failed to sign transaction: no chain ID")
+	}
+	if s.policy != nil {
+		if err := s.policy.Authorize(s.address, SignKindTransaction, s.chainID, tx.To(), tx.Value(), tx.Data()); err != nil {
+			return nil, err
+		}
 	}
+	return s.key.SignTx(tx, s.chainID)
+}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(s.privateKey, s.chainID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create transactor: %w", err)
+// SignReplacement signs replacement, which must make the same call as original at the same
+// nonce, e.g. with higher fees. With a policy, it is checked for scope but its value is not
+// counted against the daily cap again, since original was already charged.
+func (s *SessionKeySigner) SignReplacement(original, replacement *types.Transaction) (*types.Transaction, error) {
+	if s.chainID == nil {
+		return nil, errors.New("failed to sign transaction: no chain ID")
+	}
+	if replacement.Nonce() != original.Nonce() || !sameTarget(replacement.To(), original.To()) ||
+		bigOrZero(replacement.Value()).Cmp(bigOrZero(original.Value())) != 0 || !bytes.Equal(replacement.Data(), original.Data()) {
+		return nil, errors.New("failed to sign replacement: it does not make the same call at the same nonce")
+	}
+	if s.policy != nil {
+		if err := s.policy.Reauthorize(s.address, SignKindTransaction, s.chainID, replacement.To(), replacement.Value(), replacement.Data()); err != nil {
+			return nil, err
+		}
 	}
+	return s.key.SignTx(replacement, s.chainID)
+}
 
-	auth.Nonce = big.NewInt(int64(nonce))
-	auth.Value = big.NewInt(0)     // default to 0
-	auth.GasLimit = uint64(300000) // standard limit for simple contract calls
-	auth.GasPrice = gasPrice
+func sameTarget(a, b *common.Address) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
 
-	return auth, nil
+// WithChainID returns a signer for the same key that signs transactions for another chain.
+func (s *SessionKeySigner) WithChainID(chainID *big.Int) *SessionKeySigner {
+	return &SessionKeySigner{
+		key:     s.key,
+		address: s.address,
+		chainID: chainID,
+		policy:  s.policy,
+	}
+}
+
+// WithPolicy returns a signer for the same key that refuses to sign outside policy. Signers
+// derived from it share the policy and its daily value cap.
+func (s *SessionKeySigner) WithPolicy(policy *SessionPolicy) *SessionKeySigner {
+	return &SessionKeySigner{
+		key:     s.key,
+		address: s.address,
+		chainID: s.chainID,
+		policy:  policy,
+	}
 }
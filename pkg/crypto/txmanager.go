@@ -0,0 +1,543 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// FeePolicy bounds the EIP-1559 fees of the transactions a TransactionManager sends.
+type FeePolicy struct {
+	MinTip *big.Int // Floor under the node's suggested tip; nil for none
+	MaxFee *big.Int // Ceiling on the fee cap; nil for none
+}
+
+const (
+	// gasHeadroom is added to estimated gas limits, in percent, since state can change
+	// between estimation and inclusion.
+	gasHeadroom = 20
+
+	// priceBump is how much a replacement raises both fee caps, in percent. Nodes refuse
+	// replacements that raise them by less than 10%.
+	priceBump = 15
+
+	// trackTimeout is how long Send follows a transaction in the background.
+	trackTimeout = time.Hour
+)
+
+// MaybeSentError is returned when sending a transaction failed in a way that may hide a node
+// accepting it, such as a timeout. The transaction stays journaled as PENDING and its nonce is
+// not reused: follow it with WaitMined, or let a Journal resume it.
+type MaybeSentError struct {
+	Tx  *types.Transaction
+	Err error
+}
+
+func (e *MaybeSentError) Error() string {
+	return fmt.Sprintf("transaction %s may not have been sent: %v", e.Tx.Hash().Hex(), e.Err)
+}
+
+func (e *MaybeSentError) Unwrap() error {
+	return e.Err
+}
+
+// nonceQueue hands out the nonces of one account, so concurrent sends never reuse one.
+// Nonces of transactions that were never sent are handed out again first.
+type nonceQueue struct {
+	mu     sync.Mutex
+	next   uint64
+	synced bool
+	free   []uint64 // Released nonces, lowest first
+}
+
+// take reserves a nonce. Until the queue is synced, sync says where the sequence starts.
+func (q *nonceQueue) take(sync func() (uint64, error)) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.free) > 0 {
+		nonce := q.free[0]
+		q.free = q.free[1:]
+		return nonce, nil
+	}
+	if !q.synced {
+		next, err := sync()
+		if err != nil {
+			return 0, err
+		}
+		q.next, q.synced = next, true
+	}
+	nonce := q.next
+	q.next++
+	return nonce, nil
+}
+
+// release returns a nonce whose transaction was never sent.
+func (q *nonceQueue) release(nonce uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.synced || nonce >= q.next {
+		return
+	}
+	q.free = append(q.free, nonce)
+	sort.Slice(q.free, func(i, j int) bool { return q.free[i] < q.free[j] })
+}
+
+// reset makes the next take sync again, after the account's nonces were used elsewhere.
+func (q *nonceQueue) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.synced, q.free = false, nil
+}
+
+// WithSigner sets the key transactions are sent from when there is no smart account.
+func (m *TransactionManager) WithSigner(signer *SessionKeySigner) *TransactionManager {
+	m.signer = signer
+	return m
+}
+
+// WithFeePolicy bounds the fees of the transactions the manager sends.
+func (m *TransactionManager) WithFeePolicy(policy FeePolicy) *TransactionManager {
+	m.fees = policy
+	return m
+}
+
+// WithChain polls for receipts every block time of chain, and has WaitMined wait for the
+// chain's confirmations on top of a receipt.
+func (m *TransactionManager) WithChain(chain chains.ChainConfig) *TransactionManager {
+	m.pollInterval = chain.PollInterval()
+	m.confirmations = chain.Confirmations
+	return m
+}
+
+// WithStore journals sent transactions in db until they are mined, so nonces in flight are
+// not reused after a restart and a Journal can resume them.
+func (m *TransactionManager) WithStore(db *storage.DB) *TransactionManager {
+	m.store = db
+	return m
+}
+
+// WithReplacement has WaitMined speed up transactions still pending after timeout. Zero
+// disables replacement.
+func (m *TransactionManager) WithReplacement(timeout time.Duration) *TransactionManager {
+	m.stuckAfter = timeout
+	return m
+}
+
+// Senders hands out one TransactionManager per chain for a key, so everything sending from
+// the key shares its nonce sequence, fee policy and journal. Managers are built on first use
+// from the chain registry.
+type Senders struct {
+	clients    *chains.MultiClient
+	signer     *SessionKeySigner
+	store      *storage.DB
+	stuckAfter time.Duration
+
+	mu       sync.Mutex
+	managers map[chains.ChainID]*TransactionManager
+}
+
+// NewSenders sends from signer on the chains of clients, journaling in db. db may be nil.
+func NewSenders(clients *chains.MultiClient, signer *SessionKeySigner, db *storage.DB) *Senders {
+	return &Senders{clients: clients, signer: signer, store: db, managers: make(map[chains.ChainID]*TransactionManager)}
+}
+
+// WithReplacement speeds up transactions still pending after timeout, as
+// TransactionManager.WithReplacement does.
+func (s *Senders) WithReplacement(timeout time.Duration) *Senders {
+	s.stuckAfter = timeout
+	return s
+}
+
+// Address returns the address transactions are sent from.
+func (s *Senders) Address() common.Address {
+	return s.signer.Address()
+}
+
+// Manager returns the manager sending on chain id, within the chain's fee bounds.
+func (s *Senders) Manager(id chains.ChainID) (*TransactionManager, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.managers[id]; ok {
+		return m, nil
+	}
+	chain, err := chains.GetChainConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.clients.GetClient(id)
+	if err != nil {
+		return nil, err
+	}
+	m := NewTransactionManager(client, nil).
+		WithChain(chain).
+		WithSigner(s.signer.WithChainID(new(big.Int).SetUint64(uint64(id)))).
+		WithFeePolicy(FeePolicy{MinTip: chain.MinPriorityFee, MaxFee: chain.MaxFeePerGas}).
+		WithStore(s.store).
+		WithReplacement(s.stuckAfter)
+	s.managers[id] = m
+	return m, nil
+}
+
+// Send makes call from the smart account when one is configured, or else from the signer's
+// own address. It returns the userOpHash or the transaction hash. Transactions are followed in
+// the background until mined, and sped up when replacement is enabled.
+func (m *TransactionManager) Send(ctx context.Context, call Call) (string, error) {
+	if m.account != nil {
+		return m.SendUserOperation(ctx, call)
+	}
+	tx, err := m.SendTransaction(ctx, call)
+	if err != nil {
+		return "", err
+	}
+	go m.track(tx)
+	return tx.Hash().Hex(), nil
+}
+
+// track waits for a transaction sent by Send and logs the outcome.
+func (m *TransactionManager) track(tx *types.Transaction) {
+	ctx, cancel := context.WithTimeout(context.Background(), trackTimeout)
+	defer cancel()
+	receipt, err := m.WaitMined(ctx, tx)
+	if err != nil {
+		log.Printf("⚠️  tx: %v", err)
+		return
+	}
+	log.Printf("✅ tx: %s mined in block %s", receipt.TxHash.Hex(), receipt.BlockNumber)
+}
+
+// SendTransaction sends call from the signer's address and returns the signed transaction.
+// The nonce comes from the manager's sequence, the gas limit is estimated unless call sets one,
+// and fees follow the fee policy unless call sets them. The signed transaction is journaled
+// before it is broadcast; a send that may have reached the node returns a *MaybeSentError.
+func (m *TransactionManager) SendTransaction(ctx context.Context, call Call) (*types.Transaction, error) {
+	if m.signer == nil {
+		return nil, errors.New("no signer configured")
+	}
+	sender := m.signer.Address()
+
+	// 1. Gas limit
+	gas := call.Gas
+	if gas == 0 {
+		estimated, err := m.client.EstimateGas(ctx, ethereum.CallMsg{From: sender, To: &call.To, Value: call.Value, Data: call.Data})
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate gas: %w", err)
+		}
+		gas = estimated * (100 + gasHeadroom) / 100
+	}
+
+	// 2. Fees
+	tip, feeCap := call.MaxPriorityFeePerGas, call.MaxFeePerGas
+	if tip == nil || feeCap == nil {
+		suggestedTip, suggestedCap, err := m.suggestFees(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if tip == nil {
+			tip = suggestedTip
+		}
+		if feeCap == nil {
+			feeCap = suggestedCap
+		}
+	}
+
+	// 3. Nonce and signature
+	nonce, err := m.nonces.take(func() (uint64, error) { return m.syncNonce(ctx, sender) })
+	if err != nil {
+		return nil, err
+	}
+	tx, err := m.signer.SignTx(types.NewTx(&types.DynamicFeeTx{
+		ChainID:   m.signer.chainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        &call.To,
+		Value:     bigOrZero(call.Value),
+		Data:      call.Data,
+	}))
+	if err != nil {
+		m.nonces.release(nonce)
+		return nil, err
+	}
+
+	// 4. Journal, so a crash or a lost answer leaves the transaction to resume
+	if err := m.record(tx, nil, call); err != nil {
+		m.nonces.release(nonce)
+		return nil, err
+	}
+
+	// 5. Broadcast
+	if err := m.client.SendTransaction(ctx, tx); err != nil && !alreadyKnown(err) {
+		switch {
+		case strings.Contains(err.Error(), "nonce too low"):
+			m.drop(tx, err)
+			m.nonces.reset()
+		case rejected(err):
+			m.drop(tx, err)
+			m.nonces.release(nonce)
+		default:
+			// The node may have accepted the transaction; keep it journaled and resync rather
+			// than reuse its nonce.
+			m.nonces.reset()
+			return nil, &MaybeSentError{Tx: tx, Err: err}
+		}
+		return nil, fmt.Errorf("failed to send transaction: %w", err)
+	}
+	log.Printf("📤 tx: Sent %s from %s (nonce %d, tip %s, fee cap %s)", tx.Hash().Hex(), sender.Hex(), nonce, tip, feeCap)
+	return tx, nil
+}
+
+// WaitMined waits until tx, or a replacement of it, is mined with the chain's confirmations
+// on top, and returns its receipt. With replacement enabled, a transaction still pending after
+// the timeout is sped up.
+func (m *TransactionManager) WaitMined(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+	sent := []*types.Transaction{tx}
+	lastSent := time.Now()
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	var mined *types.Receipt
+	for {
+		// Any of the transactions may be the one that is mined.
+		for _, t := range sent {
+			if mined != nil {
+				break
+			}
+			receipt, err := m.client.TransactionReceipt(ctx, t.Hash())
+			if err == nil {
+				mined = receipt
+			} else if !errors.Is(err, ethereum.NotFound) {
+				log.Printf("⚠️  tx: Failed to get receipt of %s: %v", t.Hash().Hex(), err)
+			}
+		}
+		if mined != nil {
+			receipt, final, err := chains.Final(ctx, m.client, mined, m.confirmations)
+			if err != nil {
+				log.Printf("⚠️  tx: %v", err)
+			} else if final {
+				m.settle(receipt)
+				return receipt, nil
+			} else {
+				mined = receipt
+			}
+		}
+
+		if mined == nil && m.stuckAfter > 0 && time.Since(lastSent) >= m.stuckAfter {
+			replacement, err := m.SpeedUp(ctx, sent[len(sent)-1])
+			var maybeSent *MaybeSentError
+			switch {
+			case errors.As(err, &maybeSent):
+				log.Printf("⚠️  tx: %v", err)
+				sent = append(sent, maybeSent.Tx)
+			case err != nil:
+				log.Printf("⚠️  tx: Failed to speed up %s: %v", sent[len(sent)-1].Hash().Hex(), err)
+			default:
+				sent = append(sent, replacement)
+			}
+			lastSent = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("transaction %s not mined: %w", tx.Hash().Hex(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// SpeedUp replaces a pending transaction with the same call at the same nonce and higher fees:
+// both caps are raised by priceBump percent, or to the current suggestion if that is higher.
+// Like SendTransaction, it journals the replacement before broadcasting it.
+func (m *TransactionManager) SpeedUp(ctx context.Context, tx *types.Transaction) (*types.Transaction, error) {
+	if m.signer == nil {
+		return nil, errors.New("no signer configured")
+	}
+	tip, feeCap, err := m.suggestFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tip = maxBig(tip, bump(tx.GasTipCap()))
+	feeCap = maxBig(feeCap, bump(tx.GasFeeCap()), tip)
+	if m.fees.MaxFee != nil && feeCap.Cmp(m.fees.MaxFee) > 0 {
+		return nil, fmt.Errorf("replacement fee cap %s exceeds the maximum of %s", feeCap, m.fees.MaxFee)
+	}
+
+	replacement, err := m.signer.SignReplacement(tx, types.NewTx(&types.DynamicFeeTx{
+		ChainID:    tx.ChainId(),
+		Nonce:      tx.Nonce(),
+		GasTipCap:  tip,
+		GasFeeCap:  feeCap,
+		Gas:        tx.Gas(),
+		To:         tx.To(),
+		Value:      tx.Value(),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	replaced := tx.Hash()
+	if err := m.record(replacement, &replaced, Call{}); err != nil {
+		return nil, err
+	}
+	if err := m.client.SendTransaction(ctx, replacement); err != nil && !alreadyKnown(err) {
+		var rpcErr rpc.Error
+		if !errors.As(err, &rpcErr) {
+			return nil, &MaybeSentError{Tx: replacement, Err: err}
+		}
+		// The node refused the replacement, so the original is still the one in flight.
+		m.revertReplacement(replacement, err)
+		return nil, fmt.Errorf("failed to send replacement: %w", err)
+	}
+	log.Printf("🚀 tx: Sped up %s as %s (nonce %d, tip %s, fee cap %s)", replaced.Hex(), replacement.Hash().Hex(), tx.Nonce(), tip, feeCap)
+	return replacement, nil
+}
+
+// suggestFees returns the tip and fee cap for a new transaction: the node's suggested tip,
+// raised to the policy minimum, on top of twice the base fee, within the policy maximum.
+func (m *TransactionManager) suggestFees(ctx context.Context) (tip, feeCap *big.Int, err error) {
+	head, err := m.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get latest block: %w", err)
+	}
+	if head.BaseFee == nil {
+		return nil, nil, errors.New("chain does not support EIP-1559 transactions")
+	}
+	tip, err = m.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to suggest gas tip: %w", err)
+	}
+	if m.fees.MinTip != nil {
+		tip = maxBig(tip, m.fees.MinTip)
+	}
+
+	feeCap = new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), tip)
+	if limit := m.fees.MaxFee; limit != nil && feeCap.Cmp(limit) > 0 {
+		if tip.Cmp(limit) > 0 {
+			return nil, nil, fmt.Errorf("tip %s exceeds the maximum fee of %s", tip, limit)
+		}
+		feeCap = new(big.Int).Set(limit)
+	}
+	return tip, feeCap, nil
+}
+
+// syncNonce returns where the nonce sequence of sender starts: at its pending nonce, or after
+// the last transaction recorded in flight if the node has not seen it.
+func (m *TransactionManager) syncNonce(ctx context.Context, sender common.Address) (uint64, error) {
+	nonce, err := m.client.PendingNonceAt(ctx, sender)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get nonce: %w", err)
+	}
+	if m.store == nil {
+		return nonce, nil
+	}
+	pending, err := m.store.ListTransactions(m.signer.chainID.Uint64(), sender.Hex(), storage.TransactionPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load transactions in flight: %w", err)
+	}
+	for _, row := range pending {
+		if row.Nonce >= nonce {
+			nonce = row.Nonce + 1
+		}
+	}
+	return nonce, nil
+}
+
+// record journals a transaction about to be sent, and the one it replaces. A replacement keeps
+// the purpose and reference of the transaction it replaces.
+func (m *TransactionManager) record(tx *types.Transaction, replaces *common.Hash, call Call) error {
+	if m.store == nil {
+		return nil
+	}
+	row, err := journalEntry(tx, m.signer.Address(), call.Operation, call.Reference)
+	if err == nil {
+		if replaces != nil {
+			err = m.store.ReplaceTransaction(replaces.Hex(), row)
+		} else {
+			err = m.store.RecordTransaction(row)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to journal %s: %w", tx.Hash().Hex(), err)
+	}
+	return nil
+}
+
+// drop closes the journal entry of a transaction the node refused.
+func (m *TransactionManager) drop(tx *types.Transaction, reason error) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.UpdateTransactionStatus(tx.Hash().Hex(), storage.TransactionDropped); err != nil {
+		log.Printf("⚠️  tx: Failed to record %s as dropped: %v", tx.Hash().Hex(), err)
+	}
+	log.Printf("🗑️  tx: Dropped %s: %v", tx.Hash().Hex(), reason)
+}
+
+// revertReplacement drops a replacement the node refused and returns the transaction it
+// replaced to PENDING.
+func (m *TransactionManager) revertReplacement(replacement *types.Transaction, reason error) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.RevertReplacement(replacement.Hash().Hex()); err != nil {
+		log.Printf("⚠️  tx: Failed to record %s as dropped: %v", replacement.Hash().Hex(), err)
+	}
+	log.Printf("🗑️  tx: Dropped replacement %s: %v", replacement.Hash().Hex(), reason)
+}
+
+// settle journals the outcome of a mined transaction.
+func (m *TransactionManager) settle(receipt *types.Receipt) {
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		log.Printf("⚠️  tx: %s reverted in block %s", receipt.TxHash.Hex(), receipt.BlockNumber)
+	}
+	if m.store == nil {
+		return
+	}
+	recordReceipt(m.store, receipt)
+}
+
+// alreadyKnown reports whether a send error means the node already has the transaction.
+func alreadyKnown(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "already known") || strings.Contains(msg, "known transaction")
+}
+
+// rejected reports whether a send error means the node refused the transaction, so its nonce
+// is still free. Transport errors and timeouts may hide a transaction the node accepted.
+func rejected(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) || alreadyKnown(err) {
+		return false
+	}
+	return !strings.Contains(err.Error(), "replacement transaction underpriced")
+}
+
+// bump raises a fee by priceBump percent, rounding up.
+func bump(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+priceBump))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(values ...*big.Int) *big.Int {
+	highest := values[0]
+	for _, v := range values[1:] {
+		if v.Cmp(highest) > 0 {
+			highest = v
+		}
+	}
+	return new(big.Int).Set(highest)
+}
@@ -0,0 +1,363 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// txChain is a fake chain that records sent transactions and mines those mined says to.
+type txChain struct {
+	*chaintest.Server
+
+	mu    sync.Mutex
+	sent  []*types.Transaction
+	mined func(tx *types.Transaction) bool
+	fail  error // Returned by the next eth_sendRawTransaction
+}
+
+func newTxChain(t *testing.T, pendingNonce uint64) (*txChain, *ethclient.Client) {
+	c := &txChain{Server: chaintest.NewServer(56), mined: func(*types.Transaction) bool { return false }}
+	t.Cleanup(c.Close)
+	c.Handle("eth_getTransactionCount", chaintest.Result(hexutil.EncodeUint64(pendingNonce)))
+	c.Handle("eth_sendRawTransaction", func(params []json.RawMessage) (any, error) {
+		var raw hexutil.Bytes
+		json.Unmarshal(params[0], &raw)
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.fail; err != nil {
+			c.fail = nil
+			return nil, err
+		}
+		c.sent = append(c.sent, tx)
+		return tx.Hash(), nil
+	})
+	c.Handle("eth_getTransactionReceipt", func(params []json.RawMessage) (any, error) {
+		var hash common.Hash
+		json.Unmarshal(params[0], &hash)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, tx := range c.sent {
+			if tx.Hash() == hash && c.mined(tx) {
+				return map[string]any{
+					"transactionHash":   hash,
+					"transactionIndex":  "0x0",
+					"blockHash":         common.Hash{1},
+					"blockNumber":       "0x2",
+					"status":            "0x1",
+					"cumulativeGasUsed": "0x5208",
+					"gasUsed":           "0x5208",
+					"effectiveGasPrice": "0x1",
+					"logsBloom":         hexutil.Encode(make([]byte, 256)),
+					"logs":              []any{},
+					"type":              "0x2",
+				}, nil
+			}
+		}
+		return nil, nil
+	})
+
+	client, err := ethclient.Dial(c.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return c, client
+}
+
+func (c *txChain) transactions() []*types.Transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*types.Transaction{}, c.sent...)
+}
+
+func TestTransactionManager_NonceQueue(t *testing.T) {
+	chain, client := newTxChain(t, 5)
+	manager := NewTransactionManager(client, nil).WithSigner(NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56)))
+	ctx := context.Background()
+
+	// 1. Concurrent sends get consecutive nonces from a single read of the pending nonce.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]}); err != nil {
+				t.Errorf("send: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	seen := make(map[uint64]bool)
+	for _, tx := range chain.transactions() {
+		seen[tx.Nonce()] = true
+		if tx.Type() != types.DynamicFeeTxType || tx.Gas() != 240_000 {
+			t.Errorf("expected an EIP-1559 transaction with 20%% gas headroom, got type %d with gas %d", tx.Type(), tx.Gas())
+		}
+	}
+	for nonce := uint64(5); nonce < 15; nonce++ {
+		if !seen[nonce] {
+			t.Errorf("nonce %d was not used", nonce)
+		}
+	}
+	if n := chain.Calls("eth_getTransactionCount"); n != 1 {
+		t.Errorf("expected the pending nonce to be read once, got %d", n)
+	}
+
+	// 2. A rejected transaction's nonce is handed out again.
+	chain.mu.Lock()
+	chain.fail = errors.New("insufficient funds for gas * price + value")
+	chain.mu.Unlock()
+	if _, err := manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]}); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+	tx, err := manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]})
+	if err != nil || tx.Nonce() != 15 {
+		t.Errorf("expected nonce 15 to be reused, got %v, %v", tx, err)
+	}
+
+	// 3. A nonce used elsewhere resyncs the sequence with the node.
+	chain.mu.Lock()
+	chain.fail = errors.New("nonce too low")
+	chain.mu.Unlock()
+	manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]})
+	manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]})
+	if n := chain.Calls("eth_getTransactionCount"); n != 2 {
+		t.Errorf("expected a resync after nonce too low, got %d reads", n)
+	}
+}
+
+func TestTransactionManager_FeePolicy(t *testing.T) {
+	_, client := newTxChain(t, 0)
+	signer := NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56))
+	ctx := context.Background()
+
+	// The node suggests a 0.001 gwei tip over a 1 gwei base fee.
+	tx, err := NewTransactionManager(client, nil).WithSigner(signer).
+		WithFeePolicy(FeePolicy{MinTip: big.NewInt(100_000_000)}).
+		SendTransaction(ctx, Call{To: testVault})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if tx.GasTipCap().Int64() != 100_000_000 || tx.GasFeeCap().Int64() != 2*chaintest.BaseFee+100_000_000 {
+		t.Errorf("expected the minimum tip on twice the base fee, got tip %s, fee cap %s", tx.GasTipCap(), tx.GasFeeCap())
+	}
+
+	tx, err = NewTransactionManager(client, nil).WithSigner(signer).
+		WithFeePolicy(FeePolicy{MaxFee: big.NewInt(1_500_000_000)}).
+		SendTransaction(ctx, Call{To: testVault})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if tx.GasFeeCap().Int64() != 1_500_000_000 {
+		t.Errorf("expected the fee cap to be capped, got %s", tx.GasFeeCap())
+	}
+
+	if _, err := NewTransactionManager(client, nil).WithSigner(signer).
+		WithFeePolicy(FeePolicy{MinTip: big.NewInt(2_000_000_000), MaxFee: big.NewInt(1_500_000_000)}).
+		SendTransaction(ctx, Call{To: testVault}); err == nil {
+		t.Error("expected a tip above the maximum fee to be refused")
+	}
+}
+
+func TestTransactionManager_SpeedUp(t *testing.T) {
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer db.Close()
+
+	chain, client := newTxChain(t, 0)
+	signer := NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56))
+	manager := NewTransactionManager(client, nil).WithSigner(signer).WithStore(db).WithReplacement(20 * time.Millisecond)
+	manager.pollInterval = 5 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// Only a replacement paying a higher tip is mined.
+	chain.mu.Lock()
+	chain.mined = func(sent *types.Transaction) bool { return sent.GasTipCap().Cmp(tx.GasTipCap()) > 0 }
+	chain.mu.Unlock()
+
+	receipt, err := manager.WaitMined(ctx, tx)
+	if err != nil {
+		t.Fatalf("wait mined: %v", err)
+	}
+	sent := chain.transactions()
+	if len(sent) != 2 || receipt.TxHash != sent[1].Hash() {
+		t.Fatalf("expected the replacement to be mined, got %d transactions", len(sent))
+	}
+	replacement := sent[1]
+	if replacement.Nonce() != tx.Nonce() || replacement.GasTipCap().Cmp(bump(tx.GasTipCap())) < 0 || replacement.GasFeeCap().Cmp(bump(tx.GasFeeCap())) < 0 {
+		t.Errorf("replacement has nonce %d, tip %s, fee cap %s", replacement.Nonce(), replacement.GasTipCap(), replacement.GasFeeCap())
+	}
+
+	replaced, _ := db.ListTransactions(56, signer.Address().Hex(), storage.TransactionReplaced)
+	confirmed, _ := db.ListTransactions(56, signer.Address().Hex(), storage.TransactionConfirmed)
+	if len(replaced) != 1 || replaced[0].ReplacedBy != replacement.Hash().Hex() {
+		t.Errorf("expected the original to be recorded as replaced, got %+v", replaced)
+	}
+	if len(confirmed) != 1 || confirmed[0].Hash != replacement.Hash().Hex() {
+		t.Errorf("expected the replacement to be recorded as confirmed, got %+v", confirmed)
+	}
+}
+
+func TestTransactionManager_ResumesNonceAfterRestart(t *testing.T) {
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer db.Close()
+
+	// The node has not seen a transaction recorded in flight with nonce 7.
+	signer := NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56))
+	db.RecordTransaction(storage.TransactionRow{Hash: "0x01", ChainID: 56, Sender: signer.Address().Hex(), Nonce: 7, Status: storage.TransactionPending})
+
+	_, client := newTxChain(t, 5)
+	tx, err := NewTransactionManager(client, nil).WithSigner(signer).WithStore(db).SendTransaction(context.Background(), Call{To: testVault})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if tx.Nonce() != 8 {
+		t.Errorf("expected nonce 8 after the transaction in flight, got %d", tx.Nonce())
+	}
+}
+
+func TestTransactionManager_SpeedUpIsNotChargedAgain(t *testing.T) {
+	chain, client := newTxChain(t, 0)
+	policy := testPolicy(nil)
+	policy.now = func() time.Time { return time.Unix(1_700_000_000, 0) }
+	signer := NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56)).WithPolicy(policy)
+	manager := NewTransactionManager(client, nil).WithSigner(signer)
+	ctx := context.Background()
+
+	// A deposit of 100 leaves 50 of the daily cap; every replacement must still be signed.
+	deposit := Call{To: testVault, Value: big.NewInt(100), Data: harvestCall[:], Gas: 100_000}
+	tx, err := manager.SendTransaction(ctx, deposit)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if tx, err = manager.SpeedUp(ctx, tx); err != nil {
+			t.Fatalf("speed up #%d: %v", i+1, err)
+		}
+	}
+	if len(chain.transactions()) != 4 {
+		t.Errorf("expected the original and three replacements, got %d", len(chain.transactions()))
+	}
+	if _, err := manager.SendTransaction(ctx, Call{To: testVault, Value: big.NewInt(50), Data: harvestCall[:], Gas: 100_000}); err != nil {
+		t.Errorf("expected 50 of the daily cap to be left, got %v", err)
+	}
+
+	// A replacement must make the same call.
+	other := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(56), Nonce: tx.Nonce(), To: &testVault, Value: big.NewInt(1), Data: harvestCall[:]})
+	if _, err := signer.SignReplacement(tx, other); err == nil {
+		t.Error("expected a replacement with another value to be refused")
+	}
+}
+
+func TestTransactionManager_AmbiguousSendErrors(t *testing.T) {
+	chain, client := newTxChain(t, 3)
+	manager := NewTransactionManager(client, nil).WithSigner(NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56)))
+	ctx := context.Background()
+
+	// 1. A node that already has the transaction accepted it.
+	chain.mu.Lock()
+	chain.fail = errors.New("already known")
+	chain.mu.Unlock()
+	tx, err := manager.SendTransaction(ctx, Call{To: testVault, Data: harvestCall[:]})
+	if err != nil || tx.Nonce() != 3 {
+		t.Fatalf("expected an already known transaction to count as sent, got %v, %v", tx, err)
+	}
+
+	// 2. Only errors the node answered with free the nonce.
+	if rejected(context.DeadlineExceeded) || rejected(errors.New("connection reset by peer")) {
+		t.Error("expected transport errors not to count as rejections")
+	}
+	chain.mu.Lock()
+	chain.fail = errors.New("insufficient funds for gas * price + value")
+	chain.mu.Unlock()
+	if err := client.SendTransaction(ctx, tx); err == nil || !rejected(err) {
+		t.Errorf("expected a JSON-RPC error to count as a rejection: %v", err)
+	}
+}
+
+func TestTransactionManager_JournalsBeforeSending(t *testing.T) {
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer db.Close()
+
+	chain, client := newTxChain(t, 3)
+	signer := NewSessionKeySignerFrom(NewTestSigner("eoa"), big.NewInt(56))
+	sender := signer.Address().Hex()
+	manager := NewTransactionManager(client, nil).WithSigner(signer).WithStore(db)
+	pending := func() []storage.TransactionRow {
+		rows, _ := db.ListTransactions(56, sender, storage.TransactionPending)
+		return rows
+	}
+
+	// Each send fails, or not, after checking what was journaled.
+	journaled := make(chan []storage.TransactionRow, 3)
+	answers := make(chan func() (any, error), 3)
+	chain.Handle("eth_sendRawTransaction", func(params []json.RawMessage) (any, error) {
+		journaled <- pending()
+		return (<-answers)()
+	})
+
+	// 1. A refused transaction is dropped from the journal and its nonce handed out again.
+	answers <- func() (any, error) { return nil, errors.New("insufficient funds for gas * price + value") }
+	_, err = manager.SendTransaction(context.Background(), Call{To: testVault, Gas: 100_000})
+	var maybeSent *MaybeSentError
+	if err == nil || errors.As(err, &maybeSent) {
+		t.Fatalf("expected the send to be refused, got %v", err)
+	}
+	rows := <-journaled
+	if len(rows) != 1 || rows[0].Nonce != 3 || rows[0].Raw == "" {
+		t.Fatalf("expected the transaction to be journaled before it was sent, got %+v", rows)
+	}
+	if dropped, _ := db.ListTransactions(56, sender, storage.TransactionDropped); len(dropped) != 1 || dropped[0].Hash != rows[0].Hash {
+		t.Errorf("expected the refused transaction to be dropped, got %+v", dropped)
+	}
+
+	// 2. A send whose answer is lost stays journaled, and its nonce is not reused.
+	answers <- func() (any, error) { time.Sleep(200 * time.Millisecond); return nil, nil }
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = manager.SendTransaction(ctx, Call{To: testVault, Gas: 100_000})
+	if !errors.As(err, &maybeSent) || maybeSent.Tx.Nonce() != 3 {
+		t.Fatalf("expected a transaction that may have been sent with nonce 3, got %v", err)
+	}
+	if rows := <-journaled; len(rows) != 1 || rows[0].Hash != maybeSent.Tx.Hash().Hex() {
+		t.Fatalf("expected the transaction to be journaled before it was sent, got %+v", rows)
+	}
+	if rows := pending(); len(rows) != 1 || rows[0].Hash != maybeSent.Tx.Hash().Hex() {
+		t.Errorf("expected the transaction to stay pending, got %+v", rows)
+	}
+
+	answers <- func() (any, error) { return nil, nil }
+	tx, err := manager.SendTransaction(context.Background(), Call{To: testVault, Gas: 100_000})
+	if err != nil || tx.Nonce() != 4 {
+		t.Fatalf("expected nonce 4 after the transaction in flight, got %v, %v", tx, err)
+	}
+	<-journaled
+}
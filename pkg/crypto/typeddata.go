@@ -0,0 +1,167 @@
+package crypto
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Primary types of the messages agents sign under the SettlerEngine domain.
+const (
+	TypeIntentToPay      = "IntentToPay"
+	TypeBoundIntentToPay = "BoundIntentToPay"
+	TypeDeposit          = "Deposit"
+	TypeCreditSpend      = "CreditSpend"
+	TypeSubscribe        = "Subscribe"
+	TypeEscrowRelease    = "EscrowRelease"
+)
+
+// SettlerEngine EIP-712 domain. DomainVersion is used when DomainParams leaves Version
+// empty; it is the version agents that hardcode the domain sign under.
+const (
+	DomainName    = "SettlerEngine"
+	DomainVersion = "1"
+)
+
+var eip712DomainFields = []apitypes.Type{
+	{Name: "name", Type: "string"},
+	{Name: "version", Type: "string"},
+	{Name: "chainId", Type: "uint256"},
+	{Name: "verifyingContract", Type: "address"},
+}
+
+// intentFields are shared by every payment-like message.
+var intentFields = []apitypes.Type{
+	{Name: "recipient", Type: "address"},
+	{Name: "amount", Type: "uint256"},
+	{Name: "asset", Type: "address"},
+	{Name: "nonce", Type: "string"},
+	{Name: "deadline", Type: "uint256"},
+}
+
+// typedDataTypes is the registry of message types, keyed by primary type.
+var typedDataTypes = map[string][]apitypes.Type{
+	TypeIntentToPay: intentFields,
+	TypeBoundIntentToPay: withFields(intentFields,
+		apitypes.Type{Name: "method", Type: "string"},
+		apitypes.Type{Name: "url", Type: "string"},
+		apitypes.Type{Name: "bodyHash", Type: "bytes32"},
+	),
+	TypeDeposit: intentFields,
+	TypeCreditSpend: {
+		{Name: "deposit", Type: "bytes32"},
+		{Name: "nonce", Type: "string"},
+		{Name: "deadline", Type: "uint256"},
+		{Name: "method", Type: "string"},
+		{Name: "url", Type: "string"},
+		{Name: "bodyHash", Type: "bytes32"},
+	},
+	TypeSubscribe: withFields(intentFields,
+		apitypes.Type{Name: "period", Type: "uint256"}, // Seconds between charges
+	),
+	TypeEscrowRelease: withFields(intentFields,
+		apitypes.Type{Name: "escrowId", Type: "bytes32"},
+	),
+}
+
+func withFields(base []apitypes.Type, extra ...apitypes.Type) []apitypes.Type {
+	return append(append([]apitypes.Type{}, base...), extra...)
+}
+
+// TypedDataFields returns the EIP-712 fields of a registered primary type.
+func TypedDataFields(primaryType string) ([]apitypes.Type, error) {
+	fields, ok := typedDataTypes[primaryType]
+	if !ok {
+		return nil, fmt.Errorf("unknown typed data type %q", primaryType)
+	}
+	return append([]apitypes.Type{}, fields...), nil
+}
+
+// PrimaryTypes lists the registered primary types in sorted order.
+func PrimaryTypes() []string {
+	types := make([]string, 0, len(typedDataTypes))
+	for t := range typedDataTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// TypedDataDomain is the EIP-712 domain as sent to agents.
+type TypedDataDomain struct {
+	Name              string   `json:"name"`
+	Version           string   `json:"version"`
+	ChainID           *big.Int `json:"chainId"`
+	VerifyingContract string   `json:"verifyingContract"`
+}
+
+// TypedDataSchema is everything but the message an agent needs to call eth_signTypedData_v4.
+type TypedDataSchema struct {
+	Types       apitypes.Types  `json:"types"`
+	PrimaryType string          `json:"primaryType"`
+	Domain      TypedDataDomain `json:"domain"`
+}
+
+// NewTypedDataSchema returns the schema of a registered message type under the SettlerEngine domain.
+func NewTypedDataSchema(primaryType string, params DomainParams) (*TypedDataSchema, error) {
+	fields, err := TypedDataFields(primaryType)
+	if err != nil {
+		return nil, err
+	}
+	domain := settlerDomain(params)
+	return &TypedDataSchema{
+		Types: apitypes.Types{
+			"EIP712Domain": append([]apitypes.Type{}, eip712DomainFields...),
+			primaryType:    fields,
+		},
+		PrimaryType: primaryType,
+		Domain: TypedDataDomain{
+			Name:              domain.Name,
+			Version:           domain.Version,
+			ChainID:           params.ChainID,
+			VerifyingContract: domain.VerifyingContract,
+		},
+	}, nil
+}
+
+// TypedData completes the schema with a message, ready to be hashed or signed.
+func (s *TypedDataSchema) TypedData(message apitypes.TypedDataMessage) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types:       s.Types,
+		PrimaryType: s.PrimaryType,
+		Domain: apitypes.TypedDataDomain{
+			Name:              s.Domain.Name,
+			Version:           s.Domain.Version,
+			ChainId:           (*math.HexOrDecimal256)(s.Domain.ChainID),
+			VerifyingContract: s.Domain.VerifyingContract,
+		},
+		Message: message,
+	}
+}
+
+// HashTypedMessage returns the EIP-712 digest of a message of a registered type under the
+// SettlerEngine domain.
+func HashTypedMessage(primaryType string, message apitypes.TypedDataMessage, params DomainParams) ([]byte, error) {
+	fields, err := TypedDataFields(primaryType)
+	if err != nil {
+		return nil, err
+	}
+	return hashTypedData(primaryType, fields, message, settlerDomain(params))
+}
+
+// settlerDomain returns the EIP-712 domain SettlerEngine messages are signed under.
+func settlerDomain(params DomainParams) apitypes.TypedDataDomain {
+	version := params.Version
+	if version == "" {
+		version = DomainVersion
+	}
+	return apitypes.TypedDataDomain{
+		Name:              DomainName,
+		Version:           version,
+		ChainId:           (*math.HexOrDecimal256)(params.ChainID),
+		VerifyingContract: params.VerifyingContract.Hex(),
+	}
+}
@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// webAuthnUserPresent is the authenticatorData flag set when the user touched the authenticator.
+const webAuthnUserPresent = 0x01
+
+// WebAuthnAssertion is a passkey signature as returned by navigator.credentials.get. All fields
+// are base64url encoded, padded or not.
+type WebAuthnAssertion struct {
+	CredentialID      string `json:"credentialId"`
+	AuthenticatorData string `json:"authenticatorData"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	Signature         string `json:"signature"` // ASN.1 DER, or raw r||s
+}
+
+// clientData holds the clientDataJSON fields that are checked.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// VerifyIntentToPayWebAuthn checks that a passkey signed the intent, with the EIP-712 digest as
+// the WebAuthn challenge.
+func VerifyIntentToPayWebAuthn(intent IntentToPay, assertion WebAuthnAssertion, params DomainParams, pub *ecdsa.PublicKey, rpID string) error {
+	sighash, err := HashIntentToPay(intent, params)
+	if err != nil {
+		return err
+	}
+	return VerifyWebAuthn(pub, sighash, assertion, rpID)
+}
+
+// VerifyDepositWebAuthn is VerifyIntentToPayWebAuthn for deposits.
+func VerifyDepositWebAuthn(deposit DepositIntent, assertion WebAuthnAssertion, params DomainParams, pub *ecdsa.PublicKey, rpID string) error {
+	sighash, err := HashDeposit(deposit, params)
+	if err != nil {
+		return err
+	}
+	return VerifyWebAuthn(pub, sighash, assertion, rpID)
+}
+
+// VerifyWebAuthn checks a WebAuthn assertion over challenge: clientDataJSON must be a
+// "webauthn.get" for that challenge, authenticatorData must show user presence and, when rpID
+// is set, its RP ID hash, and the P-256 signature must cover both. Failures wrap ErrInvalidSignature.
+func VerifyWebAuthn(pub *ecdsa.PublicKey, challenge []byte, assertion WebAuthnAssertion, rpID string) error {
+	authData, err := decodeBase64URL(assertion.AuthenticatorData)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode authenticatorData: %v", ErrInvalidSignature, err)
+	}
+	clientDataJSON, err := decodeBase64URL(assertion.ClientDataJSON)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode clientDataJSON: %v", ErrInvalidSignature, err)
+	}
+	sig, err := decodeBase64URL(assertion.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode signature: %v", ErrInvalidSignature, err)
+	}
+
+	var client clientData
+	if err := json.Unmarshal(clientDataJSON, &client); err != nil {
+		return fmt.Errorf("%w: failed to parse clientDataJSON: %v", ErrInvalidSignature, err)
+	}
+	if client.Type != "webauthn.get" {
+		return fmt.Errorf("%w: unexpected clientDataJSON type %q", ErrInvalidSignature, client.Type)
+	}
+	if client.Challenge != base64.RawURLEncoding.EncodeToString(challenge) {
+		return fmt.Errorf("%w: assertion is for another challenge", ErrInvalidSignature)
+	}
+
+	// authenticatorData is rpIdHash (32) || flags (1) || signCount (4) || extensions.
+	if len(authData) < 37 {
+		return fmt.Errorf("%w: authenticatorData too short: %d bytes", ErrInvalidSignature, len(authData))
+	}
+	if rpID != "" {
+		if rpIDHash := sha256.Sum256([]byte(rpID)); !bytes.Equal(authData[:32], rpIDHash[:]) {
+			return fmt.Errorf("%w: assertion is for another relying party", ErrInvalidSignature)
+		}
+	}
+	if authData[32]&webAuthnUserPresent == 0 {
+		return fmt.Errorf("%w: user presence flag not set", ErrInvalidSignature)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	valid := false
+	if len(sig) == 64 {
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		valid = ecdsa.Verify(pub, digest[:], r, s)
+	} else {
+		valid = ecdsa.VerifyASN1(pub, digest[:], sig)
+	}
+	if !valid {
+		return fmt.Errorf("%w: P-256 signature does not verify", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// ParseP256PublicKey parses a passkey public key, given either as a hex uncompressed point
+// (0x04 || X || Y) or as base64 SubjectPublicKeyInfo DER, as returned by getPublicKey().
+func ParseP256PublicKey(key string) (*ecdsa.PublicKey, error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "0x") {
+		raw, err := hexutil.Decode(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode public key: %w", err)
+		}
+		if _, err := ecdh.P256().NewPublicKey(raw); err != nil {
+			return nil, fmt.Errorf("invalid P-256 point: %w", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(raw[1:33]),
+			Y:     new(big.Int).SetBytes(raw[33:]),
+		}, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		if der, err = decodeBase64URL(key); err != nil {
+			return nil, fmt.Errorf("failed to decode public key: %w", err)
+		}
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	pub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("public key is not P-256")
+	}
+	return pub, nil
+}
+
+// EncodeP256PublicKey returns the hex uncompressed point ParseP256PublicKey accepts.
+func EncodeP256PublicKey(pub *ecdsa.PublicKey) string {
+	raw := make([]byte, 65)
+	raw[0] = 0x04
+	pub.X.FillBytes(raw[1:33])
+	pub.Y.FillBytes(raw[33:])
+	return hexutil.Encode(raw)
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
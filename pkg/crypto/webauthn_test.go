@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// signWebAuthn produces the assertion an authenticator for rpID would return for challenge.
+func signWebAuthn(t *testing.T, key *ecdsa.PrivateKey, challenge []byte, rpID string, flags byte) WebAuthnAssertion {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], flags, 0, 0, 0, 1)
+	clientDataJSON := []byte(fmt.Sprintf(`{"type":"webauthn.get","challenge":%q,"origin":"https://%s","crossOrigin":false}`,
+		base64.RawURLEncoding.EncodeToString(challenge), rpID))
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return WebAuthnAssertion{
+		CredentialID:      base64.RawURLEncoding.EncodeToString([]byte("credential")),
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+		Signature:         base64.RawURLEncoding.EncodeToString(sig),
+	}
+}
+
+func TestVerifyIntentToPayWebAuthn(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	params := DomainParams{
+		ChainID:           big.NewInt(8453),
+		VerifyingContract: common.HexToAddress("0x1234567890123456789012345678901234567890"),
+	}
+	intent := IntentToPay{
+		Recipient: "0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B",
+		Amount:    "1000000",
+		Asset:     "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+		Nonce:     "test-nonce-123",
+		Deadline:  1739686400,
+	}
+	sighash, err := HashIntentToPay(intent, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := signWebAuthn(t, key, sighash, "agent.example", webAuthnUserPresent)
+	if err := VerifyIntentToPayWebAuthn(intent, valid, params, &key.PublicKey, "agent.example"); err != nil {
+		t.Fatalf("expected a valid assertion, got %v", err)
+	}
+
+	otherIntent := intent
+	otherIntent.Amount = "1"
+	tests := []struct {
+		name      string
+		intent    IntentToPay
+		assertion WebAuthnAssertion
+		pub       *ecdsa.PublicKey
+		rpID      string
+	}{
+		{"other key", intent, valid, &other.PublicKey, "agent.example"},
+		{"other intent", otherIntent, valid, &key.PublicKey, "agent.example"},
+		{"other relying party", intent, valid, &key.PublicKey, "evil.example"},
+		{"user not present", intent, signWebAuthn(t, key, sighash, "agent.example", 0), &key.PublicKey, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyIntentToPayWebAuthn(tt.intent, tt.assertion, params, tt.pub, tt.rpID)
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseP256PublicKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	pub, err := ParseP256PublicKey(EncodeP256PublicKey(&key.PublicKey))
+	if err != nil || !pub.Equal(&key.PublicKey) {
+		t.Fatalf("failed to round-trip the uncompressed point: %v", err)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pub, err = ParseP256PublicKey(base64.StdEncoding.EncodeToString(der))
+	if err != nil || !pub.Equal(&key.PublicKey) {
+		t.Fatalf("failed to parse SubjectPublicKeyInfo: %v", err)
+	}
+
+	if _, err := ParseP256PublicKey("0x04" + "00"); err == nil {
+		t.Error("expected a truncated point to be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+package facilitator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// Client calls a facilitator over HTTP. It implements x402.Facilitator.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a client for the facilitator at rawURL, either an http(s):// URL or
+// unix:///path/to/facilitator.sock for a facilitator on the same host.
+func NewClient(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid facilitator URL: %w", err)
+	}
+
+	c := &Client{http: &http.Client{Timeout: 30 * time.Second}}
+	switch u.Scheme {
+	case "http", "https":
+		c.baseURL = strings.TrimRight(rawURL, "/")
+	case "unix":
+		socket := u.Path
+		c.baseURL = "http://facilitator"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported facilitator URL scheme %q", u.Scheme)
+	}
+	return c, nil
+}
+
+// WithToken sends token as the bearer token the facilitator requires.
+func (c *Client) WithToken(token string) *Client {
+	c.token = token
+	return c
+}
+
+// Verify asks the facilitator whether the payment meets the requirements.
+func (c *Client) Verify(ctx context.Context, payment x402.SpecPayment, requirements x402.PaymentDescriptor) (*x402.VerifyResponse, error) {
+	var resp x402.VerifyResponse
+	if err := c.post(ctx, "/verify", payment, requirements, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Settle asks the facilitator to settle the payment on-chain.
+func (c *Client) Settle(ctx context.Context, payment x402.SpecPayment, requirements x402.PaymentDescriptor) (*x402.SettlementResponse, error) {
+	var resp x402.SettlementResponse
+	if err := c.post(ctx, "/settle", payment, requirements, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Supported lists the schemes, networks and assets the facilitator accepts.
+func (c *Client) Supported(ctx context.Context) (*x402.SupportedResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/supported", nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp x402.SupportedResponse
+	if err := c.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, payment x402.SpecPayment, requirements x402.PaymentDescriptor, out any) error {
+	body, err := json.Marshal(x402.VerifyRequest{
+		X402Version:         x402.X402Version,
+		PaymentPayload:      payment,
+		PaymentRequirements: requirements,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach facilitator: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("facilitator %s returned %s: %s", req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode facilitator response: %w", err)
+	}
+	return nil
+}
+
+var _ x402.Facilitator = (*Client)(nil)
@@ -0,0 +1,272 @@
+package facilitator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/settlement"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+	"github.com/nathfavour/settlerengine/pkg/uds"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+const (
+	testChainID = 31337
+	testPayTo   = "0x0000000000000000000000000000000000000123"
+)
+
+var testToken = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+// newFacilitator starts a facilitator for a test chain whose token holds balance for every payer.
+func newFacilitator(t *testing.T, balance int64) (*Server, <-chan *types.Transaction) {
+	srv := chaintest.NewServer(testChainID)
+	t.Cleanup(srv.Close)
+	chains.RegisterChain(chains.ChainConfig{
+		Name:         "Test",
+		Network:      "test",
+		ChainID:      testChainID,
+		RPCURL:       srv.URL,
+		USDCAddress:  testToken.Hex(),
+		TokenDomains: map[string]chains.TokenDomain{"USDC": {Name: "USD Coin", Version: "2"}},
+	})
+	sent := srv.ServeToken(big.NewInt(balance))
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	key, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(key)), big.NewInt(testChainID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	t.Cleanup(clients.Close)
+	return NewServer(settlement.NewEIP3009Settler(clients, crypto.NewSenders(clients, signer, db), db), db), sent
+}
+
+func signPayment(t *testing.T, value string, nonce byte) x402.SpecPayment {
+	agent, _ := ethcrypto.GenerateKey()
+	auth := crypto.TransferWithAuthorization{
+		From:        ethcrypto.PubkeyToAddress(agent.PublicKey).Hex(),
+		To:          testPayTo,
+		Value:       value,
+		ValidAfter:  "0",
+		ValidBefore: fmt.Sprint(time.Now().Add(time.Minute).Unix()),
+		Nonce:       hexutil.Encode(bytes.Repeat([]byte{nonce}, 32)),
+	}
+	sighash, err := crypto.HashTransferWithAuthorization(auth, crypto.TokenDomain{
+		Name: "USD Coin", Version: "2", ChainID: big.NewInt(testChainID), VerifyingContract: testToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := ethcrypto.Sign(sighash, agent)
+	sig[64] += 27
+	return x402.SpecPayment{
+		X402Version: x402.X402Version,
+		Scheme:      x402.SchemeExact,
+		Network:     "test",
+		Payload:     x402.ExactPayload{Signature: hexutil.Encode(sig), Authorization: auth},
+	}
+}
+
+func requirements(amount string) x402.PaymentDescriptor {
+	return x402.PaymentDescriptor{
+		Scheme:            x402.SchemeExact,
+		Network:           "test",
+		MaxAmountRequired: amount,
+		PayTo:             testPayTo,
+		Asset:             testToken.Hex(),
+		Extra:             map[string]string{"name": "USD Coin", "version": "2"},
+	}
+}
+
+func TestServer_VerifySettleSupported(t *testing.T) {
+	server, sent := newFacilitator(t, 5000)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client, err := NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	supported, err := client.Supported(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, kind := range supported.Kinds {
+		if kind.Network == "test" && kind.Scheme == x402.SchemeExact && len(kind.Assets) == 1 && kind.Assets[0].Symbol == "USDC" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected USDC on the test network to be supported, got %+v", supported.Kinds)
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		req    x402.PaymentDescriptor
+		reason string
+	}{
+		{name: "valid", value: "1000", req: requirements("1000")},
+		{name: "underpaid", value: "999", req: requirements("1000"), reason: x402.ReasonAmountMismatch},
+		{name: "over balance", value: "10000", req: requirements("10000"), reason: x402.ReasonInsufficientFunds},
+		{name: "other network", value: "1000", req: func() x402.PaymentDescriptor { r := requirements("1000"); r.Network = "base"; return r }(), reason: x402.ReasonChainMismatch},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Verify(ctx, signPayment(t, tt.value, byte(i+1)), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsValid != (tt.reason == "") || resp.InvalidReason != tt.reason {
+				t.Errorf("expected reason %q, got %+v", tt.reason, resp)
+			}
+		})
+	}
+
+	payment := signPayment(t, "1000", 9)
+	settled, err := client.Settle(ctx, payment, requirements("1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := <-sent
+	if !settled.Success || settled.Transaction != tx.Hash().Hex() || settled.Network != "test" {
+		t.Errorf("unexpected settlement response %+v", settled)
+	}
+
+	// Settling again returns the recorded transaction without submitting it twice.
+	again, err := client.Settle(ctx, payment, requirements("1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Success || again.Transaction != settled.Transaction {
+		t.Errorf("expected the recorded transaction %s, got %+v", settled.Transaction, again)
+	}
+	select {
+	case tx := <-sent:
+		t.Errorf("expected no second transaction, got %s", tx.Hash().Hex())
+	default:
+	}
+
+	rejected, err := client.Settle(ctx, signPayment(t, "10000", 10), requirements("10000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Success || rejected.ErrorReason != x402.ReasonInsufficientFunds {
+		t.Errorf("expected %s, got %+v", x402.ReasonInsufficientFunds, rejected)
+	}
+}
+
+func TestServer_RequiresToken(t *testing.T) {
+	server, sent := newFacilitator(t, 5000)
+	server.Token = "s3cret"
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx := context.Background()
+
+	for _, token := range []string{"", "wrong"} {
+		client, _ := NewClient(ts.URL)
+		if _, err := client.WithToken(token).Settle(ctx, signPayment(t, "1000", 1), requirements("1000")); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("expected 401 with token %q, got %v", token, err)
+		}
+	}
+	select {
+	case tx := <-sent:
+		t.Fatalf("expected no transaction without the token, got %s", tx.Hash().Hex())
+	default:
+	}
+
+	client, _ := NewClient(ts.URL)
+	settled, err := client.WithToken("s3cret").Settle(ctx, signPayment(t, "1000", 1), requirements("1000"))
+	if err != nil || !settled.Success {
+		t.Fatalf("expected the payment to settle with the token, got %+v %v", settled, err)
+	}
+	<-sent
+	if _, err := client.WithToken("").Supported(ctx); err != nil {
+		t.Errorf("expected /supported to stay open, got %v", err)
+	}
+}
+
+func TestClient_MiddlewareOverUnixSocket(t *testing.T) {
+	server, sent := newFacilitator(t, 5000)
+	socket := filepath.Join(t.TempDir(), "facilitator.sock")
+	listener := uds.NewServer(socket)
+	if err := listener.StartHTTP(server); err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the socket to be private to its owner, got %v", info.Mode().Perm())
+	}
+
+	client, err := NewClient("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := x402.NewMiddleware(x402.Config{
+		DomainParams: crypto.DomainParams{ChainID: big.NewInt(testChainID)},
+		NonceExpiry:  time.Minute,
+		Recipient:    testPayTo,
+		Asset:        testToken.Hex(),
+		Amount:       "1000",
+		Facilitator:  client,
+	})
+	t.Cleanup(mw.Close)
+
+	pay := func(payment x402.SpecPayment) *httptest.ResponseRecorder {
+		header, _ := x402.EncodeSpecPayment(payment)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-PAYMENT", header)
+		rr := httptest.NewRecorder()
+		mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := pay(signPayment(t, "10000", 1))
+	var challenge x402.ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &challenge)
+	if rr.Code != http.StatusPaymentRequired || challenge.Error != x402.ReasonInsufficientFunds {
+		t.Fatalf("expected 402 %s, got %d %s", x402.ReasonInsufficientFunds, rr.Code, rr.Body.String())
+	}
+
+	rr = pay(signPayment(t, "1000", 2))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	tx := <-sent
+	raw, _ := base64.StdEncoding.DecodeString(rr.Header().Get(x402.HeaderPaymentResponse))
+	var settled x402.SettlementResponse
+	json.Unmarshal(raw, &settled)
+	if settled.Transaction != tx.Hash().Hex() {
+		t.Errorf("expected transaction %s in X-PAYMENT-RESPONSE, got %q", tx.Hash().Hex(), settled.Transaction)
+	}
+}
@@ -0,0 +1,225 @@
+// Package facilitator verifies and settles x402 payments for other gateways over HTTP.
+package facilitator
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/settlement"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// maxRequestBytes caps the size of a /verify or /settle request body.
+const maxRequestBytes = 64 << 10
+
+// Server answers the facilitator API:
+//
+//	POST /verify     checks a payment against its requirements
+//	POST /settle     verifies a payment and settles it on-chain
+//	GET  /supported  lists the schemes, networks and assets accepted
+//
+// It also implements x402.Facilitator for use in-process.
+type Server struct {
+	settler *settlement.EIP3009Settler
+	db      *storage.DB
+	mux     *http.ServeMux
+
+	// Batcher, when set, queues verified payments for batched settlement instead of
+	// submitting each one. /settle then answers before the transaction exists.
+	Batcher *settlement.Batcher
+
+	// Token, when set, is the bearer token /verify and /settle require. /settle spends the
+	// facilitator key's gas, so it must not be open to anyone who can reach the listener.
+	Token string
+}
+
+// NewServer creates a facilitator backed by settler. db may be nil; when set, repeated
+// /settle calls for a payment return the recorded transaction instead of resubmitting it.
+func NewServer(settler *settlement.EIP3009Settler, db *storage.DB) *Server {
+	s := &Server{settler: settler, db: db, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /verify", s.authorized(s.handleVerify))
+	s.mux.HandleFunc("POST /settle", s.authorized(s.handleSettle))
+	s.mux.HandleFunc("GET /supported", s.handleSupported)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Verify checks the payment's signature, amount and validity window, then that the
+// authorization is unused on-chain and the payer can cover it.
+func (s *Server) Verify(ctx context.Context, payment x402.SpecPayment, requirements x402.PaymentDescriptor) (*x402.VerifyResponse, error) {
+	payer := payment.Payload.Authorization.From
+	p, err := s.verify(payment, requirements)
+	if err == nil {
+		_, err = s.settler.Verify(ctx, p)
+	}
+
+	var rejection *x402.PaymentError
+	switch {
+	case errors.As(err, &rejection):
+		return &x402.VerifyResponse{IsValid: false, InvalidReason: rejection.Reason, Payer: payer}, nil
+	case err != nil:
+		return nil, err
+	}
+	return &x402.VerifyResponse{IsValid: true, Payer: payer}, nil
+}
+
+// Settle verifies the payment once and submits it on-chain, or queues it when batching.
+func (s *Server) Settle(ctx context.Context, payment x402.SpecPayment, requirements x402.PaymentDescriptor) (*x402.SettlementResponse, error) {
+	resp := &x402.SettlementResponse{Network: payment.Network, Payer: payment.Payload.Authorization.From}
+
+	// A retried call must not submit the same authorization twice.
+	if id, err := x402.ExactPaymentID(payment, requirements); err == nil && s.db != nil {
+		row, err := s.db.LoadSettlement(id)
+		if err != nil {
+			return nil, err
+		}
+		if row != nil && row.Status != storage.SettlementFailed {
+			resp.Success, resp.Transaction = true, row.TxHash
+			return resp, nil
+		}
+	}
+
+	p, err := s.verify(payment, requirements)
+	if err == nil {
+		_, err = s.settler.Verify(ctx, p)
+	}
+	switch {
+	case err != nil:
+	case s.Batcher != nil:
+		_, err = s.Batcher.Enqueue(p)
+	default:
+		resp.Transaction, err = s.settler.Submit(ctx, p)
+	}
+
+	var rejection *x402.PaymentError
+	switch {
+	case errors.As(err, &rejection):
+		resp.ErrorReason = rejection.Reason
+	case err != nil:
+		log.Printf("⚠️  facilitator: Failed to settle payment from %s: %v", resp.Payer, err)
+		resp.ErrorReason = x402.ReasonSettlementFailed
+	default:
+		resp.Success = true
+	}
+	return resp, nil
+}
+
+// verify runs the off-chain checks and builds the settlement for the payment.
+func (s *Server) verify(payment x402.SpecPayment, requirements x402.PaymentDescriptor) (x402.ExactSettlement, error) {
+	if _, err := x402.VerifyExact(payment, requirements); err != nil {
+		return x402.ExactSettlement{}, err
+	}
+	domain, err := requirements.TokenDomain()
+	if err != nil {
+		return x402.ExactSettlement{}, &x402.PaymentError{Reason: x402.ReasonChainMismatch, Err: err}
+	}
+	paymentID, err := x402.ExactPaymentID(payment, requirements)
+	if err != nil {
+		return x402.ExactSettlement{}, err
+	}
+	return x402.ExactSettlement{
+		PaymentID:     paymentID,
+		Token:         domain,
+		Authorization: payment.Payload.Authorization,
+		Signature:     payment.Payload.Signature,
+	}, nil
+}
+
+// Supported lists the networks of the chains registry with an EIP-3009 token.
+func (s *Server) Supported() x402.SupportedResponse {
+	resp := x402.SupportedResponse{Kinds: []x402.SupportedKind{}}
+	for _, chain := range chains.Chains() {
+		if chain.Network == "" {
+			continue
+		}
+		kind := x402.SupportedKind{X402Version: x402.X402Version, Scheme: x402.SchemeExact, Network: chain.Network}
+		for symbol, domain := range chain.TokenDomains {
+			if addr, ok := chain.TokenAddress(symbol); ok {
+				kind.Assets = append(kind.Assets, x402.SupportedAsset{Symbol: symbol, Address: addr, Name: domain.Name, Version: domain.Version})
+			}
+		}
+		if len(kind.Assets) == 0 {
+			continue
+		}
+		sort.Slice(kind.Assets, func(i, j int) bool { return kind.Assets[i].Symbol < kind.Assets[j].Symbol })
+		resp.Kinds = append(resp.Kinds, kind)
+	}
+	return resp
+}
+
+// authorized rejects requests that do not carry the server's token.
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid facilitator token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp, err := s.Verify(r.Context(), req.PaymentPayload, req.PaymentRequirements)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleSettle(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp, err := s.Settle(r.Context(), req.PaymentPayload, req.PaymentRequirements)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleSupported(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Supported())
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request) (*x402.VerifyRequest, error) {
+	var req x402.VerifyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if req.X402Version != x402.X402Version || req.PaymentPayload.X402Version != x402.X402Version {
+		return nil, fmt.Errorf("unsupported x402Version %d", req.X402Version)
+	}
+	return &req, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+var _ x402.Facilitator = (*Server)(nil)
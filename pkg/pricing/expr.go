@@ -0,0 +1,367 @@
+package pricing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// env is what a rule expression is evaluated against.
+type env struct {
+	r      *http.Request
+	signer string // lower-case hex, empty when the payer is unknown
+	now    time.Time
+}
+
+// evalFunc evaluates a compiled expression node to a string, float64 or bool.
+type evalFunc func(e *env) any
+
+// variables are the identifiers available in expressions.
+var variables = map[string]evalFunc{
+	"method":  func(e *env) any { return e.r.Method },
+	"host":    func(e *env) any { return requestHost(e.r) },
+	"path":    func(e *env) any { return e.r.URL.Path },
+	"signer":  func(e *env) any { return e.signer },
+	"hour":    func(e *env) any { return float64(e.now.Hour()) },
+	"minute":  func(e *env) any { return float64(e.now.Minute()) },
+	"weekday": func(e *env) any { return float64(e.now.Weekday()) },
+}
+
+// functions are the calls available in expressions, keyed by name and arity.
+var functions = map[string]struct {
+	arity int
+	call  func(e *env, args []any) any
+}{
+	"header":    {1, func(e *env, args []any) any { return e.r.Header.Get(toString(args[0])) }},
+	"query":     {1, func(e *env, args []any) any { return e.r.URL.Query().Get(toString(args[0])) }},
+	"lower":     {1, func(e *env, args []any) any { return strings.ToLower(toString(args[0])) }},
+	"hasPrefix": {2, func(e *env, args []any) any { return strings.HasPrefix(toString(args[0]), toString(args[1])) }},
+}
+
+// compileExpr parses a rule condition such as
+//
+//	header("X-Tier") == "pro" && (hour >= 22 || weekday == 0)
+//
+// into an evaluator. Unknown identifiers and functions are rejected here, not at request time.
+func compileExpr(src string) (evalFunc, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	fn, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	return fn, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], src[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokString, src[i+1 : i+1+end], i})
+			i += end + 2
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of expression", len(src)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at offset %d, found %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (evalFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *env) any { return truthy(l(e)) || truthy(right(e)) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (evalFunc, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *env) any { return truthy(l(e)) && truthy(right(e)) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (evalFunc, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(e *env) any { return !truthy(operand(e)) }, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (evalFunc, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return left, nil
+	}
+	switch tok.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	op := tok.text
+	return func(e *env) any { return compare(op, left(e), right(e)) }, nil
+}
+
+func (p *parser) parsePrimary() (evalFunc, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		s := tok.text
+		return func(*env) any { return s }, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return func(*env) any { return n }, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return func(*env) any { return true }, nil
+		case "false":
+			return func(*env) any { return false }, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(tok)
+		}
+		if v, ok := variables[tok.text]; ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("unknown identifier %q at offset %d", tok.text, tok.pos)
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+func (p *parser) parseCall(name token) (evalFunc, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.pos)
+	}
+	var args []evalFunc
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%s expects %d argument(s), got %d", name.text, fn.arity, len(args))
+	}
+	return func(e *env) any {
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = arg(e)
+		}
+		return fn.call(e, values)
+	}, nil
+}
+
+// compare compares numerically when both sides are numbers, and as strings otherwise.
+func compare(op string, a, b any) bool {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch op {
+			case "==":
+				return x == y
+			case "!=":
+				return x != y
+			case "<":
+				return x < y
+			case "<=":
+				return x <= y
+			case ">":
+				return x > y
+			case ">=":
+				return x >= y
+			}
+		}
+	}
+	x, y := toString(a), toString(b)
+	switch op {
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+func toNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
@@ -0,0 +1,332 @@
+// Package pricing compiles declarative route pricing rules into an x402.PriceResolver.
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// ErrNoMatch is returned when no rule matches a request and there is no default price.
+var ErrNoMatch = errors.New("no pricing rule matches the request")
+
+// Terms are the payment requirements a rule sets. Empty fields are inherited.
+type Terms struct {
+	Price     string `json:"price,omitempty"`     // Amount in atomic units
+	Asset     string `json:"asset,omitempty"`     // Token contract address
+	Recipient string `json:"recipient,omitempty"` // Merchant wallet address
+	ChainID   uint64 `json:"chainId,omitempty"`   // Chain the price applies to, 0 for the gateway's chain
+}
+
+// Rule prices the requests matching its host, method and path patterns and condition.
+type Rule struct {
+	Name    string   `json:"name,omitempty"`
+	Host    string   `json:"host,omitempty"`    // Glob such as "*.example.com"; empty matches any host
+	Methods []string `json:"methods,omitempty"` // Empty matches any method
+	Path    string   `json:"path,omitempty"`    // Segment glob; "*" is one segment, a trailing "**" the rest
+	When    string   `json:"when,omitempty"`    // Optional expression, see compileExpr
+	Terms
+}
+
+// File is the on-disk pricing configuration.
+type File struct {
+	Timezone string `json:"timezone,omitempty"` // IANA zone for hour, minute and weekday; defaults to UTC
+	Defaults Terms  `json:"defaults"`
+	Rules    []Rule `json:"rules"`
+}
+
+// Table is a compiled pricing configuration. Rules are tried in order and the first match wins.
+type Table struct {
+	defaults Terms
+	rules    []compiledRule
+	location *time.Location
+	now      func() time.Time
+}
+
+type compiledRule struct {
+	name    string
+	host    string
+	methods []string
+	path    []string
+	when    evalFunc
+	terms   Terms
+}
+
+// LoadFile reads and compiles a pricing file. base supplies terms the file leaves unset.
+func LoadFile(filename string, base Terms) (*Table, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+	return Parse(data, base)
+}
+
+// Parse compiles a JSON pricing configuration, validating every rule up front.
+func Parse(data []byte, base Terms) (*Table, error) {
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file: %w", err)
+	}
+
+	t := &Table{defaults: inherit(f.Defaults, base), location: time.UTC, now: time.Now}
+	if f.Timezone != "" {
+		loc, err := time.LoadLocation(f.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", f.Timezone, err)
+		}
+		t.location = loc
+	}
+	if t.defaults.Price != "" {
+		if err := validateTerms(t.defaults); err != nil {
+			return nil, fmt.Errorf("defaults: %w", err)
+		}
+	}
+
+	for i, rule := range f.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		compiled, err := compileRule(rule, t.defaults)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		compiled.name = name
+		t.rules = append(t.rules, compiled)
+	}
+	return t, nil
+}
+
+func compileRule(rule Rule, defaults Terms) (compiledRule, error) {
+	c := compiledRule{
+		host:  strings.ToLower(rule.Host),
+		terms: inherit(rule.Terms, defaults),
+	}
+	if err := validateTerms(c.terms); err != nil {
+		return c, err
+	}
+	if _, err := path.Match(c.host, ""); err != nil {
+		return c, fmt.Errorf("invalid host pattern %q: %w", rule.Host, err)
+	}
+	for _, m := range rule.Methods {
+		c.methods = append(c.methods, strings.ToUpper(m))
+	}
+	if rule.Path != "" {
+		c.path = splitPath(rule.Path)
+		for _, segment := range c.path {
+			if _, err := path.Match(segment, ""); err != nil {
+				return c, fmt.Errorf("invalid path pattern %q: %w", rule.Path, err)
+			}
+		}
+	}
+	if rule.When != "" {
+		when, err := compileExpr(rule.When)
+		if err != nil {
+			return c, fmt.Errorf("invalid condition: %w", err)
+		}
+		c.when = when
+	}
+	return c, nil
+}
+
+// Resolve returns the terms of the first rule matching the request, falling back to the defaults.
+func (t *Table) Resolve(r *http.Request) (Terms, error) {
+	e := &env{r: r, now: t.now().In(t.location)}
+	if signer, ok := r.Context().Value(x402.SignerContextKey).(common.Address); ok {
+		e.signer = strings.ToLower(signer.Hex())
+	}
+
+	for _, rule := range t.rules {
+		if rule.matches(e) {
+			return rule.terms, nil
+		}
+	}
+	if t.defaults.Price != "" {
+		return t.defaults, nil
+	}
+	return Terms{}, ErrNoMatch
+}
+
+// PriceResolver adapts the table for x402.Config.
+func (t *Table) PriceResolver() x402.PriceResolver {
+	return func(r *http.Request) (string, string, string, error) {
+		terms, err := t.Resolve(r)
+		return terms.Price, terms.Asset, terms.Recipient, err
+	}
+}
+
+// OptionsResolver offers the gateway's payment options priced by the matching rule. A rule
+// with a chainId only offers the options on that chain. Amounts, assets and recipients the
+// options leave empty come from the rule.
+func (t *Table) OptionsResolver(offered []x402.PaymentOption) x402.OptionsResolver {
+	return func(r *http.Request) ([]x402.PaymentOption, error) {
+		terms, err := t.Resolve(r)
+		if err != nil {
+			return nil, err
+		}
+		return terms.offer(offered)
+	}
+}
+
+// offer prices the offered options with the terms.
+func (terms Terms) offer(offered []x402.PaymentOption) ([]x402.PaymentOption, error) {
+	var options []x402.PaymentOption
+	for _, opt := range offered {
+		if terms.ChainID != 0 && (opt.ChainID == nil || !opt.ChainID.IsUint64() || opt.ChainID.Uint64() != terms.ChainID) {
+			continue
+		}
+		if opt.Amount == "" {
+			opt.Amount = terms.Price
+		}
+		if opt.Asset == "" {
+			opt.Asset = terms.Asset
+		}
+		if opt.Recipient == "" {
+			opt.Recipient = terms.Recipient
+		}
+		options = append(options, opt.WithChainDefaults())
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("chain %d is priced but not offered", terms.ChainID)
+	}
+	return options, nil
+}
+
+// CheckOffered reports a chain the rules price for explicitly that none of the offered
+// options is on, since requests matching such a rule could not be quoted.
+func (t *Table) CheckOffered(offered []x402.PaymentOption) error {
+	for _, id := range t.ChainIDs() {
+		found := false
+		for _, opt := range offered {
+			if opt.ChainID != nil && opt.ChainID.IsUint64() && opt.ChainID.Uint64() == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("chain %d is priced but not offered", id)
+		}
+	}
+	return nil
+}
+
+// ChainIDs lists the chains rules price for explicitly.
+func (t *Table) ChainIDs() []uint64 {
+	seen := make(map[uint64]bool)
+	var ids []uint64
+	for _, terms := range append([]Terms{t.defaults}, t.ruleTerms()...) {
+		if terms.ChainID != 0 && !seen[terms.ChainID] {
+			seen[terms.ChainID] = true
+			ids = append(ids, terms.ChainID)
+		}
+	}
+	return ids
+}
+
+// Len returns the number of rules.
+func (t *Table) Len() int {
+	return len(t.rules)
+}
+
+func (t *Table) ruleTerms() []Terms {
+	terms := make([]Terms, len(t.rules))
+	for i, rule := range t.rules {
+		terms[i] = rule.terms
+	}
+	return terms
+}
+
+func (c compiledRule) matches(e *env) bool {
+	if c.host != "" {
+		if ok, _ := path.Match(c.host, requestHost(e.r)); !ok {
+			return false
+		}
+	}
+	if len(c.methods) > 0 && !contains(c.methods, e.r.Method) {
+		return false
+	}
+	if c.path != nil && !matchPath(c.path, splitPath(e.r.URL.Path)) {
+		return false
+	}
+	return c.when == nil || truthy(c.when(e))
+}
+
+// matchPath matches path segments against a pattern, where a trailing "**" matches any remainder.
+func matchPath(pattern, segments []string) bool {
+	for i, p := range pattern {
+		if p == "**" && i == len(pattern)-1 {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if ok, _ := path.Match(p, segments[i]); !ok {
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func inherit(terms, from Terms) Terms {
+	if terms.Price == "" {
+		terms.Price = from.Price
+	}
+	if terms.Asset == "" {
+		terms.Asset = from.Asset
+	}
+	if terms.Recipient == "" {
+		terms.Recipient = from.Recipient
+	}
+	if terms.ChainID == 0 {
+		terms.ChainID = from.ChainID
+	}
+	return terms
+}
+
+func validateTerms(terms Terms) error {
+	if price, ok := new(big.Int).SetString(terms.Price, 10); !ok || price.Sign() < 0 {
+		return fmt.Errorf("invalid price %q", terms.Price)
+	}
+	if !common.IsHexAddress(terms.Asset) {
+		return fmt.Errorf("invalid asset address %q", terms.Asset)
+	}
+	if !common.IsHexAddress(terms.Recipient) {
+		return fmt.Errorf("invalid recipient address %q", terms.Recipient)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+const testConfig = `{
+	"defaults": {"price": "100", "recipient": "0x0000000000000000000000000000000000000123"},
+	"rules": [
+		{"name": "partner", "when": "signer == '0x0000000000000000000000000000000000000abc'", "price": "10"},
+		{"name": "pro", "host": "*.example.com", "path": "/v1/**", "when": "header('X-Tier') == 'pro' && query('size') > 10", "price": "2000"},
+		{"name": "night", "methods": ["get"], "path": "/reports/*", "when": "hour >= 22 || hour < 6", "price": "50", "chainId": 8453},
+		{"name": "uploads", "methods": ["POST", "PUT"], "path": "/upload", "price": "500",
+		 "asset": "0x0000000000000000000000000000000000000789"}
+	]
+}`
+
+var base = Terms{Asset: "0x0000000000000000000000000000000000000456"}
+
+func TestTable_Resolve(t *testing.T) {
+	table, err := Parse([]byte(testConfig), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noon := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	table.now = func() time.Time { return noon }
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		header  string
+		signer  string
+		at      time.Time
+		price   string
+		asset   string
+		chainID uint64
+	}{
+		{name: "default", method: "GET", url: "http://api.example.com/", price: "100", asset: base.Asset},
+		{name: "header and query", method: "GET", url: "http://API.example.com:8080/v1/models/large?size=64", header: "pro", price: "2000"},
+		{name: "query below threshold", method: "GET", url: "http://api.example.com/v1/models?size=5", header: "pro", price: "100"},
+		{name: "other host", method: "GET", url: "http://api.other.com/v1/models?size=64", header: "pro", price: "100"},
+		{name: "signer", method: "GET", url: "http://api.example.com/v1/models", signer: "0x0000000000000000000000000000000000000ABC", price: "10"},
+		{name: "time of day", method: "GET", url: "http://x/reports/daily", at: time.Date(2026, 1, 5, 23, 0, 0, 0, time.UTC), price: "50", chainID: 8453},
+		{name: "outside hours", method: "GET", url: "http://x/reports/daily", price: "100"},
+		{name: "segment count", method: "GET", url: "http://x/reports/daily/csv", at: time.Date(2026, 1, 5, 23, 0, 0, 0, time.UTC), price: "100"},
+		{name: "method and asset", method: "PUT", url: "http://x/upload", price: "500", asset: "0x0000000000000000000000000000000000000789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table.now = func() time.Time { return noon }
+			if !tt.at.IsZero() {
+				table.now = func() time.Time { return tt.at }
+			}
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tier", tt.header)
+			}
+			if tt.signer != "" {
+				req = req.WithContext(context.WithValue(req.Context(), x402.SignerContextKey, common.HexToAddress(tt.signer)))
+			}
+
+			terms, err := table.Resolve(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if terms.Price != tt.price {
+				t.Errorf("expected price %s, got %s", tt.price, terms.Price)
+			}
+			if tt.asset != "" && terms.Asset != tt.asset {
+				t.Errorf("expected asset %s, got %s", tt.asset, terms.Asset)
+			}
+			if terms.ChainID != tt.chainID {
+				t.Errorf("expected chain %d, got %d", tt.chainID, terms.ChainID)
+			}
+		})
+	}
+}
+
+func TestTable_NoMatch(t *testing.T) {
+	table, err := Parse([]byte(`{"rules": [{"path": "/paid", "price": "1"}]}`), Terms{
+		Asset:     "0x0000000000000000000000000000000000000456",
+		Recipient: "0x0000000000000000000000000000000000000123",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Resolve(httptest.NewRequest("GET", "/free", nil)); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+}
+
+func TestTable_OptionsResolver(t *testing.T) {
+	table, err := Parse([]byte(testConfig), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.now = func() time.Time { return time.Date(2026, 1, 5, 23, 0, 0, 0, time.UTC) }
+	offered := []x402.PaymentOption{{ChainID: big.NewInt(84532)}, {ChainID: big.NewInt(8453)}}
+	resolve := table.OptionsResolver(offered)
+
+	// The night rule prices Base only.
+	options, err := resolve(httptest.NewRequest("GET", "/reports/daily", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 1 || options[0].ChainID.Uint64() != 8453 || options[0].Amount != "50" ||
+		options[0].Asset != base.Asset || options[0].Recipient != "0x0000000000000000000000000000000000000123" {
+		t.Errorf("expected a single Base option at 50, got %+v", options)
+	}
+
+	// Rules without a chain price every offered option.
+	options, err = resolve(httptest.NewRequest("GET", "/other", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 2 || options[0].Amount != "100" || options[1].Amount != "100" {
+		t.Errorf("expected both options at 100, got %+v", options)
+	}
+
+	if _, err := table.OptionsResolver(offered[:1])(httptest.NewRequest("GET", "/reports/daily", nil)); err == nil {
+		t.Error("expected a rule pricing a chain that is not offered to fail")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown identifier": `{"rules": [{"when": "tier == 'pro'", "price": "1"}]}`,
+		"unknown function":   `{"rules": [{"when": "cookie('a') == 'b'", "price": "1"}]}`,
+		"wrong arity":        `{"rules": [{"when": "header() == 'b'", "price": "1"}]}`,
+		"unbalanced":         `{"rules": [{"when": "(hour > 1", "price": "1"}]}`,
+		"trailing operator":  `{"rules": [{"when": "hour > 1 &&", "price": "1"}]}`,
+		"unterminated":       `{"rules": [{"when": "header('a) == 'b'", "price": "1"}]}`,
+		"bad price":          `{"rules": [{"price": "1.5"}]}`,
+		"missing recipient":  `{"defaults": {"recipient": ""}, "rules": [{"price": "1", "recipient": "nope"}]}`,
+		"bad pattern":        `{"rules": [{"path": "/[a", "price": "1"}]}`,
+		"bad timezone":       `{"timezone": "Mars/Olympus", "rules": []}`,
+	}
+	full := Terms{
+		Asset:     "0x0000000000000000000000000000000000000456",
+		Recipient: "0x0000000000000000000000000000000000000123",
+	}
+	for name, config := range tests {
+		if _, err := Parse([]byte(config), full); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWatcher_HotReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "pricing.json")
+	write := func(price string) {
+		config := `{"defaults": {"price": "` + price + `", "recipient": "0x0000000000000000000000000000000000000123"}}`
+		if err := os.WriteFile(filename, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("100")
+
+	w, err := Watch(filename, base, nil, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	resolve := w.PriceResolver()
+
+	price := func() string {
+		amount, _, _, err := resolve(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return amount
+	}
+	eventually := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for price() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected price %s, still %s", want, price())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	write("250")
+	eventually("250")
+
+	// A broken edit keeps the previous rules.
+	if err := os.WriteFile(filename, []byte(`{"defaults": {"price": "oops"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := price(); got != "250" {
+		t.Errorf("expected previous price 250 after a broken edit, got %s", got)
+	}
+
+	write("300")
+	eventually("300")
+}
+
+func TestWatcher_KeepsRulesPricingUnofferedChains(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "pricing.json")
+	write := func(chainID string) {
+		config := `{"defaults": {"price": "100", "recipient": "0x0000000000000000000000000000000000000123"},
+			"rules": [{"path": "/reports/*", "price": "50", "chainId": ` + chainID + `}]}`
+		if err := os.WriteFile(filename, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offered := []x402.PaymentOption{{ChainID: big.NewInt(8453)}}
+
+	write("84532")
+	if _, err := Watch(filename, base, offered, 10*time.Millisecond); err == nil {
+		t.Fatal("expected a file pricing a chain that is not offered to be refused")
+	}
+
+	write("8453")
+	w, err := Watch(filename, base, offered, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	write("84532")
+	if err := w.Reload(); err == nil {
+		t.Fatal("expected a reload pricing a chain that is not offered to fail")
+	}
+	options, err := w.OptionsResolver()(httptest.NewRequest("GET", "/reports/daily", nil))
+	if err != nil {
+		t.Fatalf("expected the previous rules to stay in force: %v", err)
+	}
+	if len(options) != 1 || options[0].Amount != "50" {
+		t.Errorf("expected the Base option at 50, got %+v", options)
+	}
+}
@@ -0,0 +1,123 @@
+package pricing
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// Watcher keeps a pricing table in sync with its file. An invalid edit, or one pricing a
+// chain that is not offered, is logged and the previous rules stay in force.
+type Watcher struct {
+	filename string
+	base     Terms
+	offered  []x402.PaymentOption
+	table    atomic.Pointer[Table]
+
+	mu      sync.Mutex // guards modTime and size
+	modTime time.Time
+	size    int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Watch loads a pricing file and polls it for changes every interval (default one second).
+// Rules may only price the chains of the offered options.
+func Watch(filename string, base Terms, offered []x402.PaymentOption, interval time.Duration) (*Watcher, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	w := &Watcher{filename: filename, base: base, offered: offered, stop: make(chan struct{})}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	go w.poll(interval)
+	return w, nil
+}
+
+// Table returns the rules currently in force.
+func (w *Watcher) Table() *Table {
+	return w.table.Load()
+}
+
+// PriceResolver resolves against whichever rules are in force when the request arrives.
+func (w *Watcher) PriceResolver() x402.PriceResolver {
+	return func(r *http.Request) (string, string, string, error) {
+		return w.Table().PriceResolver()(r)
+	}
+}
+
+// OptionsResolver prices the offered options against whichever rules are in force when the
+// request arrives.
+func (w *Watcher) OptionsResolver() x402.OptionsResolver {
+	return func(r *http.Request) ([]x402.PaymentOption, error) {
+		return w.Table().OptionsResolver(w.offered)(r)
+	}
+}
+
+// Reload re-reads the file, replacing the rules only if it compiles and prices only offered
+// chains.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload()
+}
+
+func (w *Watcher) reload() error {
+	info, err := os.Stat(w.filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat pricing file: %w", err)
+	}
+	table, err := LoadFile(w.filename, w.base)
+	if err != nil {
+		return err
+	}
+	if err := table.CheckOffered(w.offered); err != nil {
+		return err
+	}
+	w.table.Store(table)
+	w.modTime, w.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// Close stops polling.
+func (w *Watcher) Close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *Watcher) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.reloadIfChanged()
+		}
+	}
+}
+
+func (w *Watcher) reloadIfChanged() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.filename)
+	if err != nil || (info.ModTime().Equal(w.modTime) && info.Size() == w.size) {
+		return
+	}
+	if err := w.reload(); err != nil {
+		// Remember the broken version so it is reported once, not on every tick.
+		w.modTime, w.size = info.ModTime(), info.Size()
+		log.Printf("⚠️  pricing: Keeping previous rules, failed to reload %s: %v", w.filename, err)
+		return
+	}
+	log.Printf("🔄 pricing: Reloaded %d rules from %s", w.Table().Len(), w.filename)
+}
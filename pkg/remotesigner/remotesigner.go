@@ -0,0 +1,228 @@
+// Package remotesigner signs through an external JSON-RPC signer such as Clef or Web3Signer, so
+// private keys never enter the settler process.
+package remotesigner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// ErrAccountNotAllowed is returned for an account that is not on the allowlist.
+var ErrAccountNotAllowed = errors.New("account is not allowlisted")
+
+// DefaultTimeout bounds each request when Config.Timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// Config says which signer to talk to and which of its accounts the engine may use.
+type Config struct {
+	URL      string           // http(s):// or ws(s):// endpoint, or the path of a Unix socket
+	Accounts []common.Address // Accounts the engine may sign with
+	Timeout  time.Duration    // Per request; DefaultTimeout if zero
+}
+
+// Client calls a remote signer's account_signTransaction, account_signData and
+// eth_signTypedData_v4 methods.
+type Client struct {
+	rpc     *rpc.Client
+	allowed map[common.Address]bool
+	timeout time.Duration
+}
+
+// Dial connects to the signer at cfg.URL. A Unix socket is connected to immediately; an HTTP
+// endpoint on the first request.
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	if len(cfg.Accounts) == 0 {
+		return nil, errors.New("no remote signer accounts allowlisted")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c, err := rpc.DialContext(ctx, cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial remote signer: %w", err)
+	}
+
+	allowed := make(map[common.Address]bool, len(cfg.Accounts))
+	for _, addr := range cfg.Accounts {
+		allowed[addr] = true
+	}
+	return &Client{rpc: c, allowed: allowed, timeout: timeout}, nil
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() {
+	c.rpc.Close()
+}
+
+// Account returns a signer for an allowlisted account.
+func (c *Client) Account(addr common.Address) (*Account, error) {
+	if !c.allowed[addr] {
+		return nil, fmt.Errorf("%w: %s", ErrAccountNotAllowed, addr.Hex())
+	}
+	return &Account{client: c, address: addr}, nil
+}
+
+// FromEnv signs with the account in SETTLER_REMOTE_SIGNER_ACCOUNT, held by the signer at
+// SETTLER_REMOTE_SIGNER. Requests time out after SETTLER_REMOTE_SIGNER_TIMEOUT.
+func FromEnv(ctx context.Context) (*Account, error) {
+	account := os.Getenv("SETTLER_REMOTE_SIGNER_ACCOUNT")
+	if !common.IsHexAddress(account) {
+		return nil, errors.New("SETTLER_REMOTE_SIGNER_ACCOUNT must be the signing account address")
+	}
+	cfg := Config{
+		URL:      os.Getenv("SETTLER_REMOTE_SIGNER"),
+		Accounts: []common.Address{common.HexToAddress(account)},
+	}
+	if v := os.Getenv("SETTLER_REMOTE_SIGNER_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SETTLER_REMOTE_SIGNER_TIMEOUT: %w", err)
+		}
+		cfg.Timeout = timeout
+	}
+	client, err := Dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return client.Account(cfg.Accounts[0])
+}
+
+// call makes one request, bounded by the client timeout.
+func (c *Client) call(result any, method string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return c.rpc.CallContext(ctx, result, method, args...)
+}
+
+// Account signs with one account held by the remote signer. It implements crypto.Signer.
+// Every answer is checked to come from the account, so a misconfigured signer cannot slip in
+// another key or alter a transaction.
+type Account struct {
+	client  *Client
+	address common.Address
+}
+
+func (a *Account) Address() common.Address {
+	return a.address
+}
+
+// SignTx signs tx with account_signTransaction.
+func (a *Account) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args, err := a.txArgs(tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := a.client.call(&res, "account_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(res.Raw); err != nil {
+		return nil, fmt.Errorf("remote signer returned an invalid transaction: %w", err)
+	}
+	txSigner := types.LatestSignerForChainID(chainID)
+	if txSigner.Hash(signed) != txSigner.Hash(tx) {
+		return nil, errors.New("remote signer returned a different transaction")
+	}
+	sender, err := types.Sender(txSigner, signed)
+	if err != nil || sender != a.address {
+		return nil, fmt.Errorf("remote signer did not sign as %s", a.address.Hex())
+	}
+	return signed, nil
+}
+
+// SignText signs data as an EIP-191 personal message with account_signData.
+func (a *Account) SignText(data []byte) ([]byte, error) {
+	var sig hexutil.Bytes
+	if err := a.client.call(&sig, "account_signData", accounts.MimetypeTextPlain, common.NewMixedcaseAddress(a.address), hexutil.Bytes(data)); err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+	return a.checkSignature(accounts.TextHash(data), sig)
+}
+
+// SignTypedData signs EIP-712 typed data with eth_signTypedData_v4.
+func (a *Account) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash typed data: %w", err)
+	}
+	var sig hexutil.Bytes
+	if err := a.client.call(&sig, "eth_signTypedData_v4", a.address, typedData); err != nil {
+		return nil, fmt.Errorf("failed to sign typed data: %w", err)
+	}
+	return a.checkSignature(hash, sig)
+}
+
+// checkSignature normalizes V to 0 or 1 and checks that sig over hash recovers to the account.
+func (a *Account) checkSignature(hash []byte, sig []byte) ([]byte, error) {
+	if len(sig) != gethcrypto.SignatureLength {
+		return nil, fmt.Errorf("remote signer returned a %d-byte signature", len(sig))
+	}
+	sig = append([]byte{}, sig...)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	pub, err := gethcrypto.SigToPub(hash, sig)
+	if err != nil || gethcrypto.PubkeyToAddress(*pub) != a.address {
+		return nil, fmt.Errorf("remote signer did not sign as %s", a.address.Hex())
+	}
+	return sig, nil
+}
+
+// txArgs encodes tx the way Clef's SendTxArgs expects it.
+func (a *Account) txArgs(tx *types.Transaction, chainID *big.Int) (*apitypes.SendTxArgs, error) {
+	data := hexutil.Bytes(tx.Data())
+	args := &apitypes.SendTxArgs{
+		From:  common.NewMixedcaseAddress(a.address),
+		Gas:   hexutil.Uint64(tx.Gas()),
+		Value: hexutil.Big(*tx.Value()),
+		Nonce: hexutil.Uint64(tx.Nonce()),
+		Input: &data,
+	}
+	if tx.To() != nil {
+		to := common.NewMixedcaseAddress(*tx.To())
+		args.To = &to
+	}
+	if chainID != nil {
+		args.ChainID = (*hexutil.Big)(chainID)
+	}
+
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.AccessListTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+		accessList := tx.AccessList()
+		args.AccessList = &accessList
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+		accessList := tx.AccessList()
+		args.AccessList = &accessList
+	default:
+		return nil, fmt.Errorf("remote signer does not support transaction type %d", tx.Type())
+	}
+	return args, nil
+}
+
+var _ crypto.Signer = (*Account)(nil)
@@ -0,0 +1,224 @@
+package remotesigner
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// stubSigner answers like Clef, signing everything with key after delay.
+type stubSigner struct {
+	key   *crypto.PrivateKeySigner
+	delay time.Duration
+}
+
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+// SignTransaction is account_signTransaction.
+func (s *stubSigner) SignTransaction(args apitypes.SendTxArgs) (*signTxResult, error) {
+	time.Sleep(s.delay)
+	tx, err := args.ToTransaction()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := s.key.SignTx(tx, args.ChainID.ToInt())
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	return &signTxResult{Raw: raw}, err
+}
+
+// SignData is account_signData. Like Clef, it answers with V of 27 or 28.
+func (s *stubSigner) SignData(contentType string, addr common.MixedcaseAddress, data hexutil.Bytes) (hexutil.Bytes, error) {
+	if contentType != accounts.MimetypeTextPlain {
+		return nil, errors.New("unsupported content type")
+	}
+	sig, err := s.key.SignText(data)
+	if err != nil {
+		return nil, err
+	}
+	sig[64] += 27
+	return sig, nil
+}
+
+// stubEth answers eth_signTypedData_v4.
+type stubEth struct {
+	key *crypto.PrivateKeySigner
+}
+
+func (s *stubEth) SignTypedData_v4(addr common.Address, typedData apitypes.TypedData) (hexutil.Bytes, error) {
+	return s.key.SignTypedData(typedData)
+}
+
+func newStub(t *testing.T, stub *stubSigner) *rpc.Server {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("account", stub); err != nil {
+		t.Fatalf("register account: %v", err)
+	}
+	if err := srv.RegisterName("eth", &stubEth{key: stub.key}); err != nil {
+		t.Fatalf("register eth: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	return srv
+}
+
+// serveHTTP starts the stub over HTTP and returns its URL.
+func serveHTTP(t *testing.T, stub *stubSigner) string {
+	httpSrv := httptest.NewServer(newStub(t, stub))
+	t.Cleanup(httpSrv.Close)
+	return httpSrv.URL
+}
+
+func dial(t *testing.T, cfg Config) *Client {
+	c, err := Dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func testTypedData() apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {{Name: "name", Type: "string"}, {Name: "chainId", Type: "uint256"}},
+			"Harvest":      {{Name: "vault", Type: "address"}, {Name: "amount", Type: "uint256"}},
+		},
+		PrimaryType: "Harvest",
+		Domain:      apitypes.TypedDataDomain{Name: "SettlerEngine", ChainId: (*math.HexOrDecimal256)(big.NewInt(56))},
+		Message:     apitypes.TypedDataMessage{"vault": "0x7a11777a11777a11777a11777a11777a11777a11", "amount": "1000"},
+	}
+}
+
+func TestAccount_SignOverHTTP(t *testing.T) {
+	key := crypto.NewTestSigner("remote")
+	c := dial(t, Config{URL: serveHTTP(t, &stubSigner{key: key}), Accounts: []common.Address{key.Address()}})
+	account, err := c.Account(key.Address())
+	if err != nil {
+		t.Fatalf("account: %v", err)
+	}
+
+	// 1. Transactions
+	chainID := big.NewInt(56)
+	to := common.HexToAddress("0x7a11")
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID: chainID, Nonce: 7, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2),
+		Gas: 21_000, To: &to, Value: big.NewInt(5), Data: []byte{0x4e, 0x71, 0xd9, 0x2d},
+	})
+	signed, err := account.SignTx(tx, chainID)
+	if err != nil {
+		t.Fatalf("sign tx: %v", err)
+	}
+	if sender, _ := types.Sender(types.LatestSignerForChainID(chainID), signed); sender != key.Address() {
+		t.Errorf("transaction signed by %s", sender.Hex())
+	}
+
+	// 2. Typed data, identical to a local signature
+	sig, err := account.SignTypedData(testTypedData())
+	if err != nil {
+		t.Fatalf("sign typed data: %v", err)
+	}
+	if local, _ := key.SignTypedData(testTypedData()); hexutil.Encode(sig) != hexutil.Encode(local) {
+		t.Errorf("remote typed data signature %x, local %x", sig, local)
+	}
+
+	// 3. User operations, through the session key signer
+	signer := crypto.NewSessionKeySignerFrom(account, chainID)
+	op := &crypto.UserOperation{Sender: common.HexToAddress("0x4337"), Nonce: new(big.Int)}
+	ep := crypto.SmartAccount{}.EntryPoint()
+	if err := signer.SignUserOperation(op, ep); err != nil {
+		t.Fatalf("sign user operation: %v", err)
+	}
+	hash, _ := ep.UserOpHash(op, chainID)
+	sig = append([]byte{}, op.Signature...)
+	sig[64] -= 27
+	if pub, err := gethcrypto.SigToPub(accounts.TextHash(hash.Bytes()), sig); err != nil || gethcrypto.PubkeyToAddress(*pub) != key.Address() {
+		t.Error("user operation signature does not recover to the remote account")
+	}
+}
+
+func TestAccount_SignOverUnixSocket(t *testing.T) {
+	key := crypto.NewTestSigner("remote")
+	path := filepath.Join(t.TempDir(), "clef.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go newStub(t, &stubSigner{key: key}).ServeListener(l)
+
+	c := dial(t, Config{URL: path, Accounts: []common.Address{key.Address()}})
+	account, _ := c.Account(key.Address())
+	msg := []byte("settler")
+	sig, err := account.SignText(msg)
+	if err != nil {
+		t.Fatalf("sign text: %v", err)
+	}
+	if sig[64] > 1 {
+		t.Errorf("expected V of 0 or 1, got %d", sig[64])
+	}
+}
+
+func TestClient_Allowlist(t *testing.T) {
+	if _, err := Dial(context.Background(), Config{URL: "http://127.0.0.1:8550"}); err == nil {
+		t.Error("expected a client without allowlisted accounts to be refused")
+	}
+
+	key := crypto.NewTestSigner("remote")
+	c := dial(t, Config{URL: serveHTTP(t, &stubSigner{key: key}), Accounts: []common.Address{key.Address()}})
+	if _, err := c.Account(crypto.NewTestSigner("other").Address()); !errors.Is(err, ErrAccountNotAllowed) {
+		t.Errorf("expected ErrAccountNotAllowed, got %v", err)
+	}
+}
+
+func TestAccount_RejectsForeignSignatures(t *testing.T) {
+	// The signer holds a different key than the one the engine expects.
+	expected := crypto.NewTestSigner("expected")
+	c := dial(t, Config{
+		URL:      serveHTTP(t, &stubSigner{key: crypto.NewTestSigner("rogue")}),
+		Accounts: []common.Address{expected.Address()},
+	})
+	account, _ := c.Account(expected.Address())
+
+	to := common.HexToAddress("0x7a11")
+	tx := types.NewTx(&types.LegacyTx{Gas: 21_000, GasPrice: big.NewInt(1), To: &to})
+	if _, err := account.SignTx(tx, big.NewInt(56)); err == nil {
+		t.Error("expected a transaction signed by another key to be rejected")
+	}
+	if _, err := account.SignText([]byte("settler")); err == nil {
+		t.Error("expected a message signed by another key to be rejected")
+	}
+}
+
+func TestAccount_Timeout(t *testing.T) {
+	key := crypto.NewTestSigner("remote")
+	c := dial(t, Config{
+		URL:      serveHTTP(t, &stubSigner{key: key, delay: 200 * time.Millisecond}),
+		Accounts: []common.Address{key.Address()},
+		Timeout:  20 * time.Millisecond,
+	})
+	account, _ := c.Account(key.Address())
+
+	to := common.HexToAddress("0x7a11")
+	tx := types.NewTx(&types.LegacyTx{Gas: 21_000, GasPrice: big.NewInt(1), To: &to})
+	if _, err := account.SignTx(tx, big.NewInt(56)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+}
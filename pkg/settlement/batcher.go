@@ -0,0 +1,505 @@
+package settlement
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/nathfavour/settlerengine/core/domain/service"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// Publisher receives settlement events. *service.LocalBus implements it.
+type Publisher interface {
+	Publish(eventType string, data interface{})
+}
+
+// Result is published when a payment settles or gives up.
+type Result struct {
+	PaymentID string
+	ChainID   uint64
+	TxHash    string
+	Status    string // storage.SettlementConfirmed or storage.SettlementFailed
+	Error     string
+}
+
+// BatchSubmitted is published when a batch transaction is sent.
+type BatchSubmitted struct {
+	ChainID    uint64
+	TxHash     string
+	PaymentIDs []string
+}
+
+// BatchPolicy decides when queued payments are submitted.
+type BatchPolicy struct {
+	MaxSize     int           // Submit once this many payments are queued on a chain. Defaults to 50.
+	MaxValue    *big.Int      // Submit once payments queued on a chain add up to this many atomic units. Nil disables.
+	MaxWait     time.Duration // Submit once the oldest queued payment has waited this long. Defaults to 30 seconds.
+	MaxAttempts int           // Give up on a payment after this many failed submissions. Defaults to 3.
+}
+
+func (p BatchPolicy) normalize() BatchPolicy {
+	if p.MaxSize <= 0 {
+		p.MaxSize = 50
+	}
+	if p.MaxWait <= 0 {
+		p.MaxWait = 30 * time.Second
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	return p
+}
+
+// Batcher settles verified payments in batches: one Multicall3 aggregate3 transaction per
+// chain carries many transferWithAuthorization calls. Payments move through PENDING,
+// SUBMITTED and then CONFIRMED or FAILED in the settlements table.
+type Batcher struct {
+	clients *chains.MultiClient
+	senders *crypto.Senders
+	db      *storage.DB
+	journal *crypto.Journal
+	bus     Publisher
+	policy  BatchPolicy
+
+	// ReceiptTimeout bounds how long a batch transaction is awaited. Defaults to DefaultReceiptTimeout.
+	ReceiptTimeout time.Duration
+	// PollInterval controls how often thresholds are checked. Defaults to one second.
+	PollInterval time.Duration
+
+	flushMu  sync.Mutex
+	mu       sync.Mutex
+	queuedAt map[string]time.Time
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewBatcher creates a batcher sending from senders. bus may be nil.
+func NewBatcher(clients *chains.MultiClient, senders *crypto.Senders, db *storage.DB, bus Publisher, policy BatchPolicy) *Batcher {
+	return &Batcher{
+		clients:        clients,
+		senders:        senders,
+		db:             db,
+		journal:        crypto.NewJournal(db, clients),
+		bus:            bus,
+		policy:         policy.normalize(),
+		ReceiptTimeout: DefaultReceiptTimeout,
+		PollInterval:   time.Second,
+		queuedAt:       make(map[string]time.Time),
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Enqueue records a verified payment and queues it for the next batch. It returns false
+// if the payment was already queued.
+func (b *Batcher) Enqueue(p x402.ExactSettlement) (bool, error) {
+	id, err := chainID(p.Token.ChainID)
+	if err != nil {
+		return false, err
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+
+	auth := p.Authorization
+	if err := b.db.RecordPayment(p.PaymentID, auth.From, auth.Value, p.Token.VerifyingContract.Hex(), auth.Nonce); err != nil {
+		return false, fmt.Errorf("failed to record payment: %w", err)
+	}
+	queued, err := b.db.QueueSettlement(p.PaymentID, uint64(id), string(payload))
+	if err != nil || !queued {
+		return false, err
+	}
+
+	b.mu.Lock()
+	b.queuedAt[p.PaymentID] = time.Now()
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// Start resumes tracking batches submitted before a restart and starts submitting.
+func (b *Batcher) Start() error {
+	submitted, err := b.db.ListSettlements(storage.SettlementSubmitted, 0)
+	if err != nil {
+		return fmt.Errorf("failed to load submitted settlements: %w", err)
+	}
+	batches := make(map[string][]queued)
+	for _, row := range submitted {
+		if q, ok := b.decode(row); ok {
+			batches[row.TxHash] = append(batches[row.TxHash], q)
+		}
+	}
+	for txHash, items := range batches {
+		id, hash := chains.ChainID(items[0].ChainID), common.HexToHash(txHash)
+		b.wg.Add(1)
+		go b.track(hash, items, func(ctx context.Context) (*types.Receipt, error) {
+			receipt, err := b.clients.WaitReceipt(ctx, id, hash)
+			if err == nil {
+				b.journal.Settle(receipt)
+			}
+			return receipt, err
+		})
+	}
+
+	b.wg.Add(1)
+	go b.run()
+	return nil
+}
+
+// Close stops submitting and waits for in-flight batches to be tracked.
+func (b *Batcher) Close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	b.wg.Wait()
+}
+
+// Flush submits every queued payment now, regardless of thresholds.
+func (b *Batcher) Flush(ctx context.Context) error {
+	return b.flush(ctx, true)
+}
+
+func (b *Batcher) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.wake:
+		case <-ticker.C:
+		}
+		if err := b.flush(context.Background(), false); err != nil {
+			log.Printf("⚠️  settlement: Batch flush failed: %v", err)
+		}
+	}
+}
+
+// queued is a pending settlement decoded from its payload.
+type queued struct {
+	storage.QueuedSettlement
+	settlement x402.ExactSettlement
+	value      *big.Int
+}
+
+func (b *Batcher) decode(row storage.QueuedSettlement) (queued, bool) {
+	q := queued{QueuedSettlement: row}
+	err := json.Unmarshal([]byte(row.Payload), &q.settlement)
+	if err == nil {
+		var ok bool
+		if q.value, ok = new(big.Int).SetString(q.settlement.Authorization.Value, 10); !ok {
+			err = fmt.Errorf("invalid value %q", q.settlement.Authorization.Value)
+		}
+	}
+	if err != nil {
+		b.fail(q, fmt.Sprintf("invalid settlement payload: %v", err))
+		return q, false
+	}
+	return q, true
+}
+
+// flush submits, per chain, the queued payments whose batch is due.
+func (b *Batcher) flush(ctx context.Context, force bool) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	pending, err := b.db.ListSettlements(storage.SettlementPending, 0)
+	if err != nil {
+		return fmt.Errorf("failed to load pending settlements: %w", err)
+	}
+
+	now := time.Now()
+	byChain := make(map[uint64][]queued)
+	var order []uint64
+	for _, row := range pending {
+		// Payments queued before a restart count as queued now.
+		b.mu.Lock()
+		if _, ok := b.queuedAt[row.PaymentID]; !ok {
+			b.queuedAt[row.PaymentID] = now
+		}
+		b.mu.Unlock()
+
+		if q, ok := b.decode(row); ok {
+			if _, seen := byChain[row.ChainID]; !seen {
+				order = append(order, row.ChainID)
+			}
+			byChain[row.ChainID] = append(byChain[row.ChainID], q)
+		}
+	}
+
+	for _, id := range order {
+		items := byChain[id]
+		if !force && !b.due(items, now) {
+			continue
+		}
+		for len(items) > 0 {
+			n := min(len(items), b.policy.MaxSize)
+			b.submit(ctx, chains.ChainID(id), items[:n])
+			items = items[n:]
+		}
+	}
+	return nil
+}
+
+// due reports whether a chain's queue has hit a size, value or time threshold.
+func (b *Batcher) due(items []queued, now time.Time) bool {
+	if len(items) >= b.policy.MaxSize {
+		return true
+	}
+	total := new(big.Int)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range items {
+		total.Add(total, q.value)
+		if now.Sub(b.queuedAt[q.PaymentID]) >= b.policy.MaxWait {
+			return true
+		}
+	}
+	return b.policy.MaxValue != nil && total.Cmp(b.policy.MaxValue) >= 0
+}
+
+// submit sends one batch. Authorizations already used on-chain are not resubmitted; see settleUsed.
+func (b *Batcher) submit(ctx context.Context, id chains.ChainID, items []queued) {
+	client, err := b.clients.GetClient(id)
+	if err != nil {
+		b.retryAll(items, err.Error())
+		return
+	}
+
+	now := big.NewInt(time.Now().Unix())
+	var batch []queued
+	var calls []chains.Call3
+	for _, q := range items {
+		p := q.settlement
+		nonce, err := authorizationNonce(p.Authorization.Nonce)
+		if err != nil {
+			b.fail(q, err.Error())
+			continue
+		}
+		used, err := b.clients.AuthorizationState(ctx, id, p.Token.VerifyingContract, common.HexToAddress(p.Authorization.From), nonce)
+		if err != nil {
+			b.retry(q, err.Error())
+			continue
+		}
+		if used {
+			b.settleUsed(ctx, client, q)
+			continue
+		}
+		if validBefore, ok := new(big.Int).SetString(p.Authorization.ValidBefore, 10); !ok || validBefore.Cmp(now) <= 0 {
+			b.fail(q, "authorization expired before settlement")
+			continue
+		}
+		data, err := packTransfer(p)
+		if err != nil {
+			b.fail(q, err.Error())
+			continue
+		}
+		batch = append(batch, q)
+		calls = append(calls, chains.Call3{Target: p.Token.VerifyingContract, AllowFailure: true, CallData: data})
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	ids := make([]string, len(batch))
+	for i, q := range batch {
+		ids[i] = q.PaymentID
+	}
+	manager, tx, err := b.send(ctx, id, calls, ids)
+	if err != nil {
+		log.Printf("⚠️  settlement: Failed to submit batch of %d on chain %d: %v", len(batch), id, err)
+		b.retryAll(batch, err.Error())
+		return
+	}
+
+	if err := b.db.SubmitSettlements(ids, tx.Hash().Hex()); err != nil {
+		log.Printf("⚠️  settlement: Failed to record batch %s: %v", tx.Hash().Hex(), err)
+	}
+	b.publish(service.EventSettlementBatchSubmitted, BatchSubmitted{ChainID: uint64(id), TxHash: tx.Hash().Hex(), PaymentIDs: ids})
+	log.Printf("📦 settlement: Submitted %d payments on chain %d in %s", len(batch), id, tx.Hash().Hex())
+
+	b.wg.Add(1)
+	go b.track(tx.Hash(), batch, func(ctx context.Context) (*types.Receipt, error) {
+		return manager.WaitMined(ctx, tx)
+	})
+}
+
+// send submits a batch through the chain's manager, which journals it against the payments.
+func (b *Batcher) send(ctx context.Context, id chains.ChainID, calls []chains.Call3, paymentIDs []string) (*crypto.TransactionManager, *types.Transaction, error) {
+	chain, err := chains.GetChainConfig(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	manager, err := b.senders.Manager(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := chains.Multicall3ABI.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to pack batch: %w", err)
+	}
+	tx, err := manager.SendTransaction(ctx, crypto.Call{
+		Operation: crypto.OperationSettle,
+		Reference: strings.Join(paymentIDs, ","),
+		To:        chain.Multicall(),
+		Data:      data,
+	})
+	return manager, tx, err
+}
+
+// track waits for a batch receipt with the chain's confirmations on top, using wait. Each
+// payment is confirmed by the AuthorizationUsed event its token emits; payments without one
+// failed inside the batch and are retried. The receipt may be that of a replacement of hash.
+func (b *Batcher) track(hash common.Hash, items []queued, wait func(context.Context) (*types.Receipt, error)) {
+	defer b.wg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), b.ReceiptTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	receipt, err := wait(ctx)
+	switch {
+	case err != nil:
+		// The batch may still be mined; the next attempt checks authorizationState first.
+		b.retryAll(items, fmt.Sprintf("no receipt for %s: %v", hash.Hex(), err))
+		return
+	case receipt.Status != types.ReceiptStatusSuccessful:
+		b.retryAll(items, fmt.Sprintf("batch %s reverted", hash.Hex()))
+		return
+	}
+
+	used := authorizationsUsed(receipt)
+	for _, q := range items {
+		if used[authorizationKey(q.settlement)] {
+			b.confirm(q, receipt.TxHash.Hex())
+		} else {
+			b.retry(q, fmt.Sprintf("transfer failed in batch %s", receipt.TxHash.Hex()))
+		}
+	}
+}
+
+// settleUsed handles a payment whose authorization is already used on-chain. It is confirmed
+// only if the last batch submitted for it, whose receipt may have timed out, was mined and did
+// the transfer, once the chain's confirmations are on top of it. Otherwise the payer cancelled
+// the authorization or it was spent elsewhere.
+func (b *Batcher) settleUsed(ctx context.Context, client *ethclient.Client, q queued) {
+	if q.TxHash != "" {
+		receipt, err := client.TransactionReceipt(ctx, common.HexToHash(q.TxHash))
+		switch {
+		case err == nil:
+			if receipt.Status == types.ReceiptStatusSuccessful && authorizationsUsed(receipt)[authorizationKey(q.settlement)] {
+				chain, _ := chains.GetChainConfig(chains.ChainID(q.ChainID))
+				if _, final, err := chains.Final(ctx, client, receipt, chain.Confirmations); err != nil || !final {
+					return // Checked again on the next flush
+				}
+				b.confirm(q, q.TxHash)
+				return
+			}
+		case !errors.Is(err, ethereum.NotFound):
+			b.retry(q, fmt.Sprintf("failed to get receipt of %s: %v", q.TxHash, err))
+			return
+		}
+	}
+	b.fail(q, "authorization used or cancelled outside the batcher")
+}
+
+// authorizationsUsed returns the authorizations whose AuthorizationUsed event a receipt holds,
+// keyed like authorizationKey.
+func authorizationsUsed(receipt *types.Receipt) map[[3]common.Hash]bool {
+	event := chains.TokenABI.Events["AuthorizationUsed"].ID
+	used := make(map[[3]common.Hash]bool)
+	for _, l := range receipt.Logs {
+		if len(l.Topics) == 3 && l.Topics[0] == event {
+			used[[3]common.Hash{common.BytesToHash(l.Address.Bytes()), l.Topics[1], l.Topics[2]}] = true
+		}
+	}
+	return used
+}
+
+// authorizationKey identifies an authorization by token, authorizer and nonce.
+func authorizationKey(p x402.ExactSettlement) [3]common.Hash {
+	return [3]common.Hash{
+		common.BytesToHash(p.Token.VerifyingContract.Bytes()),
+		common.BytesToHash(common.HexToAddress(p.Authorization.From).Bytes()),
+		common.HexToHash(p.Authorization.Nonce),
+	}
+}
+
+func (b *Batcher) confirm(q queued, txHash string) {
+	if err := b.db.RecordSettlement(storage.SettlementRow{
+		PaymentID: q.PaymentID, ChainID: q.ChainID, TxHash: txHash, Status: storage.SettlementConfirmed,
+	}); err != nil {
+		log.Printf("⚠️  settlement: Failed to confirm %s: %v", q.PaymentID, err)
+	}
+	b.forget(q.PaymentID)
+	b.publish(service.EventPaymentSettled, Result{PaymentID: q.PaymentID, ChainID: q.ChainID, TxHash: txHash, Status: storage.SettlementConfirmed})
+}
+
+// retry returns a payment to the queue, or fails it once it has used up its attempts.
+func (b *Batcher) retry(q queued, reason string) {
+	if q.Attempts+1 >= b.policy.MaxAttempts {
+		b.fail(q, reason)
+		return
+	}
+	if err := b.db.RetrySettlement(q.PaymentID, reason); err != nil {
+		log.Printf("⚠️  settlement: Failed to requeue %s: %v", q.PaymentID, err)
+	}
+
+	// Wait out MaxWait again unless the batch fills up first.
+	b.mu.Lock()
+	b.queuedAt[q.PaymentID] = time.Now()
+	b.mu.Unlock()
+}
+
+func (b *Batcher) retryAll(items []queued, reason string) {
+	for _, q := range items {
+		b.retry(q, reason)
+	}
+}
+
+func (b *Batcher) fail(q queued, reason string) {
+	if err := b.db.UpdateSettlementStatus(q.PaymentID, storage.SettlementFailed, reason); err != nil {
+		log.Printf("⚠️  settlement: Failed to record failure of %s: %v", q.PaymentID, err)
+	}
+	b.forget(q.PaymentID)
+	b.publish(service.EventPaymentSettlementFailed, Result{PaymentID: q.PaymentID, ChainID: q.ChainID, TxHash: q.TxHash, Status: storage.SettlementFailed, Error: reason})
+	log.Printf("❌ settlement: Gave up on %s: %s", q.PaymentID, reason)
+}
+
+func (b *Batcher) forget(paymentID string) {
+	b.mu.Lock()
+	delete(b.queuedAt, paymentID)
+	b.mu.Unlock()
+}
+
+func (b *Batcher) publish(eventType string, data interface{}) {
+	if b.bus != nil {
+		b.bus.Publish(eventType, data)
+	}
+}
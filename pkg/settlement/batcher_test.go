@@ -0,0 +1,212 @@
+package settlement
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/core/domain/service"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// signSettlement signs an authorization of value to the test recipient.
+func signSettlement(t *testing.T, value string, nonce byte) x402.ExactSettlement {
+	agent, _ := ethcrypto.GenerateKey()
+	domain := crypto.TokenDomain{Name: "USD Coin", Version: "2", ChainID: big.NewInt(testChainID), VerifyingContract: testToken}
+	auth := crypto.TransferWithAuthorization{
+		From:        ethcrypto.PubkeyToAddress(agent.PublicKey).Hex(),
+		To:          "0x0000000000000000000000000000000000000123",
+		Value:       value,
+		ValidAfter:  "0",
+		ValidBefore: fmt.Sprint(time.Now().Add(time.Hour).Unix()),
+		Nonce:       hexutil.Encode(bytes.Repeat([]byte{nonce}, 32)),
+	}
+	sighash, err := crypto.HashTransferWithAuthorization(auth, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := ethcrypto.Sign(sighash, agent)
+	sig[64] += 27
+	return x402.ExactSettlement{PaymentID: hexutil.Encode(sig), Token: domain, Authorization: auth, Signature: hexutil.Encode(sig)}
+}
+
+func TestBatcher_SettlesInBatches(t *testing.T) {
+	_, sent := fakeToken(t, 1500)
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	key, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(key)), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	bus := service.NewLocalBus()
+	settled := bus.Subscribe(service.EventPaymentSettled)
+	failed := bus.Subscribe(service.EventPaymentSettlementFailed)
+	submitted := bus.Subscribe(service.EventSettlementBatchSubmitted)
+
+	batcher := NewBatcher(clients, crypto.NewSenders(clients, signer, db), db, bus, BatchPolicy{MaxSize: 3, MaxWait: time.Hour, MaxAttempts: 2})
+	batcher.PollInterval = 10 * time.Millisecond
+	if err := batcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer batcher.Close()
+
+	// The second payment exceeds the payer's balance, so its transfer fails inside the batch.
+	payments := []x402.ExactSettlement{signSettlement(t, "1000", 1), signSettlement(t, "2000", 2), signSettlement(t, "500", 3)}
+	for i, p := range payments {
+		if ok, err := batcher.Enqueue(p); err != nil || !ok {
+			t.Fatalf("failed to enqueue payment %d: %v", i, err)
+		}
+		if i < 2 {
+			if row, _ := db.LoadSettlement(p.PaymentID); row == nil || row.Status != storage.SettlementPending {
+				t.Fatalf("expected payment %d to be pending below the size threshold, got %+v", i, row)
+			}
+		}
+	}
+	if ok, _ := batcher.Enqueue(payments[0]); ok {
+		t.Error("expected a payment to be queued only once")
+	}
+
+	// Reaching MaxSize submits all three in one aggregate3 transaction.
+	tx := receive(t, sent)
+	if *tx.To() != common.HexToAddress(chains.Multicall3Address) {
+		t.Errorf("expected the batch to go to Multicall3, got %s", tx.To().Hex())
+	}
+	if batch := receive(t, submitted).Data.(BatchSubmitted); len(batch.PaymentIDs) != 3 || batch.TxHash != tx.Hash().Hex() {
+		t.Errorf("unexpected batch event %+v", batch)
+	}
+	for range 2 {
+		result := receive(t, settled).Data.(Result)
+		if result.TxHash != tx.Hash().Hex() || result.Status != storage.SettlementConfirmed {
+			t.Errorf("unexpected settlement result %+v", result)
+		}
+	}
+
+	// The failed transfer is retried, then given up on after MaxAttempts.
+	waitStatus(t, db, payments[1].PaymentID, storage.SettlementPending)
+	if err := batcher.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, sent)
+	result := receive(t, failed).Data.(Result)
+	if result.PaymentID != payments[1].PaymentID || result.Status != storage.SettlementFailed {
+		t.Errorf("unexpected failure result %+v", result)
+	}
+
+	for i, want := range []string{storage.SettlementConfirmed, storage.SettlementFailed, storage.SettlementConfirmed} {
+		if row, _ := db.LoadSettlement(payments[i].PaymentID); row == nil || row.Status != want {
+			t.Errorf("expected payment %d to be %s, got %+v", i, want, row)
+		}
+	}
+}
+
+func TestBatcher_FailsAuthorizationUsedElsewhere(t *testing.T) {
+	_, sent := fakeToken(t, 1500)
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	key, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(key)), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	bus := service.NewLocalBus()
+	settled := bus.Subscribe(service.EventPaymentSettled)
+	failed := bus.Subscribe(service.EventPaymentSettlementFailed)
+
+	// The payer's authorization is spent by someone else before the batch is submitted.
+	payment := signSettlement(t, "1000", 4)
+	data, err := packTransfer(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := clients.GetClient(testChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := ethcrypto.GenerateKey()
+	tx, _ := types.SignTx(types.NewTx(&types.LegacyTx{To: &testToken, Gas: 100_000, GasPrice: big.NewInt(1), Data: data}),
+		types.LatestSignerForChainID(big.NewInt(testChainID)), other)
+	if err := client.SendTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, sent)
+
+	batcher := NewBatcher(clients, crypto.NewSenders(clients, signer, db), db, bus, BatchPolicy{MaxSize: 1, MaxWait: time.Hour})
+	batcher.PollInterval = 10 * time.Millisecond
+	if err := batcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer batcher.Close()
+	if ok, err := batcher.Enqueue(payment); err != nil || !ok {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	select {
+	case result := <-failed:
+		if r := result.Data.(Result); r.Error != "authorization used or cancelled outside the batcher" {
+			t.Errorf("unexpected failure %+v", r)
+		}
+	case <-settled:
+		t.Fatal("expected a payment settled by another transaction not to be confirmed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting")
+	}
+	select {
+	case tx := <-sent:
+		t.Errorf("expected no batch to be submitted, got %s", tx.Hash().Hex())
+	default:
+	}
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting")
+	}
+	var zero T
+	return zero
+}
+
+func waitStatus(t *testing.T, db *storage.DB, paymentID, status string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		row, _ := db.LoadSettlement(paymentID)
+		if row != nil && row.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s, last state %+v", status, row)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
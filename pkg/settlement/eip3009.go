@@ -0,0 +1,219 @@
+// Package settlement moves the funds of accepted x402 payments on-chain.
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+// DefaultReceiptTimeout bounds how long a submitted settlement is tracked.
+const DefaultReceiptTimeout = 5 * time.Minute
+
+// EIP3009Settler settles "exact" payments by submitting the agent's signed
+// transferWithAuthorization to the token contract. The facilitator key pays the gas,
+// so the agent never needs native tokens.
+type EIP3009Settler struct {
+	clients *chains.MultiClient
+	senders *crypto.Senders
+	db      *storage.DB
+
+	// ReceiptTimeout bounds how long a submitted transaction is awaited. Defaults to DefaultReceiptTimeout.
+	ReceiptTimeout time.Duration
+}
+
+// NewEIP3009Settler creates a settler. db may be nil, in which case settlements are not recorded.
+// senders may be nil for a settler that only verifies; share them with anything else sending
+// from the facilitator key, such as a Batcher, so nonces are not reused.
+func NewEIP3009Settler(clients *chains.MultiClient, senders *crypto.Senders, db *storage.DB) *EIP3009Settler {
+	return &EIP3009Settler{
+		clients:        clients,
+		senders:        senders,
+		db:             db,
+		ReceiptTimeout: DefaultReceiptTimeout,
+	}
+}
+
+// Verify checks the authorization's signature and that it can still be executed: its
+// nonce is unused on-chain and the payer holds enough of the token.
+func (s *EIP3009Settler) Verify(ctx context.Context, p x402.ExactSettlement) (common.Address, error) {
+	payer, err := crypto.VerifyTransferWithAuthorization(p.Authorization, p.Signature, p.Token)
+	if err != nil {
+		return common.Address{}, &x402.PaymentError{Reason: x402.ReasonInvalidSignature, Err: err}
+	}
+
+	id, err := chainID(p.Token.ChainID)
+	if err != nil {
+		return common.Address{}, err
+	}
+	nonce, err := authorizationNonce(p.Authorization.Nonce)
+	if err != nil {
+		return common.Address{}, &x402.PaymentError{Reason: x402.ReasonMalformedPayment, Err: err}
+	}
+	value, ok := new(big.Int).SetString(p.Authorization.Value, 10)
+	if !ok {
+		return common.Address{}, &x402.PaymentError{Reason: x402.ReasonMalformedPayment, Err: fmt.Errorf("invalid value %q", p.Authorization.Value)}
+	}
+
+	used, err := s.clients.AuthorizationState(ctx, id, p.Token.VerifyingContract, payer, nonce)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to read authorization state: %w", err)
+	}
+	if used {
+		return common.Address{}, &x402.PaymentError{Reason: x402.ReasonNonceUsed, Err: fmt.Errorf("authorization %s was already used on-chain", p.Authorization.Nonce)}
+	}
+
+	balance, err := s.clients.TokenBalance(ctx, id, p.Token.VerifyingContract, payer)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to read balance: %w", err)
+	}
+	if balance.Cmp(value) < 0 {
+		return common.Address{}, &x402.PaymentError{Reason: x402.ReasonInsufficientFunds, Err: fmt.Errorf("balance %s is below %s", balance, value)}
+	}
+	return payer, nil
+}
+
+// Settle verifies the authorization, then submits it.
+func (s *EIP3009Settler) Settle(ctx context.Context, p x402.ExactSettlement) (string, error) {
+	if _, err := s.Verify(ctx, p); err != nil {
+		return "", err
+	}
+	return s.Submit(ctx, p)
+}
+
+// Submit sends an authorization that has already been verified on-chain and records the
+// transaction against the payment. Confirmation is tracked in the background.
+func (s *EIP3009Settler) Submit(ctx context.Context, p x402.ExactSettlement) (string, error) {
+	if s.senders == nil {
+		return "", fmt.Errorf("no settlement key configured")
+	}
+	id, err := chainID(p.Token.ChainID)
+	if err != nil {
+		return "", err
+	}
+	manager, err := s.senders.Manager(id)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.submit(ctx, manager, p)
+	var maybeSent *crypto.MaybeSentError
+	switch {
+	case errors.As(err, &maybeSent):
+		// The node may have the transaction, so it is followed like a submitted one and the
+		// authorization is not released.
+		s.record(storage.SettlementRow{PaymentID: p.PaymentID, ChainID: uint64(id), TxHash: maybeSent.Tx.Hash().Hex(), Status: storage.SettlementSubmitted})
+		go s.track(manager, id, p.PaymentID, maybeSent.Tx)
+		return "", &x402.PaymentError{Reason: x402.ReasonSettlementPending, Err: err}
+	case err != nil:
+		s.record(storage.SettlementRow{PaymentID: p.PaymentID, ChainID: uint64(id), Status: storage.SettlementFailed, Error: err.Error()})
+		return "", err
+	}
+	s.record(storage.SettlementRow{PaymentID: p.PaymentID, ChainID: uint64(id), TxHash: tx.Hash().Hex(), Status: storage.SettlementSubmitted})
+
+	go s.track(manager, id, p.PaymentID, tx)
+	return tx.Hash().Hex(), nil
+}
+
+// submit sends the transferWithAuthorization call. The manager journals the transaction
+// against the payment.
+func (s *EIP3009Settler) submit(ctx context.Context, manager *crypto.TransactionManager, p x402.ExactSettlement) (*types.Transaction, error) {
+	data, err := packTransfer(p)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := manager.SendTransaction(ctx, crypto.Call{
+		Operation: crypto.OperationSettle,
+		Reference: p.PaymentID,
+		To:        p.Token.VerifyingContract,
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transferWithAuthorization: %w", err)
+	}
+	return tx, nil
+}
+
+// packTransfer encodes the transferWithAuthorization call that settles a payment.
+func packTransfer(p x402.ExactSettlement) ([]byte, error) {
+	auth := p.Authorization
+	sig, err := crypto.CanonicalSignature(p.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization signature: %w", err)
+	}
+	v := sig[64]
+	var r, ss [32]byte
+	copy(r[:], sig[:32])
+	copy(ss[:], sig[32:64])
+
+	nonce, err := authorizationNonce(auth.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := new(big.Int).SetString(auth.Value, 10)
+	validAfter, ok1 := new(big.Int).SetString(auth.ValidAfter, 10)
+	validBefore, ok2 := new(big.Int).SetString(auth.ValidBefore, 10)
+	if !ok || !ok1 || !ok2 {
+		return nil, fmt.Errorf("invalid authorization amounts")
+	}
+	return chains.TokenABI.Pack("transferWithAuthorization",
+		common.HexToAddress(auth.From), common.HexToAddress(auth.To), value, validAfter, validBefore, nonce, v, r, ss)
+}
+
+// track waits for the settlement receipt, with the chain's confirmations on top, and records
+// the outcome. The receipt may be that of a replacement the manager sent to speed it up.
+func (s *EIP3009Settler) track(manager *crypto.TransactionManager, id chains.ChainID, paymentID string, tx *types.Transaction) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.ReceiptTimeout)
+	defer cancel()
+
+	receipt, err := manager.WaitMined(ctx, tx)
+	switch {
+	case err != nil:
+		log.Printf("⚠️  settlement: Gave up waiting for %s: %v", tx.Hash().Hex(), err)
+	case receipt.Status == types.ReceiptStatusSuccessful:
+		s.record(storage.SettlementRow{PaymentID: paymentID, ChainID: uint64(id), TxHash: receipt.TxHash.Hex(), Status: storage.SettlementConfirmed})
+		log.Printf("✅ settlement: %s confirmed in block %s", receipt.TxHash.Hex(), receipt.BlockNumber)
+	default:
+		s.record(storage.SettlementRow{PaymentID: paymentID, ChainID: uint64(id), TxHash: receipt.TxHash.Hex(), Status: storage.SettlementFailed, Error: "transaction reverted"})
+		log.Printf("❌ settlement: %s reverted", receipt.TxHash.Hex())
+	}
+}
+
+func (s *EIP3009Settler) record(row storage.SettlementRow) {
+	if s.db == nil {
+		return
+	}
+	if err := s.db.RecordSettlement(row); err != nil {
+		log.Printf("⚠️  settlement: Failed to record settlement of %s: %v", row.PaymentID, err)
+	}
+}
+
+func chainID(id *big.Int) (chains.ChainID, error) {
+	if id == nil || !id.IsUint64() {
+		return 0, fmt.Errorf("invalid chain ID %v", id)
+	}
+	return chains.ChainID(id.Uint64()), nil
+}
+
+func authorizationNonce(s string) ([32]byte, error) {
+	var nonce [32]byte
+	b, err := hexutil.Decode(s)
+	if err != nil || len(b) != 32 {
+		return nonce, fmt.Errorf("authorization nonce must be 32 bytes of hex")
+	}
+	copy(nonce[:], b)
+	return nonce, nil
+}
+
+var _ x402.Settler = (*EIP3009Settler)(nil)
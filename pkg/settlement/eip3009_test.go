@@ -0,0 +1,287 @@
+package settlement
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+	"github.com/nathfavour/settlerengine/pkg/x402"
+)
+
+const testChainID = 31337
+
+var testToken = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+// fakeToken registers a test chain whose RPC endpoint serves testToken.
+func fakeToken(t *testing.T, balance int64) (*chaintest.Server, <-chan *types.Transaction) {
+	srv := chaintest.NewServer(testChainID)
+	t.Cleanup(srv.Close)
+	chains.RegisterChain(chains.ChainConfig{
+		Name:         "Test",
+		Network:      "test",
+		ChainID:      testChainID,
+		RPCURL:       srv.URL,
+		USDCAddress:  testToken.Hex(),
+		TokenDomains: map[string]chains.TokenDomain{"USDC": {Name: "USD Coin", Version: "2"}},
+	})
+	return srv, srv.ServeToken(big.NewInt(balance))
+}
+
+func TestEIP3009Settler_SettlesThroughMiddleware(t *testing.T) {
+	_, sent := fakeToken(t, 5000)
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	facilitator, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(facilitator)), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+	settler := NewEIP3009Settler(clients, crypto.NewSenders(clients, signer, db), db)
+
+	mw := x402.NewMiddleware(x402.Config{
+		DomainParams: crypto.DomainParams{ChainID: big.NewInt(testChainID)},
+		NonceExpiry:  time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Asset:        testToken.Hex(),
+		Amount:       "1000",
+		DB:           db,
+		Settler:      settler,
+	})
+	t.Cleanup(mw.Close)
+
+	agent, _ := ethcrypto.GenerateKey()
+	pay := func(value string) *httptest.ResponseRecorder {
+		auth := crypto.TransferWithAuthorization{
+			From:        ethcrypto.PubkeyToAddress(agent.PublicKey).Hex(),
+			To:          "0x0000000000000000000000000000000000000123",
+			Value:       value,
+			ValidAfter:  "0",
+			ValidBefore: fmt.Sprint(time.Now().Add(time.Minute).Unix()),
+			Nonce:       hexutil.Encode(bytes.Repeat([]byte{byte(len(value))}, 32)),
+		}
+		sighash, _ := crypto.HashTransferWithAuthorization(auth, crypto.TokenDomain{
+			Name: "USD Coin", Version: "2", ChainID: big.NewInt(testChainID), VerifyingContract: testToken,
+		})
+		sig, _ := ethcrypto.Sign(sighash, agent)
+		sig[64] += 27
+		header, _ := x402.EncodeSpecPayment(x402.SpecPayment{
+			X402Version: 1,
+			Scheme:      x402.SchemeExact,
+			Network:     "test",
+			Payload:     x402.ExactPayload{Signature: hexutil.Encode(sig), Authorization: auth},
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-PAYMENT", header)
+		rr := httptest.NewRecorder()
+		mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The agent cannot cover a payment larger than its balance.
+	rr := pay("10000")
+	var resp x402.ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusPaymentRequired || resp.Error != x402.ReasonInsufficientFunds {
+		t.Fatalf("expected 402 %s, got %d %s", x402.ReasonInsufficientFunds, rr.Code, rr.Body.String())
+	}
+
+	rr = pay("1000")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	tx := <-sent
+	if *tx.To() != testToken || tx.ChainId().Uint64() != testChainID {
+		t.Errorf("expected a transaction to the token on chain %d, got %s on %s", testChainID, tx.To().Hex(), tx.ChainId())
+	}
+	method, err := chains.TokenABI.MethodById(tx.Data()[:4])
+	if err != nil || method.Name != "transferWithAuthorization" {
+		t.Errorf("expected transferWithAuthorization, got %v (%v)", method, err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(rr.Header().Get(x402.HeaderPaymentResponse))
+	var settlement x402.SettlementResponse
+	json.Unmarshal(raw, &settlement)
+	if settlement.Transaction != tx.Hash().Hex() {
+		t.Errorf("expected transaction %s in X-PAYMENT-RESPONSE, got %q", tx.Hash().Hex(), settlement.Transaction)
+	}
+
+	// The settlement is recorded against the payment and confirmed in the background.
+	var paymentID string
+	db.QueryRow(`SELECT signature FROM verified_payments WHERE amount = '1000'`).Scan(&paymentID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		row, err := db.LoadSettlement(paymentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row != nil && row.Status == storage.SettlementConfirmed {
+			if row.TxHash != tx.Hash().Hex() || row.ChainID != testChainID {
+				t.Errorf("unexpected settlement record: %+v", row)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("settlement was not confirmed, last state %+v", row)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The transaction is journaled with its purpose and receipt.
+	journaled, err := db.ListTransactions(testChainID, signer.Address().Hex(), storage.TransactionConfirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journaled) != 1 || journaled[0].Purpose != crypto.OperationSettle || journaled[0].Reference != paymentID || journaled[0].Receipt == "" {
+		t.Errorf("unexpected journal entries: %+v", journaled)
+	}
+}
+
+func TestEIP3009Settler_WaitsForConfirmations(t *testing.T) {
+	srv, sent := fakeToken(t, 5000)
+	chain, _ := chains.GetChainConfig(testChainID)
+	chain.Confirmations, chain.BlockTime = 2, 5*time.Millisecond
+	chains.RegisterChain(chain)
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	facilitator, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(facilitator)), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	// The transfer is mined in block 2 while the head is block 1.
+	payment := signSettlement(t, "1000", 9)
+	if _, err := NewEIP3009Settler(clients, crypto.NewSenders(clients, signer, db), db).Settle(context.Background(), payment); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, sent)
+	time.Sleep(50 * time.Millisecond)
+	if row, _ := db.LoadSettlement(payment.PaymentID); row == nil || row.Status != storage.SettlementSubmitted {
+		t.Fatalf("expected the settlement to wait for confirmations, got %+v", row)
+	}
+
+	srv.Handle("eth_blockNumber", chaintest.Result("0x4"))
+	waitStatus(t, db, payment.PaymentID, storage.SettlementConfirmed)
+}
+
+func TestEIP3009Settler_SharesNoncesWithBatcher(t *testing.T) {
+	_, sent := fakeToken(t, 5000)
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	facilitator, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(facilitator)), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	// The node reports the same pending nonce to both, so only the shared manager keeps them apart.
+	senders := crypto.NewSenders(clients, signer, db)
+	settler := NewEIP3009Settler(clients, senders, db)
+	batcher := NewBatcher(clients, senders, db, nil, BatchPolicy{MaxWait: time.Hour})
+	defer batcher.Close()
+	if ok, err := batcher.Enqueue(signSettlement(t, "1000", 1)); err != nil || !ok {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := settler.Settle(context.Background(), signSettlement(t, "1000", 2))
+		errs <- err
+	}()
+	go func() {
+		defer wg.Done()
+		errs <- batcher.Flush(context.Background())
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, second := receive(t, sent), receive(t, sent)
+	if first.Nonce() == second.Nonce() {
+		t.Errorf("expected distinct nonces, both settlements used %d", first.Nonce())
+	}
+}
+
+func TestEIP3009Settler_KeepsSettlementsThatMayHaveBeenSent(t *testing.T) {
+	srv, _ := fakeToken(t, 5000)
+
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	facilitator, _ := ethcrypto.GenerateKey()
+	signer, err := crypto.NewSessionKeySigner(common.Bytes2Hex(ethcrypto.FromECDSA(facilitator)), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	// The node accepts the transfer but answers too late; it is then mined.
+	srv.Handle("eth_sendRawTransaction", func(params []json.RawMessage) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return nil, nil
+	})
+	payment := signSettlement(t, "1000", 7)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewEIP3009Settler(clients, crypto.NewSenders(clients, signer, db), db).Settle(ctx, payment)
+	var rejection *x402.PaymentError
+	if !errors.As(err, &rejection) || rejection.Reason != x402.ReasonSettlementPending {
+		t.Fatalf("expected %s, got %v", x402.ReasonSettlementPending, err)
+	}
+
+	// It is followed like a submitted settlement.
+	waitStatus(t, db, payment.PaymentID, storage.SettlementConfirmed)
+	if row, _ := db.LoadSettlement(payment.PaymentID); row.TxHash == "" {
+		t.Errorf("expected the settlement to keep its transaction, got %+v", row)
+	}
+}
@@ -3,10 +3,12 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/nathfavour/settlerengine/core/domain/model"
@@ -17,6 +19,8 @@ import (
 type DB struct {
 	*sql.DB
 	DataDir string
+
+	creditMu sync.Mutex // Serializes balance read-modify-write cycles
 }
 
 func OpenDefault() (*DB, error) {
@@ -25,13 +29,19 @@ func OpenDefault() (*DB, error) {
 		return nil, fmt.Errorf("failed to get user config dir: %w", err)
 	}
 
-	dataDir := filepath.Join(configDir, "settlerengine")
+	return Open(filepath.Join(configDir, "settlerengine"))
+}
+
+// Open opens (and migrates) the settler database inside dataDir, creating the directory if needed.
+func Open(dataDir string) (*DB, error) {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data dir: %w", err)
 	}
 
+	// WAL and a busy timeout let background writers (sweepers, settlement trackers)
+	// run alongside request handling without SQLITE_BUSY errors.
 	dbPath := filepath.Join(dataDir, "settler.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
 	}
@@ -67,8 +77,125 @@ func (db *DB) migrate() error {
 		created_at DATETIME NOT NULL,
 		expires_at DATETIME NOT NULL
 	);
+
+	CREATE TABLE IF NOT EXISTS x402_nonces (
+		nonce TEXT PRIMARY KEY,
+		challenge TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		consumed_at INTEGER
+	);
+
+	CREATE TABLE IF NOT EXISTS payment_usage (
+		payment_id TEXT PRIMARY KEY,
+		signer TEXT NOT NULL,
+		resource TEXT NOT NULL,
+		uses INTEGER NOT NULL DEFAULT 1,
+		max_uses INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS credit_deposits (
+		deposit_id TEXT PRIMARY KEY,
+		signer TEXT NOT NULL,
+		chain_id INTEGER NOT NULL,
+		asset TEXT NOT NULL,
+		amount TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS credit_balances (
+		signer TEXT NOT NULL,
+		chain_id INTEGER NOT NULL,
+		asset TEXT NOT NULL,
+		balance TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (signer, chain_id, asset)
+	);
+
+	CREATE TABLE IF NOT EXISTS x402_spent_nonces (
+		key TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS settlements (
+		payment_id TEXT PRIMARY KEY,
+		chain_id INTEGER NOT NULL,
+		tx_hash TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS passkeys (
+		credential_id TEXT PRIMARY KEY,
+		agent TEXT NOT NULL,
+		public_key TEXT NOT NULL,
+		rp_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS session_key_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		signer TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		chain_id INTEGER NOT NULL DEFAULT 0,
+		target TEXT NOT NULL DEFAULT '',
+		selector TEXT NOT NULL DEFAULT '',
+		value TEXT NOT NULL DEFAULT '0',
+		allowed BOOLEAN NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS transactions (
+		hash TEXT PRIMARY KEY,
+		chain_id INTEGER NOT NULL,
+		sender TEXT NOT NULL,
+		nonce INTEGER NOT NULL,
+		raw TEXT NOT NULL,
+		status TEXT NOT NULL,
+		replaced_by TEXT NOT NULL DEFAULT '',
+		purpose TEXT NOT NULL DEFAULT '',
+		reference TEXT NOT NULL DEFAULT '',
+		receipt TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS transactions_sender ON transactions (chain_id, sender, status);
 	`
-	_, err := db.Exec(query)
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// Columns added to tables that existed before migrations were tracked.
+	if err := db.addColumn("verified_payments", "settlement_payload", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// addColumn adds a column to an existing table unless it is already there.
+func (db *DB) addColumn(table, column, definition string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
 	return err
 }
 
@@ -112,7 +239,8 @@ func (db *DB) UpdateStatus(ctx context.Context, id string, status model.InvoiceS
 }
 
 func (db *DB) RecordPayment(signature, signer, amount, asset, nonce string) error {
-	query := `INSERT OR REPLACE INTO verified_payments (signature, signer, amount, asset, nonce) VALUES (?, ?, ?, ?, ?)`
+	query := `INSERT INTO verified_payments (signature, signer, amount, asset, nonce) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(signature) DO UPDATE SET signer = excluded.signer, amount = excluded.amount, asset = excluded.asset, nonce = excluded.nonce`
 	_, err := db.Exec(query, signature, signer, amount, asset, nonce)
 	return err
 }
@@ -127,6 +255,615 @@ func (db *DB) CheckPayment(signature string) (string, error) {
 	return signer, err
 }
 
+// SaveNonce stores an issued x402 nonce with its encoded challenge.
+func (db *DB) SaveNonce(nonce string, challenge []byte, expiresAt time.Time) error {
+	query := `INSERT INTO x402_nonces (nonce, challenge, expires_at) VALUES (?, ?, ?)`
+	_, err := db.Exec(query, nonce, string(challenge), expiresAt.UnixMilli())
+	return err
+}
+
+// NonceRow is a persisted x402 nonce.
+type NonceRow struct {
+	Challenge []byte
+	ExpiresAt time.Time
+	Consumed  bool
+}
+
+// LoadNonce returns a stored nonce, or nil if it was never issued.
+func (db *DB) LoadNonce(nonce string) (*NonceRow, error) {
+	var challenge string
+	var expiresAt int64
+	var consumedAt sql.NullInt64
+	query := `SELECT challenge, expires_at, consumed_at FROM x402_nonces WHERE nonce = ?`
+	err := db.QueryRow(query, nonce).Scan(&challenge, &expiresAt, &consumedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &NonceRow{
+		Challenge: []byte(challenge),
+		ExpiresAt: time.UnixMilli(expiresAt),
+		Consumed:  consumedAt.Valid,
+	}, nil
+}
+
+// ConsumeNonce atomically marks an unexpired nonce as used. It reports false if the
+// nonce is unknown, expired or already consumed.
+func (db *DB) ConsumeNonce(nonce string, now time.Time) (bool, error) {
+	query := `UPDATE x402_nonces SET consumed_at = ? WHERE nonce = ? AND consumed_at IS NULL AND expires_at > ?`
+	res, err := db.Exec(query, now.UnixMilli(), nonce, now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteExpiredNonces removes nonces that expired before now.
+func (db *DB) DeleteExpiredNonces(now time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM x402_nonces WHERE expires_at <= ?`, now.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// MarkNonceSpent records a redeemed stateless nonce key. It reports false if the key
+// was already recorded.
+func (db *DB) MarkNonceSpent(key string, expiresAt time.Time) (bool, error) {
+	query := `INSERT OR IGNORE INTO x402_spent_nonces (key, expires_at) VALUES (?, ?)`
+	res, err := db.Exec(query, key, expiresAt.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UnmarkNonceSpent forgets a spent-set key.
+func (db *DB) UnmarkNonceSpent(key string) error {
+	_, err := db.Exec(`DELETE FROM x402_spent_nonces WHERE key = ?`, key)
+	return err
+}
+
+// IsNonceSpent reports whether a stateless nonce key has been redeemed.
+func (db *DB) IsNonceSpent(key string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(1) FROM x402_spent_nonces WHERE key = ?`, key).Scan(&n)
+	return n > 0, err
+}
+
+// DeleteExpiredSpentNonces removes spent-set entries that expired before now.
+func (db *DB) DeleteExpiredSpentNonces(now time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM x402_spent_nonces WHERE expires_at <= ?`, now.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PaymentUsageRow tracks how much of an accepted payment has been used.
+type PaymentUsageRow struct {
+	Signer    string
+	Resource  string
+	Uses      int
+	MaxUses   int
+	ExpiresAt time.Time
+}
+
+// CreatePaymentUsage starts usage accounting for a payment, counting the current request.
+// maxUses of 0 means unlimited; a zero expiresAt means the payment never expires.
+func (db *DB) CreatePaymentUsage(paymentID, signer, resource string, maxUses int, expiresAt time.Time) error {
+	var expires int64
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UnixMilli()
+	}
+	query := `INSERT OR REPLACE INTO payment_usage (payment_id, signer, resource, uses, max_uses, expires_at) VALUES (?, ?, ?, 1, ?, ?)`
+	_, err := db.Exec(query, paymentID, signer, resource, maxUses, expires)
+	return err
+}
+
+// LoadPaymentUsage returns the usage of a payment, or nil if none is tracked.
+func (db *DB) LoadPaymentUsage(paymentID string) (*PaymentUsageRow, error) {
+	var row PaymentUsageRow
+	var expires int64
+	query := `SELECT signer, resource, uses, max_uses, expires_at FROM payment_usage WHERE payment_id = ?`
+	err := db.QueryRow(query, paymentID).Scan(&row.Signer, &row.Resource, &row.Uses, &row.MaxUses, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expires > 0 {
+		row.ExpiresAt = time.UnixMilli(expires)
+	}
+	return &row, nil
+}
+
+// IncrementPaymentUsage atomically records one more use of a payment. It reports false
+// if the payment is exhausted or expired.
+func (db *DB) IncrementPaymentUsage(paymentID string, now time.Time) (bool, error) {
+	query := `UPDATE payment_usage SET uses = uses + 1
+		WHERE payment_id = ? AND (max_uses = 0 OR uses < max_uses) AND (expires_at = 0 OR expires_at > ?)`
+	res, err := db.Exec(query, paymentID, now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ErrInsufficientCredit is returned by DebitCredit when the balance cannot cover a charge.
+var ErrInsufficientCredit = errors.New("insufficient credit")
+
+// DepositSigner returns the signer a deposit was credited to, if it has been credited.
+func (db *DB) DepositSigner(depositID string) (string, bool, error) {
+	var signer string
+	err := db.QueryRow(`SELECT signer FROM credit_deposits WHERE deposit_id = ?`, depositID).Scan(&signer)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return signer, err == nil, err
+}
+
+// CreditDeposit adds a deposit to the signer's balance on a chain. It is idempotent per
+// depositID and reports false if the deposit was already credited.
+func (db *DB) CreditDeposit(depositID, signer string, chainID uint64, asset string, amount *big.Int) (bool, error) {
+	db.creditMu.Lock()
+	defer db.creditMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO credit_deposits (deposit_id, signer, chain_id, asset, amount) VALUES (?, ?, ?, ?, ?)`,
+		depositID, signer, chainID, asset, amount.String())
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	balance, err := creditBalance(tx, signer, chainID, asset)
+	if err != nil {
+		return false, err
+	}
+	if err := setCreditBalance(tx, signer, chainID, asset, balance.Add(balance, amount)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DebitCredit charges amount against the signer's balance on a chain and returns what is left.
+// It returns ErrInsufficientCredit, with the current balance, if the balance is too low.
+func (db *DB) DebitCredit(signer string, chainID uint64, asset string, amount *big.Int) (*big.Int, error) {
+	db.creditMu.Lock()
+	defer db.creditMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	balance, err := creditBalance(tx, signer, chainID, asset)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Cmp(amount) < 0 {
+		return balance, ErrInsufficientCredit
+	}
+	balance.Sub(balance, amount)
+	if err := setCreditBalance(tx, signer, chainID, asset, balance); err != nil {
+		return nil, err
+	}
+	return balance, tx.Commit()
+}
+
+// CreditBalance returns the signer's prepaid balance in asset on a chain.
+func (db *DB) CreditBalance(signer string, chainID uint64, asset string) (*big.Int, error) {
+	return creditBalance(db, signer, chainID, asset)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func creditBalance(q queryRower, signer string, chainID uint64, asset string) (*big.Int, error) {
+	var raw string
+	err := q.QueryRow(`SELECT balance FROM credit_balances WHERE signer = ? AND chain_id = ? AND asset = ?`, signer, chainID, asset).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return new(big.Int), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	balance, ok := new(big.Int).SetString(raw, 10)
+	if !ok {
+		return nil, fmt.Errorf("corrupt balance %q for %s", raw, signer)
+	}
+	return balance, nil
+}
+
+func setCreditBalance(tx *sql.Tx, signer string, chainID uint64, asset string, balance *big.Int) error {
+	query := `INSERT INTO credit_balances (signer, chain_id, asset, balance, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(signer, chain_id, asset) DO UPDATE SET balance = excluded.balance, updated_at = excluded.updated_at`
+	_, err := tx.Exec(query, signer, chainID, asset, balance.String())
+	return err
+}
+
+// Settlement statuses.
+const (
+	SettlementPending   = "PENDING"
+	SettlementSubmitted = "SUBMITTED"
+	SettlementConfirmed = "CONFIRMED"
+	SettlementFailed    = "FAILED"
+)
+
+// SettlementRow records the on-chain settlement of a verified payment.
+type SettlementRow struct {
+	PaymentID string
+	ChainID   uint64
+	TxHash    string
+	Status    string
+	Error     string
+	Attempts  int // Failed submissions so far
+}
+
+// QueuedSettlement is a settlement together with the payload of its verified payment.
+type QueuedSettlement struct {
+	SettlementRow
+	Payload string
+}
+
+// RecordSettlement creates the settlement record of a payment, or updates its transaction,
+// status and error. The attempts and creation time of an existing record are kept.
+func (db *DB) RecordSettlement(row SettlementRow) error {
+	query := `INSERT INTO settlements (payment_id, chain_id, tx_hash, status, error) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(payment_id) DO UPDATE SET chain_id = excluded.chain_id, tx_hash = excluded.tx_hash,
+		status = excluded.status, error = excluded.error, updated_at = CURRENT_TIMESTAMP`
+	_, err := db.Exec(query, row.PaymentID, row.ChainID, row.TxHash, row.Status, row.Error)
+	return err
+}
+
+// UpdateSettlementStatus moves a settlement to a new status.
+func (db *DB) UpdateSettlementStatus(paymentID, status, errMsg string) error {
+	query := `UPDATE settlements SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP WHERE payment_id = ?`
+	_, err := db.Exec(query, status, errMsg, paymentID)
+	return err
+}
+
+// LoadSettlement returns the settlement of a payment, or nil if it has none.
+func (db *DB) LoadSettlement(paymentID string) (*SettlementRow, error) {
+	row := SettlementRow{PaymentID: paymentID}
+	query := `SELECT chain_id, tx_hash, status, error, attempts FROM settlements WHERE payment_id = ?`
+	err := db.QueryRow(query, paymentID).Scan(&row.ChainID, &row.TxHash, &row.Status, &row.Error, &row.Attempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// QueueSettlement stores the settlement payload of a verified payment and queues it as
+// PENDING. It returns false if the payment already has a settlement.
+func (db *DB) QueueSettlement(paymentID string, chainID uint64, payload string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO settlements (payment_id, chain_id, status) VALUES (?, ?, ?)`, paymentID, chainID, SettlementPending)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	res, err = tx.Exec(`UPDATE verified_payments SET settlement_payload = ? WHERE signature = ?`, payload, paymentID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, fmt.Errorf("payment %s is not verified", paymentID)
+	}
+	return true, tx.Commit()
+}
+
+// ListSettlements returns settlements in a status with their payloads, oldest first.
+// A limit of zero returns all of them.
+func (db *DB) ListSettlements(status string, limit int) ([]QueuedSettlement, error) {
+	query := `SELECT s.payment_id, s.chain_id, s.tx_hash, s.status, s.error, s.attempts, v.settlement_payload
+		FROM settlements s JOIN verified_payments v ON v.signature = s.payment_id
+		WHERE s.status = ? ORDER BY s.created_at, s.rowid`
+	args := []interface{}{status}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []QueuedSettlement
+	for rows.Next() {
+		var q QueuedSettlement
+		if err := rows.Scan(&q.PaymentID, &q.ChainID, &q.TxHash, &q.Status, &q.Error, &q.Attempts, &q.Payload); err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+// SubmitSettlements marks settlements as SUBMITTED in one transaction.
+func (db *DB) SubmitSettlements(paymentIDs []string, txHash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE settlements SET status = ?, tx_hash = ?, error = '', updated_at = CURRENT_TIMESTAMP WHERE payment_id = ?`
+	for _, id := range paymentIDs {
+		if _, err := tx.Exec(query, SettlementSubmitted, txHash, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RetrySettlement counts a failed submission and returns the settlement to PENDING.
+func (db *DB) RetrySettlement(paymentID, errMsg string) error {
+	query := `UPDATE settlements SET status = ?, error = ?, attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP WHERE payment_id = ?`
+	_, err := db.Exec(query, SettlementPending, errMsg, paymentID)
+	return err
+}
+
+// PasskeyRow registers a WebAuthn credential as a signing key of an agent.
+type PasskeyRow struct {
+	CredentialID string
+	Agent        string
+	PublicKey    string // Hex uncompressed P-256 point
+	RPID         string // Relying party the credential is scoped to, if checked
+}
+
+// SavePasskey registers or replaces a passkey.
+func (db *DB) SavePasskey(row PasskeyRow) error {
+	query := `INSERT OR REPLACE INTO passkeys (credential_id, agent, public_key, rp_id) VALUES (?, ?, ?, ?)`
+	_, err := db.Exec(query, row.CredentialID, row.Agent, row.PublicKey, row.RPID)
+	return err
+}
+
+// LoadPasskey returns a passkey by credential ID, or nil if it is not registered.
+func (db *DB) LoadPasskey(credentialID string) (*PasskeyRow, error) {
+	row := PasskeyRow{CredentialID: credentialID}
+	query := `SELECT agent, public_key, rp_id FROM passkeys WHERE credential_id = ?`
+	err := db.QueryRow(query, credentialID).Scan(&row.Agent, &row.PublicKey, &row.RPID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// ListPasskeys returns the passkeys of an agent, or of every agent when agent is empty.
+func (db *DB) ListPasskeys(agent string) ([]PasskeyRow, error) {
+	query := `SELECT credential_id, agent, public_key, rp_id FROM passkeys WHERE ? = '' OR agent = ? ORDER BY created_at, rowid`
+	rows, err := db.Query(query, agent, agent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []PasskeyRow
+	for rows.Next() {
+		var row PasskeyRow
+		if err := rows.Scan(&row.CredentialID, &row.Agent, &row.PublicKey, &row.RPID); err != nil {
+			return nil, err
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// DeletePasskey removes a passkey. It reports false if it was not registered.
+func (db *DB) DeletePasskey(credentialID string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM passkeys WHERE credential_id = ?`, credentialID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// SessionDecisionRow records a session key policy allowing or refusing to sign.
+type SessionDecisionRow struct {
+	Signer    string
+	Kind      string // What was to be signed, e.g. "transaction" or "user_operation"
+	ChainID   uint64
+	Target    string
+	Selector  string // Hex function selector, empty for plain transfers
+	Value     string // Native value in wei
+	Allowed   bool
+	Reason    string // Why the request was refused
+	CreatedAt time.Time
+}
+
+// RecordSessionDecision appends a decision to the session key audit log.
+func (db *DB) RecordSessionDecision(row SessionDecisionRow) error {
+	query := `INSERT INTO session_key_audit (signer, kind, chain_id, target, selector, value, allowed, reason) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, row.Signer, row.Kind, row.ChainID, row.Target, row.Selector, row.Value, row.Allowed, row.Reason)
+	return err
+}
+
+// ListSessionDecisions returns the audit log of a signer, or of every signer when signer is
+// empty, newest first. A limit of zero returns all of it.
+func (db *DB) ListSessionDecisions(signer string, limit int) ([]SessionDecisionRow, error) {
+	query := `SELECT signer, kind, chain_id, target, selector, value, allowed, reason, created_at
+		FROM session_key_audit WHERE ? = '' OR signer = ? ORDER BY id DESC`
+	args := []interface{}{signer, signer}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []SessionDecisionRow
+	for rows.Next() {
+		var row SessionDecisionRow
+		if err := rows.Scan(&row.Signer, &row.Kind, &row.ChainID, &row.Target, &row.Selector, &row.Value, &row.Allowed, &row.Reason, &row.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// Transaction statuses.
+const (
+	TransactionPending   = "PENDING"
+	TransactionReplaced  = "REPLACED"
+	TransactionConfirmed = "CONFIRMED"
+	TransactionReverted  = "REVERTED"
+	TransactionDropped   = "DROPPED" // Never mined; the node refused it or another transaction used its nonce
+)
+
+// TransactionRow is an entry of the outgoing transaction journal. Every transaction the engine
+// sends is kept with its raw bytes until it is mined, so a restart can pick it up again.
+type TransactionRow struct {
+	Hash       string
+	ChainID    uint64
+	Sender     string
+	Nonce      uint64
+	Raw        string // Hex signed transaction, to rebroadcast it
+	Status     string
+	ReplacedBy string // Hash of the transaction that replaced this one
+	Purpose    string // e.g. "harvest", "deposit", "settle" or "refund"
+	Reference  string // Invoice, payment or strategy the transaction was sent for
+	Receipt    string // JSON receipt once mined
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// RecordTransaction stores a transaction about to be sent. The same transaction sent again, after
+// the node refused it, takes the new status, purpose and reference.
+func (db *DB) RecordTransaction(row TransactionRow) error {
+	query := `INSERT INTO transactions (hash, chain_id, sender, nonce, raw, status, purpose, reference) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hash) DO UPDATE SET status = excluded.status, purpose = excluded.purpose,
+		reference = excluded.reference, updated_at = CURRENT_TIMESTAMP`
+	_, err := db.Exec(query, row.Hash, row.ChainID, row.Sender, row.Nonce, row.Raw, row.Status, row.Purpose, row.Reference)
+	return err
+}
+
+// ReplaceTransaction marks a transaction as REPLACED by row and stores row, in one transaction.
+// The replacement keeps the purpose and reference of the transaction it replaces.
+func (db *DB) ReplaceTransaction(hash string, row TransactionRow) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE transactions SET status = ?, replaced_by = ?, updated_at = CURRENT_TIMESTAMP WHERE hash = ?`
+	if _, err := tx.Exec(query, TransactionReplaced, row.Hash, hash); err != nil {
+		return err
+	}
+	query = `INSERT INTO transactions (hash, chain_id, sender, nonce, raw, status, purpose, reference)
+		SELECT ?, ?, ?, ?, ?, ?, purpose, reference FROM transactions WHERE hash = ?`
+	if _, err := tx.Exec(query, row.Hash, row.ChainID, row.Sender, row.Nonce, row.Raw, row.Status, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevertReplacement marks a replacement the node refused as DROPPED and returns the
+// transaction it replaced to PENDING, in one transaction.
+func (db *DB) RevertReplacement(hash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE transactions SET status = ?, replaced_by = '', updated_at = CURRENT_TIMESTAMP WHERE replaced_by = ? AND status = ?`
+	if _, err := tx.Exec(query, TransactionPending, hash, TransactionReplaced); err != nil {
+		return err
+	}
+	query = `UPDATE transactions SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE hash = ?`
+	if _, err := tx.Exec(query, TransactionDropped, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateTransactionStatus moves a transaction to a new status.
+func (db *DB) UpdateTransactionStatus(hash, status string) error {
+	query := `UPDATE transactions SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE hash = ?`
+	_, err := db.Exec(query, status, hash)
+	return err
+}
+
+// RecordTransactionReceipt moves a mined transaction to CONFIRMED or REVERTED and keeps its receipt.
+func (db *DB) RecordTransactionReceipt(hash, status, receipt string) error {
+	query := `UPDATE transactions SET status = ?, receipt = ?, updated_at = CURRENT_TIMESTAMP WHERE hash = ?`
+	_, err := db.Exec(query, status, receipt, hash)
+	return err
+}
+
+// ListTransactions returns the transactions of a sender on a chain in a status, by nonce.
+func (db *DB) ListTransactions(chainID uint64, sender, status string) ([]TransactionRow, error) {
+	return db.listTransactions(`WHERE chain_id = ? AND sender = ? AND status = ?`, chainID, sender, status)
+}
+
+// ListPendingTransactions returns every PENDING transaction in the journal, by chain, sender and nonce.
+func (db *DB) ListPendingTransactions() ([]TransactionRow, error) {
+	return db.listTransactions(`WHERE status = ?`, TransactionPending)
+}
+
+func (db *DB) listTransactions(where string, args ...interface{}) ([]TransactionRow, error) {
+	query := `SELECT hash, chain_id, sender, nonce, raw, status, replaced_by, purpose, reference, receipt, created_at, updated_at
+		FROM transactions ` + where + ` ORDER BY chain_id, sender, nonce, created_at`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []TransactionRow
+	for rows.Next() {
+		var row TransactionRow
+		if err := rows.Scan(&row.Hash, &row.ChainID, &row.Sender, &row.Nonce, &row.Raw, &row.Status, &row.ReplacedBy,
+			&row.Purpose, &row.Reference, &row.Receipt, &row.CreatedAt, &row.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
 func (db *DB) SocketPath() string {
 	return filepath.Join(db.DataDir, "settler.sock")
 }
+
+// KeysDir is where `settler keys` keeps encrypted signing keys.
+func (db *DB) KeysDir() string {
+	return filepath.Join(db.DataDir, "keys")
+}
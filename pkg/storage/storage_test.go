@@ -2,6 +2,7 @@ package storage
 
 import (
 	"testing"
+	"time"
 )
 
 func TestStorage_OpenDefault(t *testing.T) {
@@ -28,7 +29,7 @@ func TestStorage_PaymentPersistence(t *testing.T) {
 
 	sig := "0xabc123"
 	signer := "0xsigner"
-	
+
 	err = db.RecordPayment(sig, signer, "100", "0xasset", "nonce1")
 	if err != nil {
 		t.Fatalf("Failed to record: %v", err)
@@ -43,3 +44,93 @@ func TestStorage_PaymentPersistence(t *testing.T) {
 		t.Errorf("Expected %s, got %s", signer, recovered)
 	}
 }
+
+func TestStorage_NonceLifecycle(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	if err := db.SaveNonce("n1", []byte(`{"amount":"100"}`), now.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to save nonce: %v", err)
+	}
+
+	row, err := db.LoadNonce("n1")
+	if err != nil || row == nil {
+		t.Fatalf("Failed to load nonce: %v", err)
+	}
+	if string(row.Challenge) != `{"amount":"100"}` || row.Consumed {
+		t.Errorf("Unexpected nonce row: %+v", row)
+	}
+
+	if ok, err := db.ConsumeNonce("n1", now); err != nil || !ok {
+		t.Fatalf("Expected first consume to succeed: %v", err)
+	}
+	if ok, _ := db.ConsumeNonce("n1", now); ok {
+		t.Error("Expected second consume to fail")
+	}
+
+	if n, err := db.DeleteExpiredNonces(now.Add(2 * time.Minute)); err != nil || n != 1 {
+		t.Errorf("Expected 1 expired nonce removed, got %d (%v)", n, err)
+	}
+}
+
+func TestStorage_SettlementQueue(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.QueueSettlement("0xunknown", 1, "{}"); err == nil {
+		t.Error("Expected queueing an unverified payment to fail")
+	}
+
+	for _, sig := range []string{"0xa", "0xb"} {
+		if err := db.RecordPayment(sig, "0xsigner", "100", "0xasset", "nonce-"+sig); err != nil {
+			t.Fatalf("Failed to record: %v", err)
+		}
+		if ok, err := db.QueueSettlement(sig, 8453, `{"id":"`+sig+`"}`); err != nil || !ok {
+			t.Fatalf("Failed to queue %s: %v", sig, err)
+		}
+	}
+	if ok, _ := db.QueueSettlement("0xa", 8453, "{}"); ok {
+		t.Error("Expected a payment to be queued only once")
+	}
+
+	pending, err := db.ListSettlements(SettlementPending, 0)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Expected 2 pending settlements, got %d (%v)", len(pending), err)
+	}
+	if pending[0].PaymentID != "0xa" || pending[0].Payload != `{"id":"0xa"}` || pending[0].ChainID != 8453 {
+		t.Errorf("Unexpected queued settlement: %+v", pending[0])
+	}
+
+	if err := db.SubmitSettlements([]string{"0xa", "0xb"}, "0xtx"); err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	if err := db.RetrySettlement("0xb", "reverted"); err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+
+	row, err := db.LoadSettlement("0xb")
+	if err != nil || row == nil {
+		t.Fatalf("Failed to load settlement: %v", err)
+	}
+	if row.Status != SettlementPending || row.Attempts != 1 || row.Error != "reverted" {
+		t.Errorf("Unexpected retried settlement: %+v", row)
+	}
+	if submitted, _ := db.ListSettlements(SettlementSubmitted, 0); len(submitted) != 1 || submitted[0].TxHash != "0xtx" {
+		t.Errorf("Expected 0xa submitted in 0xtx, got %+v", submitted)
+	}
+
+	// Recording the outcome keeps the attempts and the queue position.
+	if err := db.RecordSettlement(SettlementRow{PaymentID: "0xb", ChainID: 8453, TxHash: "0xtx2", Status: SettlementConfirmed}); err != nil {
+		t.Fatalf("Failed to record settlement: %v", err)
+	}
+	if row, _ := db.LoadSettlement("0xb"); row.Status != SettlementConfirmed || row.TxHash != "0xtx2" || row.Attempts != 1 || row.Error != "" {
+		t.Errorf("Unexpected confirmed settlement: %+v", row)
+	}
+}
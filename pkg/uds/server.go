@@ -3,6 +3,7 @@ package uds
 import (
 	"log"
 	"net"
+	"net/http"
 	"os"
 )
 
@@ -16,6 +17,25 @@ func NewServer(path string) *Server {
 }
 
 func (s *Server) Start() error {
+	if err := s.listen(); err != nil {
+		return err
+	}
+
+	go s.accept()
+	return nil
+}
+
+// StartHTTP serves handler over the socket instead of the raw connection protocol.
+func (s *Server) StartHTTP(handler http.Handler) error {
+	if err := s.listen(); err != nil {
+		return err
+	}
+
+	go http.Serve(s.l, handler)
+	return nil
+}
+
+func (s *Server) listen() error {
 	// Remove existing socket if any
 	if err := os.RemoveAll(s.path); err != nil {
 		return err
@@ -25,11 +45,14 @@ func (s *Server) Start() error {
 	if err != nil {
 		return err
 	}
+	// Only processes running as the same user may connect.
+	if err := os.Chmod(s.path, 0600); err != nil {
+		l.Close()
+		return err
+	}
 	s.l = l
 
 	log.Printf("🔌 UDS Server: Listening on %s", s.path)
-
-	go s.accept()
 	return nil
 }
 
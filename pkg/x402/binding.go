@@ -0,0 +1,87 @@
+package x402
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// DefaultMaxBodyBytes caps how much of a request body is read to check its hash.
+const DefaultMaxBodyBytes = 1 << 20
+
+// BindingFields lists the IntentToPay fields that bind a payment to a request.
+var BindingFields = []string{"method", "url", "bodyHash"}
+
+// CanonicalURL returns the form of the request URL that bound intents sign: the lower-cased
+// host, the escaped path and the query sorted by key. The scheme is omitted so TLS
+// termination in front of the gateway does not change it.
+func CanonicalURL(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	u := strings.ToLower(host) + r.URL.EscapedPath()
+	if q := r.URL.Query(); len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
+
+// BodyHash returns the keccak256 hash of a request body as bound intents sign it.
+func BodyHash(body []byte) string {
+	return hexutil.Encode(ethcrypto.Keccak256(body))
+}
+
+// requestBodyHash hashes the request body and restores it for the next handler.
+func requestBodyHash(r *http.Request, maxBytes int64) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return BodyHash(nil), nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	r.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if int64(len(body)) > maxBytes {
+		return "", fmt.Errorf("request body exceeds %d bytes", maxBytes)
+	}
+	return BodyHash(body), nil
+}
+
+// checkBinding verifies that a bound intent covers the request being served.
+func (m *Middleware) checkBinding(r *http.Request, intent crypto.IntentToPay) error {
+	if !intent.IsBound() {
+		if m.config.RequireRequestBinding {
+			return reject(ReasonBindingRequired, "payment must be bound to the request (%s)", strings.Join(BindingFields, ", "))
+		}
+		return nil
+	}
+
+	return m.checkRequest(r, intent.Method, intent.URL, intent.BodyHash)
+}
+
+// checkRequest verifies that a signed method, canonical URL and body hash describe r.
+func (m *Middleware) checkRequest(r *http.Request, method, url, bodyHash string) error {
+	if !strings.EqualFold(method, r.Method) {
+		return reject(ReasonRequestMismatch, "payment is bound to method %s, not %s", method, r.Method)
+	}
+	if u := CanonicalURL(r); url != u {
+		return reject(ReasonRequestMismatch, "payment is bound to %s, not %s", url, u)
+	}
+
+	hash, err := requestBodyHash(r, m.config.MaxBodyBytes)
+	if err != nil {
+		return reject(ReasonRequestMismatch, "%v", err)
+	}
+	if !strings.EqualFold(bodyHash, hash) {
+		return reject(ReasonRequestMismatch, "payment is bound to a different request body")
+	}
+	return nil
+}
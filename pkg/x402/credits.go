@@ -0,0 +1,376 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+const (
+	// HeaderPaymentCost reports the amount charged to a prepaid balance for the request.
+	HeaderPaymentCost = "X-Payment-Cost"
+	// HeaderPaymentBalance reports the prepaid balance left after the request.
+	HeaderPaymentBalance = "X-Payment-Balance"
+)
+
+// ErrInsufficientCredit is returned by CreditLedger.Debit when a balance cannot cover a charge.
+var ErrInsufficientCredit = storage.ErrInsufficientCredit
+
+// CreditLedger holds prepaid balances funded by signed deposits. A signer has one balance per
+// chain and asset.
+type CreditLedger interface {
+	// Depositor returns the signer a deposit was credited to, if it has been credited.
+	Depositor(depositID string) (common.Address, bool, error)
+
+	// Deposit credits amount to the signer's balance. It is idempotent per depositID
+	// and reports false if the deposit was already credited.
+	Deposit(depositID string, signer common.Address, chainID *big.Int, asset string, amount *big.Int) (bool, error)
+
+	// Debit charges amount and returns the remaining balance. If the balance is too low
+	// it returns ErrInsufficientCredit together with the current balance.
+	Debit(signer common.Address, chainID *big.Int, asset string, amount *big.Int) (*big.Int, error)
+
+	// Balance returns the signer's current balance.
+	Balance(signer common.Address, chainID *big.Int, asset string) (*big.Int, error)
+}
+
+type creditKey struct {
+	signer  common.Address
+	chainID string
+	asset   string
+}
+
+func newCreditKey(signer common.Address, chainID *big.Int, asset string) creditKey {
+	return creditKey{signer, chainID.String(), normalizeAsset(asset)}
+}
+
+// MemoryCreditLedger keeps balances in process memory.
+type MemoryCreditLedger struct {
+	mu       sync.Mutex
+	deposits map[string]common.Address
+	balances map[creditKey]*big.Int
+}
+
+func NewMemoryCreditLedger() *MemoryCreditLedger {
+	return &MemoryCreditLedger{
+		deposits: make(map[string]common.Address),
+		balances: make(map[creditKey]*big.Int),
+	}
+}
+
+func (l *MemoryCreditLedger) Depositor(depositID string) (common.Address, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	signer, ok := l.deposits[depositID]
+	return signer, ok, nil
+}
+
+func (l *MemoryCreditLedger) Deposit(depositID string, signer common.Address, chainID *big.Int, asset string, amount *big.Int) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.deposits[depositID]; ok {
+		return false, nil
+	}
+	l.deposits[depositID] = signer
+	key := newCreditKey(signer, chainID, asset)
+	l.balances[key] = new(big.Int).Add(l.balance(key), amount)
+	return true, nil
+}
+
+func (l *MemoryCreditLedger) Debit(signer common.Address, chainID *big.Int, asset string, amount *big.Int) (*big.Int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := newCreditKey(signer, chainID, asset)
+	balance := l.balance(key)
+	if balance.Cmp(amount) < 0 {
+		return balance, ErrInsufficientCredit
+	}
+	balance = new(big.Int).Sub(balance, amount)
+	l.balances[key] = balance
+	return new(big.Int).Set(balance), nil
+}
+
+func (l *MemoryCreditLedger) Balance(signer common.Address, chainID *big.Int, asset string) (*big.Int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balance(newCreditKey(signer, chainID, asset)), nil
+}
+
+func (l *MemoryCreditLedger) balance(key creditKey) *big.Int {
+	if b, ok := l.balances[key]; ok {
+		return new(big.Int).Set(b)
+	}
+	return new(big.Int)
+}
+
+// SQLiteCreditLedger persists balances in the settler database.
+type SQLiteCreditLedger struct {
+	db *storage.DB
+}
+
+func NewSQLiteCreditLedger(db *storage.DB) *SQLiteCreditLedger {
+	return &SQLiteCreditLedger{db: db}
+}
+
+func (l *SQLiteCreditLedger) Depositor(depositID string) (common.Address, bool, error) {
+	signer, ok, err := l.db.DepositSigner(depositID)
+	return common.HexToAddress(signer), ok, err
+}
+
+func (l *SQLiteCreditLedger) Deposit(depositID string, signer common.Address, chainID *big.Int, asset string, amount *big.Int) (bool, error) {
+	return l.db.CreditDeposit(depositID, signer.Hex(), chainID.Uint64(), normalizeAsset(asset), amount)
+}
+
+func (l *SQLiteCreditLedger) Debit(signer common.Address, chainID *big.Int, asset string, amount *big.Int) (*big.Int, error) {
+	return l.db.DebitCredit(signer.Hex(), chainID.Uint64(), normalizeAsset(asset), amount)
+}
+
+func (l *SQLiteCreditLedger) Balance(signer common.Address, chainID *big.Int, asset string) (*big.Int, error) {
+	return l.db.CreditBalance(signer.Hex(), chainID.Uint64(), normalizeAsset(asset))
+}
+
+// normalizeAsset gives token addresses a canonical spelling for ledger keys.
+func normalizeAsset(asset string) string {
+	if common.IsHexAddress(asset) {
+		return common.HexToAddress(asset).Hex()
+	}
+	return asset
+}
+
+// Ensure implementations of CreditLedger.
+var (
+	_ CreditLedger = (*MemoryCreditLedger)(nil)
+	_ CreditLedger = (*SQLiteCreditLedger)(nil)
+)
+
+// spendCredit funds the signer's balance from a deposit the first time it is seen, then
+// debits the price of the request. Balance and cost are reported in response headers.
+func (m *Middleware) spendCredit(w http.ResponseWriter, r *http.Request, payload *PaymentPayload) (common.Address, error) {
+	if !m.creditsEnabled() {
+		return common.Address{}, reject(ReasonMalformedPayment, "prepaid credits are not enabled")
+	}
+
+	signer, chainID, err := m.redeemDeposit(r, payload)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	// Charge the price of the option in the deposited asset on the deposit's chain.
+	ctx := context.WithValue(r.Context(), SignerContextKey, signer)
+	options, err := m.config.OptionsResolver(r.WithContext(ctx))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to resolve price: %w", err)
+	}
+	asset := payload.Deposit.Asset
+	var price *big.Int
+	for _, opt := range options {
+		if opt.ChainID != nil && opt.ChainID.Cmp(chainID) == 0 && sameAddress(opt.Asset, asset) {
+			p, ok := new(big.Int).SetString(opt.Amount, 10)
+			if !ok {
+				return common.Address{}, fmt.Errorf("invalid price %q", opt.Amount)
+			}
+			price = p
+			break
+		}
+	}
+	if price == nil {
+		return common.Address{}, reject(ReasonAssetMismatch, "%s on chain %s is not accepted for this resource", asset, chainID)
+	}
+
+	balance, err := m.credits.Debit(signer, chainID, asset, price)
+	if errors.Is(err, ErrInsufficientCredit) {
+		w.Header().Set(HeaderPaymentBalance, balance.String())
+		return common.Address{}, reject(ReasonInsufficientCredit, "balance %s does not cover price %s", balance, price)
+	}
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to debit credit: %w", err)
+	}
+
+	w.Header().Set(HeaderPaymentCost, price.String())
+	w.Header().Set(HeaderPaymentBalance, balance.String())
+	return signer, nil
+}
+
+// redeemDeposit verifies a signed deposit and credits it once, returning the depositor and
+// the chain the deposit was signed for. Later requests spending a credited deposit must carry
+// a spend proof from the depositor.
+func (m *Middleware) redeemDeposit(r *http.Request, payload *PaymentPayload) (common.Address, *big.Int, error) {
+	deposit := *payload.Deposit
+
+	// A nonce that is no longer live may belong to a deposit already credited.
+	challenge, err := m.nonces.Verify(deposit.Nonce)
+	if err != nil {
+		keys, lookupErr := m.paymentKeys(r, payload, func(params crypto.DomainParams) ([]byte, error) {
+			return crypto.HashDeposit(deposit, params)
+		})
+		if lookupErr != nil {
+			return common.Address{}, nil, lookupErr
+		}
+		for _, key := range keys {
+			signer, credited, err := m.credits.Depositor(key.id)
+			if err != nil {
+				return common.Address{}, nil, fmt.Errorf("failed to look up deposit: %w", err)
+			}
+			if credited {
+				if err := m.checkSpend(r, payload, key); err != nil {
+					return common.Address{}, nil, err
+				}
+				return signer, key.params.ChainID, nil
+			}
+		}
+		return common.Address{}, nil, err
+	}
+
+	if !sameAddress(deposit.Asset, challenge.Asset) {
+		return common.Address{}, nil, reject(ReasonAssetMismatch, "deposit asset %s does not match %s", deposit.Asset, challenge.Asset)
+	}
+	if !sameAddress(deposit.Recipient, challenge.Recipient) {
+		return common.Address{}, nil, reject(ReasonRecipientMismatch, "deposit recipient %s does not match %s", deposit.Recipient, challenge.Recipient)
+	}
+	if challenge.ChainID == nil {
+		return common.Address{}, nil, reject(ReasonChainMismatch, "nonce was issued without a chain")
+	}
+	if challenge.Resource != "" && challenge.Resource != r.URL.Path {
+		return common.Address{}, nil, reject(ReasonResourceMismatch, "nonce was issued for %s, not %s", challenge.Resource, r.URL.Path)
+	}
+	if deposit.Deadline <= uint64(time.Now().Unix()) {
+		return common.Address{}, nil, reject(ReasonIntentExpired, "deposit deadline %d has passed", deposit.Deadline)
+	}
+	if challenge.Payer != "" && payload.From != "" && !sameAddress(payload.From, challenge.Payer) {
+		return common.Address{}, nil, reject(ReasonSignerMismatch, "deposit was quoted for %s, not %s", challenge.Payer, payload.From)
+	}
+
+	amount, ok := new(big.Int).SetString(deposit.Amount, 10)
+	if !ok {
+		return common.Address{}, nil, reject(ReasonAmountMismatch, "invalid deposit amount %q", deposit.Amount)
+	}
+	if min := m.minDeposit(challenge.Amount); amount.Cmp(min) < 0 {
+		return common.Address{}, nil, reject(ReasonDepositTooSmall, "deposit %s is below the minimum of %s", amount, min)
+	}
+	if max, ok := new(big.Int).SetString(m.config.MaxDeposit, 10); ok && amount.Cmp(max) > 0 {
+		return common.Address{}, nil, reject(ReasonDepositTooLarge, "deposit %s is above the maximum of %s", amount, max)
+	}
+
+	params, err := m.signingDomain(challenge, payload)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	sighash, err := crypto.HashDeposit(deposit, params)
+	if err != nil {
+		return common.Address{}, nil, &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	signer, err := m.verifySigner(r, payload, sighash, challenge.ChainID)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	if challenge.Payer != "" && !sameAddress(signer.Hex(), challenge.Payer) {
+		return common.Address{}, nil, reject(ReasonSignerMismatch, "deposit was quoted for %s, not %s", challenge.Payer, signer.Hex())
+	}
+
+	if err := m.checkSolvency(r, SolvencyQuery{
+		ChainID: challenge.ChainID,
+		Asset:   common.HexToAddress(deposit.Asset),
+		Payer:   signer,
+		Amount:  amount,
+		Spender: m.challengeDomain(challenge).VerifyingContract,
+	}); err != nil {
+		return common.Address{}, nil, err
+	}
+
+	if err := m.nonces.Consume(deposit.Nonce); err != nil {
+		return common.Address{}, nil, err
+	}
+	key := crypto.IdempotencyKey(sighash, signer)
+	if _, err := m.credits.Deposit(key, signer, challenge.ChainID, deposit.Asset, amount); err != nil {
+		return common.Address{}, nil, fmt.Errorf("failed to credit deposit: %w", err)
+	}
+
+	if m.config.DB != nil {
+		if err := m.config.DB.RecordPayment(key, signer.Hex(), deposit.Amount, deposit.Asset, deposit.Nonce); err != nil {
+			log.Printf("⚠️  x402: Failed to record deposit %s: %v", key, err)
+		}
+	}
+	return signer, challenge.ChainID, nil
+}
+
+// checkSpend verifies the spend proof of a request paid from an already credited deposit:
+// the deposit is still within its deadline, and its signer signed this request with a nonce
+// not used before.
+func (m *Middleware) checkSpend(r *http.Request, payload *PaymentPayload, key paymentKey) error {
+	now := time.Now()
+	if payload.Deposit.Deadline <= uint64(now.Unix()) {
+		return reject(ReasonIntentExpired, "deposit deadline %d has passed", payload.Deposit.Deadline)
+	}
+	proof := payload.Spend
+	if proof == nil {
+		return reject(ReasonSpendRequired, "deposit was already credited; sign a %s for this request", crypto.TypeCreditSpend)
+	}
+	if !strings.EqualFold(proof.Deposit, hexutil.Encode(key.sighash)) {
+		return reject(ReasonRequestMismatch, "spend proof is for deposit %s", proof.Deposit)
+	}
+	if proof.Nonce == "" {
+		return reject(ReasonMalformedPayment, "spend proof has no nonce")
+	}
+	if proof.Deadline <= uint64(now.Unix()) {
+		return reject(ReasonIntentExpired, "spend deadline %d has passed", proof.Deadline)
+	}
+	if err := m.checkRequest(r, proof.Method, proof.URL, proof.BodyHash); err != nil {
+		return err
+	}
+
+	sighash, err := crypto.HashCreditSpend(proof.CreditSpend, key.params)
+	if err != nil {
+		return &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	signed := &PaymentPayload{From: payload.From, Signature: proof.Signature, WebAuthn: proof.WebAuthn}
+	signer, err := m.verifySigner(r, signed, sighash, key.params.ChainID)
+	if err != nil {
+		return err
+	}
+	if signer != key.signer {
+		return reject(ReasonSignerMismatch, "spend proof is signed by %s, not the depositor %s", signer.Hex(), key.signer.Hex())
+	}
+
+	// Proofs are only accepted while the deposit is, so their nonces need not outlive it.
+	expiresAt := now.Add(365 * 24 * time.Hour)
+	if deadline := min(proof.Deadline, payload.Deposit.Deadline); deadline < uint64(expiresAt.Unix()) {
+		expiresAt = time.Unix(int64(deadline), 0)
+	}
+	fresh, err := m.authorizations.MarkSpent(spentKey("credit:"+key.id+":"+proof.Nonce), expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to record spend proof: %w", err)
+	}
+	if !fresh {
+		return reject(ReasonNonceUsed, "spend nonce %s was already used", proof.Nonce)
+	}
+	return nil
+}
+
+// creditsEnabled reports whether deposits are offered and credited. A deposit is only a signed
+// promise, so the depositor's balance must be checked on-chain before it is credited.
+func (m *Middleware) creditsEnabled() bool {
+	return m.config.Credits && m.config.Solvency != nil
+}
+
+// minDeposit is the larger of the configured minimum and the request price.
+func (m *Middleware) minDeposit(price string) *big.Int {
+	min, ok := new(big.Int).SetString(price, 10)
+	if !ok {
+		min = new(big.Int)
+	}
+	if configured, ok := new(big.Int).SetString(m.config.MinDeposit, 10); ok && configured.Cmp(min) > 0 {
+		min = configured
+	}
+	return min
+}
@@ -0,0 +1,194 @@
+package x402
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	crypto2 "github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// solvencyFunc adapts a function to SolvencyChecker.
+type solvencyFunc func(ctx context.Context, q SolvencyQuery) error
+
+func (f solvencyFunc) CheckSolvency(ctx context.Context, q SolvencyQuery) error { return f(ctx, q) }
+
+func TestMiddleware_Credits(t *testing.T) {
+	held := big.NewInt(1000)
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Asset:        "0x0000000000000000000000000000000000000456",
+		Amount:       "100",
+		Credits:      true,
+		MinDeposit:   "200",
+		MaxDeposit:   "500",
+		Solvency: solvencyFunc(func(_ context.Context, q SolvencyQuery) error {
+			if q.Amount.Cmp(held) > 0 {
+				return reject(ReasonInsufficientFunds, "balance %s is below %s", held, q.Amount)
+			}
+			return nil
+		}),
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	key, _ := crypto.GenerateKey()
+	deposit := func(amount string) PaymentPayload {
+		d := crypto2.DepositIntent{
+			Recipient: cfg.Recipient,
+			Amount:    amount,
+			Asset:     cfg.Asset,
+			Nonce:     requestNonce(t, mw),
+			Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+		}
+		sighash, err := crypto2.HashDeposit(d, cfg.DomainParams)
+		if err != nil {
+			t.Fatalf("failed to hash deposit: %v", err)
+		}
+		signature, _ := crypto.Sign(sighash, key)
+		signature[64] += 27
+		return PaymentPayload{Deposit: &d, Signature: "0x" + common.Bytes2Hex(signature)}
+	}
+
+	if rr := sendPayment(t, mw, "/", deposit("150")); !hasReason(rr, ReasonDepositTooSmall) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonDepositTooSmall, rr.Code, rr.Body.String())
+	}
+	if rr := sendPayment(t, mw, "/", deposit("600")); !hasReason(rr, ReasonDepositTooLarge) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonDepositTooLarge, rr.Code, rr.Body.String())
+	}
+
+	// A deposit the depositor cannot cover on-chain is not credited.
+	held = big.NewInt(300)
+	if rr := sendPayment(t, mw, "/", deposit("400")); !hasReason(rr, ReasonInsufficientFunds) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonInsufficientFunds, rr.Code, rr.Body.String())
+	}
+
+	// Requests after the one that credits a deposit carry a spend proof from the depositor.
+	spend := func(payload PaymentPayload, signer *ecdsa.PrivateKey, nonce string) PaymentPayload {
+		depositHash, _ := crypto2.HashDeposit(*payload.Deposit, cfg.DomainParams)
+		c := crypto2.CreditSpend{
+			Deposit:  hexutil.Encode(depositHash),
+			Nonce:    nonce,
+			Deadline: uint64(time.Now().Add(1 * time.Minute).Unix()),
+			Method:   "GET",
+			URL:      "example.com/",
+			BodyHash: BodyHash(nil),
+		}
+		sighash, err := crypto2.HashCreditSpend(c, cfg.DomainParams)
+		if err != nil {
+			t.Fatalf("failed to hash spend: %v", err)
+		}
+		signature, _ := crypto.Sign(sighash, signer)
+		signature[64] += 27
+		payload.Spend = &SpendProof{CreditSpend: c, Signature: hexutil.Encode(signature)}
+		return payload
+	}
+
+	// One deposit of 250 pays for two requests at 100 each.
+	payload := deposit("250")
+	for i, remaining := range []string{"150", "50"} {
+		sent := payload
+		if i > 0 {
+			sent = spend(payload, key, "spend-1")
+		}
+		rr := sendPayment(t, mw, "/", sent)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get(HeaderPaymentCost); got != "100" {
+			t.Errorf("expected cost 100, got %q", got)
+		}
+		if got := rr.Header().Get(HeaderPaymentBalance); got != remaining {
+			t.Errorf("expected balance %s, got %q", remaining, got)
+		}
+	}
+
+	// A credited deposit is not a bearer token: replaying it, or its proof, is refused.
+	if rr := sendPayment(t, mw, "/", payload); !hasReason(rr, ReasonSpendRequired) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonSpendRequired, rr.Code, rr.Body.String())
+	}
+	if rr := sendPayment(t, mw, "/", spend(payload, key, "spend-1")); !hasReason(rr, ReasonNonceUsed) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonNonceUsed, rr.Code, rr.Body.String())
+	}
+	other, _ := crypto.GenerateKey()
+	if rr := sendPayment(t, mw, "/", spend(payload, other, "spend-2")); !hasReason(rr, ReasonSignerMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonSignerMismatch, rr.Code, rr.Body.String())
+	}
+	if rr := sendPayment(t, mw, "/other", spend(payload, key, "spend-2")); !hasReason(rr, ReasonRequestMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonRequestMismatch, rr.Code, rr.Body.String())
+	}
+
+	rr := sendPayment(t, mw, "/", spend(payload, key, "spend-3"))
+	if !hasReason(rr, ReasonInsufficientCredit) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonInsufficientCredit, rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get(HeaderPaymentBalance); got != "50" {
+		t.Errorf("expected balance 50 on rejection, got %q", got)
+	}
+
+	// A fresh deposit tops the balance up.
+	if rr := sendPayment(t, mw, "/", deposit("200")); rr.Code != http.StatusOK || rr.Header().Get(HeaderPaymentBalance) != "150" {
+		t.Errorf("expected top-up to leave 150, got %d %q", rr.Code, rr.Header().Get(HeaderPaymentBalance))
+	}
+
+	// Without a solvency check nothing backs a deposit, so none is credited.
+	cfg.Solvency = nil
+	mw = NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+	if rr := sendPayment(t, mw, "/", deposit("200")); !hasReason(rr, ReasonMalformedPayment) {
+		t.Errorf("expected 402 %s without a solvency check, got %d %s", ReasonMalformedPayment, rr.Code, rr.Body.String())
+	}
+}
+
+func TestSQLiteCreditLedger(t *testing.T) {
+	dir := t.TempDir()
+	db, err := storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := common.HexToAddress("0x0000000000000000000000000000000000000abc")
+	asset := "0x0000000000000000000000000000000000000456"
+	chainID := big.NewInt(8453)
+	ledger := NewSQLiteCreditLedger(db)
+	if ok, err := ledger.Deposit("sig", signer, chainID, asset, big.NewInt(300)); !ok || err != nil {
+		t.Fatalf("expected deposit to be credited, got %v (%v)", ok, err)
+	}
+	if ok, _ := ledger.Deposit("sig", signer, chainID, asset, big.NewInt(300)); ok {
+		t.Error("expected the same deposit to be credited only once")
+	}
+	db.Close()
+
+	// Balances survive restarts.
+	db, err = storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ledger = NewSQLiteCreditLedger(db)
+
+	if depositor, ok, _ := ledger.Depositor("sig"); !ok || depositor != signer {
+		t.Errorf("expected deposit to be remembered for %s, got %s", signer.Hex(), depositor.Hex())
+	}
+	balance, err := ledger.Debit(signer, chainID, asset, big.NewInt(200))
+	if err != nil || balance.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("expected balance 100, got %v (%v)", balance, err)
+	}
+	if _, err := ledger.Debit(signer, chainID, asset, big.NewInt(101)); err != ErrInsufficientCredit {
+		t.Errorf("expected ErrInsufficientCredit, got %v", err)
+	}
+
+	// The same token address on another chain is another balance.
+	if _, err := ledger.Debit(signer, big.NewInt(1), asset, big.NewInt(1)); err != ErrInsufficientCredit {
+		t.Errorf("expected no balance on another chain, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package x402
+
+import "fmt"
+
+// Rejection reasons reported in the error field of a 402 challenge.
+const (
+	ReasonMalformedPayment      = "malformed_payment"
+	ReasonUnknownNonce          = "unknown_nonce"
+	ReasonNonceExpired          = "nonce_expired"
+	ReasonNonceUsed             = "nonce_used"
+	ReasonAmountMismatch        = "amount_mismatch"
+	ReasonAssetMismatch         = "asset_mismatch"
+	ReasonRecipientMismatch     = "recipient_mismatch"
+	ReasonChainMismatch         = "chain_mismatch"
+	ReasonResourceMismatch      = "resource_mismatch"
+	ReasonRequestMismatch       = "request_mismatch"
+	ReasonBindingRequired       = "binding_required"
+	ReasonIntentExpired         = "intent_expired"
+	ReasonInvalidSignature      = "invalid_signature"
+	ReasonPaymentExhausted      = "payment_exhausted"
+	ReasonPaymentExpired        = "payment_expired"
+	ReasonDepositTooSmall       = "deposit_too_small"
+	ReasonDepositTooLarge       = "deposit_too_large"
+	ReasonInsufficientCredit    = "insufficient_credit"
+	ReasonSpendRequired         = "spend_proof_required"
+	ReasonSignerMismatch        = "signer_mismatch"
+	ReasonNotYetValid           = "authorization_not_yet_valid"
+	ReasonInsufficientFunds     = "insufficient_funds"
+	ReasonInsufficientAllowance = "insufficient_allowance"
+	ReasonSettlementFailed      = "settlement_failed"
+	ReasonSettlementPending     = "settlement_pending"
+	ReasonDomainVersion         = "unsupported_domain_version"
+)
+
+// PaymentError describes why a payment payload was rejected.
+type PaymentError struct {
+	Reason string
+	Err    error
+}
+
+func reject(reason, format string, args ...interface{}) *PaymentError {
+	return &PaymentError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *PaymentError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *PaymentError) Unwrap() error {
+	return e.Err
+}
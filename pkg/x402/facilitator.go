@@ -0,0 +1,159 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// VerifyRequest is the body of a facilitator's /verify and /settle endpoints.
+type VerifyRequest struct {
+	X402Version         int               `json:"x402Version"`
+	PaymentPayload      SpecPayment       `json:"paymentPayload"`
+	PaymentRequirements PaymentDescriptor `json:"paymentRequirements"`
+}
+
+// VerifyResponse is returned by a facilitator's /verify endpoint.
+type VerifyResponse struct {
+	IsValid       bool   `json:"isValid"`
+	InvalidReason string `json:"invalidReason,omitempty"` // A rejection reason such as "insufficient_funds"
+	Payer         string `json:"payer,omitempty"`
+}
+
+// SupportedResponse is returned by a facilitator's /supported endpoint.
+type SupportedResponse struct {
+	Kinds []SupportedKind `json:"kinds"`
+}
+
+// SupportedKind is a scheme and network a facilitator verifies and settles.
+type SupportedKind struct {
+	X402Version int              `json:"x402Version"`
+	Scheme      string           `json:"scheme"`
+	Network     string           `json:"network"`
+	Assets      []SupportedAsset `json:"assets,omitempty"`
+}
+
+// SupportedAsset is a token accepted on a supported network.
+type SupportedAsset struct {
+	Symbol  string `json:"symbol"`
+	Address string `json:"address"`
+	Name    string `json:"name"`    // EIP-712 domain name
+	Version string `json:"version"` // EIP-712 domain version
+}
+
+// Facilitator verifies and settles "exact" payments on behalf of the gateway.
+// Implementations report rejected payments in the response rather than as errors.
+type Facilitator interface {
+	Verify(ctx context.Context, payment SpecPayment, requirements PaymentDescriptor) (*VerifyResponse, error)
+	Settle(ctx context.Context, payment SpecPayment, requirements PaymentDescriptor) (*SettlementResponse, error)
+}
+
+// TokenDomain returns the EIP-712 domain of the requirements' asset, resolving the
+// network slug through the chains registry.
+func (d PaymentDescriptor) TokenDomain() (crypto.TokenDomain, error) {
+	chain, err := chains.LookupChain(d.Network)
+	if err != nil {
+		return crypto.TokenDomain{}, err
+	}
+	if !common.IsHexAddress(d.Asset) {
+		return crypto.TokenDomain{}, fmt.Errorf("invalid asset %q", d.Asset)
+	}
+	domain := crypto.TokenDomain{
+		Name:              d.Extra["name"],
+		Version:           d.Extra["version"],
+		ChainID:           new(big.Int).SetUint64(uint64(chain.ChainID)),
+		VerifyingContract: common.HexToAddress(d.Asset),
+	}
+	if domain.Name == "" {
+		if symbol, ok := chain.SymbolOf(d.Asset); ok {
+			if known, ok := chain.EIP3009Domain(symbol); ok {
+				domain.Name, domain.Version = known.Name, known.Version
+			}
+		}
+	}
+	if domain.Name == "" {
+		return crypto.TokenDomain{}, fmt.Errorf("no EIP-712 domain known for %s on %s", d.Asset, d.Network)
+	}
+	return domain, nil
+}
+
+// VerifyExact checks an "exact" payment against payment requirements without touching
+// the chain: the signature, recipient, value and validity window. It returns the payer.
+func VerifyExact(payment SpecPayment, requirements PaymentDescriptor) (common.Address, error) {
+	if payment.Scheme != SchemeExact || requirements.Scheme != SchemeExact {
+		return common.Address{}, reject(ReasonMalformedPayment, "scheme %q is not supported", payment.Scheme)
+	}
+	if payment.Network != requirements.Network {
+		return common.Address{}, reject(ReasonChainMismatch, "payment network %q does not match %q", payment.Network, requirements.Network)
+	}
+	domain, err := requirements.TokenDomain()
+	if err != nil {
+		return common.Address{}, &PaymentError{Reason: ReasonChainMismatch, Err: err}
+	}
+	payer, err := crypto.VerifyTransferWithAuthorization(payment.Payload.Authorization, payment.Payload.Signature, domain)
+	if err != nil {
+		return common.Address{}, &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	if err := checkAuthorization(payment.Payload.Authorization, requirements.PayTo, requirements.MaxAmountRequired, time.Now()); err != nil {
+		return common.Address{}, err
+	}
+	return payer, nil
+}
+
+// ExactPaymentID returns the idempotency key of an "exact" payment: its EIP-3009 digest and
+// payer, so a re-encoded signature names the same payment.
+func ExactPaymentID(payment SpecPayment, requirements PaymentDescriptor) (string, error) {
+	domain, err := requirements.TokenDomain()
+	if err != nil {
+		return "", &PaymentError{Reason: ReasonChainMismatch, Err: err}
+	}
+	key, err := exactKey(payment.Payload.Authorization, payment.Payload.Signature, domain)
+	if err != nil {
+		return "", &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	return key, nil
+}
+
+// exactKey returns the idempotency key of an authorization signed for a token.
+func exactKey(auth crypto.TransferWithAuthorization, signature string, domain crypto.TokenDomain) (string, error) {
+	payer, err := crypto.VerifyTransferWithAuthorization(auth, signature, domain)
+	if err != nil {
+		return "", err
+	}
+	digest, err := crypto.HashTransferWithAuthorization(auth, domain)
+	if err != nil {
+		return "", err
+	}
+	return crypto.IdempotencyKey(digest, payer), nil
+}
+
+// checkAuthorization checks that an authorization pays at least amount to payTo and is
+// valid at now.
+func checkAuthorization(auth crypto.TransferWithAuthorization, payTo, amount string, now time.Time) error {
+	if !sameAddress(auth.To, payTo) {
+		return reject(ReasonRecipientMismatch, "authorization pays %s, not %s", auth.To, payTo)
+	}
+	value, ok := new(big.Int).SetString(auth.Value, 10)
+	price, _ := new(big.Int).SetString(amount, 10)
+	if !ok || price == nil || value.Cmp(price) < 0 {
+		return reject(ReasonAmountMismatch, "authorization value %s does not cover price %s", auth.Value, amount)
+	}
+	validAfter, ok1 := new(big.Int).SetString(auth.ValidAfter, 10)
+	validBefore, ok2 := new(big.Int).SetString(auth.ValidBefore, 10)
+	if !ok1 || !ok2 {
+		return reject(ReasonMalformedPayment, "invalid authorization validity window")
+	}
+	unix := big.NewInt(now.Unix())
+	if validAfter.Cmp(unix) > 0 {
+		return reject(ReasonNotYetValid, "authorization is valid after %s", validAfter)
+	}
+	if validBefore.Cmp(unix) <= 0 {
+		return reject(ReasonIntentExpired, "authorization expired at %s", validBefore)
+	}
+	return nil
+}
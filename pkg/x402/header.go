@@ -2,8 +2,10 @@ package x402
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/nathfavour/settlerengine/pkg/crypto"
 )
@@ -11,28 +13,76 @@ import (
 const (
 	HeaderPayment          = "X-Payment"
 	HeaderPaymentSignature = "X-Payment-Signature"
+
+	// HeaderPaymentFrom lets an agent announce the address it will pay from, so
+	// signer-specific prices can be quoted. The quote is then bound to that address.
+	HeaderPaymentFrom = "X-Payment-From"
 )
 
+// ErrNoPayment is returned by ParseHeader when the request carries no payment.
+var ErrNoPayment = errors.New("no payment header found")
+
 // PaymentPayload represents the data extracted from the payment header.
 type PaymentPayload struct {
 	Intent    crypto.IntentToPay `json:"intent"`
 	Signature string             `json:"signature"`
+	Network   string             `json:"network,omitempty"` // Chain ID the agent signed for
+
+	// DomainVersion names the SettlerEngine domain version the agent signed under, as
+	// advertised in the challenge's typed data. Empty means crypto.DomainVersion.
+	DomainVersion string `json:"domainVersion,omitempty"`
+
+	// From claims the signer. Smart contract accounts must set it, since their ERC-1271 or
+	// EIP-6492 signatures cannot be recovered to an address.
+	From string `json:"from,omitempty"`
+
+	// WebAuthn carries a passkey assertion over the intent or deposit digest in place of an
+	// ECDSA signature. Its signature then identifies the payment in Signature.
+	WebAuthn *crypto.WebAuthnAssertion `json:"webauthn,omitempty"`
+
+	// Deposit funds a prepaid balance instead of paying for a single request. The
+	// signature then covers the deposit, and Intent is ignored.
+	Deposit *crypto.DepositIntent `json:"deposit,omitempty"`
+
+	// Spend proves the depositor sent this request. Every request that spends a credited
+	// deposit, other than the one that credited it, must carry one.
+	Spend *SpendProof `json:"spend,omitempty"`
+
+	// Spec is set when the header carried a base64 payment from the public x402 spec.
+	Spec *SpecPayment `json:"-"`
+}
+
+// SpendProof is a CreditSpend signed by the depositor, with an ECDSA signature or a passkey
+// assertion like the deposit itself.
+type SpendProof struct {
+	crypto.CreditSpend
+	Signature string                    `json:"signature"`
+	WebAuthn  *crypto.WebAuthnAssertion `json:"webauthn,omitempty"`
 }
 
 // ParseHeader extracts and decodes the payment information from a request.
 func ParseHeader(r *http.Request) (*PaymentPayload, error) {
-	// Try X-Payment first (JSON payload)
-	if val := r.Header.Get(HeaderPayment); val != "" {
+	// Try X-Payment first: raw JSON, or base64 as sent by standard x402 clients
+	if val := strings.TrimSpace(r.Header.Get(HeaderPayment)); val != "" {
+		if !strings.HasPrefix(val, "{") {
+			return decodeSpecPayment(val)
+		}
 		var payload PaymentPayload
 		if err := json.Unmarshal([]byte(val), &payload); err != nil {
 			return nil, fmt.Errorf("failed to decode %s header: %w", HeaderPayment, err)
 		}
+		if payload.WebAuthn != nil {
+			payload.Signature = payload.WebAuthn.Signature
+		}
+		if payload.Spend != nil && payload.Spend.WebAuthn != nil {
+			payload.Spend.Signature = payload.Spend.WebAuthn.Signature
+		}
 		return &payload, nil
 	}
 
 	// Fallback to separate signature header (simplified version)
 	// This would require the intent to be reconstructible or passed elsewhere.
 	// For MVP, we'll focus on the self-contained JSON payload.
-	
-	return nil, fmt.Errorf("no payment header found")
+
+	return nil, ErrNoPayment
 }
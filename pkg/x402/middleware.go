@@ -3,11 +3,18 @@ package x402
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/chains"
 	"github.com/nathfavour/settlerengine/pkg/crypto"
 	"github.com/nathfavour/settlerengine/pkg/storage"
 )
@@ -27,16 +34,80 @@ type Config struct {
 	Amount        string
 	PriceResolver PriceResolver
 	DB            *storage.DB
+
+	// Options lists the chains and assets offered in each challenge. Empty offers a single
+	// option on DomainParams. Amounts and recipients left empty come from PriceResolver.
+	Options []PaymentOption
+	// OptionsResolver overrides Options when the offer depends on the request.
+	OptionsResolver OptionsResolver
+
+	// NonceIssuer issues and redeems challenge nonces. Defaults to a NonceManager over NonceStore.
+	NonceIssuer NonceIssuer
+	// NonceStore persists issued nonces. Defaults to SQLite when DB is set, memory otherwise.
+	NonceStore NonceStore
+	// SweepInterval controls how often expired nonces are purged. Defaults to one minute.
+	SweepInterval time.Duration
+
+	// Usage limits how many requests, and for how long, one payment unlocks.
+	Usage UsagePolicy
+	// GrantStore tracks payment usage. Defaults to SQLite when DB is set, memory otherwise.
+	GrantStore GrantStore
+
+	// RequireRequestBinding rejects intents that are not bound to method, URL and body.
+	RequireRequestBinding bool
+	// MaxBodyBytes caps the body read when checking a bound intent. Defaults to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+
+	// Credits lets agents fund a prepaid balance with one signed deposit and be debited per request.
+	// Nothing moves the deposited funds, so deposits are only offered and credited when Solvency
+	// is set to confirm the depositor holds them.
+	Credits bool
+	// MinDeposit is the smallest deposit accepted, in atomic units. The request price is always the floor.
+	MinDeposit string
+	// MaxDeposit is the largest deposit credited, in atomic units. Empty for no cap.
+	MaxDeposit string
+	// CreditLedger stores balances. Defaults to SQLite when DB is set, memory otherwise.
+	CreditLedger CreditLedger
+
+	// AuthorizationSpent remembers redeemed EIP-3009 nonces of "exact" scheme payments.
+	// Defaults to SQLite when DB is set, memory otherwise.
+	AuthorizationSpent SpentSet
+	// Settler, when set, settles "exact" payments on-chain before the request is served.
+	Settler Settler
+	// Facilitator, when set, verifies and settles "exact" payments instead of the gateway.
+	Facilitator Facilitator
+
+	// Solvency, when set, confirms on-chain that the payer can cover a payment before it is accepted.
+	Solvency SolvencyChecker
+	// Clients, when set, verifies ERC-1271 and EIP-6492 signatures of smart contract accounts.
+	Clients *chains.MultiClient
+
+	// Passkeys accepts intents and deposits signed by a registered WebAuthn passkey.
+	Passkeys bool
+	// PasskeyRegistry holds registered passkeys. Defaults to SQLite when DB is set, memory otherwise.
+	PasskeyRegistry PasskeyRegistry
+
+	// DomainVersions lists the SettlerEngine EIP-712 domain versions accepted, current first.
+	// Challenges advertise the first; listing an old one keeps its agents working during a
+	// migration. Defaults to DomainParams.Version, or crypto.DomainVersion.
+	DomainVersions []string
 }
 
 // PriceResolver dynamically determines the payment requirements for a request.
+// When the payer is known, its address is available under SignerContextKey.
 type PriceResolver func(r *http.Request) (amount, asset, recipient string, err error)
 
 // Middleware handles the x402 handshake.
 type Middleware struct {
-	config   Config
-	nonces   *NonceManager
-	verified sync.Map // Map of signature hash to Address
+	config  Config
+	nonces  NonceIssuer
+	grants  GrantStore
+	credits CreditLedger
+
+	authorizations SpentSet
+
+	stopOnce sync.Once
+	stop     chan struct{}
 }
 
 func NewMiddleware(cfg Config) *Middleware {
@@ -46,9 +117,93 @@ func NewMiddleware(cfg Config) *Middleware {
 		}
 	}
 
-	return &Middleware{
-		config: cfg,
-		nonces: NewNonceManager(),
+	if cfg.OptionsResolver == nil {
+		cfg.OptionsResolver = cfg.defaultOptions()
+	}
+
+	if cfg.NonceStore == nil && cfg.DB != nil {
+		cfg.NonceStore = NewSQLiteNonceStore(cfg.DB)
+	}
+	if cfg.SweepInterval <= 0 {
+		cfg.SweepInterval = time.Minute
+	}
+
+	if cfg.NonceIssuer == nil {
+		cfg.NonceIssuer = NewNonceManager(cfg.NonceStore)
+	}
+	if cfg.GrantStore == nil {
+		if cfg.DB != nil {
+			cfg.GrantStore = NewSQLiteGrantStore(cfg.DB)
+		} else {
+			cfg.GrantStore = NewMemoryGrantStore()
+		}
+	}
+	if cfg.CreditLedger == nil {
+		if cfg.DB != nil {
+			cfg.CreditLedger = NewSQLiteCreditLedger(cfg.DB)
+		} else {
+			cfg.CreditLedger = NewMemoryCreditLedger()
+		}
+	}
+	if cfg.PasskeyRegistry == nil {
+		if cfg.DB != nil {
+			cfg.PasskeyRegistry = NewSQLitePasskeyRegistry(cfg.DB)
+		} else {
+			cfg.PasskeyRegistry = NewMemoryPasskeyRegistry()
+		}
+	}
+	if cfg.AuthorizationSpent == nil {
+		if cfg.DB != nil {
+			cfg.AuthorizationSpent = NewSQLiteSpentSet(cfg.DB)
+		} else {
+			cfg.AuthorizationSpent = NewMemorySpentSet()
+		}
+	}
+	if len(cfg.DomainVersions) == 0 {
+		cfg.DomainVersions = []string{crypto.DomainVersion}
+		if cfg.DomainParams.Version != "" {
+			cfg.DomainVersions[0] = cfg.DomainParams.Version
+		}
+	}
+	cfg.Usage = cfg.Usage.normalize()
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	m := &Middleware{
+		config:  cfg,
+		nonces:  cfg.NonceIssuer,
+		grants:  cfg.GrantStore,
+		credits: cfg.CreditLedger,
+
+		authorizations: cfg.AuthorizationSpent,
+
+		stop: make(chan struct{}),
+	}
+	go m.sweep(cfg.SweepInterval)
+	return m
+}
+
+// Close stops the background nonce sweeper.
+func (m *Middleware) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// sweep periodically purges expired nonce state until Close is called.
+func (m *Middleware) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.nonces.Cleanup()
+			if _, err := m.authorizations.Sweep(time.Now()); err != nil {
+				log.Printf("⚠️  x402: Failed to sweep spent authorizations: %v", err)
+			}
+		}
 	}
 }
 
@@ -61,80 +216,382 @@ func GetSigner(ctx context.Context) (common.Address, bool) {
 // Handler handles the x402 handshake.
 func (m *Middleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rejection *PaymentError
+
 		// 1. Try to parse payment header
 		payload, err := ParseHeader(r)
 		if err == nil {
-			// 2. Check Cache & DB (Idempotency)
-			if addr, ok := m.verified.Load(payload.Signature); ok {
-				ctx := context.WithValue(r.Context(), SignerContextKey, addr.(common.Address))
+			// 2. Authorize the request against the payment or the prepaid balance it funds
+			var signer common.Address
+			switch {
+			case payload.Spec != nil:
+				signer, err = m.authorizeExact(w, r, payload)
+			case payload.Deposit != nil:
+				signer, err = m.spendCredit(w, r, payload)
+			default:
+				signer, err = m.authorize(r, payload)
+			}
+			if err == nil {
+				ctx := context.WithValue(r.Context(), SignerContextKey, signer)
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
-
-			if m.config.DB != nil {
-				signer, err := m.config.DB.CheckPayment(payload.Signature)
-				if err == nil && signer != "" {
-					recovered := common.HexToAddress(signer)
-					m.verified.Store(payload.Signature, recovered)
-					ctx := context.WithValue(r.Context(), SignerContextKey, recovered)
-					next.ServeHTTP(w, r.WithContext(ctx))
-					return
-				}
+			if !errors.As(err, &rejection) {
+				http.Error(w, "Failed to verify payment", http.StatusInternalServerError)
+				return
 			}
+		} else if !errors.Is(err, ErrNoPayment) {
+			rejection = &PaymentError{Reason: ReasonMalformedPayment, Err: err}
+		}
 
-			// 3. Validate Nonce
-			if m.nonces.Verify(payload.Intent.Nonce) {
-				// 4. Verify Signature
-				recovered, err := crypto.VerifyIntentToPay(payload.Intent, payload.Signature, m.config.DomainParams)
-				if err == nil && recovered.Hex() != "" {
-					// Authorized!
-					m.verified.Store(payload.Signature, recovered)
-
-					if m.config.DB != nil {
-						_ = m.config.DB.RecordPayment(
-							payload.Signature,
-							recovered.Hex(),
-							payload.Intent.Amount,
-							payload.Intent.Asset,
-							payload.Intent.Nonce,
-						)
-					}
-					
-					ctx := context.WithValue(r.Context(), SignerContextKey, recovered)
-					next.ServeHTTP(w, r.WithContext(ctx))
-					return
-				}
+		// 3. Fail and issue challenge (HTTP 402)
+		m.challenge(w, r, rejection)
+	})
+}
+
+// authorize accepts a per-request payment, either by redeeming an earlier use of the
+// same signed intent or by verifying a fresh one, and returns the payer.
+func (m *Middleware) authorize(r *http.Request, payload *PaymentPayload) (common.Address, error) {
+	// Bound payments must match the request on every use.
+	if err := m.checkBinding(r, payload.Intent); err != nil {
+		return common.Address{}, err
+	}
+
+	// A nonce that is no longer live may belong to a payment already accepted. Payments are keyed
+	// by digest and signer, so a re-encoded signature redeems the same grant.
+	challenge, err := m.nonces.Verify(payload.Intent.Nonce)
+	if err != nil {
+		keys, lookupErr := m.paymentKeys(r, payload, func(params crypto.DomainParams) ([]byte, error) {
+			return crypto.HashIntentToPay(payload.Intent, params)
+		})
+		if lookupErr != nil {
+			return common.Address{}, lookupErr
+		}
+		for _, key := range keys {
+			grant, err := m.grants.Use(key.id, r.URL.Path, time.Now())
+			if err != nil {
+				return common.Address{}, err
+			}
+			if grant != nil {
+				return grant.Signer, nil
 			}
 		}
+		return common.Address{}, err
+	}
+
+	recovered, key, err := m.verifyIntent(r, payload, challenge)
+	if err != nil {
+		return common.Address{}, err
+	}
 
-		// 5. Fail and issue challenge (HTTP 402)
-		amount, asset, recipient, err := m.config.PriceResolver(r)
+	if err := m.grants.Create(key, m.newGrant(recovered, r.URL.Path)); err != nil {
+		return common.Address{}, fmt.Errorf("failed to record payment usage: %w", err)
+	}
+
+	if m.config.DB != nil {
+		if err := m.config.DB.RecordPayment(key, recovered.Hex(), payload.Intent.Amount, payload.Intent.Asset, payload.Intent.Nonce); err != nil {
+			log.Printf("⚠️  x402: Failed to record payment %s: %v", key, err)
+		}
+	}
+	return recovered, nil
+}
+
+// challenge answers with HTTP 402 and fresh payment options.
+func (m *Middleware) challenge(w http.ResponseWriter, r *http.Request, rejection *PaymentError) {
+	// An announced payer is made available to the resolver and bound to the quote.
+	var payer string
+	if from := r.Header.Get(HeaderPaymentFrom); common.IsHexAddress(from) {
+		signer := common.HexToAddress(from)
+		payer = signer.Hex()
+		r = r.WithContext(context.WithValue(r.Context(), SignerContextKey, signer))
+	}
+
+	options, err := m.config.OptionsResolver(r)
+	if err != nil {
+		http.Error(w, "Failed to resolve price", http.StatusInternalServerError)
+		return
+	}
+
+	binding := &RequestBinding{
+		Fields:   BindingFields,
+		Required: m.config.RequireRequestBinding,
+		Method:   r.Method,
+		URL:      CanonicalURL(r),
+	}
+
+	// Each option gets its own nonce, bound to its chain, asset and price.
+	accepts := make([]PaymentDescriptor, 0, len(options))
+	for _, opt := range options {
+		challenge := Challenge{
+			Amount:    opt.Amount,
+			Asset:     opt.Asset,
+			Recipient: opt.Recipient,
+			ChainID:   opt.ChainID,
+			Resource:  r.URL.Path,
+			Payer:     payer,
+		}
+		if opt.VerifyingContract != (common.Address{}) {
+			challenge.VerifyingContract = opt.VerifyingContract.Hex()
+		}
+		nonce, err := m.nonces.Generate(challenge, m.config.NonceExpiry)
 		if err != nil {
-			http.Error(w, "Failed to resolve price", http.StatusInternalServerError)
+			http.Error(w, "Failed to generate nonce", http.StatusInternalServerError)
+			return
+		}
+		typedData, err := m.typedData(&challenge)
+		if err != nil {
+			http.Error(w, "Failed to describe typed data", http.StatusInternalServerError)
 			return
 		}
 
-		nonce, _ := m.nonces.Generate(m.config.NonceExpiry)
-
-		resp := ChallengeResponse{
-			Status:      http.StatusPaymentRequired,
-			Title:       "Payment Required",
-			Description: "This resource requires a valid x402 payment signature.",
-			Accepts: []PaymentDescriptor{
-				{
-					Scheme:  "x402",
-					Price:   amount,
-					Asset:   asset,
-					Network: m.config.DomainParams.ChainID.String(),
-					PayTo:   recipient,
-					Nonce:   nonce,
-				},
-			},
-			Resource: r.URL.Path,
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusPaymentRequired)
-		json.NewEncoder(w).Encode(resp)
-	})
+		descriptor := PaymentDescriptor{
+			Scheme:            "x402",
+			Price:             opt.Amount,
+			Asset:             opt.Asset,
+			Network:           opt.ChainID.String(),
+			PayTo:             opt.Recipient,
+			Nonce:             nonce,
+			VerifyingContract: challenge.VerifyingContract,
+			Binding:           binding,
+			TypedData:         typedData,
+		}
+		if m.creditsEnabled() {
+			descriptor.MinDeposit = m.minDeposit(opt.Amount).String()
+			descriptor.MaxDeposit = m.config.MaxDeposit
+		}
+		if m.config.Passkeys {
+			descriptor.SignatureSchemes = []string{SignatureSchemeSecp256k1, SignatureSchemeWebAuthn}
+		}
+		accepts = append(accepts, descriptor)
+	}
+
+	// Standard x402 clients pay with an EIP-3009 authorization where the asset supports it
+	// and the gateway can settle it.
+	for _, opt := range options {
+		if m.settlesExact() && opt.supportsExact() {
+			accepts = append(accepts, m.exactDescriptor(r, opt))
+		}
+	}
+
+	resp := ChallengeResponse{
+		X402Version: X402Version,
+		Status:      http.StatusPaymentRequired,
+		Title:       "Payment Required",
+		Description: "This resource requires a valid x402 payment signature.",
+		Accepts:     accepts,
+		Resource:    r.URL.Path,
+	}
+	if rejection != nil {
+		resp.Error = rejection.Reason
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPaymentRequired)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newGrant builds the entitlement for a freshly accepted payment.
+func (m *Middleware) newGrant(signer common.Address, resource string) Grant {
+	g := Grant{
+		Signer:   signer,
+		Resource: resource,
+		MaxUses:  m.config.Usage.MaxRequests,
+	}
+	if m.config.Usage.Window > 0 {
+		g.ExpiresAt = time.Now().Add(m.config.Usage.Window)
+	}
+	return g
+}
+
+// verifyIntent checks that a signed intent matches the challenge its nonce was issued for
+// and returns the recovered signer and the payment's idempotency key.
+func (m *Middleware) verifyIntent(r *http.Request, payload *PaymentPayload, challenge *Challenge) (common.Address, string, error) {
+	intent := payload.Intent
+
+	if !sameAmount(intent.Amount, challenge.Amount) {
+		return common.Address{}, "", reject(ReasonAmountMismatch, "intent amount %s does not match price %s", intent.Amount, challenge.Amount)
+	}
+	if !sameAddress(intent.Asset, challenge.Asset) {
+		return common.Address{}, "", reject(ReasonAssetMismatch, "intent asset %s does not match %s", intent.Asset, challenge.Asset)
+	}
+	if !sameAddress(intent.Recipient, challenge.Recipient) {
+		return common.Address{}, "", reject(ReasonRecipientMismatch, "intent recipient %s does not match %s", intent.Recipient, challenge.Recipient)
+	}
+	if challenge.ChainID == nil {
+		return common.Address{}, "", reject(ReasonChainMismatch, "nonce was issued without a chain")
+	}
+	if payload.Network != "" && payload.Network != challenge.ChainID.String() {
+		return common.Address{}, "", reject(ReasonChainMismatch, "payment network %s does not match chain %s", payload.Network, challenge.ChainID)
+	}
+	if challenge.Resource != "" && challenge.Resource != r.URL.Path {
+		return common.Address{}, "", reject(ReasonResourceMismatch, "nonce was issued for %s, not %s", challenge.Resource, r.URL.Path)
+	}
+	if intent.Deadline <= uint64(time.Now().Unix()) {
+		return common.Address{}, "", reject(ReasonIntentExpired, "intent deadline %d has passed", intent.Deadline)
+	}
+
+	if challenge.Payer != "" && payload.From != "" && !sameAddress(payload.From, challenge.Payer) {
+		return common.Address{}, "", reject(ReasonSignerMismatch, "price was quoted for %s, not %s", challenge.Payer, payload.From)
+	}
+
+	// Verify against the domain of whichever option the agent chose.
+	params, err := m.signingDomain(challenge, payload)
+	if err != nil {
+		return common.Address{}, "", err
+	}
+	sighash, err := crypto.HashIntentToPay(intent, params)
+	if err != nil {
+		return common.Address{}, "", &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	recovered, err := m.verifySigner(r, payload, sighash, challenge.ChainID)
+	if err != nil {
+		return common.Address{}, "", err
+	}
+	if challenge.Payer != "" && !sameAddress(recovered.Hex(), challenge.Payer) {
+		return common.Address{}, "", reject(ReasonSignerMismatch, "price was quoted for %s, not %s", challenge.Payer, recovered.Hex())
+	}
+
+	// A valid signature proves intent, not funds. The verifying contract pulls the amount.
+	amount, _ := new(big.Int).SetString(intent.Amount, 10)
+	if err := m.checkSolvency(r, SolvencyQuery{
+		ChainID: challenge.ChainID,
+		Asset:   common.HexToAddress(intent.Asset),
+		Payer:   recovered,
+		Amount:  amount,
+		Spender: m.challengeDomain(challenge).VerifyingContract,
+	}); err != nil {
+		return common.Address{}, "", err
+	}
+
+	// Redeem the nonce last so a rejected payment does not burn it.
+	if err := m.nonces.Consume(intent.Nonce); err != nil {
+		return common.Address{}, "", err
+	}
+	return recovered, crypto.IdempotencyKey(sighash, recovered), nil
+}
+
+// verifySigner returns the signer of a payment over sighash. Passkey assertions are checked
+// against the registry; otherwise, without a claimed signer, it is recovered from the ECDSA
+// signature, and with one the claim is checked, which also admits smart accounts.
+func (m *Middleware) verifySigner(r *http.Request, payload *PaymentPayload, sighash []byte, chainID *big.Int) (common.Address, error) {
+	signer, err := m.recoverSigner(payload, sighash)
+	var rejection *PaymentError
+	if payload.WebAuthn != nil || payload.From == "" || !errors.As(err, &rejection) || rejection.Reason != ReasonInvalidSignature {
+		return signer, err
+	}
+
+	// Not the claimed signer's key, so ask its account contract.
+	signer = common.HexToAddress(payload.From)
+	if err := crypto.VerifySignatureFrom(r.Context(), m.config.Clients, chainID, signer, sighash, payload.Signature); err != nil {
+		if errors.Is(err, crypto.ErrInvalidSignature) {
+			return common.Address{}, &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+		}
+		return common.Address{}, fmt.Errorf("failed to verify signature of %s: %w", signer.Hex(), err)
+	}
+	return signer, nil
+}
+
+// recoverSigner is verifySigner without contract calls: passkeys are checked against the
+// registry and ECDSA signatures recovered, and a claimed signer must be the recovered key.
+func (m *Middleware) recoverSigner(payload *PaymentPayload, sighash []byte) (common.Address, error) {
+	if payload.WebAuthn != nil {
+		return m.verifyPasskey(payload, sighash)
+	}
+	if payload.From != "" && !common.IsHexAddress(payload.From) {
+		return common.Address{}, reject(ReasonMalformedPayment, "invalid signer %q", payload.From)
+	}
+
+	signer, err := crypto.RecoverSigner(sighash, payload.Signature)
+	if err != nil {
+		return common.Address{}, &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	if payload.From != "" && signer != common.HexToAddress(payload.From) {
+		return common.Address{}, reject(ReasonInvalidSignature, "signature is not by %s", payload.From)
+	}
+	return signer, nil
+}
+
+// paymentKey is an idempotency key an accepted payment could be stored under, with the domain,
+// digest and signer it was derived from.
+type paymentKey struct {
+	id      string
+	params  crypto.DomainParams
+	sighash []byte
+	signer  common.Address
+}
+
+// paymentKeys returns the idempotency keys an already accepted payment could be stored under.
+// Its challenge may be gone, so the message is hashed under the domain of every option offered
+// for the request, and each signer that verifies gives a key. Signers are recovered locally;
+// a claimed smart account is only asked on the chain the payment names.
+func (m *Middleware) paymentKeys(r *http.Request, payload *PaymentPayload, hash func(crypto.DomainParams) ([]byte, error)) ([]paymentKey, error) {
+	version := payload.DomainVersion
+	if version == "" {
+		version = crypto.DomainVersion
+	}
+	if !slices.Contains(m.config.DomainVersions, version) {
+		return nil, nil
+	}
+	options, err := m.config.OptionsResolver(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve price: %w", err)
+	}
+
+	var keys []paymentKey
+	seen := make(map[string]bool)
+	for _, opt := range options {
+		if opt.ChainID == nil || (payload.Network != "" && payload.Network != opt.ChainID.String()) {
+			continue
+		}
+		params := crypto.DomainParams{ChainID: opt.ChainID, VerifyingContract: m.config.DomainParams.VerifyingContract, Version: version}
+		if opt.VerifyingContract != (common.Address{}) {
+			params.VerifyingContract = opt.VerifyingContract
+		}
+		domain := params.ChainID.String() + params.VerifyingContract.Hex()
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+
+		sighash, err := hash(params)
+		if err != nil {
+			return nil, nil // Left for full verification to reject
+		}
+		var signer common.Address
+		if payload.Network != "" {
+			signer, err = m.verifySigner(r, payload, sighash, params.ChainID)
+		} else {
+			signer, err = m.recoverSigner(payload, sighash)
+		}
+		var rejection *PaymentError
+		if errors.As(err, &rejection) && rejection.Reason == ReasonInvalidSignature {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, paymentKey{id: crypto.IdempotencyKey(sighash, signer), params: params, sighash: sighash, signer: signer})
+	}
+	return keys, nil
+}
+
+// sameAmount compares two uint256 decimal strings numerically.
+func sameAmount(a, b string) bool {
+	x, ok := new(big.Int).SetString(a, 10)
+	if !ok {
+		return false
+	}
+	y, ok := new(big.Int).SetString(b, 10)
+	if !ok {
+		return false
+	}
+	return x.Cmp(y) == 0
+}
+
+// sameAddress compares two hex addresses regardless of checksum casing.
+func sameAddress(a, b string) bool {
+	if !common.IsHexAddress(a) || !common.IsHexAddress(b) {
+		return strings.EqualFold(a, b)
+	}
+	return common.HexToAddress(a) == common.HexToAddress(b)
 }
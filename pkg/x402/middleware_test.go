@@ -1,17 +1,22 @@
 package x402
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"encoding/json"
+	"io"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/nathfavour/settlerengine/pkg/chains"
 	crypto2 "github.com/nathfavour/settlerengine/pkg/crypto"
 )
 
@@ -27,6 +32,7 @@ func TestMiddleware_402Challenge(t *testing.T) {
 		Amount:      "100",
 	}
 	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
 
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -78,8 +84,10 @@ func TestMiddleware_Authorized(t *testing.T) {
 		Recipient:   agentAddr.Hex(), // Just for testing
 		Asset:       "0x0000000000000000000000000000000000000456",
 		Amount:      "100",
+		Usage:       UsagePolicy{MaxRequests: 2},
 	}
 	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
 
 	// First request to get a nonce
 	req1 := httptest.NewRequest("GET", "/", nil)
@@ -102,46 +110,16 @@ func TestMiddleware_Authorized(t *testing.T) {
 		Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
 	}
 
-	typedData := apitypes.TypedData{
-		Types: apitypes.Types{
-			"EIP712Domain": []apitypes.Type{
-				{Name: "name", Type: "string"},
-				{Name: "version", Type: "string"},
-				{Name: "chainId", Type: "uint256"},
-				{Name: "verifyingContract", Type: "address"},
-			},
-			"IntentToPay": []apitypes.Type{
-				{Name: "recipient", Type: "address"},
-				{Name: "amount", Type: "uint256"},
-				{Name: "asset", Type: "address"},
-				{Name: "nonce", Type: "string"},
-				{Name: "deadline", Type: "uint256"},
-			},
-		},
-		PrimaryType: "IntentToPay",
-		Domain: apitypes.TypedDataDomain{
-			Name:              "SettlerEngine",
-			Version:           "1",
-			ChainId:           (*math.HexOrDecimal256)(chainID),
-			VerifyingContract: verifyingContract.Hex(),
-		},
-		Message: apitypes.TypedDataMessage{
-			"recipient": intent.Recipient,
-			"amount":    intent.Amount,
-			"asset":     intent.Asset,
-			"nonce":     intent.Nonce,
-			"deadline":  (*math.HexOrDecimal256)(big.NewInt(int64(intent.Deadline))),
-		},
+	// Sign from the schema advertised in the challenge rather than a hardcoded struct
+	descriptor := challenge.Accepts[0]
+	if len(descriptor.TypedData) == 0 || descriptor.TypedData[0].PrimaryType != crypto2.TypeIntentToPay {
+		t.Fatalf("expected the challenge to describe IntentToPay, got %+v", descriptor.TypedData)
+	}
+	_, message := intent.TypedMessage()
+	sighash, _, err := apitypes.TypedDataAndHash(descriptor.TypedData[0].TypedData(message))
+	if err != nil {
+		t.Fatalf("failed to hash intent from the advertised schema: %v", err)
 	}
-
-	domainSeparator, _ := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
-	typedDataHash, _ := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
-	rawData := make([]byte, 2+32+32)
-	rawData[0] = 0x19
-	rawData[1] = 0x01
-	copy(rawData[2:34], domainSeparator)
-	copy(rawData[34:66], typedDataHash)
-	sighash := crypto.Keccak256(rawData)
 
 	signature, _ := crypto.Sign(sighash, privateKey)
 	signature[64] += 27 // Transform V
@@ -187,3 +165,575 @@ func TestMiddleware_Authorized(t *testing.T) {
 		t.Error("next handler was not called (cached)")
 	}
 }
+
+func TestMiddleware_RejectsIntentNotMatchingChallenge(t *testing.T) {
+	privateKey, _ := crypto.GenerateKey()
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{
+			ChainID:           big.NewInt(84532),
+			VerifyingContract: common.HexToAddress("0x0"),
+		},
+		NonceExpiry: 1 * time.Minute,
+		Recipient:   "0x0000000000000000000000000000000000000123",
+		Asset:       "0x0000000000000000000000000000000000000456",
+		Amount:      "100",
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(p *PaymentPayload)
+		reason string
+	}{
+		{"amount", func(p *PaymentPayload) { p.Intent.Amount = "1" }, ReasonAmountMismatch},
+		{"asset", func(p *PaymentPayload) { p.Intent.Asset = "0x0000000000000000000000000000000000000789" }, ReasonAssetMismatch},
+		{"recipient", func(p *PaymentPayload) { p.Intent.Recipient = crypto.PubkeyToAddress(privateKey.PublicKey).Hex() }, ReasonRecipientMismatch},
+		{"deadline", func(p *PaymentPayload) { p.Intent.Deadline = uint64(time.Now().Add(-time.Minute).Unix()) }, ReasonIntentExpired},
+		{"network", func(p *PaymentPayload) { p.Network = "8453" }, ReasonChainMismatch},
+		{"nonce", func(p *PaymentPayload) { p.Intent.Nonce = "deadbeef" }, ReasonUnknownNonce},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := NewMiddleware(cfg)
+			t.Cleanup(mw.Close)
+			payload := PaymentPayload{
+				Intent: crypto2.IntentToPay{
+					Recipient: cfg.Recipient,
+					Amount:    cfg.Amount,
+					Asset:     cfg.Asset,
+					Nonce:     requestNonce(t, mw),
+					Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+				},
+			}
+			tt.mutate(&payload)
+			payload.Signature = signIntent(t, privateKey, payload.Intent, cfg.DomainParams)
+
+			rr := sendPayment(t, mw, "/", payload)
+			if rr.Code != http.StatusPaymentRequired {
+				t.Fatalf("expected status 402, got %d", rr.Code)
+			}
+			var resp ChallengeResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Error != tt.reason {
+				t.Errorf("expected reason %q, got %q", tt.reason, resp.Error)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MalformedPayment(t *testing.T) {
+	mw := NewMiddleware(Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Amount:       "100",
+	})
+	t.Cleanup(mw.Close)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Payment", "{not json")
+	rr := httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, req)
+
+	var resp ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusPaymentRequired || resp.Error != ReasonMalformedPayment {
+		t.Errorf("expected 402 %s, got %d %q", ReasonMalformedPayment, rr.Code, resp.Error)
+	}
+}
+
+// requestNonce performs an unpaid request and returns the nonce from the first descriptor.
+func requestNonce(t *testing.T, mw *Middleware) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	var challenge ChallengeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &challenge); err != nil || len(challenge.Accepts) == 0 {
+		t.Fatalf("expected a challenge, got %d: %s", rr.Code, rr.Body.String())
+	}
+	return challenge.Accepts[0].Nonce
+}
+
+// signIntent signs an intent the way an agent would and returns the hex signature.
+func signIntent(t *testing.T, key *ecdsa.PrivateKey, intent crypto2.IntentToPay, params crypto2.DomainParams) string {
+	t.Helper()
+	sighash, err := crypto2.HashIntentToPay(intent, params)
+	if err != nil {
+		t.Fatalf("failed to hash intent: %v", err)
+	}
+	signature, err := crypto.Sign(sighash, key)
+	if err != nil {
+		t.Fatalf("failed to sign intent: %v", err)
+	}
+	signature[64] += 27
+	return "0x" + common.Bytes2Hex(signature)
+}
+
+// sendPayment submits a payment payload to the middleware and records the response.
+func sendPayment(t *testing.T, mw *Middleware, path string, payload PaymentPayload) *httptest.ResponseRecorder {
+	t.Helper()
+	payloadJSON, _ := json.Marshal(payload)
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("X-Payment", string(payloadJSON))
+	rr := httptest.NewRecorder()
+	mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMiddleware_NonceIsSingleUse(t *testing.T) {
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Asset:        "0x0000000000000000000000000000000000000456",
+		Amount:       "100",
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	intent := crypto2.IntentToPay{
+		Recipient: cfg.Recipient,
+		Amount:    cfg.Amount,
+		Asset:     cfg.Asset,
+		Nonce:     requestNonce(t, mw),
+		Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+	}
+
+	first, _ := crypto.GenerateKey()
+	rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, first, intent, cfg.DomainParams)})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected first payment to succeed, got %d", rr.Code)
+	}
+
+	// A second signature over the same nonce must not unlock the resource again.
+	second, _ := crypto.GenerateKey()
+	rr = sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, second, intent, cfg.DomainParams)})
+	var resp ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusPaymentRequired || resp.Error != ReasonNonceUsed {
+		t.Errorf("expected 402 %s, got %d %q", ReasonNonceUsed, rr.Code, resp.Error)
+	}
+}
+
+func TestMiddleware_StatelessNonces(t *testing.T) {
+	issuer, err := NewStatelessNonceManager([]byte("0123456789abcdef0123456789abcdef"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Asset:        "0x0000000000000000000000000000000000000456",
+		Amount:       "100",
+		NonceIssuer:  issuer,
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	key, _ := crypto.GenerateKey()
+	intent := crypto2.IntentToPay{
+		Recipient: cfg.Recipient,
+		Amount:    cfg.Amount,
+		Asset:     cfg.Asset,
+		Nonce:     requestNonce(t, mw),
+		Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+	}
+	payload := PaymentPayload{Intent: intent, Signature: signIntent(t, key, intent, cfg.DomainParams)}
+
+	// The nonce was issued for "/", so it cannot pay for another resource.
+	rr := sendPayment(t, mw, "/other", payload)
+	var resp ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusPaymentRequired || resp.Error != ReasonResourceMismatch {
+		t.Errorf("expected 402 %s, got %d %q", ReasonResourceMismatch, rr.Code, resp.Error)
+	}
+
+	if rr := sendPayment(t, mw, "/", payload); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMiddleware_UsagePolicy(t *testing.T) {
+	newPaidMiddleware := func(t *testing.T, usage UsagePolicy, path string) (*Middleware, PaymentPayload) {
+		cfg := Config{
+			DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+			NonceExpiry:  1 * time.Minute,
+			Recipient:    "0x0000000000000000000000000000000000000123",
+			Asset:        "0x0000000000000000000000000000000000000456",
+			Amount:       "100",
+			Usage:        usage,
+		}
+		mw := NewMiddleware(cfg)
+		t.Cleanup(mw.Close)
+
+		rr := httptest.NewRecorder()
+		mw.Handler(nil).ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var challenge ChallengeResponse
+		json.Unmarshal(rr.Body.Bytes(), &challenge)
+
+		key, _ := crypto.GenerateKey()
+		intent := crypto2.IntentToPay{
+			Recipient: cfg.Recipient,
+			Amount:    cfg.Amount,
+			Asset:     cfg.Asset,
+			Nonce:     challenge.Accepts[0].Nonce,
+			Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+		}
+		return mw, PaymentPayload{Intent: intent, Signature: signIntent(t, key, intent, cfg.DomainParams)}
+	}
+
+	expectRejected := func(t *testing.T, rr *httptest.ResponseRecorder, reason string) {
+		t.Helper()
+		var resp ChallengeResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if rr.Code != http.StatusPaymentRequired || resp.Error != reason {
+			t.Errorf("expected 402 %s, got %d %q", reason, rr.Code, resp.Error)
+		}
+	}
+
+	t.Run("single request by default", func(t *testing.T) {
+		mw, payload := newPaidMiddleware(t, UsagePolicy{}, "/")
+		if rr := sendPayment(t, mw, "/", payload); rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+		expectRejected(t, sendPayment(t, mw, "/", payload), ReasonPaymentExhausted)
+	})
+
+	t.Run("request quota", func(t *testing.T) {
+		mw, payload := newPaidMiddleware(t, UsagePolicy{MaxRequests: 3}, "/")
+		for i := 0; i < 3; i++ {
+			if rr := sendPayment(t, mw, "/", payload); rr.Code != http.StatusOK {
+				t.Fatalf("request %d: expected status 200, got %d", i+1, rr.Code)
+			}
+		}
+		expectRejected(t, sendPayment(t, mw, "/", payload), ReasonPaymentExhausted)
+	})
+
+	t.Run("time window", func(t *testing.T) {
+		mw, payload := newPaidMiddleware(t, UsagePolicy{Window: 50 * time.Millisecond}, "/")
+		for i := 0; i < 2; i++ {
+			if rr := sendPayment(t, mw, "/", payload); rr.Code != http.StatusOK {
+				t.Fatalf("request %d: expected status 200, got %d", i+1, rr.Code)
+			}
+		}
+		time.Sleep(60 * time.Millisecond)
+		expectRejected(t, sendPayment(t, mw, "/", payload), ReasonPaymentExpired)
+	})
+
+	t.Run("bound to resource", func(t *testing.T) {
+		mw, payload := newPaidMiddleware(t, UsagePolicy{MaxRequests: 10}, "/cheap")
+		if rr := sendPayment(t, mw, "/cheap", payload); rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+		expectRejected(t, sendPayment(t, mw, "/expensive", payload), ReasonResourceMismatch)
+	})
+}
+
+func TestMiddleware_RequestBinding(t *testing.T) {
+	cfg := Config{
+		DomainParams:          crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:           1 * time.Minute,
+		Recipient:             "0x0000000000000000000000000000000000000123",
+		Asset:                 "0x0000000000000000000000000000000000000456",
+		Amount:                "100",
+		Usage:                 UsagePolicy{MaxRequests: 5},
+		RequireRequestBinding: true,
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	body := `{"prompt":"hello"}`
+	send := func(payload PaymentPayload, body string) (*httptest.ResponseRecorder, string) {
+		payloadJSON, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "http://API.example.com/v1/infer?b=2&a=1", strings.NewReader(body))
+		req.Header.Set("X-Payment", string(payloadJSON))
+		rr := httptest.NewRecorder()
+		var received string
+		mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			received = string(b)
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rr, req)
+		return rr, received
+	}
+
+	rr := httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, httptest.NewRequest("POST", "http://API.example.com/v1/infer?b=2&a=1", strings.NewReader(body)))
+	var challenge ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &challenge)
+	binding := challenge.Accepts[0].Binding
+	if binding == nil || !binding.Required || binding.Method != "POST" || binding.URL != "api.example.com/v1/infer?a=1&b=2" {
+		t.Fatalf("unexpected binding in challenge: %+v", binding)
+	}
+
+	key, _ := crypto.GenerateKey()
+	intent := crypto2.IntentToPay{
+		Recipient: cfg.Recipient,
+		Amount:    cfg.Amount,
+		Asset:     cfg.Asset,
+		Nonce:     challenge.Accepts[0].Nonce,
+		Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+	}
+
+	// Unbound intents are refused when binding is required.
+	unbound := PaymentPayload{Intent: intent, Signature: signIntent(t, key, intent, cfg.DomainParams)}
+	if rr, _ := send(unbound, body); !hasReason(rr, ReasonBindingRequired) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonBindingRequired, rr.Code, rr.Body.String())
+	}
+
+	intent.Method = binding.Method
+	intent.URL = binding.URL
+	intent.BodyHash = BodyHash([]byte(body))
+	bound := PaymentPayload{Intent: intent, Signature: signIntent(t, key, intent, cfg.DomainParams)}
+
+	rr, received := send(bound, body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	if received != body {
+		t.Errorf("expected upstream to receive the original body, got %q", received)
+	}
+
+	// The same header cannot be replayed with a different payload.
+	if rr, _ := send(bound, `{"prompt":"something else"}`); !hasReason(rr, ReasonRequestMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonRequestMismatch, rr.Code, rr.Body.String())
+	}
+}
+
+func hasReason(rr *httptest.ResponseRecorder, reason string) bool {
+	var resp ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code == http.StatusPaymentRequired && resp.Error == reason
+}
+
+func TestMiddleware_QuoteBoundToAnnouncedPayer(t *testing.T) {
+	partner, _ := crypto.GenerateKey()
+	partnerAddr := crypto.PubkeyToAddress(partner.PublicKey)
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Asset:        "0x0000000000000000000000000000000000000456",
+		PriceResolver: func(r *http.Request) (string, string, string, error) {
+			if signer, ok := r.Context().Value(SignerContextKey).(common.Address); ok && signer == partnerAddr {
+				return "10", "0x0000000000000000000000000000000000000456", "0x0000000000000000000000000000000000000123", nil
+			}
+			return "100", "0x0000000000000000000000000000000000000456", "0x0000000000000000000000000000000000000123", nil
+		},
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(HeaderPaymentFrom, partnerAddr.Hex())
+	rr := httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, req)
+	var challenge ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &challenge)
+	if challenge.Accepts[0].Price != "10" {
+		t.Fatalf("expected the partner price, got %s", challenge.Accepts[0].Price)
+	}
+
+	intent := crypto2.IntentToPay{
+		Recipient: cfg.Recipient,
+		Amount:    "10",
+		Asset:     cfg.Asset,
+		Nonce:     challenge.Accepts[0].Nonce,
+		Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+	}
+
+	// Another signer cannot use the discounted quote.
+	other, _ := crypto.GenerateKey()
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, other, intent, cfg.DomainParams)}); !hasReason(rr, ReasonSignerMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonSignerMismatch, rr.Code, rr.Body.String())
+	}
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, partner, intent, cfg.DomainParams)}); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMiddleware_MultipleOptions(t *testing.T) {
+	baseUSDC, err := TokenOption(chains.ChainIDBaseSepolia, "USDC", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	polygonUSDC, err := TokenOption(chains.ChainIDPolygon, "usdc", "150")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TokenOption(chains.ChainIDBase, "BUSD", "1"); err == nil {
+		t.Error("expected an error for a token the chain does not list")
+	}
+
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Amount:       "100",
+		Options:      []PaymentOption{baseUSDC, polygonUSDC},
+		Settler: settlerFunc(func(context.Context, ExactSettlement) (string, error) {
+			return "0x01", nil
+		}),
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	rr := httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	var challenge ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &challenge)
+	// Both USDC options are also offered in the spec's "exact" scheme.
+	if len(challenge.Accepts) != 4 || challenge.Accepts[2].Scheme != SchemeExact || challenge.Accepts[3].Network != "polygon" {
+		t.Fatalf("expected 2 x402 and 2 exact options, got %d", len(challenge.Accepts))
+	}
+	polygon := challenge.Accepts[1]
+	if polygon.Network != "137" || polygon.Price != "150" || polygon.Asset != polygonUSDC.Asset || polygon.PayTo != cfg.Recipient {
+		t.Fatalf("unexpected Polygon option: %+v", polygon)
+	}
+	if polygon.Nonce == challenge.Accepts[0].Nonce {
+		t.Error("expected a distinct nonce per option")
+	}
+
+	key, _ := crypto.GenerateKey()
+	intent := crypto2.IntentToPay{
+		Recipient: polygon.PayTo,
+		Amount:    polygon.Price,
+		Asset:     polygon.Asset,
+		Nonce:     polygon.Nonce,
+		Deadline:  uint64(time.Now().Add(1 * time.Hour).Unix()),
+	}
+
+	// The Base price cannot be paid with the Polygon nonce.
+	cheap := intent
+	cheap.Amount = "100"
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: cheap, Signature: signIntent(t, key, cheap, polygonUSDC.Domain())}); !hasReason(rr, ReasonAmountMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonAmountMismatch, rr.Code, rr.Body.String())
+	}
+
+	// The payer is recovered under the Polygon domain it signed for.
+	var payer common.Address
+	payload := PaymentPayload{Intent: intent, Signature: signIntent(t, key, intent, polygonUSDC.Domain()), Network: "137"}
+	payloadJSON, _ := json.Marshal(payload)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Payment", string(payloadJSON))
+	rr = httptest.NewRecorder()
+	mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payer, _ = r.Context().Value(SignerContextKey).(common.Address)
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	if payer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("expected payer %s, got %s", crypto.PubkeyToAddress(key.PublicKey).Hex(), payer.Hex())
+	}
+}
+
+func TestMiddleware_DomainVersions(t *testing.T) {
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{
+			ChainID:           big.NewInt(8453),
+			VerifyingContract: common.HexToAddress("0x0000000000000000000000000000000000000def"),
+		},
+		NonceExpiry:    time.Minute,
+		Recipient:      "0x0000000000000000000000000000000000000123",
+		Asset:          "0x0000000000000000000000000000000000000456",
+		Amount:         "1000",
+		DomainVersions: []string{"2", crypto2.DomainVersion},
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	rr := httptest.NewRecorder()
+	mw.Handler(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	var challenge ChallengeResponse
+	json.NewDecoder(rr.Body).Decode(&challenge)
+	if v := challenge.Accepts[0].TypedData[0].Domain.Version; v != "2" {
+		t.Errorf("expected the current domain version 2 to be advertised, got %q", v)
+	}
+
+	agent, _ := crypto.GenerateKey()
+	tests := []struct {
+		name    string
+		version string // Version signed under and named in the payload
+		code    int
+	}{
+		{"current version", "2", http.StatusOK},
+		{"previous version", crypto2.DomainVersion, http.StatusOK},
+		{"legacy agent", "", http.StatusOK},
+		{"unknown version", "3", http.StatusPaymentRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent := crypto2.IntentToPay{
+				Recipient: cfg.Recipient,
+				Amount:    cfg.Amount,
+				Asset:     cfg.Asset,
+				Nonce:     requestNonce(t, mw),
+				Deadline:  uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			params := cfg.DomainParams
+			params.Version = tt.version
+			rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, agent, intent, params), DomainVersion: tt.version})
+			if rr.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code != http.StatusOK && !hasReason(rr, ReasonDomainVersion) {
+				t.Errorf("expected %s, got %s", ReasonDomainVersion, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware_SignatureEncodings(t *testing.T) {
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{
+			ChainID:           big.NewInt(8453),
+			VerifyingContract: common.HexToAddress("0x0000000000000000000000000000000000000def"),
+		},
+		NonceExpiry: time.Minute,
+		Recipient:   "0x0000000000000000000000000000000000000123",
+		Asset:       "0x0000000000000000000000000000000000000456",
+		Amount:      "1000",
+		Usage:       UsagePolicy{MaxRequests: 2},
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	agent, _ := crypto.GenerateKey()
+	intent := crypto2.IntentToPay{
+		Recipient: cfg.Recipient,
+		Amount:    cfg.Amount,
+		Asset:     cfg.Asset,
+		Nonce:     requestNonce(t, mw),
+		Deadline:  uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	signature := signIntent(t, agent, intent, cfg.DomainParams)
+	compact, err := crypto2.CompactSignature(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The high-s twin of a valid signature verifies under plain ECDSA, but is refused.
+	sig := common.FromHex(signature)
+	new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64])).FillBytes(sig[32:64])
+	sig[64] ^= 1
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: hexutil.Encode(sig)}); rr.Code != http.StatusPaymentRequired || !hasReason(rr, ReasonInvalidSignature) {
+		t.Fatalf("expected a high-s signature to be rejected, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// An EIP-2098 signature pays, and its 65-byte encoding redeems the same payment.
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: hexutil.Encode(compact)}); rr.Code != http.StatusOK {
+		t.Fatalf("expected a compact signature to pay, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signature}); rr.Code != http.StatusOK {
+		t.Fatalf("expected the second use of the payment, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signature}); !hasReason(rr, ReasonPaymentExhausted) {
+		t.Errorf("expected both encodings to count against one payment, got %d %s", rr.Code, rr.Body.String())
+	}
+}
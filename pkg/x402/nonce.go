@@ -4,53 +4,102 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"sync"
+	"log"
+	"math/big"
 	"time"
 )
 
-// NonceManager handles the generation and validation of cryptographic nonces.
+// Challenge records the payment terms a nonce was issued for.
+type Challenge struct {
+	Amount    string   `json:"amount"`
+	Asset     string   `json:"asset"`
+	Recipient string   `json:"recipient"`
+	ChainID   *big.Int `json:"chainId"`
+	// VerifyingContract is the EIP-712 verifying contract of the chosen option.
+	VerifyingContract string `json:"verifyingContract,omitempty"`
+	Resource          string `json:"resource"`
+	Payer             string `json:"payer,omitempty"` // Address the price was quoted for, if the agent announced one
+}
+
+// NonceIssuer issues challenge nonces and redeems them once a payment is accepted.
+type NonceIssuer interface {
+	// Generate creates a nonce bound to the given terms.
+	Generate(c Challenge, expiry time.Duration) (string, error)
+
+	// Verify returns the terms a nonce was issued for, or a PaymentError if it
+	// is unknown, expired or already redeemed.
+	Verify(nonce string) (*Challenge, error)
+
+	// Consume redeems a nonce so it cannot back another payment.
+	Consume(nonce string) error
+
+	// Cleanup purges expired state.
+	Cleanup()
+}
+
+// NonceManager is a stateful NonceIssuer that records every issued nonce in a NonceStore.
 type NonceManager struct {
-	nonces sync.Map // Map of string nonce to expiry time.Time
+	store NonceStore
 }
 
-func NewNonceManager() *NonceManager {
-	return &NonceManager{}
+// NewNonceManager creates a manager backed by store, or by a MemoryNonceStore if store is nil.
+func NewNonceManager(store NonceStore) *NonceManager {
+	if store == nil {
+		store = NewMemoryNonceStore()
+	}
+	return &NonceManager{store: store}
 }
 
-// Generate creates a new nonce that expires after a certain duration.
-func (nm *NonceManager) Generate(expiry time.Duration) (string, error) {
+// Generate creates a new nonce bound to the given terms that expires after a certain duration.
+func (nm *NonceManager) Generate(c Challenge, expiry time.Duration) (string, error) {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
 		return "", fmt.Errorf("failed to generate random nonce: %w", err)
 	}
 	nonce := hex.EncodeToString(b)
-	nm.nonces.Store(nonce, time.Now().Add(expiry))
+	if err := nm.store.Save(nonce, c, time.Now().Add(expiry)); err != nil {
+		return "", fmt.Errorf("failed to store nonce: %w", err)
+	}
 	return nonce, nil
 }
 
-// Verify checks if a nonce is valid and has not expired.
-func (nm *NonceManager) Verify(nonce string) bool {
-	val, ok := nm.nonces.Load(nonce)
-	if !ok {
-		return false
+// Verify checks if a nonce is valid, unused and has not expired, returning the terms it was issued for.
+func (nm *NonceManager) Verify(nonce string) (*Challenge, error) {
+	rec, err := nm.store.Load(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load nonce: %w", err)
 	}
-	expiry := val.(time.Time)
-	if time.Now().After(expiry) {
-		nm.nonces.Delete(nonce)
-		return false
+	if rec == nil {
+		return nil, reject(ReasonUnknownNonce, "nonce %q was not issued by this gateway", nonce)
 	}
-	// Once verified, we should probably invalidate it to prevent reuse if needed,
-	// but for x402 handshake, a session nonce might be reused within its TTL.
-	// For strict single-use, we would nm.nonces.Delete(nonce) here.
-	return true
+	if rec.Consumed {
+		return nil, reject(ReasonNonceUsed, "nonce %q was already redeemed", nonce)
+	}
+	if !time.Now().Before(rec.ExpiresAt) {
+		return nil, reject(ReasonNonceExpired, "nonce %q expired at %s", nonce, rec.ExpiresAt.Format(time.RFC3339))
+	}
+	c := rec.Challenge
+	return &c, nil
 }
 
-// Cleanup removes expired nonces from the map.
+// Consume redeems a nonce so it cannot back another payment.
+func (nm *NonceManager) Consume(nonce string) error {
+	ok, err := nm.store.Consume(nonce, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to consume nonce: %w", err)
+	}
+	if !ok {
+		return reject(ReasonNonceUsed, "nonce %q was already redeemed", nonce)
+	}
+	return nil
+}
+
+// Cleanup removes expired nonces from the store.
 func (nm *NonceManager) Cleanup() {
-	nm.nonces.Range(func(key, value interface{}) bool {
-		if time.Now().After(value.(time.Time)) {
-			nm.nonces.Delete(key)
-		}
-		return true
-	})
+	if _, err := nm.store.Sweep(time.Now()); err != nil {
+		log.Printf("⚠️  x402: Failed to sweep expired nonces: %v", err)
+	}
 }
+
+// Ensure implementation of NonceIssuer.
+var _ NonceIssuer = (*NonceManager)(nil)
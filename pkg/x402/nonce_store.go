@@ -0,0 +1,130 @@
+package x402
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// NonceRecord is the stored state of an issued nonce.
+type NonceRecord struct {
+	Challenge Challenge
+	ExpiresAt time.Time
+	Consumed  bool
+}
+
+// NonceStore persists issued challenge nonces.
+type NonceStore interface {
+	// Save records a freshly issued nonce and the terms it is bound to.
+	Save(nonce string, c Challenge, expiresAt time.Time) error
+
+	// Load returns the record for a nonce, or nil if it was never issued.
+	Load(nonce string) (*NonceRecord, error)
+
+	// Consume atomically marks an unexpired nonce as used. It reports false if the
+	// nonce is unknown, expired or already consumed.
+	Consume(nonce string, now time.Time) (bool, error)
+
+	// Sweep deletes nonces that expired before now.
+	Sweep(now time.Time) (int, error)
+}
+
+// MemoryNonceStore keeps nonces in process memory. Nonces are lost on restart.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]NonceRecord
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]NonceRecord)}
+}
+
+func (s *MemoryNonceStore) Save(nonce string, c Challenge, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nonces[nonce] = NonceRecord{Challenge: c, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryNonceStore) Load(nonce string) (*NonceRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.nonces[nonce]
+	if !ok {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+func (s *MemoryNonceStore) Consume(nonce string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.nonces[nonce]
+	if !ok || rec.Consumed || !now.Before(rec.ExpiresAt) {
+		return false, nil
+	}
+	rec.Consumed = true
+	s.nonces[nonce] = rec
+	return true, nil
+}
+
+func (s *MemoryNonceStore) Sweep(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for nonce, rec := range s.nonces {
+		if !now.Before(rec.ExpiresAt) {
+			delete(s.nonces, nonce)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// SQLiteNonceStore persists nonces in the settler database so they survive restarts
+// and can be shared by processes using the same data directory.
+type SQLiteNonceStore struct {
+	db *storage.DB
+}
+
+func NewSQLiteNonceStore(db *storage.DB) *SQLiteNonceStore {
+	return &SQLiteNonceStore{db: db}
+}
+
+func (s *SQLiteNonceStore) Save(nonce string, c Challenge, expiresAt time.Time) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode challenge: %w", err)
+	}
+	return s.db.SaveNonce(nonce, data, expiresAt)
+}
+
+func (s *SQLiteNonceStore) Load(nonce string) (*NonceRecord, error) {
+	row, err := s.db.LoadNonce(nonce)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	var c Challenge
+	if err := json.Unmarshal(row.Challenge, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode challenge: %w", err)
+	}
+	return &NonceRecord{Challenge: c, ExpiresAt: row.ExpiresAt, Consumed: row.Consumed}, nil
+}
+
+func (s *SQLiteNonceStore) Consume(nonce string, now time.Time) (bool, error) {
+	return s.db.ConsumeNonce(nonce, now)
+}
+
+func (s *SQLiteNonceStore) Sweep(now time.Time) (int, error) {
+	n, err := s.db.DeleteExpiredNonces(now)
+	return int(n), err
+}
+
+// Ensure implementations of NonceStore.
+var (
+	_ NonceStore = (*MemoryNonceStore)(nil)
+	_ NonceStore = (*SQLiteNonceStore)(nil)
+)
@@ -0,0 +1,150 @@
+package x402
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+func TestNonceManager_SingleUse(t *testing.T) {
+	nm := NewNonceManager(nil)
+	nonce, err := nm.Generate(Challenge{Amount: "100", ChainID: big.NewInt(1)}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nm.Verify(nonce); err != nil {
+		t.Fatalf("expected nonce to verify: %v", err)
+	}
+	if err := nm.Consume(nonce); err != nil {
+		t.Fatalf("expected first consume to succeed: %v", err)
+	}
+	if err := nm.Consume(nonce); err == nil {
+		t.Error("expected second consume to fail")
+	}
+	if _, err := nm.Verify(nonce); err == nil || err.(*PaymentError).Reason != ReasonNonceUsed {
+		t.Errorf("expected %s, got %v", ReasonNonceUsed, err)
+	}
+}
+
+func TestNonceManager_Sweep(t *testing.T) {
+	store := NewMemoryNonceStore()
+	nm := NewNonceManager(store)
+	if _, err := nm.Generate(Challenge{Amount: "1"}, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	nm.Cleanup()
+	if len(store.nonces) != 0 {
+		t.Errorf("expected expired nonce to be swept, %d left", len(store.nonces))
+	}
+}
+
+func TestSQLiteNonceStore_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	db, err := storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, err := NewNonceManager(NewSQLiteNonceStore(db)).Generate(Challenge{
+		Amount:    "100",
+		Asset:     "0x0000000000000000000000000000000000000456",
+		Recipient: "0x0000000000000000000000000000000000000123",
+		ChainID:   big.NewInt(84532),
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	nm := NewNonceManager(NewSQLiteNonceStore(db))
+	c, err := nm.Verify(nonce)
+	if err != nil {
+		t.Fatalf("expected nonce to survive restart: %v", err)
+	}
+	if c.Amount != "100" || c.ChainID.Int64() != 84532 {
+		t.Errorf("unexpected challenge: %+v", c)
+	}
+	if err := nm.Consume(nonce); err != nil {
+		t.Fatalf("expected consume to succeed: %v", err)
+	}
+	if err := nm.Consume(nonce); err == nil {
+		t.Error("expected nonce to be single-use")
+	}
+}
+
+func TestStatelessNonceManager(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	issuer, err := NewStatelessNonceManager(secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := issuer.Generate(Challenge{Amount: "100", ChainID: big.NewInt(84532), Resource: "/data"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second replica sharing only the secret and spent-set can verify and redeem it.
+	spent := NewMemorySpentSet()
+	replica, _ := NewStatelessNonceManager(secret, spent)
+	c, err := replica.Verify(nonce)
+	if err != nil {
+		t.Fatalf("expected replica to verify nonce: %v", err)
+	}
+	if c.Amount != "100" || c.Resource != "/data" || c.ChainID.Int64() != 84532 {
+		t.Errorf("unexpected challenge: %+v", c)
+	}
+	if err := replica.Consume(nonce); err != nil {
+		t.Fatalf("expected consume to succeed: %v", err)
+	}
+	if err := replica.Consume(nonce); err == nil {
+		t.Error("expected nonce to be single-use")
+	}
+
+	other, _ := NewStatelessNonceManager([]byte("fedcba9876543210fedcba9876543210"), nil)
+	if _, err := other.Verify(nonce); err == nil {
+		t.Error("expected nonce to be rejected under a different secret")
+	}
+
+	tampered := nonce[:len(nonce)-2] + "AA"
+	if _, err := issuer.Verify(tampered); err == nil {
+		t.Error("expected tampered nonce to be rejected")
+	}
+
+	expired, _ := issuer.Generate(Challenge{Amount: "100"}, -time.Second)
+	if _, err := issuer.Verify(expired); err == nil || err.(*PaymentError).Reason != ReasonNonceExpired {
+		t.Errorf("expected %s, got %v", ReasonNonceExpired, err)
+	}
+}
+
+func TestSQLiteSpentSet(t *testing.T) {
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	spent := NewSQLiteSpentSet(db)
+	now := time.Now()
+	if ok, err := spent.MarkSpent("k1", now.Add(time.Minute)); err != nil || !ok {
+		t.Fatalf("expected first mark to succeed: %v", err)
+	}
+	if ok, _ := spent.MarkSpent("k1", now.Add(time.Minute)); ok {
+		t.Error("expected second mark to fail")
+	}
+	if n, _ := spent.Sweep(now.Add(2 * time.Minute)); n != 1 {
+		t.Errorf("expected 1 entry swept, got %d", n)
+	}
+	if ok, _ := spent.IsSpent("k1"); ok {
+		t.Error("expected swept key to be forgotten")
+	}
+}
@@ -0,0 +1,174 @@
+package x402
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// PaymentOption is one way of paying offered in a 402 challenge.
+type PaymentOption struct {
+	ChainID           *big.Int
+	VerifyingContract common.Address
+	Asset             string
+	Amount            string // Atomic units; empty takes the price from PriceResolver
+	Recipient         string // Empty takes the recipient from PriceResolver
+
+	// Network, TokenName and TokenVersion enable the public x402 "exact" scheme for
+	// this option: the network slug and the asset's EIP-3009 EIP-712 domain.
+	Network      string
+	TokenName    string
+	TokenVersion string
+}
+
+// WithChainDefaults fills the network slug and token domain from the chains registry when
+// the option's asset is a known token there.
+func (o PaymentOption) WithChainDefaults() PaymentOption {
+	if o.ChainID == nil || !o.ChainID.IsUint64() {
+		return o
+	}
+	chain, err := chains.GetChainConfig(chains.ChainID(o.ChainID.Uint64()))
+	if err != nil {
+		return o
+	}
+	if o.Network == "" {
+		o.Network = chain.Network
+	}
+	if o.TokenName == "" {
+		if symbol, ok := chain.SymbolOf(o.Asset); ok {
+			if domain, ok := chain.EIP3009Domain(symbol); ok {
+				o.TokenName, o.TokenVersion = domain.Name, domain.Version
+			}
+		}
+	}
+	return o
+}
+
+// supportsExact reports whether the option can be paid with an EIP-3009 authorization.
+func (o PaymentOption) supportsExact() bool {
+	return o.Network != "" && o.TokenName != "" && common.IsHexAddress(o.Asset)
+}
+
+// tokenDomain returns the EIP-712 domain of the option's asset.
+func (o PaymentOption) tokenDomain() crypto.TokenDomain {
+	return crypto.TokenDomain{
+		Name:              o.TokenName,
+		Version:           o.TokenVersion,
+		ChainID:           o.ChainID,
+		VerifyingContract: common.HexToAddress(o.Asset),
+	}
+}
+
+// Domain returns the EIP-712 domain intents for this option are signed under.
+func (o PaymentOption) Domain() crypto.DomainParams {
+	return crypto.DomainParams{ChainID: o.ChainID, VerifyingContract: o.VerifyingContract}
+}
+
+// OptionsResolver determines the payment options offered for a request.
+type OptionsResolver func(r *http.Request) ([]PaymentOption, error)
+
+// TokenOption builds an option for a token from the chains registry, such as USDC on Base.
+func TokenOption(id chains.ChainID, symbol, amount string) (PaymentOption, error) {
+	chain, err := chains.GetChainConfig(id)
+	if err != nil {
+		return PaymentOption{}, err
+	}
+	asset, ok := chain.TokenAddress(symbol)
+	if !ok {
+		return PaymentOption{}, fmt.Errorf("%s is not available on %s", symbol, chain.Name)
+	}
+	opt := PaymentOption{
+		ChainID: new(big.Int).SetUint64(uint64(id)),
+		Asset:   asset,
+		Amount:  amount,
+	}
+	if common.IsHexAddress(chain.FacilitatorAddress) {
+		opt.VerifyingContract = common.HexToAddress(chain.FacilitatorAddress)
+	}
+	return opt.WithChainDefaults(), nil
+}
+
+// defaultOptions offers Config.Options, or a single option on Config.DomainParams, priced by PriceResolver.
+func (cfg Config) defaultOptions() OptionsResolver {
+	return func(r *http.Request) ([]PaymentOption, error) {
+		amount, asset, recipient, err := cfg.PriceResolver(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.Options) == 0 {
+			opt := PaymentOption{
+				ChainID:           cfg.DomainParams.ChainID,
+				VerifyingContract: cfg.DomainParams.VerifyingContract,
+				Asset:             asset,
+				Amount:            amount,
+				Recipient:         recipient,
+			}
+			return []PaymentOption{opt.WithChainDefaults()}, nil
+		}
+
+		options := make([]PaymentOption, len(cfg.Options))
+		for i, opt := range cfg.Options {
+			if opt.Amount == "" {
+				opt.Amount = amount
+			}
+			if opt.Recipient == "" {
+				opt.Recipient = recipient
+			}
+			options[i] = opt
+		}
+		return options, nil
+	}
+}
+
+// signingDomain returns the EIP-712 domain a payment answering c was signed under, in the
+// domain version the payload names. Payloads that name none were signed under crypto.DomainVersion.
+func (m *Middleware) signingDomain(c *Challenge, payload *PaymentPayload) (crypto.DomainParams, error) {
+	version := payload.DomainVersion
+	if version == "" {
+		version = crypto.DomainVersion
+	}
+	if !slices.Contains(m.config.DomainVersions, version) {
+		return crypto.DomainParams{}, reject(ReasonDomainVersion, "domain version %q is not accepted", version)
+	}
+	params := m.challengeDomain(c)
+	params.Version = version
+	return params, nil
+}
+
+// typedData returns the schemas of the messages an agent may sign for an option, under the
+// current domain version.
+func (m *Middleware) typedData(c *Challenge) ([]crypto.TypedDataSchema, error) {
+	params := m.challengeDomain(c)
+	params.Version = m.config.DomainVersions[0]
+
+	types := []string{crypto.TypeIntentToPay, crypto.TypeBoundIntentToPay}
+	if m.config.RequireRequestBinding {
+		types = types[1:]
+	}
+	if m.creditsEnabled() {
+		types = append(types, crypto.TypeDeposit, crypto.TypeCreditSpend)
+	}
+	schemas := make([]crypto.TypedDataSchema, 0, len(types))
+	for _, t := range types {
+		schema, err := crypto.NewTypedDataSchema(t, params)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, *schema)
+	}
+	return schemas, nil
+}
+
+// challengeDomain returns the EIP-712 domain a nonce was issued under.
+func (m *Middleware) challengeDomain(c *Challenge) crypto.DomainParams {
+	params := crypto.DomainParams{ChainID: c.ChainID, VerifyingContract: m.config.DomainParams.VerifyingContract}
+	if common.IsHexAddress(c.VerifyingContract) {
+		params.VerifyingContract = common.HexToAddress(c.VerifyingContract)
+	}
+	return params
+}
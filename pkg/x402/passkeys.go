@@ -0,0 +1,134 @@
+package x402
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// Signature schemes advertised in the 402 challenge.
+const (
+	SignatureSchemeSecp256k1 = "secp256k1"     // EIP-712 ECDSA, ERC-1271 and EIP-6492
+	SignatureSchemeWebAuthn  = "webauthn-p256" // WebAuthn assertion by a registered passkey
+)
+
+// Passkey is a WebAuthn credential registered as a signing key of an agent. Payments
+// it signs are attributed to the agent's address.
+type Passkey struct {
+	CredentialID string
+	Agent        common.Address
+	PublicKey    *ecdsa.PublicKey
+	RPID         string // Relying party assertions must be scoped to; empty to skip the check
+}
+
+// PasskeyRegistry maps WebAuthn credential IDs to the agents that registered them.
+type PasskeyRegistry interface {
+	// Register adds a passkey, replacing any earlier one with the same credential ID.
+	Register(p Passkey) error
+
+	// Lookup returns a passkey by credential ID, or nil if it is not registered.
+	Lookup(credentialID string) (*Passkey, error)
+}
+
+// MemoryPasskeyRegistry keeps passkeys in process memory.
+type MemoryPasskeyRegistry struct {
+	mu       sync.RWMutex
+	passkeys map[string]Passkey
+}
+
+func NewMemoryPasskeyRegistry() *MemoryPasskeyRegistry {
+	return &MemoryPasskeyRegistry{passkeys: make(map[string]Passkey)}
+}
+
+func (r *MemoryPasskeyRegistry) Register(p Passkey) error {
+	if p.CredentialID == "" || p.PublicKey == nil {
+		return errors.New("passkey needs a credential ID and public key")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.passkeys[p.CredentialID] = p
+	return nil
+}
+
+func (r *MemoryPasskeyRegistry) Lookup(credentialID string) (*Passkey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.passkeys[credentialID]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// SQLitePasskeyRegistry persists passkeys in the settler database.
+type SQLitePasskeyRegistry struct {
+	db *storage.DB
+}
+
+func NewSQLitePasskeyRegistry(db *storage.DB) *SQLitePasskeyRegistry {
+	return &SQLitePasskeyRegistry{db: db}
+}
+
+func (r *SQLitePasskeyRegistry) Register(p Passkey) error {
+	if p.CredentialID == "" || p.PublicKey == nil {
+		return errors.New("passkey needs a credential ID and public key")
+	}
+	return r.db.SavePasskey(storage.PasskeyRow{
+		CredentialID: p.CredentialID,
+		Agent:        p.Agent.Hex(),
+		PublicKey:    crypto.EncodeP256PublicKey(p.PublicKey),
+		RPID:         p.RPID,
+	})
+}
+
+func (r *SQLitePasskeyRegistry) Lookup(credentialID string) (*Passkey, error) {
+	row, err := r.db.LoadPasskey(credentialID)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	pub, err := crypto.ParseP256PublicKey(row.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse passkey %s: %w", credentialID, err)
+	}
+	return &Passkey{
+		CredentialID: row.CredentialID,
+		Agent:        common.HexToAddress(row.Agent),
+		PublicKey:    pub,
+		RPID:         row.RPID,
+	}, nil
+}
+
+var (
+	_ PasskeyRegistry = (*MemoryPasskeyRegistry)(nil)
+	_ PasskeyRegistry = (*SQLitePasskeyRegistry)(nil)
+)
+
+// verifyPasskey checks a WebAuthn assertion over sighash against the registered passkey
+// and returns the agent it belongs to.
+func (m *Middleware) verifyPasskey(payload *PaymentPayload, sighash []byte) (common.Address, error) {
+	if !m.config.Passkeys {
+		return common.Address{}, reject(ReasonMalformedPayment, "passkey signatures are not accepted")
+	}
+	assertion := payload.WebAuthn
+
+	passkey, err := m.config.PasskeyRegistry.Lookup(assertion.CredentialID)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to look up passkey: %w", err)
+	}
+	if passkey == nil {
+		return common.Address{}, reject(ReasonInvalidSignature, "passkey %q is not registered", assertion.CredentialID)
+	}
+	if payload.From != "" && !sameAddress(payload.From, passkey.Agent.Hex()) {
+		return common.Address{}, reject(ReasonSignerMismatch, "passkey belongs to %s, not %s", passkey.Agent.Hex(), payload.From)
+	}
+
+	if err := crypto.VerifyWebAuthn(passkey.PublicKey, sighash, *assertion, passkey.RPID); err != nil {
+		return common.Address{}, &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+	return passkey.Agent, nil
+}
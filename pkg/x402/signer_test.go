@@ -0,0 +1,223 @@
+package x402
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	crypto2 "github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+func TestMiddleware_SmartAccountSigner(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	account := common.HexToAddress("0x000000000000000000000000000000000000a11c")
+
+	// The account accepts any signature by its owner, answering isValidSignature with the magic value.
+	srv := chaintest.NewServer(31339)
+	defer srv.Close()
+	chains.RegisterChain(chains.ChainConfig{Name: "Signer Test", ChainID: 31339, RPCURL: srv.URL})
+	srv.Handle("eth_call", func(params []json.RawMessage) (any, error) {
+		var call struct {
+			Input hexutil.Bytes `json:"input"`
+		}
+		json.Unmarshal(params[0], &call)
+		hash, sig := call.Input[4:36], call.Input[100:165]
+		result := make([]byte, 32)
+		if pub, err := crypto.SigToPub(hash, append(sig[:64:64], sig[64]-27)); err == nil && crypto.PubkeyToAddress(*pub) == crypto.PubkeyToAddress(owner.PublicKey) {
+			copy(result, []byte{0x16, 0x26, 0xba, 0x7e})
+		}
+		return hexutil.Encode(result), nil
+	})
+
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{
+			ChainID:           big.NewInt(31339),
+			VerifyingContract: common.HexToAddress("0x0000000000000000000000000000000000000def"),
+		},
+		NonceExpiry: time.Minute,
+		Recipient:   "0x0000000000000000000000000000000000000123",
+		Asset:       "0x0000000000000000000000000000000000000456",
+		Amount:      "1000",
+		Clients:     clients,
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	stranger, _ := crypto.GenerateKey()
+	tests := []struct {
+		name   string
+		from   string
+		key    *ecdsa.PrivateKey
+		code   int
+		reason string
+	}{
+		{"smart account", account.Hex(), owner, http.StatusOK, ""},
+		{"smart account wrong owner", account.Hex(), stranger, http.StatusPaymentRequired, ReasonInvalidSignature},
+		{"claimed EOA", crypto.PubkeyToAddress(stranger.PublicKey).Hex(), stranger, http.StatusOK, ""},
+		{"malformed signer", "not-an-address", owner, http.StatusPaymentRequired, ReasonMalformedPayment},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent := crypto2.IntentToPay{
+				Recipient: cfg.Recipient,
+				Amount:    cfg.Amount,
+				Asset:     cfg.Asset,
+				Nonce:     requestNonce(t, mw),
+				Deadline:  uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, tt.key, intent, cfg.DomainParams), From: tt.from})
+			if rr.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.reason != "" && !hasReason(rr, tt.reason) {
+				t.Errorf("expected reason %s, got %s", tt.reason, rr.Body.String())
+			}
+		})
+	}
+
+	// Payments that fail their challenge are rejected before the account contract is asked.
+	calls := srv.Calls("eth_call") + srv.Calls("eth_getCode")
+	rejected := []struct {
+		reason string
+		intent crypto2.IntentToPay
+	}{
+		{ReasonUnknownNonce, crypto2.IntentToPay{Recipient: cfg.Recipient, Amount: cfg.Amount, Asset: cfg.Asset, Nonce: "unknown", Deadline: uint64(time.Now().Add(time.Hour).Unix())}},
+		{ReasonAmountMismatch, crypto2.IntentToPay{Recipient: cfg.Recipient, Amount: "1", Asset: cfg.Asset, Nonce: requestNonce(t, mw), Deadline: uint64(time.Now().Add(time.Hour).Unix())}},
+	}
+	for _, tt := range rejected {
+		rr := sendPayment(t, mw, "/", PaymentPayload{Intent: tt.intent, Signature: signIntent(t, stranger, tt.intent, cfg.DomainParams), From: account.Hex()})
+		if !hasReason(rr, tt.reason) {
+			t.Errorf("expected 402 %s, got %d %s", tt.reason, rr.Code, rr.Body.String())
+		}
+	}
+	if n := srv.Calls("eth_call") + srv.Calls("eth_getCode") - calls; n != 0 {
+		t.Errorf("expected no contract calls for rejected payments, got %d", n)
+	}
+}
+
+// signPasskey returns the WebAuthn assertion a passkey for rpID would produce over the intent.
+func signPasskey(t *testing.T, key *ecdsa.PrivateKey, credentialID, rpID string, intent crypto2.IntentToPay, params crypto2.DomainParams) *crypto2.WebAuthnAssertion {
+	t.Helper()
+	sighash, err := crypto2.HashIntentToPay(intent, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], 0x05, 0, 0, 0, 7)
+	clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"` + base64.RawURLEncoding.EncodeToString(sighash) + `","origin":"https://` + rpID + `"}`)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &crypto2.WebAuthnAssertion{
+		CredentialID:      credentialID,
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+		Signature:         base64.RawURLEncoding.EncodeToString(sig),
+	}
+}
+
+func TestMiddleware_PasskeySigner(t *testing.T) {
+	passkey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	agent := common.HexToAddress("0x000000000000000000000000000000000000a9e7")
+
+	registry := NewMemoryPasskeyRegistry()
+	if err := registry.Register(Passkey{CredentialID: "cred-1", Agent: agent, PublicKey: &passkey.PublicKey, RPID: "agent.example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{
+			ChainID:           big.NewInt(8453),
+			VerifyingContract: common.HexToAddress("0x0000000000000000000000000000000000000def"),
+		},
+		NonceExpiry:     time.Minute,
+		Recipient:       "0x0000000000000000000000000000000000000123",
+		Asset:           "0x0000000000000000000000000000000000000456",
+		Amount:          "1000",
+		Passkeys:        true,
+		PasskeyRegistry: registry,
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	// The challenge advertises both signature schemes.
+	rr := httptest.NewRecorder()
+	mw.Handler(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	var challenge ChallengeResponse
+	json.NewDecoder(rr.Body).Decode(&challenge)
+	if schemes := challenge.Accepts[0].SignatureSchemes; len(schemes) != 2 || schemes[1] != SignatureSchemeWebAuthn {
+		t.Errorf("expected %s to be advertised, got %v", SignatureSchemeWebAuthn, schemes)
+	}
+
+	intent := func() crypto2.IntentToPay {
+		return crypto2.IntentToPay{
+			Recipient: cfg.Recipient,
+			Amount:    cfg.Amount,
+			Asset:     cfg.Asset,
+			Nonce:     requestNonce(t, mw),
+			Deadline:  uint64(time.Now().Add(time.Hour).Unix()),
+		}
+	}
+
+	var signer common.Address
+	paid := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signer, _ = GetSigner(r.Context())
+	}))
+	i := intent()
+	payload, _ := json.Marshal(PaymentPayload{Intent: i, WebAuthn: signPasskey(t, passkey, "cred-1", "agent.example", i, cfg.DomainParams)})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(HeaderPayment, string(payload))
+	rr = httptest.NewRecorder()
+	paid.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || signer != agent {
+		t.Fatalf("expected the passkey to pay as %s, got %d %s", agent.Hex(), rr.Code, signer.Hex())
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tests := []struct {
+		name   string
+		sign   func(i crypto2.IntentToPay) PaymentPayload
+		reason string
+	}{
+		{"unregistered credential", func(i crypto2.IntentToPay) PaymentPayload {
+			return PaymentPayload{Intent: i, WebAuthn: signPasskey(t, passkey, "cred-2", "agent.example", i, cfg.DomainParams)}
+		}, ReasonInvalidSignature},
+		{"wrong key", func(i crypto2.IntentToPay) PaymentPayload {
+			return PaymentPayload{Intent: i, WebAuthn: signPasskey(t, other, "cred-1", "agent.example", i, cfg.DomainParams)}
+		}, ReasonInvalidSignature},
+		{"wrong relying party", func(i crypto2.IntentToPay) PaymentPayload {
+			return PaymentPayload{Intent: i, WebAuthn: signPasskey(t, passkey, "cred-1", "evil.example", i, cfg.DomainParams)}
+		}, ReasonInvalidSignature},
+		{"claimed by another agent", func(i crypto2.IntentToPay) PaymentPayload {
+			return PaymentPayload{Intent: i, From: cfg.Recipient, WebAuthn: signPasskey(t, passkey, "cred-1", "agent.example", i, cfg.DomainParams)}
+		}, ReasonSignerMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := sendPayment(t, mw, "/", tt.sign(intent()))
+			if rr.Code != http.StatusPaymentRequired || !hasReason(rr, tt.reason) {
+				t.Errorf("expected 402 %s, got %d %s", tt.reason, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+)
+
+// DefaultSolvencyTTL is how long ChainSolvency trusts a balance reading.
+const DefaultSolvencyTTL = 15 * time.Second
+
+// SolvencyQuery describes a payment a payer is about to make.
+type SolvencyQuery struct {
+	ChainID *big.Int
+	Asset   common.Address
+	Payer   common.Address
+	Amount  *big.Int
+
+	// Spender, when set, must be allowed to transfer Amount on behalf of Payer. Intents
+	// and deposits are pulled by the verifying contract.
+	Spender common.Address
+	// AuthorizationNonce, when set, must still be unused on-chain (EIP-3009 payments).
+	AuthorizationNonce *[32]byte
+}
+
+// SolvencyChecker confirms that a payer can actually pay before a request is served.
+// It returns a PaymentError when the payer cannot.
+type SolvencyChecker interface {
+	CheckSolvency(ctx context.Context, q SolvencyQuery) error
+}
+
+// ChainSolvency reads balances, allowances and authorization state through chains.MultiClient.
+// Balance and allowance are cached per payer for a short TTL and reduced by every payment
+// accepted against them, so a burst of requests cannot spend the same funds twice.
+type ChainSolvency struct {
+	clients *chains.MultiClient
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	cache map[solvencyKey]*solvencyEntry
+}
+
+type solvencyKey struct {
+	chainID               uint64
+	asset, payer, spender common.Address
+}
+
+type solvencyEntry struct {
+	balance   *big.Int
+	allowance *big.Int // Nil when no spender is involved
+	expiresAt time.Time
+}
+
+// maxSolvencyEntries bounds the cache before expired readings are dropped.
+const maxSolvencyEntries = 4096
+
+// NewChainSolvency creates a checker caching readings for ttl. A non-positive ttl uses DefaultSolvencyTTL.
+func NewChainSolvency(clients *chains.MultiClient, ttl time.Duration) *ChainSolvency {
+	if ttl <= 0 {
+		ttl = DefaultSolvencyTTL
+	}
+	return &ChainSolvency{
+		clients: clients,
+		ttl:     ttl,
+		now:     time.Now,
+		cache:   make(map[solvencyKey]*solvencyEntry),
+	}
+}
+
+// CheckSolvency implements SolvencyChecker.
+func (s *ChainSolvency) CheckSolvency(ctx context.Context, q SolvencyQuery) error {
+	if q.ChainID == nil || !q.ChainID.IsUint64() || q.Amount == nil {
+		return reject(ReasonMalformedPayment, "incomplete solvency query")
+	}
+	id := chains.ChainID(q.ChainID.Uint64())
+
+	if q.AuthorizationNonce != nil {
+		used, err := s.clients.AuthorizationState(ctx, id, q.Asset, q.Payer, *q.AuthorizationNonce)
+		if err != nil {
+			return fmt.Errorf("failed to read authorization state: %w", err)
+		}
+		if used {
+			return reject(ReasonNonceUsed, "authorization %x was already used on-chain", *q.AuthorizationNonce)
+		}
+	}
+
+	key := solvencyKey{chainID: uint64(id), asset: q.Asset, payer: q.Payer, spender: q.Spender}
+	entry, err := s.lookup(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	// Reserve the amount so later payments within the TTL see what is left.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.balance.Cmp(q.Amount) < 0 {
+		return reject(ReasonInsufficientFunds, "%s holds %s of %s, needs %s", q.Payer.Hex(), entry.balance, q.Asset.Hex(), q.Amount)
+	}
+	if entry.allowance != nil && entry.allowance.Cmp(q.Amount) < 0 {
+		return reject(ReasonInsufficientAllowance, "%s allows %s to spend %s, needs %s", q.Payer.Hex(), q.Spender.Hex(), entry.allowance, q.Amount)
+	}
+	entry.balance.Sub(entry.balance, q.Amount)
+	if entry.allowance != nil {
+		entry.allowance.Sub(entry.allowance, q.Amount)
+	}
+	return nil
+}
+
+// lookup returns the cached reading for key, reading the chain when it is missing or stale.
+func (s *ChainSolvency) lookup(ctx context.Context, key solvencyKey) (*solvencyEntry, error) {
+	now := s.now()
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry, nil
+	}
+
+	id := chains.ChainID(key.chainID)
+	entry = &solvencyEntry{expiresAt: now.Add(s.ttl)}
+	balance, err := s.clients.TokenBalance(ctx, id, key.asset, key.payer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balance: %w", err)
+	}
+	entry.balance = balance
+	if key.spender != (common.Address{}) {
+		allowance, err := s.clients.TokenAllowance(ctx, id, key.asset, key.payer, key.spender)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read allowance: %w", err)
+		}
+		entry.allowance = allowance
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cache) >= maxSolvencyEntries {
+		for k, e := range s.cache {
+			if !now.Before(e.expiresAt) {
+				delete(s.cache, k)
+			}
+		}
+	}
+	s.cache[key] = entry
+	return entry, nil
+}
+
+// checkSolvency runs the configured solvency check, if any.
+func (m *Middleware) checkSolvency(r *http.Request, q SolvencyQuery) error {
+	if m.config.Solvency == nil {
+		return nil
+	}
+	return m.config.Solvency.CheckSolvency(r.Context(), q)
+}
+
+var _ SolvencyChecker = (*ChainSolvency)(nil)
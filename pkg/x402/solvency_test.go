@@ -0,0 +1,83 @@
+package x402
+
+import (
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/nathfavour/settlerengine/pkg/chains"
+	"github.com/nathfavour/settlerengine/pkg/chains/chaintest"
+	crypto2 "github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+func TestMiddleware_Solvency(t *testing.T) {
+	srv := chaintest.NewServer(31337)
+	defer srv.Close()
+	srv.ServeToken(big.NewInt(1500))
+	chains.RegisterChain(chains.ChainConfig{Name: "Solvency Test", ChainID: 31337, RPCURL: srv.URL})
+
+	clients := chains.NewMultiClient()
+	defer clients.Close()
+	solvency := NewChainSolvency(clients, time.Minute)
+
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{
+			ChainID:           big.NewInt(31337),
+			VerifyingContract: common.HexToAddress("0x0000000000000000000000000000000000000def"),
+		},
+		NonceExpiry: time.Minute,
+		Recipient:   "0x0000000000000000000000000000000000000123",
+		Asset:       "0x0000000000000000000000000000000000000456",
+		Amount:      "1000",
+		Solvency:    solvency,
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	agent, _ := crypto.GenerateKey()
+	pay := func() (int, string) {
+		intent := crypto2.IntentToPay{
+			Recipient: cfg.Recipient,
+			Amount:    cfg.Amount,
+			Asset:     cfg.Asset,
+			Nonce:     requestNonce(t, mw),
+			Deadline:  uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		rr := sendPayment(t, mw, "/", PaymentPayload{Intent: intent, Signature: signIntent(t, agent, intent, cfg.DomainParams)})
+		if rr.Code == http.StatusOK {
+			return rr.Code, ""
+		}
+		var reason string
+		for _, r := range []string{ReasonInsufficientFunds, ReasonInsufficientAllowance} {
+			if hasReason(rr, r) {
+				reason = r
+			}
+		}
+		return rr.Code, reason
+	}
+
+	if code, reason := pay(); code != http.StatusOK {
+		t.Fatalf("expected a funded payment to succeed, got %d %s", code, reason)
+	}
+	calls := srv.Calls("eth_call")
+	if calls != 2 {
+		t.Errorf("expected balance and allowance to be read once each, got %d calls", calls)
+	}
+
+	// The first payment is reserved against the cached balance, leaving 500.
+	if code, reason := pay(); code != http.StatusPaymentRequired || reason != ReasonInsufficientFunds {
+		t.Errorf("expected 402 %s, got %d %q", ReasonInsufficientFunds, code, reason)
+	}
+	if got := srv.Calls("eth_call"); got != calls {
+		t.Errorf("expected the cached reading to be used, got %d more calls", got-calls)
+	}
+
+	// Once the reading expires the chain is asked again.
+	solvency.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if code, reason := pay(); code != http.StatusOK {
+		t.Errorf("expected a fresh reading to accept the payment, got %d %s", code, reason)
+	}
+}
@@ -0,0 +1,324 @@
+package x402
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+const (
+	// X402Version is the version of the public x402 spec the gateway speaks.
+	X402Version = 1
+	// SchemeExact is the spec scheme paying an exact amount with an EIP-3009 authorization.
+	SchemeExact = "exact"
+
+	// HeaderPaymentResponse carries the base64 settlement response defined by the spec.
+	HeaderPaymentResponse = "X-Payment-Response"
+)
+
+// SpecPayment is the base64-encoded X-PAYMENT payload defined by the public x402 spec.
+type SpecPayment struct {
+	X402Version int          `json:"x402Version"`
+	Scheme      string       `json:"scheme"`
+	Network     string       `json:"network"` // Network slug, e.g. "base-sepolia"
+	Payload     ExactPayload `json:"payload"`
+}
+
+// ExactPayload is the payload of the "exact" scheme.
+type ExactPayload struct {
+	Signature     string                           `json:"signature"`
+	Authorization crypto.TransferWithAuthorization `json:"authorization"`
+}
+
+// SettlementResponse is returned base64-encoded in the X-PAYMENT-RESPONSE header.
+type SettlementResponse struct {
+	Success     bool   `json:"success"`
+	ErrorReason string `json:"errorReason,omitempty"`
+	Transaction string `json:"transaction"` // Empty until the payment is settled on-chain
+	Network     string `json:"network"`
+	Payer       string `json:"payer"`
+}
+
+// ExactSettlement is an accepted "exact" payment to be settled on-chain.
+type ExactSettlement struct {
+	PaymentID     string // The payment signature, as recorded in verified_payments
+	Token         crypto.TokenDomain
+	Authorization crypto.TransferWithAuthorization
+	Signature     string
+}
+
+// Settler moves the funds of an accepted "exact" payment on-chain and returns the
+// transaction hash. It returns a PaymentError when the payer cannot pay.
+type Settler interface {
+	Settle(ctx context.Context, s ExactSettlement) (string, error)
+}
+
+// decodeSpecPayment decodes a base64 X-PAYMENT header value.
+func decodeSpecPayment(val string) (*PaymentPayload, error) {
+	raw, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		if raw, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(val, "=")); err != nil {
+			return nil, fmt.Errorf("payment header is neither JSON nor base64: %w", err)
+		}
+	}
+
+	var spec SpecPayment
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, fmt.Errorf("failed to decode x402 payment: %w", err)
+	}
+	if spec.X402Version != X402Version {
+		return nil, fmt.Errorf("unsupported x402Version %d", spec.X402Version)
+	}
+	if spec.Scheme != SchemeExact {
+		return nil, fmt.Errorf("unsupported scheme %q", spec.Scheme)
+	}
+	return &PaymentPayload{Signature: spec.Payload.Signature, Network: spec.Network, Spec: &spec}, nil
+}
+
+// EncodeSpecPayment encodes a payment the way standard x402 clients send it.
+func EncodeSpecPayment(p SpecPayment) (string, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// authorizeExact accepts an "exact" scheme payment. The spec has no challenge nonce, so the
+// authorization is checked against the options currently offered for the request and its
+// EIP-3009 nonce is remembered until it expires.
+func (m *Middleware) authorizeExact(w http.ResponseWriter, r *http.Request, payload *PaymentPayload) (common.Address, error) {
+	spec := payload.Spec
+	auth := spec.Payload.Authorization
+
+	if !m.settlesExact() {
+		return common.Address{}, reject(ReasonMalformedPayment, "scheme %q is not accepted", SchemeExact)
+	}
+	if !common.IsHexAddress(auth.From) {
+		return common.Address{}, reject(ReasonMalformedPayment, "invalid authorization payer %q", auth.From)
+	}
+	ctx := context.WithValue(r.Context(), SignerContextKey, common.HexToAddress(auth.From))
+	options, err := m.config.OptionsResolver(r.WithContext(ctx))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to resolve price: %w", err)
+	}
+
+	var candidates []PaymentOption
+	for _, opt := range options {
+		if opt.supportsExact() && opt.Network == spec.Network {
+			candidates = append(candidates, opt)
+		}
+	}
+	if len(candidates) == 0 {
+		return common.Address{}, reject(ReasonChainMismatch, "network %q is not accepted", spec.Network)
+	}
+
+	// An accepted authorization is redeemed under its digest and payer, however the signature is encoded.
+	for _, opt := range candidates {
+		key, err := exactKey(auth, payload.Signature, opt.tokenDomain())
+		if err != nil {
+			continue
+		}
+		grant, err := m.grants.Use(key, r.URL.Path, time.Now())
+		if err != nil {
+			return common.Address{}, err
+		}
+		if grant != nil {
+			m.writeSettlementResponse(w, spec.Network, "", grant.Signer)
+			return grant.Signer, nil
+		}
+	}
+
+	chosen, signer, err := m.matchExact(r, payload, candidates)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if err := checkAuthorization(auth, chosen.Recipient, chosen.Amount, time.Now()); err != nil {
+		return common.Address{}, err
+	}
+	validBefore, _ := new(big.Int).SetString(auth.ValidBefore, 10)
+
+	nonce := common.FromHex(auth.Nonce)
+	if len(nonce) != 32 {
+		return common.Address{}, reject(ReasonMalformedPayment, "authorization nonce must be 32 bytes")
+	}
+	value, _ := new(big.Int).SetString(auth.Value, 10)
+	if err := m.checkSolvency(r, SolvencyQuery{
+		ChainID:            chosen.ChainID,
+		Asset:              common.HexToAddress(chosen.Asset),
+		Payer:              signer,
+		Amount:             value,
+		AuthorizationNonce: (*[32]byte)(nonce),
+	}); err != nil {
+		return common.Address{}, err
+	}
+
+	paymentID, err := exactKey(auth, payload.Signature, chosen.tokenDomain())
+	if err != nil {
+		return common.Address{}, &PaymentError{Reason: ReasonInvalidSignature, Err: err}
+	}
+
+	// EIP-3009 nonces are single use per token and payer, as they are on-chain.
+	key := spentKey(fmt.Sprintf("eip3009:%s:%s:%s:%s", chosen.ChainID, strings.ToLower(chosen.Asset), strings.ToLower(auth.From), strings.ToLower(auth.Nonce)))
+	expiresAt := time.Unix(validBefore.Int64(), 0)
+	if !validBefore.IsInt64() {
+		expiresAt = time.Now().Add(365 * 24 * time.Hour)
+	}
+	fresh, err := m.authorizations.MarkSpent(key, expiresAt)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to record authorization: %w", err)
+	}
+	if !fresh {
+		return common.Address{}, reject(ReasonNonceUsed, "authorization nonce %s was already used", auth.Nonce)
+	}
+
+	if m.config.DB != nil {
+		if err := m.config.DB.RecordPayment(paymentID, signer.Hex(), auth.Value, chosen.Asset, auth.Nonce); err != nil {
+			log.Printf("⚠️  x402: Failed to record payment %s: %v", paymentID, err)
+		}
+	}
+
+	// The nonce is held while the payment settles; a settlement that failed before it was
+	// broadcast leaves the authorization unused on-chain, so it may be presented again. One
+	// that may have been broadcast keeps it used.
+	txHash, err := m.settleExact(r, payload, chosen, paymentID)
+	if err != nil {
+		var rejection *PaymentError
+		if errors.As(err, &rejection) && rejection.Reason == ReasonSettlementPending {
+			return common.Address{}, err
+		}
+		if uerr := m.authorizations.Unmark(key); uerr != nil {
+			log.Printf("⚠️  x402: Failed to release authorization nonce %s: %v", auth.Nonce, uerr)
+		}
+		return common.Address{}, err
+	}
+
+	if err := m.grants.Create(paymentID, m.newGrant(signer, r.URL.Path)); err != nil {
+		return common.Address{}, fmt.Errorf("failed to record payment usage: %w", err)
+	}
+
+	m.writeSettlementResponse(w, spec.Network, txHash, signer)
+	return signer, nil
+}
+
+// matchExact finds the option an authorization pays for and returns it with the payer. The
+// signature identifies which token on the network the agent authorized.
+func (m *Middleware) matchExact(r *http.Request, payload *PaymentPayload, candidates []PaymentOption) (*PaymentOption, common.Address, error) {
+	spec := payload.Spec
+	if m.config.Facilitator == nil {
+		for i, opt := range candidates {
+			if recovered, err := crypto.VerifyTransferWithAuthorization(spec.Payload.Authorization, payload.Signature, opt.tokenDomain()); err == nil {
+				return &candidates[i], recovered, nil
+			}
+		}
+		return nil, common.Address{}, reject(ReasonInvalidSignature, "authorization is not signed by %s for an accepted asset", spec.Payload.Authorization.From)
+	}
+
+	// A payment signed for another asset is reported as an invalid signature, so prefer
+	// the reason given for the asset the agent did sign for.
+	reason := ReasonInvalidSignature
+	for i, opt := range candidates {
+		resp, err := m.config.Facilitator.Verify(r.Context(), *spec, m.exactDescriptor(r, opt))
+		if err != nil {
+			return nil, common.Address{}, fmt.Errorf("failed to verify payment with facilitator: %w", err)
+		}
+		if resp.IsValid && common.IsHexAddress(resp.Payer) {
+			return &candidates[i], common.HexToAddress(resp.Payer), nil
+		}
+		if resp.InvalidReason != "" && resp.InvalidReason != ReasonInvalidSignature {
+			reason = resp.InvalidReason
+		}
+	}
+	return nil, common.Address{}, reject(reason, "facilitator rejected the payment")
+}
+
+// settlesExact reports whether "exact" payments can be settled, through a facilitator or a
+// Settler. Without either they are neither advertised nor accepted.
+func (m *Middleware) settlesExact() bool {
+	return m.config.Facilitator != nil || m.config.Settler != nil
+}
+
+// settleExact settles an accepted payment through the facilitator or Settler and returns the
+// transaction hash.
+func (m *Middleware) settleExact(r *http.Request, payload *PaymentPayload, opt *PaymentOption, paymentID string) (string, error) {
+	if m.config.Facilitator != nil {
+		resp, err := m.config.Facilitator.Settle(r.Context(), *payload.Spec, m.exactDescriptor(r, *opt))
+		if err != nil {
+			// Without an answer, the facilitator may still have submitted the payment.
+			return "", &PaymentError{Reason: ReasonSettlementPending, Err: err}
+		}
+		if !resp.Success {
+			reason := resp.ErrorReason
+			if reason == "" {
+				reason = ReasonSettlementFailed
+			}
+			return "", reject(reason, "facilitator could not settle the payment")
+		}
+		return resp.Transaction, nil
+	}
+
+	if m.config.Settler == nil {
+		return "", errors.New("no settler or facilitator configured")
+	}
+	txHash, err := m.config.Settler.Settle(r.Context(), ExactSettlement{
+		PaymentID:     paymentID,
+		Token:         opt.tokenDomain(),
+		Authorization: payload.Spec.Payload.Authorization,
+		Signature:     payload.Signature,
+	})
+	var rejection *PaymentError
+	if errors.As(err, &rejection) {
+		return "", rejection
+	}
+	if err != nil {
+		return "", &PaymentError{Reason: ReasonSettlementFailed, Err: err}
+	}
+	return txHash, nil
+}
+
+// writeSettlementResponse sets the X-PAYMENT-RESPONSE header for an accepted spec payment.
+func (m *Middleware) writeSettlementResponse(w http.ResponseWriter, network, txHash string, payer common.Address) {
+	raw, err := json.Marshal(SettlementResponse{Success: true, Transaction: txHash, Network: network, Payer: payer.Hex()})
+	if err != nil {
+		return
+	}
+	w.Header().Set(HeaderPaymentResponse, base64.StdEncoding.EncodeToString(raw))
+}
+
+// exactDescriptor describes an option as payment requirements from the public x402 spec.
+func (m *Middleware) exactDescriptor(r *http.Request, opt PaymentOption) PaymentDescriptor {
+	return PaymentDescriptor{
+		Scheme:            SchemeExact,
+		Network:           opt.Network,
+		MaxAmountRequired: opt.Amount,
+		Resource:          resourceURL(r),
+		Description:       "Payment required to access this resource.",
+		MimeType:          "application/json",
+		PayTo:             opt.Recipient,
+		MaxTimeoutSeconds: int(m.config.NonceExpiry.Seconds()),
+		Asset:             opt.Asset,
+		Extra:             map[string]string{"name": opt.TokenName, "version": opt.TokenVersion},
+	}
+}
+
+// resourceURL returns the absolute URL of the request.
+func resourceURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
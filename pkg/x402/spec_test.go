@@ -0,0 +1,159 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	crypto2 "github.com/nathfavour/settlerengine/pkg/crypto"
+)
+
+// settlerFunc adapts a function to Settler.
+type settlerFunc func(ctx context.Context, s ExactSettlement) (string, error)
+
+func (f settlerFunc) Settle(ctx context.Context, s ExactSettlement) (string, error) { return f(ctx, s) }
+
+func TestMiddleware_SpecExactScheme(t *testing.T) {
+	settleErr := error(nil)
+	cfg := Config{
+		DomainParams: crypto2.DomainParams{ChainID: big.NewInt(84532)},
+		NonceExpiry:  1 * time.Minute,
+		Recipient:    "0x0000000000000000000000000000000000000123",
+		Asset:        "0x036CbD53842c5426634e7929541eC2318f3dCF7e", // USDC on Base Sepolia
+		Amount:       "1000",
+		Settler: settlerFunc(func(context.Context, ExactSettlement) (string, error) {
+			return "0x01", settleErr
+		}),
+	}
+	mw := NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+
+	rr := httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, httptest.NewRequest("GET", "http://api.example.com/weather?city=lisbon", nil))
+	var challenge ChallengeResponse
+	json.Unmarshal(rr.Body.Bytes(), &challenge)
+	if challenge.X402Version != 1 || len(challenge.Accepts) != 2 {
+		t.Fatalf("expected x402Version 1 with 2 options, got %s", rr.Body.String())
+	}
+	exact := challenge.Accepts[1]
+	if exact.Scheme != SchemeExact || exact.Network != "base-sepolia" || exact.MaxAmountRequired != "1000" ||
+		exact.Resource != "http://api.example.com/weather?city=lisbon" || exact.Extra["name"] != "USDC" || exact.Extra["version"] != "2" {
+		t.Fatalf("unexpected exact requirements: %+v", exact)
+	}
+
+	key, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(key.PublicKey)
+	domain := crypto2.TokenDomain{
+		Name:              exact.Extra["name"],
+		Version:           exact.Extra["version"],
+		ChainID:           big.NewInt(84532),
+		VerifyingContract: common.HexToAddress(exact.Asset),
+	}
+	pay := func(network, value string, nonce byte) *httptest.ResponseRecorder {
+		auth := crypto2.TransferWithAuthorization{
+			From:        payer.Hex(),
+			To:          exact.PayTo,
+			Value:       value,
+			ValidAfter:  "0",
+			ValidBefore: fmt.Sprint(time.Now().Add(time.Minute).Unix()),
+			Nonce:       hexutil.Encode(bytes.Repeat([]byte{nonce}, 32)),
+		}
+		sighash, err := crypto2.HashTransferWithAuthorization(auth, domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, _ := crypto.Sign(sighash, key)
+		signature[64] += 27
+		header, _ := EncodeSpecPayment(SpecPayment{
+			X402Version: 1,
+			Scheme:      SchemeExact,
+			Network:     network,
+			Payload:     ExactPayload{Signature: hexutil.Encode(signature), Authorization: auth},
+		})
+
+		req := httptest.NewRequest("GET", "http://api.example.com/weather?city=lisbon", nil)
+		req.Header.Set("X-PAYMENT", header)
+		rr := httptest.NewRecorder()
+		mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := pay("base-sepolia", "999", 1); !hasReason(rr, ReasonAmountMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonAmountMismatch, rr.Code, rr.Body.String())
+	}
+	if rr := pay("polygon", "1000", 2); !hasReason(rr, ReasonChainMismatch) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonChainMismatch, rr.Code, rr.Body.String())
+	}
+
+	rr = pay("base-sepolia", "1000", 3)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	raw, err := base64.StdEncoding.DecodeString(rr.Header().Get("X-PAYMENT-RESPONSE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settlement SettlementResponse
+	json.Unmarshal(raw, &settlement)
+	if !settlement.Success || settlement.Network != "base-sepolia" || settlement.Payer != payer.Hex() {
+		t.Errorf("unexpected settlement response: %+v", settlement)
+	}
+
+	// The same EIP-3009 nonce cannot pay twice, even with a fresh signature.
+	if rr := pay("base-sepolia", "2000", 3); !hasReason(rr, ReasonNonceUsed) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonNonceUsed, rr.Code, rr.Body.String())
+	}
+
+	// An authorization whose settlement failed was not used on-chain, so it can be presented again.
+	settleErr = errors.New("node unavailable")
+	if rr := pay("base-sepolia", "1000", 4); !hasReason(rr, ReasonSettlementFailed) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonSettlementFailed, rr.Code, rr.Body.String())
+	}
+	settleErr = nil
+	if rr := pay("base-sepolia", "1000", 4); rr.Code != http.StatusOK {
+		t.Errorf("expected a retried authorization to be accepted, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// One whose settlement may have been broadcast stays used.
+	settleErr = &PaymentError{Reason: ReasonSettlementPending, Err: errors.New("no answer from node")}
+	if rr := pay("base-sepolia", "1000", 6); !hasReason(rr, ReasonSettlementPending) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonSettlementPending, rr.Code, rr.Body.String())
+	}
+	settleErr = nil
+	if rr := pay("base-sepolia", "1000", 6); !hasReason(rr, ReasonNonceUsed) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonNonceUsed, rr.Code, rr.Body.String())
+	}
+
+	// The legacy JSON header keeps working alongside the spec format.
+	if rr := sendPayment(t, mw, "/", PaymentPayload{}); !hasReason(rr, ReasonUnknownNonce) {
+		t.Errorf("expected 402 %s, got %d %s", ReasonUnknownNonce, rr.Code, rr.Body.String())
+	}
+
+	// Without a settler or facilitator, "exact" is neither offered nor accepted.
+	cfg.Settler = nil
+	mw = NewMiddleware(cfg)
+	t.Cleanup(mw.Close)
+	rr = httptest.NewRecorder()
+	mw.Handler(nil).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	challenge = ChallengeResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &challenge)
+	if len(challenge.Accepts) != 1 || challenge.Accepts[0].Scheme == SchemeExact {
+		t.Errorf("expected only the x402 option without a settler, got %s", rr.Body.String())
+	}
+	if rr := pay("base-sepolia", "1000", 5); !hasReason(rr, ReasonMalformedPayment) {
+		t.Errorf("expected 402 %s without a settler, got %d %s", ReasonMalformedPayment, rr.Code, rr.Body.String())
+	}
+}
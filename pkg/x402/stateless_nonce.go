@@ -0,0 +1,251 @@
+package x402
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+const statelessNoncePrefix = "v1"
+
+// statelessClaims is the authenticated body of a stateless nonce.
+type statelessClaims struct {
+	Challenge
+	Expiry int64  `json:"exp"`
+	Salt   string `json:"salt"`
+}
+
+// StatelessNonceManager is a NonceIssuer whose nonces carry their own terms and expiry,
+// authenticated with an HMAC over a server secret. Any replica holding the secret can
+// verify a nonce without shared storage; only redeemed nonces are remembered, in a SpentSet.
+type StatelessNonceManager struct {
+	secret []byte
+	spent  SpentSet
+}
+
+// NewStatelessNonceManager creates a stateless issuer. spent defaults to a MemorySpentSet.
+func NewStatelessNonceManager(secret []byte, spent SpentSet) (*StatelessNonceManager, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("nonce secret must be at least 32 bytes, got %d", len(secret))
+	}
+	if spent == nil {
+		spent = NewMemorySpentSet()
+	}
+	return &StatelessNonceManager{secret: secret, spent: spent}, nil
+}
+
+// Generate encodes the terms and expiry into an authenticated nonce. Nothing is stored.
+func (nm *StatelessNonceManager) Generate(c Challenge, expiry time.Duration) (string, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate nonce salt: %w", err)
+	}
+
+	body, err := json.Marshal(statelessClaims{
+		Challenge: c,
+		Expiry:    time.Now().Add(expiry).Unix(),
+		Salt:      hex.EncodeToString(salt),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode nonce: %w", err)
+	}
+
+	signed := statelessNoncePrefix + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(nm.mac(signed)), nil
+}
+
+// Verify authenticates a nonce and returns the terms it carries.
+func (nm *StatelessNonceManager) Verify(nonce string) (*Challenge, error) {
+	claims, err := nm.decode(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	spent, err := nm.spent.IsSpent(spentKey(nonce))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check spent nonces: %w", err)
+	}
+	if spent {
+		return nil, reject(ReasonNonceUsed, "nonce was already redeemed")
+	}
+
+	c := claims.Challenge
+	return &c, nil
+}
+
+// Consume adds the nonce to the spent-set until it expires.
+func (nm *StatelessNonceManager) Consume(nonce string) error {
+	claims, err := nm.decode(nonce)
+	if err != nil {
+		return err
+	}
+
+	ok, err := nm.spent.MarkSpent(spentKey(nonce), time.Unix(claims.Expiry, 0))
+	if err != nil {
+		return fmt.Errorf("failed to record spent nonce: %w", err)
+	}
+	if !ok {
+		return reject(ReasonNonceUsed, "nonce was already redeemed")
+	}
+	return nil
+}
+
+// Cleanup drops spent-set entries whose nonces have expired anyway.
+func (nm *StatelessNonceManager) Cleanup() {
+	if _, err := nm.spent.Sweep(time.Now()); err != nil {
+		log.Printf("⚠️  x402: Failed to sweep spent nonces: %v", err)
+	}
+}
+
+func (nm *StatelessNonceManager) decode(nonce string) (*statelessClaims, error) {
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 3 || parts[0] != statelessNoncePrefix {
+		return nil, reject(ReasonUnknownNonce, "nonce is not a %s stateless nonce", statelessNoncePrefix)
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, nm.mac(parts[0]+"."+parts[1])) {
+		return nil, reject(ReasonUnknownNonce, "nonce authentication failed")
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, reject(ReasonUnknownNonce, "failed to decode nonce: %v", err)
+	}
+	var claims statelessClaims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, reject(ReasonUnknownNonce, "failed to decode nonce: %v", err)
+	}
+
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, reject(ReasonNonceExpired, "nonce expired at %s", time.Unix(claims.Expiry, 0).Format(time.RFC3339))
+	}
+	return &claims, nil
+}
+
+func (nm *StatelessNonceManager) mac(data string) []byte {
+	h := hmac.New(sha256.New, nm.secret)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// spentKey compacts a nonce to a fixed 16-byte hex key for the spent-set.
+func spentKey(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:16])
+}
+
+// SpentSet remembers redeemed stateless nonces until they expire.
+type SpentSet interface {
+	// MarkSpent records key until expiresAt. It reports false if key was already spent.
+	MarkSpent(key string, expiresAt time.Time) (bool, error)
+
+	// Unmark forgets key, for a redemption that did not go through.
+	Unmark(key string) error
+
+	// IsSpent reports whether key has been recorded.
+	IsSpent(key string) (bool, error)
+
+	// Sweep deletes entries that expired before now.
+	Sweep(now time.Time) (int, error)
+}
+
+// MemorySpentSet is an in-process SpentSet.
+type MemorySpentSet struct {
+	mu    sync.Mutex
+	spent map[string]time.Time
+}
+
+func NewMemorySpentSet() *MemorySpentSet {
+	return &MemorySpentSet{spent: make(map[string]time.Time)}
+}
+
+func (s *MemorySpentSet) MarkSpent(key string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.spent[key]; ok {
+		return false, nil
+	}
+	s.spent[key] = expiresAt
+	return true, nil
+}
+
+func (s *MemorySpentSet) Unmark(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.spent, key)
+	return nil
+}
+
+func (s *MemorySpentSet) IsSpent(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.spent[key]
+	return ok, nil
+}
+
+func (s *MemorySpentSet) Sweep(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for key, expiresAt := range s.spent {
+		if !now.Before(expiresAt) {
+			delete(s.spent, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// SQLiteSpentSet persists the spent-set in the settler database.
+type SQLiteSpentSet struct {
+	db *storage.DB
+}
+
+func NewSQLiteSpentSet(db *storage.DB) *SQLiteSpentSet {
+	return &SQLiteSpentSet{db: db}
+}
+
+func (s *SQLiteSpentSet) MarkSpent(key string, expiresAt time.Time) (bool, error) {
+	return s.db.MarkNonceSpent(key, expiresAt)
+}
+
+func (s *SQLiteSpentSet) Unmark(key string) error {
+	return s.db.UnmarkNonceSpent(key)
+}
+
+func (s *SQLiteSpentSet) IsSpent(key string) (bool, error) {
+	return s.db.IsNonceSpent(key)
+}
+
+func (s *SQLiteSpentSet) Sweep(now time.Time) (int, error) {
+	n, err := s.db.DeleteExpiredSpentNonces(now)
+	return int(n), err
+}
+
+// ParseNonceSecret decodes a hex-encoded stateless nonce secret.
+func ParseNonceSecret(s string) ([]byte, error) {
+	secret, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, errors.New("nonce secret must be hex encoded")
+	}
+	return secret, nil
+}
+
+// Ensure implementations of NonceIssuer and SpentSet.
+var (
+	_ NonceIssuer = (*StatelessNonceManager)(nil)
+	_ SpentSet    = (*MemorySpentSet)(nil)
+	_ SpentSet    = (*SQLiteSpentSet)(nil)
+)
@@ -1,20 +1,53 @@
 package x402
 
+import "github.com/nathfavour/settlerengine/pkg/crypto"
+
 // PaymentDescriptor defines the standard JSON structure for 402 responses.
 type PaymentDescriptor struct {
-	Scheme  string `json:"scheme"`  // e.g., "x402"
-	Price   string `json:"price"`   // Amount in atomic units (uint256 string)
-	Asset   string `json:"asset"`   // Token contract address (USDC)
-	Network string `json:"network"` // Chain ID or network name
-	PayTo   string `json:"payTo"`   // Merchant wallet address
-	Nonce   string `json:"nonce"`   // Unique session UUID for the challenge
+	Scheme  string `json:"scheme"`          // "x402", or "exact" for the public x402 spec
+	Price   string `json:"price,omitempty"` // Amount in atomic units (uint256 string)
+	Asset   string `json:"asset"`           // Token contract address (USDC)
+	Network string `json:"network"`         // Chain ID or network name
+	PayTo   string `json:"payTo"`           // Merchant wallet address
+	Nonce   string `json:"nonce,omitempty"` // Unique session UUID for the challenge
+
+	VerifyingContract string `json:"verifyingContract,omitempty"` // EIP-712 verifying contract for this network
+
+	Binding    *RequestBinding `json:"binding,omitempty"`    // How to bind the intent to this request
+	MinDeposit string          `json:"minDeposit,omitempty"` // Smallest prepaid deposit accepted, if credits are enabled
+	MaxDeposit string          `json:"maxDeposit,omitempty"` // Largest prepaid deposit credited, if capped
+
+	SignatureSchemes []string `json:"signatureSchemes,omitempty"` // Accepted signature schemes, when more than secp256k1
+
+	// TypedData holds the EIP-712 schema and domain of each message an agent may sign for
+	// this option, so agents need not hardcode them.
+	TypedData []crypto.TypedDataSchema `json:"typedData,omitempty"`
+
+	// Payment requirements defined by the public x402 spec, set on "exact" options.
+	MaxAmountRequired string            `json:"maxAmountRequired,omitempty"`
+	Resource          string            `json:"resource,omitempty"`
+	Description       string            `json:"description,omitempty"`
+	MimeType          string            `json:"mimeType,omitempty"`
+	MaxTimeoutSeconds int               `json:"maxTimeoutSeconds,omitempty"`
+	Extra             map[string]string `json:"extra,omitempty"` // EIP-712 "name" and "version" of the asset
+}
+
+// RequestBinding tells agents which IntentToPay fields bind a payment to the request
+// and the values the gateway will compare them against.
+type RequestBinding struct {
+	Fields   []string `json:"fields"`   // e.g., ["method", "url", "bodyHash"]
+	Required bool     `json:"required"` // Unbound intents are rejected when true
+	Method   string   `json:"method"`   // Expected method
+	URL      string   `json:"url"`      // Expected canonical URL
 }
 
 // ChallengeResponse is the body returned with a 402 status code.
 type ChallengeResponse struct {
+	X402Version int                 `json:"x402Version"`
 	Status      int                 `json:"status"`
 	Title       string              `json:"title"`
 	Description string              `json:"description"`
 	Accepts     []PaymentDescriptor `json:"accepts"`
 	Resource    string              `json:"resource"`
+	Error       string              `json:"error,omitempty"` // Rejection reason for a submitted payment
 }
@@ -0,0 +1,136 @@
+package x402
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+// UsagePolicy limits how often an accepted payment may be reused.
+// The zero value allows a single request.
+type UsagePolicy struct {
+	MaxRequests int           // Requests one payment unlocks; 0 means unlimited within Window
+	Window      time.Duration // How long a payment stays valid after acceptance; 0 means no time limit
+}
+
+func (p UsagePolicy) normalize() UsagePolicy {
+	if p.MaxRequests <= 0 && p.Window <= 0 {
+		p.MaxRequests = 1
+	}
+	return p
+}
+
+// Grant is the entitlement an accepted payment buys.
+type Grant struct {
+	Signer    common.Address
+	Resource  string
+	Uses      int
+	MaxUses   int       // 0 means unlimited
+	ExpiresAt time.Time // zero means no expiry
+}
+
+// GrantStore tracks how much of each accepted payment has been used.
+type GrantStore interface {
+	// Create records a new grant for a payment. The creating request counts as its first use.
+	Create(key string, g Grant) error
+
+	// Use redeems one request against a grant. It returns nil if no grant exists for key,
+	// and a PaymentError if the grant is exhausted, expired or bound to another resource.
+	Use(key, resource string, now time.Time) (*Grant, error)
+}
+
+// checkGrant validates a grant before a use is recorded.
+func checkGrant(g *Grant, resource string, now time.Time) error {
+	if g.Resource != resource {
+		return reject(ReasonResourceMismatch, "payment was made for %s, not %s", g.Resource, resource)
+	}
+	if !g.ExpiresAt.IsZero() && !now.Before(g.ExpiresAt) {
+		return reject(ReasonPaymentExpired, "payment expired at %s", g.ExpiresAt.Format(time.RFC3339))
+	}
+	if g.MaxUses > 0 && g.Uses >= g.MaxUses {
+		return reject(ReasonPaymentExhausted, "payment already used for %d of %d requests", g.Uses, g.MaxUses)
+	}
+	return nil
+}
+
+// MemoryGrantStore keeps grants in process memory.
+type MemoryGrantStore struct {
+	mu     sync.Mutex
+	grants map[string]*Grant
+}
+
+func NewMemoryGrantStore() *MemoryGrantStore {
+	return &MemoryGrantStore{grants: make(map[string]*Grant)}
+}
+
+func (s *MemoryGrantStore) Create(key string, g Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.Uses = 1
+	s.grants[key] = &g
+	return nil
+}
+
+func (s *MemoryGrantStore) Use(key, resource string, now time.Time) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.grants[key]
+	if !ok {
+		return nil, nil
+	}
+	if err := checkGrant(g, resource, now); err != nil {
+		return nil, err
+	}
+	g.Uses++
+	used := *g
+	return &used, nil
+}
+
+// SQLiteGrantStore persists grants in the settler database.
+type SQLiteGrantStore struct {
+	db *storage.DB
+}
+
+func NewSQLiteGrantStore(db *storage.DB) *SQLiteGrantStore {
+	return &SQLiteGrantStore{db: db}
+}
+
+func (s *SQLiteGrantStore) Create(key string, g Grant) error {
+	return s.db.CreatePaymentUsage(key, g.Signer.Hex(), g.Resource, g.MaxUses, g.ExpiresAt)
+}
+
+func (s *SQLiteGrantStore) Use(key, resource string, now time.Time) (*Grant, error) {
+	row, err := s.db.LoadPaymentUsage(key)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	g := &Grant{
+		Signer:    common.HexToAddress(row.Signer),
+		Resource:  row.Resource,
+		Uses:      row.Uses,
+		MaxUses:   row.MaxUses,
+		ExpiresAt: row.ExpiresAt,
+	}
+	if err := checkGrant(g, resource, now); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.db.IncrementPaymentUsage(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// Lost a race against a concurrent request for the last use.
+		return nil, reject(ReasonPaymentExhausted, "payment already used for %d of %d requests", g.MaxUses, g.MaxUses)
+	}
+	g.Uses++
+	return g, nil
+}
+
+// Ensure implementations of GrantStore.
+var (
+	_ GrantStore = (*MemoryGrantStore)(nil)
+	_ GrantStore = (*SQLiteGrantStore)(nil)
+)
@@ -0,0 +1,50 @@
+package x402
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nathfavour/settlerengine/pkg/storage"
+)
+
+func TestSQLiteGrantStore(t *testing.T) {
+	dir := t.TempDir()
+	db, err := storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := common.HexToAddress("0x0000000000000000000000000000000000000abc")
+	if err := NewSQLiteGrantStore(db).Create("sig", Grant{Signer: signer, Resource: "/data", MaxUses: 2}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Usage is tracked across restarts.
+	db, err = storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	grants := NewSQLiteGrantStore(db)
+
+	if g, err := grants.Use("unknown", "/data", time.Now()); g != nil || err != nil {
+		t.Errorf("expected no grant for unknown payment, got %+v (%v)", g, err)
+	}
+	if _, err := grants.Use("sig", "/other", time.Now()); err == nil || err.(*PaymentError).Reason != ReasonResourceMismatch {
+		t.Errorf("expected %s, got %v", ReasonResourceMismatch, err)
+	}
+
+	g, err := grants.Use("sig", "/data", time.Now())
+	if err != nil || g == nil {
+		t.Fatalf("expected second use to succeed: %v", err)
+	}
+	if g.Signer != signer || g.Uses != 2 {
+		t.Errorf("unexpected grant: %+v", g)
+	}
+
+	if _, err := grants.Use("sig", "/data", time.Now()); err == nil || err.(*PaymentError).Reason != ReasonPaymentExhausted {
+		t.Errorf("expected %s, got %v", ReasonPaymentExhausted, err)
+	}
+}
@@ -33,7 +33,7 @@ func TestX402HandshakeSignature(t *testing.T) {
 	// 3. Mock the Domain Separator Hash
 	// Normally calculated via typeddata.HashStruct
 	domainSeparator := crypto.Keccak256([]byte("SettlerEngine-V1-BaseSepolia"))
-	
+
 	// 4. Create the Typed Data Hash (The "Challenge")
 	messageHash := crypto.Keccak256(
 		domainSeparator,
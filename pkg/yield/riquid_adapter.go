@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
@@ -24,6 +25,7 @@ type RiquidAdapter struct {
 	client *ethclient.Client
 	signer *crypto.SessionKeySigner
 	abi    abi.ABI
+	tm     *crypto.TransactionManager
 }
 
 func NewRiquidAdapter(client *ethclient.Client, signer *crypto.SessionKeySigner) (*RiquidAdapter, error) {
@@ -38,17 +40,37 @@ func NewRiquidAdapter(client *ethclient.Client, signer *crypto.SessionKeySigner)
 	}, nil
 }
 
+// SessionPolicy scopes a session key to what the adapter signs: deposit and harvest calls to
+// the vaults of strategies, without native value, until expiry.
+func SessionPolicy(strategies []model.YieldStrategy, expiry time.Time, auditor crypto.PolicyAuditor) *crypto.SessionPolicy {
+	policy := &crypto.SessionPolicy{
+		MaxValuePerTx: new(big.Int),
+		Expiry:        expiry,
+		Auditor:       auditor,
+	}
+	for _, strategy := range strategies {
+		policy.Rules = append(policy.Rules, crypto.ScopeRule{
+			Target:    common.HexToAddress(strategy.VaultAddress),
+			Selectors: [][4]byte{crypto.Selector("deposit(uint256)"), crypto.Selector("harvest()")},
+		})
+	}
+	return policy
+}
+
+// WithTransactionManager sends deposits and harvests through tm: as user operations when it has
+// a smart account, so their gas can be sponsored by its paymaster, or else as transactions from
+// the session key with nonces, gas and fees managed by tm.
+func (a *RiquidAdapter) WithTransactionManager(tm *crypto.TransactionManager) *RiquidAdapter {
+	a.tm = tm
+	return a
+}
+
 // DepositToYield transfers assets from the main settlement balance to a yield-generating vault.
 func (a *RiquidAdapter) DepositToYield(ctx context.Context, amount money.Money, strategy model.YieldStrategy) error {
-	if a.signer == nil {
+	if a.signer == nil || a.tm == nil {
 		return fmt.Errorf("no signer configured for automated deposit")
 	}
 
-	auth, err := a.signer.GetTransactor(ctx, a.client)
-	if err != nil {
-		return fmt.Errorf("failed to get transactor: %w", err)
-	}
-
 	// 1. Encode deposit(amount)
 	input, err := a.abi.Pack("deposit", amount.Amount())
 	if err != nil {
@@ -57,8 +79,14 @@ func (a *RiquidAdapter) DepositToYield(ctx context.Context, amount money.Money,
 
 	// 2. Broadcast (In a real scenario, we'd also check and set ERC-20 allowance)
 	fmt.Printf("💰 Depositing %s %s to %s\n", amount.Amount().String(), amount.Currency(), strategy.VaultAddress)
-	_ = auth
-	_ = input
+	if _, err := a.tm.Send(ctx, crypto.Call{
+		Operation: crypto.OperationDeposit,
+		Reference: strategy.ID,
+		To:        common.HexToAddress(strategy.VaultAddress),
+		Data:      input,
+	}); err != nil {
+		return fmt.Errorf("failed to send deposit: %w", err)
+	}
 
 	// Update Metrics
 	metrics.YieldTVL.WithLabelValues(strategy.ID, amount.Currency()).Set(float64(amount.Amount().Int64()))
@@ -107,17 +135,11 @@ func (a *RiquidAdapter) GetAPY(ctx context.Context, vaultAddress string) (float6
 
 // Harvest triggers the claiming and reinvesting of accrued yield.
 func (a *RiquidAdapter) Harvest(ctx context.Context, strategy model.YieldStrategy) error {
-	if a.signer == nil {
+	if a.signer == nil || a.tm == nil {
 		metrics.YieldHarvests.WithLabelValues(strategy.ID, "FAILED_NO_SIGNER").Inc()
 		return fmt.Errorf("no signer configured for automated harvest")
 	}
 
-	auth, err := a.signer.GetTransactor(ctx, a.client)
-	if err != nil {
-		metrics.YieldHarvests.WithLabelValues(strategy.ID, "FAILED_SIGNER_ERROR").Inc()
-		return fmt.Errorf("failed to get transactor: %w", err)
-	}
-
 	fmt.Printf("🚜 Harvesting yield from %s using Session Key %s\n", strategy.VaultAddress, a.signer.Address().Hex())
 	
 	// Encode harvest()
@@ -126,8 +148,15 @@ func (a *RiquidAdapter) Harvest(ctx context.Context, strategy model.YieldStrateg
 		metrics.YieldHarvests.WithLabelValues(strategy.ID, "FAILED_PACK_ERROR").Inc()
 		return fmt.Errorf("failed to pack harvest call: %w", err)
 	}
-	_ = input
-	_ = auth
+	if _, err := a.tm.Send(ctx, crypto.Call{
+		Operation: crypto.OperationHarvest,
+		Reference: strategy.ID,
+		To:        common.HexToAddress(strategy.VaultAddress),
+		Data:      input,
+	}); err != nil {
+		metrics.YieldHarvests.WithLabelValues(strategy.ID, "FAILED_SEND_ERROR").Inc()
+		return fmt.Errorf("failed to send harvest: %w", err)
+	}
 
 	metrics.YieldHarvests.WithLabelValues(strategy.ID, "SUCCESS").Inc()
 	return nil